@@ -0,0 +1,277 @@
+package gohlslib
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+)
+
+// Closed captions carried into H264 and H265 SEI NAL units,
+// as described in ATSC A/72 and CEA-708.
+
+const (
+	seiPayloadTypeUserDataRegistered = 4
+
+	ccTypeNTSCField1  = 0
+	ccTypeNTSCField2  = 1
+	ccTypeDTVCCData   = 2
+	ccTypeDTVCCHeader = 3
+)
+
+var ccUserDataHeader = []byte{
+	0xb5,       // itu_t_t35_country_code (United States)
+	0x00, 0x31, // itu_t_t35_provider_code (ATSC)
+	'G', 'A', '9', '4', // user_identifier
+	0x03, // user_data_type_code (cc_data)
+}
+
+// ccTriplet is a cc_data() construct.
+type ccTriplet struct {
+	typ  uint8
+	data [2]byte
+}
+
+// captionsInstreamID is a INSTREAM-ID of a CLOSED-CAPTIONS rendition.
+type captionsInstreamID struct {
+	service int // 0 for CEA-608 channels
+	channel int // 1-4, CEA-608 only
+}
+
+func parseCaptionsInstreamID(v string) (captionsInstreamID, error) {
+	switch {
+	case strings.HasPrefix(v, "CC"):
+		n, err := strconv.ParseUint(v[len("CC"):], 10, 8)
+		if err != nil || n < 1 || n > 4 {
+			return captionsInstreamID{}, fmt.Errorf("invalid INSTREAM-ID: %v", v)
+		}
+		return captionsInstreamID{channel: int(n)}, nil
+
+	case strings.HasPrefix(v, "SERVICE"):
+		n, err := strconv.ParseUint(v[len("SERVICE"):], 10, 8)
+		if err != nil || n < 1 || n > 63 {
+			return captionsInstreamID{}, fmt.Errorf("invalid INSTREAM-ID: %v", v)
+		}
+		return captionsInstreamID{service: int(n)}, nil
+
+	default:
+		return captionsInstreamID{}, fmt.Errorf("invalid INSTREAM-ID: %v", v)
+	}
+}
+
+// seiPayloads returns the payloads of the messages contained into a SEI NALU.
+func seiPayloads(nalu []byte, headerSize int, cb func(typ int, payload []byte)) {
+	if len(nalu) <= headerSize {
+		return
+	}
+
+	buf := h264.EmulationPreventionRemove(nalu[headerSize:])
+	pos := 0
+
+	readValue := func() (int, bool) {
+		v := 0
+		for {
+			if pos >= len(buf) {
+				return 0, false
+			}
+			b := buf[pos]
+			pos++
+			v += int(b)
+			if b != 0xff {
+				return v, true
+			}
+		}
+	}
+
+	// stop at rbsp_trailing_bits
+	for pos < len(buf) && buf[pos] != 0x80 {
+		typ, ok := readValue()
+		if !ok {
+			return
+		}
+
+		size, ok := readValue()
+		if !ok || (len(buf)-pos) < size {
+			return
+		}
+
+		cb(typ, buf[pos:pos+size])
+		pos += size
+	}
+}
+
+// unmarshalCCData decodes cc_data() contained into a user_data_registered_itu_t_t35 SEI payload.
+// Invalid triplets are discarded.
+func unmarshalCCData(payload []byte) []ccTriplet {
+	if !bytes.HasPrefix(payload, ccUserDataHeader) {
+		return nil
+	}
+	payload = payload[len(ccUserDataHeader):]
+
+	if len(payload) < 2 || (payload[0]&0x40) == 0 { // process_cc_data_flag
+		return nil
+	}
+
+	ccCount := int(payload[0] & 0x1f)
+	payload = payload[2:] // skip em_data
+
+	if len(payload) < ccCount*3 {
+		return nil
+	}
+
+	var ret []ccTriplet
+
+	for i := range ccCount {
+		b := payload[i*3:]
+		if (b[0] & 0x04) == 0 { // cc_valid
+			continue
+		}
+
+		ret = append(ret, ccTriplet{
+			typ:  b[0] & 0x03,
+			data: [2]byte{b[1], b[2]},
+		})
+	}
+
+	return ret
+}
+
+// extractCCData returns closed caption data contained into a H264 or H265 access unit.
+func extractCCData(au [][]byte, isH265 bool) []ccTriplet {
+	var ret []ccTriplet
+
+	onPayload := func(typ int, payload []byte) {
+		if typ == seiPayloadTypeUserDataRegistered {
+			ret = append(ret, unmarshalCCData(payload)...)
+		}
+	}
+
+	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if isH265 {
+			typ := h265.NALUType((nalu[0] >> 1) & 0b111111)
+			if typ == h265.NALUType_PREFIX_SEI_NUT || typ == h265.NALUType_SUFFIX_SEI_NUT {
+				seiPayloads(nalu, 2, onPayload)
+			}
+		} else if h264.NALUType(nalu[0]&0x1f) == h264.NALUTypeSEI {
+			seiPayloads(nalu, 1, onPayload)
+		}
+	}
+
+	return ret
+}
+
+// captionLines splits text into lines that fit into a caption window.
+func captionLines(text string, maxRows int, maxColumns int) [][]rune {
+	var ret [][]rune
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		runes := []rune(line)
+		if len(runes) > maxColumns {
+			runes = runes[:maxColumns]
+		}
+
+		ret = append(ret, runes)
+		if len(ret) == maxRows {
+			break
+		}
+	}
+
+	return ret
+}
+
+// emulationPreventionAdd adds emulation prevention bytes to a NALU payload.
+func emulationPreventionAdd(buf []byte) []byte {
+	ret := make([]byte, 0, len(buf)+len(buf)/2)
+	zeros := 0
+
+	for _, b := range buf {
+		if zeros == 2 && b <= 3 {
+			ret = append(ret, 3)
+			zeros = 0
+		}
+
+		ret = append(ret, b)
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return ret
+}
+
+// marshalCCDataSEI encodes closed caption data into a H264 or H265 SEI NALU.
+func marshalCCDataSEI(data []ccTriplet, isH265 bool) []byte {
+	payload := make([]byte, 0, len(ccUserDataHeader)+3+len(data)*3)
+	payload = append(payload, ccUserDataHeader...)
+	payload = append(payload,
+		0xc0|byte(len(data)), // process_em_data_flag, process_cc_data_flag, cc_count
+		0xff)                 // em_data
+
+	for _, cc := range data {
+		payload = append(payload, 0xfc|cc.typ, cc.data[0], cc.data[1])
+	}
+
+	payload = append(payload, 0xff) // marker_bits
+
+	rbsp := []byte{seiPayloadTypeUserDataRegistered}
+	size := len(payload)
+	for size >= 0xff {
+		rbsp = append(rbsp, 0xff)
+		size -= 0xff
+	}
+	rbsp = append(rbsp, byte(size))
+	rbsp = append(rbsp, payload...)
+	rbsp = append(rbsp, 0x80) // rbsp_trailing_bits
+
+	var header []byte
+	if isH265 {
+		header = []byte{byte(h265.NALUType_PREFIX_SEI_NUT) << 1, 1}
+	} else {
+		header = []byte{byte(h264.NALUTypeSEI)}
+	}
+
+	return append(header, emulationPreventionAdd(rbsp)...)
+}
+
+// insertCCData inserts closed caption data into a H264 or H265 access unit,
+// before the first VCL NALU.
+func insertCCData(au [][]byte, data []ccTriplet, isH265 bool) [][]byte {
+	pos := len(au)
+
+	for i, nalu := range au {
+		if isH265 {
+			if ((nalu[0] >> 1) & 0b111111) < 32 { // VCL NALU
+				pos = i
+				break
+			}
+		} else {
+			typ := h264.NALUType(nalu[0] & 0x1f)
+			if typ >= h264.NALUTypeNonIDR && typ <= h264.NALUTypeIDR {
+				pos = i
+				break
+			}
+		}
+	}
+
+	ret := make([][]byte, 0, len(au)+1)
+	ret = append(ret, au[:pos]...)
+	ret = append(ret, marshalCCDataSEI(data, isH265))
+	ret = append(ret, au[pos:]...)
+
+	return ret
+}
@@ -0,0 +1,463 @@
+package gohlslib
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// CEA-608 line 21 captions, as described in ANSI/CTA-608-E.
+
+const (
+	cea608Rows    = 15
+	cea608Columns = 32
+)
+
+type cea608Mode int
+
+const (
+	cea608ModePopOn cea608Mode = iota
+	cea608ModeRollUp
+	cea608ModePaintOn
+	cea608ModeText
+)
+
+var cea608BasicChars = map[byte]rune{
+	0x2a: 'á',
+	0x5c: 'é',
+	0x5e: 'í',
+	0x5f: 'ó',
+	0x60: 'ú',
+	0x7b: 'ç',
+	0x7c: '÷',
+	0x7d: 'Ñ',
+	0x7e: 'ñ',
+	0x7f: '█',
+}
+
+var cea608SpecialChars = [16]rune{
+	'®', '°', '½', '¿', '™', '¢', '£', '♪',
+	'à', ' ', 'è', 'â', 'ê', 'î', 'ô', 'û',
+}
+
+var cea608ExtendedChars = [2][32]rune{
+	{
+		'Á', 'É', 'Ó', 'Ú', 'Ü', 'ü', '‘', '¡',
+		'*', '’', '—', '©', '℠', '•', '“', '”',
+		'À', 'Â', 'Ç', 'È', 'Ê', 'Ë', 'ë', 'Î',
+		'Ï', 'ï', 'Ô', 'Ù', 'ù', 'Û', '«', '»',
+	},
+	{
+		'Ã', 'ã', 'Í', 'Ì', 'ì', 'Ò', 'ò', 'Õ',
+		'õ', '{', '}', '\\', '^', '_', '|', '~',
+		'Ä', 'ä', 'Ö', 'ö', 'ß', '¥', '¤', '¦',
+		'Å', 'å', 'Ø', 'ø', '┌', '┐', '└', '┘',
+	},
+}
+
+// first bytes of preamble address codes, sorted by row.
+var cea608PACRows = [cea608Rows]struct {
+	b1   byte
+	high bool
+}{
+	{0x11, false}, {0x11, true}, {0x12, false}, {0x12, true},
+	{0x15, false}, {0x15, true}, {0x16, false}, {0x16, true},
+	{0x17, false}, {0x17, true}, {0x10, false}, {0x13, false},
+	{0x13, true}, {0x14, false}, {0x14, true},
+}
+
+// cea608CharCodes maps characters to their codes.
+// Basic characters have a zero first byte.
+var cea608CharCodes = func() map[rune][2]byte {
+	ret := make(map[rune][2]byte)
+
+	for i, r := range cea608ExtendedChars[1] {
+		ret[r] = [2]byte{0x13, 0x20 + byte(i)}
+	}
+	for i, r := range cea608ExtendedChars[0] {
+		ret[r] = [2]byte{0x12, 0x20 + byte(i)}
+	}
+	for i, r := range cea608SpecialChars {
+		ret[r] = [2]byte{0x11, 0x30 + byte(i)}
+	}
+	for b := byte(0x20); b < 0x80; b++ {
+		ret[cea608BasicChar(b)] = [2]byte{0, b}
+	}
+
+	return ret
+}()
+
+func cea608PACRow(b1 byte, b2 byte) (int, bool) {
+	high := b2 >= 0x60
+	for i, r := range cea608PACRows {
+		if r.b1 == b1 && r.high == high {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+type cea608Memory [cea608Rows][cea608Columns]rune
+
+func (m *cea608Memory) text() string {
+	var rows []string
+
+	for _, row := range m {
+		var b strings.Builder
+		for _, r := range row {
+			if r == 0 {
+				r = ' '
+			}
+			b.WriteRune(r)
+		}
+
+		s := strings.TrimSpace(b.String())
+		if s != "" {
+			rows = append(rows, s)
+		}
+	}
+
+	return strings.Join(rows, "\n")
+}
+
+// cea608Channel is the state of a CEA-608 data channel (CC1-CC4).
+type cea608Channel struct {
+	mode       cea608Mode
+	displayed  cea608Memory
+	hidden     cea608Memory
+	row        int
+	col        int
+	rollUpRows int
+	lastText   string
+}
+
+func (c *cea608Channel) initialize() {
+	c.row = cea608Rows - 1
+}
+
+func (c *cea608Channel) memory() *cea608Memory {
+	if c.mode == cea608ModePopOn {
+		return &c.hidden
+	}
+	return &c.displayed
+}
+
+func (c *cea608Channel) writeChar(r rune) {
+	if c.mode == cea608ModeText {
+		return
+	}
+
+	c.memory()[c.row][c.col] = r
+	if c.col < (cea608Columns - 1) {
+		c.col++
+	}
+}
+
+func (c *cea608Channel) backspace() {
+	if c.mode == cea608ModeText {
+		return
+	}
+
+	if c.col > 0 {
+		c.col--
+	}
+	c.memory()[c.row][c.col] = 0
+}
+
+func (c *cea608Channel) setMode(mode cea608Mode) {
+	if mode == cea608ModeRollUp && c.mode != cea608ModeRollUp {
+		c.displayed = cea608Memory{}
+		c.hidden = cea608Memory{}
+		c.row = cea608Rows - 1
+		c.col = 0
+	}
+	c.mode = mode
+}
+
+func (c *cea608Channel) carriageReturn() {
+	if c.mode != cea608ModeRollUp {
+		if c.row < (cea608Rows - 1) {
+			c.row++
+		}
+		c.col = 0
+		return
+	}
+
+	top := max(0, c.row-c.rollUpRows+1)
+	for i := range c.displayed {
+		switch {
+		case i < top || i > c.row:
+			c.displayed[i] = [cea608Columns]rune{}
+		case i < c.row:
+			c.displayed[i] = c.displayed[i+1]
+		}
+	}
+	c.displayed[c.row] = [cea608Columns]rune{}
+	c.col = 0
+}
+
+func (c *cea608Channel) miscControl(b2 byte) {
+	switch b2 {
+	case 0x20: // RCL, resume caption loading
+		c.setMode(cea608ModePopOn)
+
+	case 0x21: // BS, backspace
+		c.backspace()
+
+	case 0x24: // DER, delete to end of row
+		if c.mode != cea608ModeText {
+			for i := c.col; i < cea608Columns; i++ {
+				c.memory()[c.row][i] = 0
+			}
+		}
+
+	case 0x25, 0x26, 0x27: // RU2, RU3, RU4, roll-up captions
+		c.setMode(cea608ModeRollUp)
+		c.rollUpRows = int(b2-0x25) + 2
+
+	case 0x29: // RDC, resume direct captioning
+		c.setMode(cea608ModePaintOn)
+
+	case 0x2a, 0x2b: // TR, text restart, RTD, resume text display
+		c.mode = cea608ModeText
+
+	case 0x2c: // EDM, erase displayed memory
+		c.displayed = cea608Memory{}
+
+	case 0x2d: // CR, carriage return
+		if c.mode != cea608ModeText {
+			c.carriageReturn()
+		}
+
+	case 0x2e: // ENM, erase non-displayed memory
+		c.hidden = cea608Memory{}
+
+	case 0x2f: // EOC, end of caption
+		c.displayed, c.hidden = c.hidden, c.displayed
+		c.mode = cea608ModePopOn
+	}
+}
+
+func (c *cea608Channel) preambleAddress(row int, b2 byte) {
+	if c.mode == cea608ModeText {
+		return
+	}
+
+	if c.mode == cea608ModeRollUp && row != c.row {
+		// move the roll-up window to the new base row
+		var tmp cea608Memory
+		for i := range c.rollUpRows {
+			src := c.row - i
+			dst := row - i
+			if src >= 0 && dst >= 0 {
+				tmp[dst] = c.displayed[src]
+			}
+		}
+		c.displayed = tmp
+	}
+
+	c.row = row
+	c.col = 0
+
+	if (b2 & 0x10) != 0 { // indent
+		c.col = int((b2&0x0e)>>1) * 4
+	}
+}
+
+// cea608Decoder decodes a CEA-608 field, that contains two data channels.
+type cea608Decoder struct {
+	firstChannel int // 1 for field 1, 3 for field 2
+	onText       func(channel int, text string)
+
+	channels    [2]*cea608Channel
+	cur         int
+	lastControl [2]byte
+	xds         bool
+}
+
+func (d *cea608Decoder) initialize() {
+	for i := range d.channels {
+		d.channels[i] = &cea608Channel{}
+		d.channels[i].initialize()
+	}
+}
+
+func (d *cea608Decoder) decode(data [2]byte) {
+	b1 := data[0] & 0x7f // strip parity
+	b2 := data[1] & 0x7f
+
+	if b1 == 0 && b2 == 0 {
+		return
+	}
+
+	// control codes are usually transmitted twice
+	if b1 >= 0x10 && b1 <= 0x1f {
+		if d.lastControl == [2]byte{b1, b2} {
+			d.lastControl = [2]byte{}
+			return
+		}
+		d.lastControl = [2]byte{b1, b2}
+	} else {
+		d.lastControl = [2]byte{}
+	}
+
+	switch {
+	case b1 >= 0x01 && b1 <= 0x0f: // extended data services
+		d.xds = (b1 != 0x0f)
+		return
+
+	case b1 >= 0x10 && b1 <= 0x1f:
+		d.xds = false
+		if (b1 & 0x08) != 0 {
+			d.cur = 1
+		} else {
+			d.cur = 0
+		}
+		d.control(d.channels[d.cur], b1&0xf7, b2)
+
+	default:
+		if d.xds {
+			return
+		}
+		ch := d.channels[d.cur]
+		ch.writeChar(cea608BasicChar(b1))
+		if b2 >= 0x20 {
+			ch.writeChar(cea608BasicChar(b2))
+		}
+	}
+
+	d.emit(d.cur)
+}
+
+func (d *cea608Decoder) control(ch *cea608Channel, b1 byte, b2 byte) {
+	switch {
+	case b2 >= 0x40:
+		if row, ok := cea608PACRow(b1, b2); ok {
+			ch.preambleAddress(row, b2)
+		}
+
+	case (b1 == 0x14 || b1 == 0x15) && b2 >= 0x20 && b2 <= 0x2f:
+		ch.miscControl(b2)
+
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23: // tab offsets
+		if ch.mode != cea608ModeText {
+			ch.col = min(cea608Columns-1, ch.col+int(b2-0x20))
+		}
+
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2f: // mid-row codes
+		ch.writeChar(' ')
+
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3f:
+		ch.writeChar(cea608SpecialChars[b2-0x30])
+
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3f:
+		// extended characters replace the preceding standard character
+		ch.backspace()
+		ch.writeChar(cea608ExtendedChars[b1-0x12][b2-0x20])
+	}
+}
+
+func (d *cea608Decoder) emit(i int) {
+	ch := d.channels[i]
+
+	text := ch.displayed.text()
+	if text != ch.lastText {
+		ch.lastText = text
+		d.onText(d.firstChannel+i, text)
+	}
+}
+
+func cea608BasicChar(b byte) rune {
+	if r, ok := cea608BasicChars[b]; ok {
+		return r
+	}
+	return rune(b)
+}
+
+func cea608Parity(b byte) byte {
+	if (bits.OnesCount8(b) % 2) == 0 {
+		return b | 0x80
+	}
+	return b
+}
+
+// cea608Encode encodes text into pop-on captions of a data channel (1-4).
+// An empty text clears captions.
+func cea608Encode(channel int, text string) []ccTriplet {
+	field := (channel - 1) / 2
+	channelBit := byte((channel-1)%2) * 0x08
+	misc := byte(0x14+field) | channelBit
+
+	var ret []ccTriplet
+	var pending []byte
+
+	writePair := func(b1 byte, b2 byte) {
+		ret = append(ret, ccTriplet{
+			typ:  uint8(field),
+			data: [2]byte{cea608Parity(b1), cea608Parity(b2)},
+		})
+	}
+
+	flush := func() {
+		if len(pending) != 0 {
+			writePair(pending[0], 0)
+			pending = nil
+		}
+	}
+
+	writeChar := func(b byte) {
+		pending = append(pending, b)
+		if len(pending) == 2 {
+			writePair(pending[0], pending[1])
+			pending = nil
+		}
+	}
+
+	// control codes are transmitted twice
+	writeControl := func(b1 byte, b2 byte) {
+		flush()
+		writePair(b1|channelBit, b2)
+		writePair(b1|channelBit, b2)
+	}
+
+	lines := captionLines(text, 4, cea608Columns)
+	if len(lines) == 0 {
+		writeControl(misc, 0x2c) // EDM
+		return ret
+	}
+
+	writeControl(misc, 0x20) // RCL
+	writeControl(misc, 0x2e) // ENM
+
+	for i, line := range lines {
+		pac := cea608PACRows[cea608Rows-len(lines)+i]
+		if pac.high {
+			writeControl(pac.b1, 0x60)
+		} else {
+			writeControl(pac.b1, 0x40)
+		}
+
+		for _, r := range line {
+			code, ok := cea608CharCodes[r]
+			switch {
+			case !ok:
+				writeChar('?')
+
+			case code[0] == 0:
+				writeChar(code[1])
+
+			case code[0] == 0x11:
+				writeControl(code[0], code[1])
+
+			default:
+				// extended characters replace a standard one,
+				// that is displayed by decoders that don't support them
+				writeChar('?')
+				writeControl(code[0], code[1])
+			}
+		}
+	}
+
+	writeControl(misc, 0x2f) // EOC
+
+	return ret
+}
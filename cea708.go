@@ -0,0 +1,560 @@
+package gohlslib
+
+import (
+	"strings"
+)
+
+// CEA-708 digital television closed captions, as described in ANSI/CTA-708-E.
+// Windows are decoded into plain text; positioning, pens and styles are ignored.
+
+const (
+	cea708MaxRows    = 15
+	cea708MaxColumns = 42
+)
+
+var cea708G2Chars = map[byte]rune{
+	0x20: ' ',
+	0x21: ' ',
+	0x25: '…',
+	0x2a: 'Š',
+	0x2c: 'Œ',
+	0x30: '█',
+	0x31: '‘',
+	0x32: '’',
+	0x33: '“',
+	0x34: '”',
+	0x35: '•',
+	0x39: '™',
+	0x3a: 'š',
+	0x3c: 'œ',
+	0x3d: '℠',
+	0x3f: 'Ÿ',
+	0x76: '⅛',
+	0x77: '⅜',
+	0x78: '⅝',
+	0x79: '⅞',
+	0x7a: '│',
+	0x7b: '┐',
+	0x7c: '└',
+	0x7d: '─',
+	0x7e: '┘',
+	0x7f: '┌',
+}
+
+// cea708G2Codes maps characters to their codes in the G2 code set.
+var cea708G2Codes = func() map[rune]byte {
+	ret := make(map[rune]byte)
+	for b, r := range cea708G2Chars {
+		if r != ' ' {
+			ret[r] = b
+		}
+	}
+	return ret
+}()
+
+type cea708Window struct {
+	visible bool
+	rows    [][]rune
+	columns int
+	row     int
+	col     int
+}
+
+func (w *cea708Window) clear() {
+	for i := range w.rows {
+		w.rows[i] = nil
+	}
+	w.row = 0
+	w.col = 0
+}
+
+func (w *cea708Window) writeChar(r rune) {
+	if w.col >= w.columns {
+		return
+	}
+
+	for len(w.rows[w.row]) <= w.col {
+		w.rows[w.row] = append(w.rows[w.row], ' ')
+	}
+	w.rows[w.row][w.col] = r
+	w.col++
+}
+
+func (w *cea708Window) backspace() {
+	if w.col > 0 {
+		w.col--
+		if w.col < len(w.rows[w.row]) {
+			w.rows[w.row][w.col] = ' '
+		}
+	}
+}
+
+func (w *cea708Window) carriageReturn() {
+	w.col = 0
+
+	if w.row < (len(w.rows) - 1) {
+		w.row++
+		return
+	}
+
+	// scroll up
+	copy(w.rows, w.rows[1:])
+	w.rows[len(w.rows)-1] = nil
+}
+
+func (w *cea708Window) text() []string {
+	var ret []string
+	for _, row := range w.rows {
+		s := strings.TrimSpace(string(row))
+		if s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// cea708Service is the state of a caption service (SERVICE1-SERVICE63).
+type cea708Service struct {
+	windows  [8]*cea708Window
+	cur      int
+	lastText string
+}
+
+func (s *cea708Service) window() *cea708Window {
+	return s.windows[s.cur]
+}
+
+func (s *cea708Service) writeChar(r rune) {
+	if w := s.window(); w != nil {
+		w.writeChar(r)
+	}
+}
+
+func (s *cea708Service) forEachWindow(bitmap byte, cb func(i int, w *cea708Window)) {
+	for i, w := range s.windows {
+		if w != nil && (bitmap&(1<<i)) != 0 {
+			cb(i, w)
+		}
+	}
+}
+
+func (s *cea708Service) defineWindow(id int, params []byte) {
+	rows := min(cea708MaxRows, int(params[3]&0x0f)+1)
+	columns := min(cea708MaxColumns, int(params[4]&0x3f)+1)
+
+	w := s.windows[id]
+	if w == nil {
+		w = &cea708Window{}
+		s.windows[id] = w
+	}
+
+	w.visible = (params[0] & 0x20) != 0
+	w.columns = columns
+
+	// keep existing content when a window is redefined
+	if len(w.rows) != rows {
+		tmp := make([][]rune, rows)
+		copy(tmp, w.rows)
+		w.rows = tmp
+		w.row = min(w.row, rows-1)
+	}
+
+	s.cur = id
+}
+
+// decode decodes a service block.
+func (s *cea708Service) decode(buf []byte) {
+	for len(buf) > 0 {
+		b := buf[0]
+		n := 1
+
+		switch {
+		case b == 0x08: // BS, backspace
+			if w := s.window(); w != nil {
+				w.backspace()
+			}
+
+		case b == 0x0c: // FF, form feed
+			if w := s.window(); w != nil {
+				w.clear()
+			}
+
+		case b == 0x0d: // CR, carriage return
+			if w := s.window(); w != nil {
+				w.carriageReturn()
+			}
+
+		case b == 0x0e: // HCR, horizontal carriage return
+			if w := s.window(); w != nil {
+				w.rows[w.row] = nil
+				w.col = 0
+			}
+
+		case b == 0x10: // EXT1
+			n = s.decodeExtended(buf)
+
+		case b == 0x18: // P16, 16-bit character
+			if len(buf) >= 3 {
+				s.writeChar(rune(uint16(buf[1])<<8 | uint16(buf[2])))
+			}
+			n = 3
+
+		case b >= 0x11 && b <= 0x17:
+			n = 2
+
+		case b >= 0x19 && b <= 0x1f:
+			n = 3
+
+		case b < 0x20: // other C0 codes
+			n = 1
+
+		case b == 0x7f:
+			s.writeChar('♪')
+
+		case b < 0x7f: // G0
+			s.writeChar(rune(b))
+
+		case b >= 0x80 && b <= 0x87: // CW0-CW7, set current window
+			if s.windows[b-0x80] != nil {
+				s.cur = int(b - 0x80)
+			}
+
+		case b >= 0x88 && b <= 0x8c:
+			n = 2
+			if len(buf) >= 2 {
+				s.windowCommand(b, buf[1])
+			}
+
+		case b == 0x8d: // DLY, delay
+			n = 2
+
+		case b == 0x8f: // RST, reset
+			s.windows = [8]*cea708Window{}
+			s.cur = 0
+
+		case b == 0x90, b == 0x92: // SPA, set pen attributes, SPL, set pen location
+			n = 3
+			if b == 0x92 && len(buf) >= 3 {
+				if w := s.window(); w != nil {
+					w.row = min(len(w.rows)-1, int(buf[1]&0x0f))
+					w.col = min(w.columns-1, int(buf[2]&0x3f))
+				}
+			}
+
+		case b == 0x91: // SPC, set pen color
+			n = 4
+
+		case b == 0x97: // SWA, set window attributes
+			n = 5
+
+		case b >= 0x98 && b <= 0x9f: // DF0-DF7, define window
+			n = 7
+			if len(buf) >= 7 {
+				s.defineWindow(int(b-0x98), buf[1:7])
+			}
+
+		case b >= 0xa0: // G1
+			s.writeChar(rune(b))
+		}
+
+		if n > len(buf) {
+			return
+		}
+		buf = buf[n:]
+	}
+}
+
+func (s *cea708Service) windowCommand(cmd byte, bitmap byte) {
+	switch cmd {
+	case 0x88: // CLW, clear windows
+		s.forEachWindow(bitmap, func(_ int, w *cea708Window) {
+			w.clear()
+		})
+
+	case 0x89: // DSW, display windows
+		s.forEachWindow(bitmap, func(_ int, w *cea708Window) {
+			w.visible = true
+		})
+
+	case 0x8a: // HDW, hide windows
+		s.forEachWindow(bitmap, func(_ int, w *cea708Window) {
+			w.visible = false
+		})
+
+	case 0x8b: // TGW, toggle windows
+		s.forEachWindow(bitmap, func(_ int, w *cea708Window) {
+			w.visible = !w.visible
+		})
+
+	case 0x8c: // DLW, delete windows
+		s.forEachWindow(bitmap, func(i int, _ *cea708Window) {
+			s.windows[i] = nil
+		})
+	}
+}
+
+// decodeExtended decodes a code of the extended code space and returns its size.
+func (s *cea708Service) decodeExtended(buf []byte) int {
+	if len(buf) < 2 {
+		return 2
+	}
+
+	b := buf[1]
+
+	switch {
+	case b < 0x08: // C2
+		return 2
+	case b < 0x10:
+		return 3
+	case b < 0x18:
+		return 4
+	case b < 0x20:
+		return 5
+
+	case b < 0x80: // G2
+		if r, ok := cea708G2Chars[b]; ok {
+			s.writeChar(r)
+		}
+		return 2
+
+	case b < 0x88: // C3
+		return 6
+	case b < 0x90:
+		return 7
+	case b < 0xa0: // variable length
+		if len(buf) < 3 {
+			return 3
+		}
+		return 3 + int(buf[2]&0x3f)
+
+	default: // G3
+		return 2
+	}
+}
+
+func (s *cea708Service) text() string {
+	var rows []string
+	for _, w := range s.windows {
+		if w != nil && w.visible {
+			rows = append(rows, w.text()...)
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// cea708Decoder reassembles DTVCC packets and decodes the services they contain.
+type cea708Decoder struct {
+	onText func(service int, text string)
+
+	packet     []byte
+	packetSize int
+	services   map[int]*cea708Service
+}
+
+func (d *cea708Decoder) initialize() {
+	d.services = make(map[int]*cea708Service)
+}
+
+func (d *cea708Decoder) decode(cc ccTriplet) {
+	if cc.typ == ccTypeDTVCCHeader {
+		if d.packet != nil {
+			d.decodePacket(d.packet)
+		}
+
+		d.packetSize = int(cc.data[0]&0x3f) * 2
+		if d.packetSize == 0 {
+			d.packetSize = 128
+		}
+		d.packetSize-- // exclude the header
+		d.packet = append(make([]byte, 0, d.packetSize+1), cc.data[1])
+	} else {
+		if d.packet == nil {
+			return
+		}
+		d.packet = append(d.packet, cc.data[0], cc.data[1])
+	}
+
+	if len(d.packet) >= d.packetSize {
+		d.decodePacket(d.packet[:d.packetSize])
+		d.packet = nil
+	}
+}
+
+func (d *cea708Decoder) decodePacket(buf []byte) {
+	for len(buf) > 0 {
+		serviceNumber := int(buf[0] >> 5)
+		blockSize := int(buf[0] & 0x1f)
+		buf = buf[1:]
+
+		if serviceNumber == 0 { // null service
+			return
+		}
+
+		if serviceNumber == 7 && blockSize != 0 { // extended service number
+			if len(buf) < 1 {
+				return
+			}
+			serviceNumber = int(buf[0] & 0x3f)
+			buf = buf[1:]
+		}
+
+		if len(buf) < blockSize {
+			return
+		}
+
+		s, ok := d.services[serviceNumber]
+		if !ok {
+			s = &cea708Service{}
+			d.services[serviceNumber] = s
+		}
+
+		s.decode(buf[:blockSize])
+		buf = buf[blockSize:]
+
+		text := s.text()
+		if text != s.lastText {
+			s.lastText = text
+			d.onText(serviceNumber, text)
+		}
+	}
+}
+
+// cea708Encoder encodes text into DTVCC packets.
+// Each text is loaded into a hidden window, that is then displayed in place of the previous one.
+type cea708Encoder struct {
+	sequence  byte
+	curWindow map[int]int
+}
+
+func (e *cea708Encoder) initialize() {
+	e.curWindow = make(map[int]int)
+}
+
+func cea708EncodeChar(r rune) []byte {
+	switch {
+	case r == '♪':
+		return []byte{0x7f}
+
+	case (r >= 0x20 && r < 0x7f) || (r >= 0xa0 && r <= 0xff): // G0, G1
+		return []byte{byte(r)}
+	}
+
+	if b, ok := cea708G2Codes[r]; ok {
+		return []byte{0x10, b} // EXT1
+	}
+
+	if r <= 0xffff {
+		return []byte{0x18, byte(r >> 8), byte(r)} // P16
+	}
+
+	return []byte{'?'}
+}
+
+// encode encodes text into a caption service (1-63).
+// An empty text clears captions.
+func (e *cea708Encoder) encode(service int, text string) []ccTriplet {
+	cur := e.curWindow[service]
+
+	// each command is kept into a single service block
+	var cmds [][]byte
+
+	lines := captionLines(text, 4, 32)
+
+	if len(lines) == 0 {
+		cmds = append(cmds, []byte{0x8a, 0x03}) // HDW
+	} else {
+		next := 1 - cur
+
+		columns := 0
+		for _, line := range lines {
+			columns = max(columns, len(line))
+		}
+
+		cmds = append(cmds,
+			[]byte{
+				0x98 + byte(next),         // DFx
+				0x00,                      // hidden, priority 0
+				0x80 | 99,                 // relative positioning, anchor vertical
+				50,                        // anchor horizontal
+				0x70 | byte(len(lines)-1), // anchor point (bottom center), row count
+				byte(columns - 1),         // column count
+				0x00,                      // default window and pen styles
+			},
+			[]byte{0x88, 1 << next}) // CLW
+
+		for i, line := range lines {
+			if i != 0 {
+				cmds = append(cmds, []byte{0x0d}) // CR
+			}
+			for _, r := range line {
+				cmds = append(cmds, cea708EncodeChar(r))
+			}
+		}
+
+		cmds = append(cmds, []byte{
+			0x89, 1 << next, // DSW
+			0x8a, 1 << cur, // HDW
+		})
+
+		e.curWindow[service] = next
+	}
+
+	var ret []ccTriplet
+
+	for len(cmds) > 0 {
+		var packet []byte
+
+		for len(cmds) > 0 {
+			var block []byte
+			for len(cmds) > 0 && (len(block)+len(cmds[0])) <= 31 {
+				block = append(block, cmds[0]...)
+				cmds = cmds[1:]
+			}
+
+			var header []byte
+			if service < 7 {
+				header = []byte{byte(service<<5) | byte(len(block))}
+			} else {
+				header = []byte{0xe0 | byte(len(block)), byte(service)}
+			}
+
+			// packets are up to 128 bytes long, header included
+			if (1 + len(packet) + len(header) + len(block)) > 128 {
+				cmds = append([][]byte{block}, cmds...)
+				break
+			}
+
+			packet = append(packet, header...)
+			packet = append(packet, block...)
+		}
+
+		ret = append(ret, e.marshalPacket(packet)...)
+	}
+
+	return ret
+}
+
+func (e *cea708Encoder) marshalPacket(packet []byte) []ccTriplet {
+	// packet size is a multiple of 2
+	if (len(packet) % 2) == 0 {
+		packet = append(packet, 0x00) // null service block
+	}
+
+	sizeCode := ((len(packet) + 1) / 2) & 0x3f
+	header := (e.sequence << 6) | byte(sizeCode)
+	e.sequence = (e.sequence + 1) % 4
+
+	ret := []ccTriplet{{
+		typ:  ccTypeDTVCCHeader,
+		data: [2]byte{header, packet[0]},
+	}}
+
+	for i := 1; i < len(packet); i += 2 {
+		ret = append(ret, ccTriplet{
+			typ:  ccTypeDTVCCData,
+			data: [2]byte{packet[i], packet[i+1]},
+		})
+	}
+
+	return ret
+}
@@ -14,6 +14,9 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
 const (
@@ -24,6 +27,7 @@ const (
 	clientMaxInboundPlaylistSize = 1 * 1024 * 1024
 	clientMaxInboundSegmentSize  = 100 * 1024 * 1024
 	clientMaxInboundPartSize     = 10 * 1024 * 1024
+	clientMaxInboundKeySize      = 1024
 )
 
 // ErrClientEOS is returned by Wait() when the stream has ended.
@@ -41,6 +45,27 @@ type ClientOnDownloadSegmentFunc func(url string)
 // ClientOnDownloadPartFunc is the prototype of Client.OnDownloadPart.
 type ClientOnDownloadPartFunc func(url string)
 
+// ClientOnSelectVariantFunc is the prototype of Client.OnSelectVariant.
+type ClientOnSelectVariantFunc func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant
+
+// ClientOnSessionDataFunc is the prototype of Client.OnSessionData.
+type ClientOnSessionDataFunc func(data []*playlist.MultivariantSessionData, keys []*playlist.MultivariantSessionKey)
+
+// ClientOnVariantSwitchFunc is the prototype of Client.OnVariantSwitch.
+type ClientOnVariantSwitchFunc func(prev *playlist.MultivariantVariant, next *playlist.MultivariantVariant)
+
+// ClientOnDiscontinuityFunc is the prototype of Client.OnDiscontinuity.
+type ClientOnDiscontinuityFunc func()
+
+// ClientOnDateRangeFunc is the prototype of Client.OnDateRange.
+type ClientOnDateRangeFunc func(dr *playlist.MediaDateRange)
+
+// ClientOnSCTE35Func is the prototype of Client.OnSCTE35.
+type ClientOnSCTE35Func func(pts int64, section []byte)
+
+// ClientKeyResolverFunc is the prototype of Client.KeyResolver.
+type ClientKeyResolverFunc func(uri string, key *playlist.MediaKey, kid []byte) ([]byte, error)
+
 // ClientOnDecodeErrorFunc is the prototype of Client.OnDecodeError.
 type ClientOnDecodeErrorFunc func(err error)
 
@@ -59,18 +84,42 @@ type ClientOnDataVP9Func func(pts int64, frame []byte)
 // ClientOnDataH26xFunc is the prototype of the function passed to OnDataH26x().
 type ClientOnDataH26xFunc func(pts int64, dts int64, au [][]byte)
 
+// ClientOnDataMPEGxVideoFunc is the prototype of the function passed to OnDataMPEGxVideo().
+type ClientOnDataMPEGxVideoFunc func(pts int64, frame []byte)
+
 // ClientOnDataMPEG4AudioFunc is the prototype of the function passed to OnDataMPEG4Audio().
 type ClientOnDataMPEG4AudioFunc func(pts int64, aus [][]byte)
 
+// ClientOnDataMPEG4AudioLATMFunc is the prototype of the function passed to OnDataMPEG4AudioLATM().
+type ClientOnDataMPEG4AudioLATMFunc func(pts int64, els [][]byte)
+
 // ClientOnDataOpusFunc is the prototype of the function passed to OnDataOpus().
 type ClientOnDataOpusFunc func(pts int64, packets [][]byte)
 
+// ClientOnDataMPEG1AudioFunc is the prototype of the function passed to OnDataMPEG1Audio().
+type ClientOnDataMPEG1AudioFunc func(pts int64, frames [][]byte)
+
 // ClientOnDataFLACFunc is the prototype of the function passed to OnDataFLAC().
 type ClientOnDataFLACFunc func(pts int64, frame []byte)
 
+// ClientOnDataAC3Func is the prototype of the function passed to OnDataAC3().
+type ClientOnDataAC3Func func(pts int64, frame []byte)
+
+// ClientOnDataEAC3Func is the prototype of the function passed to OnDataEAC3().
+type ClientOnDataEAC3Func func(pts int64, frame []byte)
+
 // ClientOnDataKLVFunc is the prototype of the function passed to OnDataKLV().
 type ClientOnDataKLVFunc func(pts int64, uni []byte)
 
+// ClientOnDataID3Func is the prototype of the function passed to OnDataID3().
+type ClientOnDataID3Func func(pts int64, tag []byte)
+
+// ClientOnDataCaptionsFunc is the prototype of the function passed to OnDataCaptions().
+type ClientOnDataCaptionsFunc func(pts int64, text string)
+
+// ClientOnDataSubtitleFunc is the prototype of the function passed to OnDataSubtitle().
+type ClientOnDataSubtitleFunc func(pts int64, end int64, text string)
+
 func clientAbsoluteURL(base *url.URL, relative string) (*url.URL, error) {
 	u, err := url.Parse(relative)
 	if err != nil {
@@ -94,9 +143,35 @@ type Client struct {
 	// expressed as number of segments.
 	// It defaults to 5.
 	MaxDistance int
+	// Deliver samples as fast as possible, without synchronizing them with the system clock.
+	// It is meant to archive or transcode VOD streams.
+	// It defaults to false.
+	DisablePacing bool
+	// Number of segments that are downloaded in parallel.
+	// It is used only when DisablePacing is true.
+	// It defaults to 1.
+	DownloadConcurrency int
 	// HTTP client.
 	// It defaults to a new http.Client with cookies enabled.
 	HTTPClient *http.Client
+	// Adaptive bitrate policy.
+	// When set, the client switches between variants of a multivariant playlist
+	// at segment boundaries (part boundaries with Low-Latency streams),
+	// depending on the estimated download bandwidth.
+	// Switching is performed only between variants with compatible codecs.
+	// It defaults to nil, that disables switching.
+	ABRPolicy ClientABRPolicy
+	// Function that returns the decryption key of segments.
+	// It receives the absolute URI of the key, the EXT-X-KEY tag and,
+	// in case of fMP4 segments encrypted with SAMPLE-AES, the key ID (KID) of the track.
+	// It is required when KEYFORMAT is not "identity".
+	// It defaults to nil, that downloads keys with HTTPClient.
+	KeyResolver ClientKeyResolverFunc
+	// Languages of subtitle renditions to read, in RFC 5646 format.
+	// A language without subtags matches all its variants ("en" matches "en-US").
+	// Only WebVTT subtitles in plain (non-fMP4) segments are supported.
+	// It defaults to nil, that disables subtitles.
+	SubtitleLanguages []string
 
 	//
 	// callbacks (all optional)
@@ -105,6 +180,14 @@ type Client struct {
 	OnRequest ClientOnRequestFunc
 	// called when tracks are available.
 	OnTracks ClientOnTracksFunc
+	// called when a multivariant playlist is received, in order to select the initial variant.
+	// It receives variants with supported codecs and must return one of them.
+	// It defaults to a function that selects the variant with the greatest bandwidth.
+	OnSelectVariant ClientOnSelectVariantFunc
+	// called when a multivariant playlist is received, before OnTracks.
+	// It receives EXT-X-SESSION-DATA and EXT-X-SESSION-KEY tags of the playlist.
+	// URIs are resolved against the URL of the multivariant playlist.
+	OnSessionData ClientOnSessionDataFunc
 	// called before downloading a primary playlist.
 	OnDownloadPrimaryPlaylist ClientOnDownloadPrimaryPlaylistFunc
 	// called before downloading a stream playlist.
@@ -115,6 +198,20 @@ type Client struct {
 	OnDownloadPart ClientOnDownloadPartFunc
 	// called when a non-fatal decode error occurs.
 	OnDecodeError ClientOnDecodeErrorFunc
+	// called when the client switches to another variant.
+	OnVariantSwitch ClientOnVariantSwitchFunc
+	// called after a seek, before passing data of the new position to OnData callbacks.
+	OnDiscontinuity ClientOnDiscontinuityFunc
+	// called when playback of the leading track reaches the START-DATE of a EXT-X-DATERANGE tag.
+	// Tags with the same ID are merged together. A date range is passed again when its attributes change.
+	// Date ranges that ended before the playback position are not passed.
+	// AbsoluteTime() of the leading track can be used to correlate the date range with playback.
+	OnDateRange ClientOnDateRangeFunc
+	// called when playback of the leading track reaches the splice time of a SCTE-35
+	// splice_info_section() carried by MPEG-TS segments.
+	// pts is the splice time, expressed in 90kHz units and sharing the time base of the leading track.
+	// When the section does not specify a splice time, pts is the one of the first sample of its segment.
+	OnSCTE35 ClientOnSCTE35Func
 
 	//
 	// private
@@ -124,6 +221,9 @@ type Client struct {
 	ctxCancel         func()
 	playlistURL       *url.URL
 	primaryDownloader *clientPrimaryDownloader
+	variantSwitcher   *clientVariantSwitcher
+	seeker            *clientSeeker
+	cueScheduler      *clientCueScheduler
 	timeConv          clientTimeConv
 	tracks            map[*Track]*clientTrack
 	closeError        error
@@ -141,6 +241,9 @@ func (c *Client) Start() error {
 	if c.MaxDistance == 0 {
 		c.MaxDistance = 5
 	}
+	if c.DownloadConcurrency == 0 || !c.DisablePacing {
+		c.DownloadConcurrency = 1
+	}
 	if c.HTTPClient == nil {
 		jar, _ := cookiejar.New(nil)
 		c.HTTPClient = &http.Client{
@@ -155,6 +258,12 @@ func (c *Client) Start() error {
 			return nil
 		}
 	}
+	if c.OnSelectVariant == nil {
+		c.OnSelectVariant = pickLeadingPlaylist
+	}
+	if c.OnSessionData == nil {
+		c.OnSessionData = func(_ []*playlist.MultivariantSessionData, _ []*playlist.MultivariantSessionKey) {}
+	}
 	if c.OnDownloadPrimaryPlaylist == nil {
 		c.OnDownloadPrimaryPlaylist = func(u string) {
 			log.Printf("downloading primary playlist %v", u)
@@ -180,6 +289,14 @@ func (c *Client) Start() error {
 			log.Println(err.Error())
 		}
 	}
+	if c.OnVariantSwitch == nil {
+		c.OnVariantSwitch = func(_ *playlist.MultivariantVariant, next *playlist.MultivariantVariant) {
+			log.Printf("switching to variant %v", next.URI)
+		}
+	}
+	if c.OnDiscontinuity == nil {
+		c.OnDiscontinuity = func() {}
+	}
 
 	var err error
 	c.playlistURL, err = url.Parse(c.URI)
@@ -187,6 +304,23 @@ func (c *Client) Start() error {
 		return err
 	}
 
+	c.variantSwitcher = &clientVariantSwitcher{
+		policy:          c.ABRPolicy,
+		onVariantSwitch: c.OnVariantSwitch,
+	}
+	c.variantSwitcher.initialize()
+
+	c.seeker = &clientSeeker{
+		onDiscontinuity: c.OnDiscontinuity,
+	}
+	c.seeker.initialize()
+
+	c.cueScheduler = &clientCueScheduler{
+		onDateRange: c.OnDateRange,
+		onSCTE35:    c.OnSCTE35,
+	}
+	c.cueScheduler.initialize()
+
 	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
 
 	c.done = make(chan struct{})
@@ -243,6 +377,13 @@ func (c *Client) OnDataH26x(track *Track, cb ClientOnDataH26xFunc) {
 	}
 }
 
+// OnDataMPEGxVideo sets a callback that is called when data from a MPEG-4 Video or MPEG-1/2 Video track is received.
+func (c *Client) OnDataMPEGxVideo(track *Track, cb ClientOnDataMPEGxVideoFunc) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data[0])
+	}
+}
+
 // OnDataMPEG4Audio sets a callback that is called when data from a MPEG-4 Audio track is received.
 func (c *Client) OnDataMPEG4Audio(track *Track, cb ClientOnDataMPEG4AudioFunc) {
 	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
@@ -250,6 +391,13 @@ func (c *Client) OnDataMPEG4Audio(track *Track, cb ClientOnDataMPEG4AudioFunc) {
 	}
 }
 
+// OnDataMPEG4AudioLATM sets a callback that is called when data from a MPEG-4 Audio LATM track is received.
+func (c *Client) OnDataMPEG4AudioLATM(track *Track, cb ClientOnDataMPEG4AudioLATMFunc) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data)
+	}
+}
+
 // OnDataOpus sets a callback that is called when data from an Opus track is received.
 func (c *Client) OnDataOpus(track *Track, cb ClientOnDataOpusFunc) {
 	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
@@ -257,6 +405,13 @@ func (c *Client) OnDataOpus(track *Track, cb ClientOnDataOpusFunc) {
 	}
 }
 
+// OnDataMPEG1Audio sets a callback that is called when data from a MPEG-1 Audio track is received.
+func (c *Client) OnDataMPEG1Audio(track *Track, cb ClientOnDataMPEG1AudioFunc) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data)
+	}
+}
+
 // OnDataFLAC sets a callback that is called when data from a FLAC track is received.
 func (c *Client) OnDataFLAC(track *Track, cb ClientOnDataFLACFunc) {
 	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
@@ -264,6 +419,20 @@ func (c *Client) OnDataFLAC(track *Track, cb ClientOnDataFLACFunc) {
 	}
 }
 
+// OnDataAC3 sets a callback that is called when data from an AC-3 track is received.
+func (c *Client) OnDataAC3(track *Track, cb ClientOnDataAC3Func) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data[0])
+	}
+}
+
+// OnDataEAC3 sets a callback that is called when data from an E-AC-3 track is received.
+func (c *Client) OnDataEAC3(track *Track, cb ClientOnDataEAC3Func) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data[0])
+	}
+}
+
 // OnDataKLV sets a callback that is called when data from an KLV track is received.
 func (c *Client) OnDataKLV(track *Track, cb ClientOnDataKLVFunc) {
 	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
@@ -271,6 +440,91 @@ func (c *Client) OnDataKLV(track *Track, cb ClientOnDataKLVFunc) {
 	}
 }
 
+// OnDataID3 sets a callback that is called when a tag from an ID3 track is received.
+func (c *Client) OnDataID3(track *Track, cb ClientOnDataID3Func) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data[0])
+	}
+}
+
+// OnDataSubtitle sets a callback that is called when a cue from a subtitle track is received.
+// End is the timestamp at which the cue must be hidden.
+func (c *Client) OnDataSubtitle(track *Track, cb ClientOnDataSubtitleFunc) {
+	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+		end, text := decodeSubtitle(data)
+		cb(pts, end, text)
+	}
+}
+
+// OnDataCaptions sets a callback that is called when the closed captions
+// carried by a H264 or H265 track change.
+// instreamID is the INSTREAM-ID of the CLOSED-CAPTIONS rendition,
+// that is CC1-CC4 for CEA-608 captions or SERVICE1-SERVICE63 for CEA-708 captions.
+// Text contains the displayed captions, and is empty when captions are cleared.
+func (c *Client) OnDataCaptions(track *Track, instreamID string, cb ClientOnDataCaptionsFunc) error {
+	id, err := parseCaptionsInstreamID(instreamID)
+	if err != nil {
+		return err
+	}
+
+	var isH265 bool
+
+	switch track.Codec.(type) {
+	case *codecs.H264:
+	case *codecs.H265:
+		isH265 = true
+	default:
+		return fmt.Errorf("closed captions are supported with H264 and H265 tracks only")
+	}
+
+	ct := c.tracks[track]
+
+	if ct.captions == nil {
+		ct.captions = &clientCaptionExtractor{isH265: isH265}
+		ct.captions.initialize()
+	}
+
+	ct.captions.callbacks[id] = cb
+	return nil
+}
+
+// SetVariant requests a switch to a variant of the multivariant playlist.
+// The variant must be one of the variants passed to OnSelectVariant that
+// have codecs compatible with the current variant.
+// The switch is performed at the next segment boundary, or at the next part boundary
+// in case of Low-Latency streams, and disables automatic switching.
+// Passing nil enables automatic switching again.
+func (c *Client) SetVariant(v *playlist.MultivariantVariant) error {
+	if c.variantSwitcher == nil {
+		return fmt.Errorf("client is not started")
+	}
+	return c.variantSwitcher.requestVariant(v)
+}
+
+// Seek moves the playback position to the segment that contains the given position,
+// measured from the beginning of the playlist by summing segment durations.
+// It is available with VOD and EVENT playlists.
+// Data of the previous position is discarded and OnDiscontinuity is called
+// before data of the new position is passed to OnData callbacks.
+// Timestamps are not reset, therefore they are negative when seeking
+// before the initial position.
+func (c *Client) Seek(position time.Duration) error {
+	if c.seeker == nil {
+		return fmt.Errorf("client is not started")
+	}
+	return c.seeker.seek(&clientSeekRequest{position: position})
+}
+
+// SeekToDateTime moves the playback position to the segment that contains the given absolute time,
+// obtained from EXT-X-PROGRAM-DATE-TIME tags.
+// It behaves like Seek.
+func (c *Client) SeekToDateTime(t time.Time) error {
+	if c.seeker == nil {
+		return fmt.Errorf("client is not started")
+	}
+	return c.seeker.seek(&clientSeekRequest{dateTime: &t})
+}
+
 var zero time.Time
 
 // AbsoluteTime returns the absolute timestamp of the last sample.
@@ -291,7 +545,13 @@ func (c *Client) runInner() error {
 		primaryPlaylistURL:        c.playlistURL,
 		startDistance:             c.StartDistance,
 		maxDistance:               c.MaxDistance,
+		downloadConcurrency:       c.DownloadConcurrency,
+		subtitleLanguages:         c.SubtitleLanguages,
 		httpClient:                c.HTTPClient,
+		keyResolver:               c.KeyResolver,
+		switcher:                  c.variantSwitcher,
+		seeker:                    c.seeker,
+		cueScheduler:              c.cueScheduler,
 		rp:                        rp,
 		onRequest:                 c.OnRequest,
 		onDownloadPrimaryPlaylist: c.OnDownloadPrimaryPlaylist,
@@ -299,6 +559,8 @@ func (c *Client) runInner() error {
 		onDownloadSegment:         c.OnDownloadSegment,
 		onDownloadPart:            c.OnDownloadPart,
 		onDecodeError:             c.OnDecodeError,
+		onSelectVariant:           c.OnSelectVariant,
+		onSessionData:             c.OnSessionData,
 		client:                    c,
 	}
 	c.primaryDownloader.initialize()
@@ -319,8 +581,10 @@ func (c *Client) setTracks(tracks []*Track) (map[*Track]*clientTrack, error) {
 	c.tracks = make(map[*Track]*clientTrack)
 	for _, track := range tracks {
 		c.tracks[track] = &clientTrack{
-			track:  track,
-			onData: func(_, _ int64, _ [][]byte) {},
+			track:         track,
+			disablePacing: c.DisablePacing,
+			seeker:        c.seeker,
+			onData:        func(_, _ int64, _ [][]byte) {},
 		}
 	}
 
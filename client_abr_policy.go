@@ -0,0 +1,66 @@
+package gohlslib
+
+import (
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// ClientABRPolicy is an adaptive bitrate policy.
+// It is called by the Client at segment or part boundaries in order to decide
+// which variant of a multivariant playlist has to be downloaded next.
+type ClientABRPolicy interface {
+	// SelectVariant returns the variant to use for the next segment.
+	// variants contains variants the Client can switch to, sorted by ascending bandwidth.
+	// current is the variant currently in use.
+	// estimatedBandwidth is the estimated download bandwidth, in bits per second.
+	SelectVariant(
+		variants []*playlist.MultivariantVariant,
+		current *playlist.MultivariantVariant,
+		estimatedBandwidth int,
+	) *playlist.MultivariantVariant
+}
+
+// ClientABRPolicyThroughput is a ClientABRPolicy that picks the variant
+// with the greatest bandwidth that fits into the estimated bandwidth.
+type ClientABRPolicyThroughput struct {
+	// Fraction of the estimated bandwidth that a variant can use
+	// when switching to a higher quality.
+	// It defaults to 0.8.
+	SafetyFactor float64
+}
+
+// SelectVariant implements ClientABRPolicy.
+func (p *ClientABRPolicyThroughput) SelectVariant(
+	variants []*playlist.MultivariantVariant,
+	current *playlist.MultivariantVariant,
+	estimatedBandwidth int,
+) *playlist.MultivariantVariant {
+	safetyFactor := p.SafetyFactor
+	if safetyFactor == 0 {
+		safetyFactor = 0.8
+	}
+
+	// switch up when a higher variant fits into a fraction of the bandwidth
+	for i := len(variants) - 1; i >= 0; i-- {
+		v := variants[i]
+		if v.Bandwidth <= current.Bandwidth {
+			break
+		}
+		if float64(v.Bandwidth) <= float64(estimatedBandwidth)*safetyFactor {
+			return v
+		}
+	}
+
+	// keep the current variant while it fits into the bandwidth
+	if current.Bandwidth <= estimatedBandwidth {
+		return current
+	}
+
+	// switch down to the greatest variant that fits into the bandwidth
+	for i := len(variants) - 1; i >= 0; i-- {
+		if variants[i].Bandwidth <= estimatedBandwidth {
+			return variants[i]
+		}
+	}
+
+	return variants[0]
+}
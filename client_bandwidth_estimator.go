@@ -0,0 +1,43 @@
+package gohlslib
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// weight of the last sample in the exponentially weighted moving average.
+	clientBandwidthEstimatorAlpha = 0.3
+)
+
+type clientBandwidthEstimator struct {
+	mutex     sync.Mutex
+	available bool
+	value     float64
+}
+
+func (e *clientBandwidthEstimator) addSample(size int, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+
+	bw := float64(size) * 8 / duration.Seconds()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.available {
+		e.available = true
+		e.value = bw
+		return
+	}
+
+	e.value = clientBandwidthEstimatorAlpha*bw + (1-clientBandwidthEstimatorAlpha)*e.value
+}
+
+func (e *clientBandwidthEstimator) estimate() (int, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return int(e.value), e.available
+}
@@ -0,0 +1,98 @@
+package gohlslib
+
+import (
+	"cmp"
+	"slices"
+)
+
+const (
+	clientMaxPendingCaptions = 64
+)
+
+type clientCaptionEntry struct {
+	pts  int64
+	data []ccTriplet
+}
+
+// clientCaptionExtractor extracts closed captions from H264 and H265 access units.
+type clientCaptionExtractor struct {
+	isH265 bool
+
+	callbacks map[captionsInstreamID]ClientOnDataCaptionsFunc
+	cea608    [2]*cea608Decoder
+	cea708    *cea708Decoder
+	pending   []*clientCaptionEntry
+	curPTS    int64
+}
+
+func (e *clientCaptionExtractor) initialize() {
+	e.callbacks = make(map[captionsInstreamID]ClientOnDataCaptionsFunc)
+
+	for i := range e.cea608 {
+		e.cea608[i] = &cea608Decoder{
+			firstChannel: 1 + i*2,
+			onText: func(channel int, text string) {
+				e.emit(captionsInstreamID{channel: channel}, text)
+			},
+		}
+		e.cea608[i].initialize()
+	}
+
+	e.cea708 = &cea708Decoder{
+		onText: func(service int, text string) {
+			e.emit(captionsInstreamID{service: service}, text)
+		},
+	}
+	e.cea708.initialize()
+}
+
+func (e *clientCaptionExtractor) emit(id captionsInstreamID, text string) {
+	if cb, ok := e.callbacks[id]; ok {
+		cb(e.curPTS, text)
+	}
+}
+
+// process extracts captions from an access unit.
+// Since captions are stored in decoding order, they are reordered by PTS
+// and decoded when they can't be preceded by other access units.
+func (e *clientCaptionExtractor) process(pts int64, dts int64, au [][]byte) {
+	data := extractCCData(au, e.isH265)
+	if data != nil {
+		e.pending = append(e.pending, &clientCaptionEntry{
+			pts:  pts,
+			data: data,
+		})
+		slices.SortStableFunc(e.pending, func(a, b *clientCaptionEntry) int {
+			return cmp.Compare(a.pts, b.pts)
+		})
+	}
+
+	n := 0
+	for n < len(e.pending) && (e.pending[n].pts <= dts || (len(e.pending)-n) > clientMaxPendingCaptions) {
+		e.decode(e.pending[n])
+		n++
+	}
+	e.pending = e.pending[n:]
+}
+
+// reset discards pending captions, after a seek.
+func (e *clientCaptionExtractor) reset() {
+	e.pending = nil
+}
+
+func (e *clientCaptionExtractor) decode(entry *clientCaptionEntry) {
+	e.curPTS = entry.pts
+
+	for _, cc := range entry.data {
+		switch cc.typ {
+		case ccTypeNTSCField1:
+			e.cea608[0].decode(cc.data)
+
+		case ccTypeNTSCField2:
+			e.cea608[1].decode(cc.data)
+
+		default:
+			e.cea708.decode(cc)
+		}
+	}
+}
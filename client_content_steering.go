@@ -0,0 +1,513 @@
+package gohlslib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+const (
+	clientMaxInboundSteeringManifestSize = 1 * 1024 * 1024
+	clientSteeringDefaultTTL             = 300 * time.Second
+	clientDefaultPathwayID               = "."
+)
+
+// errClientSteeringGone is returned when the steering server asks to stop polling it.
+var errClientSteeringGone = errors.New("steering server is gone")
+
+// clientSteeringRetryAfterError is returned when the steering server asks to
+// reload the steering manifest after a given time.
+type clientSteeringRetryAfterError struct {
+	retryAfter time.Duration
+}
+
+// Error implements error.
+func (e clientSteeringRetryAfterError) Error() string {
+	return "too many requests"
+}
+
+// parseRetryAfter parses the value of a Retry-After header,
+// that is either a number of seconds or a HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if secs, err := strconv.ParseUint(v, 10, 31); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// clientSteeringManifest is a content steering manifest,
+// as described in the "HLS Content Steering" specification.
+type clientSteeringManifest struct {
+	Version         int                           `json:"VERSION"`
+	TTL             int                           `json:"TTL"`
+	ReloadURI       string                        `json:"RELOAD-URI"`
+	PathwayPriority []string                      `json:"PATHWAY-PRIORITY"`
+	PathwayClones   []*clientSteeringPathwayClone `json:"PATHWAY-CLONES"`
+}
+
+func (m clientSteeringManifest) validate() error {
+	if m.Version != 1 {
+		return fmt.Errorf("unsupported steering manifest version: %d", m.Version)
+	}
+
+	if len(m.PathwayPriority) == 0 {
+		return fmt.Errorf("PATHWAY-PRIORITY is missing")
+	}
+
+	return nil
+}
+
+type clientSteeringURIReplacement struct {
+	Host             string            `json:"HOST"`
+	Params           map[string]string `json:"PARAMS"`
+	PerVariantURIs   map[string]string `json:"PER-VARIANT-URIS"`
+	PerRenditionURIs map[string]string `json:"PER-RENDITION-URIS"`
+}
+
+// replace returns the URI of a variant or rendition of a cloned pathway.
+func (r clientSteeringURIReplacement) replace(
+	primaryPlaylistURL *url.URL,
+	uri string,
+	stableID string,
+	perStableIDURIs map[string]string,
+) (string, error) {
+	if stableID != "" {
+		if u, ok := perStableIDURIs[stableID]; ok {
+			return u, nil
+		}
+	}
+
+	u, err := clientAbsoluteURL(primaryPlaylistURL, uri)
+	if err != nil {
+		return "", err
+	}
+
+	if r.Host != "" {
+		u.Host = r.Host
+	}
+
+	if len(r.Params) != 0 {
+		q := u.Query()
+		for key, val := range r.Params {
+			q.Set(key, val)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+type clientSteeringPathwayClone struct {
+	BaseID         string                       `json:"BASE-ID"`
+	ID             string                       `json:"ID"`
+	URIReplacement clientSteeringURIReplacement `json:"URI-REPLACEMENT"`
+}
+
+// clientPathway is a set of variants and renditions, delivered through the same CDN.
+type clientPathway struct {
+	id         string
+	variants   []*playlist.MultivariantVariant
+	renditions []*playlist.MultivariantRendition
+}
+
+func (p *clientPathway) clone(
+	primaryPlaylistURL *url.URL,
+	c *clientSteeringPathwayClone,
+) (*clientPathway, error) {
+	ret := &clientPathway{
+		id: c.ID,
+	}
+
+	for _, v := range p.variants {
+		dup := *v
+		dup.PathwayID = c.ID
+
+		var err error
+		dup.URI, err = c.URIReplacement.replace(primaryPlaylistURL, v.URI, v.StableVariantID,
+			c.URIReplacement.PerVariantURIs)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.variants = append(ret.variants, &dup)
+	}
+
+	for _, r := range p.renditions {
+		dup := *r
+
+		if r.URI != nil {
+			uri, err := c.URIReplacement.replace(primaryPlaylistURL, *r.URI, r.StableRenditionID,
+				c.URIReplacement.PerRenditionURIs)
+			if err != nil {
+				return nil, err
+			}
+			dup.URI = &uri
+		}
+
+		ret.renditions = append(ret.renditions, &dup)
+	}
+
+	return ret, nil
+}
+
+func variantPathwayID(v *playlist.MultivariantVariant) string {
+	if v.PathwayID == "" {
+		return clientDefaultPathwayID
+	}
+	return v.PathwayID
+}
+
+// getPathways groups variants and renditions of a multivariant playlist
+// by pathway, in order of appearance.
+func getPathways(pl *playlist.Multivariant) []*clientPathway {
+	var ret []*clientPathway
+
+	for _, v := range pl.Variants {
+		id := variantPathwayID(v)
+
+		i := slices.IndexFunc(ret, func(p *clientPathway) bool {
+			return p.id == id
+		})
+		if i < 0 {
+			ret = append(ret, &clientPathway{id: id})
+			i = len(ret) - 1
+		}
+
+		ret[i].variants = append(ret[i].variants, v)
+	}
+
+	for _, p := range ret {
+		groups := make(map[string]struct{})
+		for _, v := range p.variants {
+			groups[v.Video] = struct{}{}
+			groups[v.Audio] = struct{}{}
+			groups[v.Subtitles] = struct{}{}
+		}
+
+		for _, r := range pl.Renditions {
+			if _, ok := groups[r.GroupID]; ok {
+				p.renditions = append(p.renditions, r)
+			}
+		}
+	}
+
+	return ret
+}
+
+// clientContentSteering downloads and refreshes the steering manifest,
+// and decides the pathway from which variants and renditions are downloaded.
+type clientContentSteering struct {
+	httpClient         *http.Client
+	onRequest          ClientOnRequestFunc
+	onDecodeError      ClientOnDecodeErrorFunc
+	primaryPlaylistURL *url.URL
+	serverURI          string
+	initialPathwayID   string
+	pathways           []*clientPathway
+	switcher           *clientVariantSwitcher
+
+	mutex     sync.Mutex
+	priority  []string
+	current   *clientPathway
+	gen       int
+	ttl       time.Duration
+	penalized map[string]time.Time
+}
+
+func (s *clientContentSteering) initialize() {
+	s.ttl = clientSteeringDefaultTTL
+	s.penalized = make(map[string]time.Time)
+
+	// until the steering manifest is obtained,
+	// the initial pathway is preferred and the others are used in order of appearance.
+	s.current = s.pathways[0]
+	for _, p := range s.pathways {
+		if p.id == s.initialPathwayID {
+			s.current = p
+		}
+	}
+
+	s.priority = []string{s.current.id}
+	for _, p := range s.pathways {
+		if p != s.current {
+			s.priority = append(s.priority, p.id)
+		}
+	}
+}
+
+func (s *clientContentSteering) run(ctx context.Context) error {
+	u, err := clientAbsoluteURL(s.primaryPlaylistURL, s.serverURI)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var m *clientSteeringManifest
+		var finalURL *url.URL
+		m, finalURL, err = s.downloadManifest(ctx, u)
+
+		if err == nil {
+			err = s.applyManifest(m)
+		}
+
+		if err == nil && m.ReloadURI != "" {
+			u, err = clientAbsoluteURL(finalURL, m.ReloadURI)
+		}
+
+		// the server asked to stop polling, the current pathway is kept.
+		if errors.Is(err, errClientSteeringGone) {
+			return nil
+		}
+
+		s.mutex.Lock()
+		wait := s.ttl
+		s.mutex.Unlock()
+
+		var retryErr clientSteeringRetryAfterError
+
+		switch {
+		case errors.As(err, &retryErr):
+			if retryErr.retryAfter > 0 {
+				wait = retryErr.retryAfter
+			}
+
+		// when the steering manifest cannot be obtained, the current pathway is kept.
+		case err != nil && ctx.Err() == nil:
+			s.onDecodeError(fmt.Errorf("unable to load steering manifest: %w", err))
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("terminated")
+		}
+	}
+}
+
+func (s *clientContentSteering) downloadManifest(
+	ctx context.Context,
+	u *url.URL,
+) (*clientSteeringManifest, *url.URL, error) {
+	u = cloneURL(u)
+
+	q := u.Query()
+
+	s.mutex.Lock()
+	q.Set("_HLS_pathway", s.current.id)
+	s.mutex.Unlock()
+
+	if bw, ok := s.switcher.estimator.estimate(); ok {
+		q.Set("_HLS_throughput", strconv.FormatInt(int64(bw), 10))
+	}
+
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.onRequest(req)
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+
+	case http.StatusGone:
+		return nil, nil, errClientSteeringGone
+
+	case http.StatusTooManyRequests:
+		return nil, nil, clientSteeringRetryAfterError{
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	byts, err := io.ReadAll(&customLimitReader{res.Body, clientMaxInboundSteeringManifestSize})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var m clientSteeringManifest
+	err = json.Unmarshal(byts, &m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = m.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &m, res.Request.URL, nil
+}
+
+func (s *clientContentSteering) applyManifest(m *clientSteeringManifest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m.TTL > 0 {
+		s.ttl = time.Duration(m.TTL) * time.Second
+	}
+
+	for _, c := range m.PathwayClones {
+		if s.pathway(c.ID) != nil {
+			continue
+		}
+
+		base := s.pathway(c.BaseID)
+		if base == nil {
+			return fmt.Errorf("base pathway '%s' of clone '%s' not found", c.BaseID, c.ID)
+		}
+
+		p, err := base.clone(s.primaryPlaylistURL, c)
+		if err != nil {
+			return err
+		}
+
+		s.pathways = append(s.pathways, p)
+	}
+
+	s.priority = m.PathwayPriority
+
+	if p := s.pickPathway(); p != nil && p != s.current {
+		s.current = p
+		s.gen++
+	}
+
+	return nil
+}
+
+func (s *clientContentSteering) pathway(id string) *clientPathway {
+	for _, p := range s.pathways {
+		if p.id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// pickPathway returns the pathway with the highest priority, among the ones that are not penalized.
+func (s *clientContentSteering) pickPathway() *clientPathway {
+	now := time.Now()
+
+	for _, id := range s.priority {
+		if until, ok := s.penalized[id]; ok && now.Before(until) {
+			continue
+		}
+
+		if p := s.pathway(id); p != nil {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// currentPathway returns the current pathway and the number of times it has been changed.
+func (s *clientContentSteering) currentPathway() (*clientPathway, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.current, s.gen
+}
+
+// failover penalizes the pathway with the given generation after a download error,
+// and switches to the next one.
+// It returns false when there are no other pathways available.
+func (s *clientContentSteering) failover(gen int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the pathway has already been changed by another stream
+	if gen != s.gen {
+		return true
+	}
+
+	s.penalized[s.current.id] = time.Now().Add(s.ttl)
+
+	p := s.pickPathway()
+	if p == nil {
+		return false
+	}
+
+	s.current = p
+	s.gen++
+	return true
+}
+
+// equivalentVariant returns the variant of a pathway that corresponds to a variant of another pathway.
+// Variants are matched by STABLE-VARIANT-ID or, when it is missing, by position.
+func (s *clientContentSteering) equivalentVariant(
+	v *playlist.MultivariantVariant,
+	to *clientPathway,
+) *playlist.MultivariantVariant {
+	if v.StableVariantID != "" {
+		for _, v2 := range to.variants {
+			if v2.StableVariantID == v.StableVariantID {
+				return v2
+			}
+		}
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, p := range s.pathways {
+		if i := slices.Index(p.variants, v); i >= 0 && i < len(to.variants) {
+			return to.variants[i]
+		}
+	}
+
+	return nil
+}
+
+// equivalentRendition returns the rendition of a pathway that corresponds to a rendition of another pathway.
+// Renditions are matched by STABLE-RENDITION-ID or, when it is missing, by position.
+func (s *clientContentSteering) equivalentRendition(
+	r *playlist.MultivariantRendition,
+	to *clientPathway,
+) *playlist.MultivariantRendition {
+	if r.StableRenditionID != "" {
+		for _, r2 := range to.renditions {
+			if r2.Type == r.Type && r2.StableRenditionID == r.StableRenditionID {
+				return r2
+			}
+		}
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, p := range s.pathways {
+		if i := slices.Index(p.renditions, r); i >= 0 && i < len(to.renditions) {
+			return to.renditions[i]
+		}
+	}
+
+	return nil
+}
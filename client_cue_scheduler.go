@@ -0,0 +1,230 @@
+package gohlslib
+
+import (
+	"cmp"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// mergeDateRanges merges EXT-X-DATERANGE tags with the same ID,
+// that describe the same date range with additional attributes.
+func mergeDateRanges(dateRanges []*playlist.MediaDateRange) map[string]*playlist.MediaDateRange {
+	ret := make(map[string]*playlist.MediaDateRange)
+
+	for _, dr := range dateRanges {
+		prev, ok := ret[dr.ID]
+		if !ok {
+			dup := *dr
+			ret[dr.ID] = &dup
+			continue
+		}
+
+		if dr.EndDate != nil {
+			prev.EndDate = dr.EndDate
+		}
+		if dr.Duration != nil {
+			prev.Duration = dr.Duration
+		}
+		if dr.PlannedDuration != nil {
+			prev.PlannedDuration = dr.PlannedDuration
+		}
+		if dr.SCTE35Cmd != nil {
+			prev.SCTE35Cmd = dr.SCTE35Cmd
+		}
+		if dr.SCTE35Out != nil {
+			prev.SCTE35Out = dr.SCTE35Out
+		}
+		if dr.SCTE35In != nil {
+			prev.SCTE35In = dr.SCTE35In
+		}
+		prev.EndOnNext = prev.EndOnNext || dr.EndOnNext
+
+		if dr.ClientAttributes != nil {
+			attrs := maps.Clone(prev.ClientAttributes)
+			if attrs == nil {
+				attrs = make(map[string]playlist.MediaDateRangeClientAttribute)
+			}
+			maps.Copy(attrs, dr.ClientAttributes)
+			prev.ClientAttributes = attrs
+		}
+	}
+
+	return ret
+}
+
+type clientDateRangeCue struct {
+	dateRange *playlist.MediaDateRange
+	fired     bool
+}
+
+type clientSCTE35Cue struct {
+	gen     int
+	pts     int64
+	section []byte
+}
+
+// clientCueScheduler delivers date ranges and SCTE-35 sections
+// when playback of the leading track reaches their start.
+type clientCueScheduler struct {
+	onDateRange ClientOnDateRangeFunc
+	onSCTE35    ClientOnSCTE35Func
+
+	mutex      sync.Mutex
+	dateRanges map[string]*clientDateRangeCue
+	sections   []*clientSCTE35Cue
+	started    bool
+	gen        int
+}
+
+func (s *clientCueScheduler) initialize() {
+	s.dateRanges = make(map[string]*clientDateRangeCue)
+}
+
+// pushDateRanges updates date ranges with the ones of a media playlist.
+// Date ranges that are updated after their start are delivered again.
+func (s *clientCueScheduler) pushDateRanges(dateRanges []*playlist.MediaDateRange) {
+	if s.onDateRange == nil {
+		return
+	}
+
+	merged := mergeDateRanges(dateRanges)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, dr := range merged {
+		cue, ok := s.dateRanges[id]
+		if !ok {
+			s.dateRanges[id] = &clientDateRangeCue{dateRange: dr}
+			continue
+		}
+
+		if !reflect.DeepEqual(cue.dateRange, dr) {
+			cue.dateRange = dr
+			cue.fired = false
+		}
+	}
+
+	// forget date ranges that were removed from the playlist
+	for id := range s.dateRanges {
+		if _, ok := merged[id]; !ok {
+			delete(s.dateRanges, id)
+		}
+	}
+}
+
+// pushSCTE35 adds a SCTE-35 section, that is delivered when
+// the leading track of the given seek generation reaches pts.
+func (s *clientCueScheduler) pushSCTE35(gen int, pts int64, section []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sections = append(s.sections, &clientSCTE35Cue{
+		gen:     gen,
+		pts:     pts,
+		section: section,
+	})
+
+	slices.SortStableFunc(s.sections, func(a, b *clientSCTE35Cue) int {
+		return cmp.Compare(a.pts, b.pts)
+	})
+}
+
+// end returns the end of a date range, or false if the date range is still ongoing.
+func (s *clientCueScheduler) end(dr *playlist.MediaDateRange) (time.Time, bool) {
+	switch {
+	case dr.EndDate != nil:
+		return *dr.EndDate, true
+
+	case dr.Duration != nil:
+		return dr.StartDate.Add(*dr.Duration), true
+
+	case dr.PlannedDuration != nil:
+		return dr.StartDate.Add(*dr.PlannedDuration), true
+
+	case dr.EndOnNext:
+		var ret *time.Time
+		for _, cue := range s.dateRanges {
+			other := cue.dateRange
+			if other.Class == dr.Class && other.StartDate.After(dr.StartDate) &&
+				(ret == nil || other.StartDate.Before(*ret)) {
+				ret = &other.StartDate
+			}
+		}
+		if ret == nil {
+			return time.Time{}, false
+		}
+		return *ret, true
+
+	default:
+		return dr.StartDate, true
+	}
+}
+
+// process is called after the leading track delivers a sample,
+// and delivers the cues whose start has been reached.
+func (s *clientCueScheduler) process(gen int, pts int64, ntp *time.Time) {
+	var dateRanges []*playlist.MediaDateRange
+	var sections []*clientSCTE35Cue
+
+	func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		// when playback starts or after a seek, skip date ranges that have already ended
+		if !s.started || gen != s.gen {
+			s.started = true
+			s.gen = gen
+
+			if ntp != nil {
+				for _, cue := range s.dateRanges {
+					if end, ok := s.end(cue.dateRange); ok && end.Before(*ntp) {
+						cue.fired = true
+					}
+				}
+			}
+		}
+
+		if ntp != nil {
+			for _, cue := range s.dateRanges {
+				if !cue.fired && !cue.dateRange.StartDate.After(*ntp) {
+					cue.fired = true
+					dateRanges = append(dateRanges, cue.dateRange)
+				}
+			}
+		}
+
+		n := 0
+		for _, cue := range s.sections {
+			switch {
+			case cue.gen < gen: // discard sections that precede a seek
+
+			case cue.gen == gen && cue.pts <= pts:
+				sections = append(sections, cue)
+
+			default:
+				s.sections[n] = cue
+				n++
+			}
+		}
+		clear(s.sections[n:])
+		s.sections = s.sections[:n]
+	}()
+
+	slices.SortFunc(dateRanges, func(a, b *playlist.MediaDateRange) int {
+		return a.StartDate.Compare(b.StartDate)
+	})
+
+	for _, dr := range dateRanges {
+		s.onDateRange(dr)
+	}
+
+	for _, cue := range sections {
+		s.onSCTE35(cue.pts, cue.section)
+	}
+}
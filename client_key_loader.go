@@ -0,0 +1,173 @@
+package gohlslib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+const (
+	clientMaxCachedKeys = 10
+)
+
+func aes128IV(key *playlist.MediaKey, seqNo int) ([]byte, error) {
+	if key.IV == "" {
+		// use the media sequence number as IV
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(seqNo))
+		return iv, nil
+	}
+
+	tmp := key.IV
+	if !strings.HasPrefix(tmp, "0x") && !strings.HasPrefix(tmp, "0X") {
+		return nil, fmt.Errorf("invalid IV: %v", key.IV)
+	}
+	tmp = tmp[2:]
+
+	if len(tmp) > aes.BlockSize*2 {
+		return nil, fmt.Errorf("invalid IV: %v", key.IV)
+	}
+	tmp = strings.Repeat("0", aes.BlockSize*2-len(tmp)) + tmp
+
+	iv, err := hex.DecodeString(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IV: %v", key.IV)
+	}
+
+	return iv, nil
+}
+
+func decryptAES128(payload []byte, key []byte, iv []byte) ([]byte, error) {
+	if len(payload) == 0 || (len(payload)%aes.BlockSize) != 0 {
+		return nil, fmt.Errorf("encrypted payload size is not a multiple of the block size")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := make([]byte, len(payload))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(dec, payload)
+
+	// remove PKCS7 padding
+	padding := int(dec[len(dec)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(dec[len(dec)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("invalid padding")
+	}
+
+	return dec[:len(dec)-padding], nil
+}
+
+type clientKeyLoader struct {
+	httpClient  *http.Client
+	onRequest   ClientOnRequestFunc
+	keyResolver ClientKeyResolverFunc
+
+	mutex sync.Mutex
+	cache map[string][]byte
+}
+
+func (l *clientKeyLoader) initialize() {
+	l.cache = make(map[string][]byte)
+}
+
+func (l *clientKeyLoader) load(
+	ctx context.Context,
+	u *url.URL,
+	key *playlist.MediaKey,
+	kid []byte,
+) ([]byte, error) {
+	ur := u.String()
+	cacheKey := ur + "#" + hex.EncodeToString(kid)
+
+	l.mutex.Lock()
+	byts, ok := l.cache[cacheKey]
+	l.mutex.Unlock()
+
+	if ok {
+		return byts, nil
+	}
+
+	var err error
+
+	switch {
+	case l.keyResolver != nil:
+		byts, err = l.keyResolver(ur, key, kid)
+
+	case key.KeyFormat != "" && key.KeyFormat != "identity":
+		return nil, fmt.Errorf("key format '%v' requires a key resolver", key.KeyFormat)
+
+	default:
+		byts, err = l.download(ctx, ur)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(byts) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key size: %d", len(byts))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// keys are rotated, therefore old ones can be discarded
+	if len(l.cache) >= clientMaxCachedKeys {
+		clear(l.cache)
+	}
+	l.cache[cacheKey] = byts
+
+	return byts, nil
+}
+
+func (l *clientKeyLoader) download(ctx context.Context, ur string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ur, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l.onRequest(req)
+
+	res, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return io.ReadAll(&customLimitReader{res.Body, clientMaxInboundKeySize})
+}
+
+// clientSegmentKey is the key of a segment encrypted with SAMPLE-AES.
+// It is loaded by stream processors, since the key ID is stored inside segments.
+type clientSegmentKey struct {
+	loader *clientKeyLoader
+	url    *url.URL
+	key    *playlist.MediaKey
+	seqNo  int
+}
+
+func (k *clientSegmentKey) load(ctx context.Context, kid []byte) (cipher.Block, error) {
+	byts, err := k.loader.load(ctx, k.url, k.key, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return aes.NewCipher(byts)
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
@@ -16,8 +17,11 @@ func checkSupport(codecs []string) bool {
 		if !strings.HasPrefix(codec, "avc1.") &&
 			!strings.HasPrefix(codec, "hvc1.") &&
 			!strings.HasPrefix(codec, "hev1.") &&
+			!strings.HasPrefix(codec, "mp4v.") &&
 			!strings.HasPrefix(codec, "mp4a.") &&
-			codec != "opus" {
+			codec != "opus" &&
+			codec != "ac-3" &&
+			codec != "ec-3" {
 			return false
 		}
 	}
@@ -40,11 +44,50 @@ func cloneURL(ur *url.URL) *url.URL {
 	}
 }
 
+// absoluteSessionData returns copies of session data with URIs resolved against the playlist URL.
+func absoluteSessionData(base *url.URL, data []*playlist.MultivariantSessionData) []*playlist.MultivariantSessionData {
+	ret := make([]*playlist.MultivariantSessionData, len(data))
+
+	for i, sd := range data {
+		dup := *sd
+
+		if sd.URI != nil {
+			if u, err := clientAbsoluteURL(base, *sd.URI); err == nil {
+				tmp := u.String()
+				dup.URI = &tmp
+			}
+		}
+
+		ret[i] = &dup
+	}
+
+	return ret
+}
+
+// absoluteSessionKeys returns copies of session keys with URIs resolved against the playlist URL.
+func absoluteSessionKeys(base *url.URL, keys []*playlist.MultivariantSessionKey) []*playlist.MultivariantSessionKey {
+	ret := make([]*playlist.MultivariantSessionKey, len(keys))
+
+	for i, sk := range keys {
+		dup := *sk
+
+		// key URIs that use custom schemes (skd://, ...) are left untouched by ResolveReference
+		if u, err := clientAbsoluteURL(base, sk.URI); err == nil {
+			dup.URI = u.String()
+		}
+
+		ret[i] = &dup
+	}
+
+	return ret
+}
+
 func downloadPlaylist(
 	ctx context.Context,
 	httpClient *http.Client,
 	onRequest ClientOnRequestFunc,
 	ur *url.URL,
+	variables map[string]string,
 ) (*url.URL, playlist.Playlist, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ur.String(), nil)
 	if err != nil {
@@ -68,7 +111,10 @@ func downloadPlaylist(
 		return nil, nil, err
 	}
 
-	pl, err := playlist.Unmarshal(byts)
+	pl, err := playlist.UnmarshalWithContext(byts, playlist.UnmarshalContext{
+		URL:       res.Request.URL,
+		Variables: variables,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -76,21 +122,21 @@ func downloadPlaylist(
 	return res.Request.URL, pl, nil
 }
 
-func pickLeadingPlaylist(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
-	var candidates []*playlist.MultivariantVariant //nolint:prealloc
+func getSupportedVariants(variants []*playlist.MultivariantVariant) []*playlist.MultivariantVariant {
+	var ret []*playlist.MultivariantVariant //nolint:prealloc
 	for _, v := range variants {
 		if !checkSupport(v.Codecs) {
 			continue
 		}
-		candidates = append(candidates, v)
-	}
-	if candidates == nil {
-		return nil
+		ret = append(ret, v)
 	}
+	return ret
+}
 
+func pickLeadingPlaylist(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
 	// pick the variant with the greatest bandwidth
 	var leadingPlaylist *playlist.MultivariantVariant
-	for _, v := range candidates {
+	for _, v := range variants {
 		if leadingPlaylist == nil ||
 			v.Bandwidth > leadingPlaylist.Bandwidth {
 			leadingPlaylist = v
@@ -99,6 +145,18 @@ func pickLeadingPlaylist(variants []*playlist.MultivariantVariant) *playlist.Mul
 	return leadingPlaylist
 }
 
+// languageMatches checks whether a language matches one of the requested ones.
+// A requested language without subtags matches all its variants ("en" matches "en-US").
+func languageMatches(language string, requested []string) bool {
+	for _, req := range requested {
+		if strings.EqualFold(language, req) ||
+			(len(language) > len(req) && language[len(req)] == '-' && strings.EqualFold(language[:len(req)], req)) {
+			return true
+		}
+	}
+	return false
+}
+
 func getRenditionsByGroup(
 	renditions []*playlist.MultivariantRendition,
 	groupID string,
@@ -124,7 +182,13 @@ type clientPrimaryDownloader struct {
 	primaryPlaylistURL        *url.URL
 	startDistance             int
 	maxDistance               int
+	downloadConcurrency       int
+	subtitleLanguages         []string
 	httpClient                *http.Client
+	keyResolver               ClientKeyResolverFunc
+	switcher                  *clientVariantSwitcher
+	seeker                    *clientSeeker
+	cueScheduler              *clientCueScheduler
 	rp                        *clientRoutinePool
 	onRequest                 ClientOnRequestFunc
 	onDownloadPrimaryPlaylist ClientOnDownloadPrimaryPlaylistFunc
@@ -132,18 +196,27 @@ type clientPrimaryDownloader struct {
 	onDownloadSegment         ClientOnDownloadSegmentFunc
 	onDownloadPart            ClientOnDownloadPartFunc
 	onDecodeError             ClientOnDecodeErrorFunc
+	onSelectVariant           ClientOnSelectVariantFunc
+	onSessionData             ClientOnSessionDataFunc
 	client                    clientPrimaryDownloaderClient
 
+	keyLoader    *clientKeyLoader
 	clientTracks map[*Track]*clientTrack
 }
 
 func (d *clientPrimaryDownloader) initialize() {
+	d.keyLoader = &clientKeyLoader{
+		httpClient:  d.httpClient,
+		onRequest:   d.onRequest,
+		keyResolver: d.keyResolver,
+	}
+	d.keyLoader.initialize()
 }
 
 func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 	d.onDownloadPrimaryPlaylist(d.primaryPlaylistURL.String())
 
-	finalURL, pl, err := downloadPlaylist(ctx, d.httpClient, d.onRequest, d.primaryPlaylistURL)
+	finalURL, pl, err := downloadPlaylist(ctx, d.httpClient, d.onRequest, d.primaryPlaylistURL, nil)
 	if err != nil {
 		return err
 	}
@@ -156,9 +229,12 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 	case *playlist.Media:
 		stream := &clientStreamDownloader{
 			isLeading:                true,
+			cueScheduler:             d.cueScheduler,
 			startDistance:            d.startDistance,
 			maxDistance:              d.maxDistance,
+			downloadConcurrency:      d.downloadConcurrency,
 			httpClient:               d.httpClient,
+			keyLoader:                d.keyLoader,
 			onRequest:                d.onRequest,
 			onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
 			onDownloadSegment:        d.onDownloadSegment,
@@ -166,6 +242,7 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 			onDecodeError:            d.onDecodeError,
 			playlistURL:              finalURL,
 			firstPlaylist:            plt,
+			seeker:                   d.seeker,
 			rp:                       d.rp,
 			client:                   d.client,
 		}
@@ -174,29 +251,73 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		streams = append(streams, stream)
 
 	case *playlist.Multivariant:
-		leadingPlaylist := pickLeadingPlaylist(plt.Variants)
-		if leadingPlaylist == nil {
+		d.onSessionData(absoluteSessionData(finalURL, plt.SessionData), absoluteSessionKeys(finalURL, plt.SessionKeys))
+
+		allVariants := plt.Variants
+		renditions := plt.Renditions
+
+		var steering *clientContentSteering
+
+		// with content steering, variants and renditions are picked from the current pathway
+		if plt.ContentSteering != nil {
+			steering = &clientContentSteering{
+				httpClient:         d.httpClient,
+				onRequest:          d.onRequest,
+				onDecodeError:      d.onDecodeError,
+				primaryPlaylistURL: finalURL,
+				serverURI:          plt.ContentSteering.ServerURI,
+				initialPathwayID:   plt.ContentSteering.PathwayID,
+				pathways:           getPathways(plt),
+				switcher:           d.switcher,
+			}
+			steering.initialize()
+			d.rp.add(steering)
+
+			pathway, _ := steering.currentPathway()
+			allVariants = pathway.variants
+			renditions = pathway.renditions
+		}
+
+		variants := getSupportedVariants(allVariants)
+		if variants == nil {
 			return fmt.Errorf("no variants with supported codecs found")
 		}
 
+		// variables that can be imported by media playlists
+		variables := plt.Variables()
+
+		leadingPlaylist := d.onSelectVariant(variants)
+		if !slices.Contains(variants, leadingPlaylist) {
+			return fmt.Errorf("selected variant is not one of the supported variants")
+		}
+
 		var u *url.URL
 		u, err = clientAbsoluteURL(finalURL, leadingPlaylist.URI)
 		if err != nil {
 			return err
 		}
 
+		d.switcher.setVariants(finalURL, getSwitchableVariants(variants, leadingPlaylist), leadingPlaylist)
+
 		stream := &clientStreamDownloader{
 			isLeading:                true,
+			cueScheduler:             d.cueScheduler,
 			startDistance:            d.startDistance,
 			maxDistance:              d.maxDistance,
+			downloadConcurrency:      d.downloadConcurrency,
 			httpClient:               d.httpClient,
+			keyLoader:                d.keyLoader,
 			onRequest:                d.onRequest,
 			onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
 			onDownloadSegment:        d.onDownloadSegment,
 			onDownloadPart:           d.onDownloadPart,
 			onDecodeError:            d.onDecodeError,
 			playlistURL:              u,
+			variables:                variables,
 			firstPlaylist:            nil,
+			switcher:                 d.switcher,
+			steering:                 steering,
+			seeker:                   d.seeker,
 			rp:                       d.rp,
 			client:                   d.client,
 		}
@@ -205,7 +326,7 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		streams = append(streams, stream)
 
 		if leadingPlaylist.Audio != "" {
-			audioPlaylists := getRenditionsByGroup(plt.Renditions, leadingPlaylist.Audio)
+			audioPlaylists := getRenditionsByGroup(renditions, leadingPlaylist.Audio)
 			if audioPlaylists == nil {
 				return fmt.Errorf("no playlist with Group ID \"%s\" found", leadingPlaylist.Audio)
 			}
@@ -227,13 +348,18 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 						onRequest:                d.onRequest,
 						startDistance:            d.startDistance,
 						maxDistance:              d.maxDistance,
+						downloadConcurrency:      d.downloadConcurrency,
 						httpClient:               d.httpClient,
+						keyLoader:                d.keyLoader,
 						onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
 						onDownloadSegment:        d.onDownloadSegment,
 						onDownloadPart:           d.onDownloadPart,
 						onDecodeError:            d.onDecodeError,
 						playlistURL:              u,
+						variables:                variables,
 						rendition:                pl,
+						steering:                 steering,
+						seeker:                   d.seeker,
 						rp:                       d.rp,
 						client:                   d.client,
 					}
@@ -244,6 +370,44 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 			}
 		}
 
+		if leadingPlaylist.Subtitles != "" && len(d.subtitleLanguages) != 0 {
+			for _, pl := range getRenditionsByGroup(renditions, leadingPlaylist.Subtitles) {
+				if pl.Type != playlist.MultivariantRenditionTypeSubtitles || pl.URI == nil ||
+					!languageMatches(pl.Language, d.subtitleLanguages) {
+					continue
+				}
+
+				u, err = clientAbsoluteURL(d.primaryPlaylistURL, *pl.URI)
+				if err != nil {
+					return err
+				}
+
+				stream = &clientStreamDownloader{
+					isLeading:                false,
+					onRequest:                d.onRequest,
+					startDistance:            d.startDistance,
+					maxDistance:              d.maxDistance,
+					downloadConcurrency:      d.downloadConcurrency,
+					httpClient:               d.httpClient,
+					keyLoader:                d.keyLoader,
+					onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
+					onDownloadSegment:        d.onDownloadSegment,
+					onDownloadPart:           d.onDownloadPart,
+					onDecodeError:            d.onDecodeError,
+					playlistURL:              u,
+					variables:                variables,
+					rendition:                pl,
+					steering:                 steering,
+					seeker:                   d.seeker,
+					rp:                       d.rp,
+					client:                   d.client,
+				}
+				stream.initialize()
+				d.rp.add(stream)
+				streams = append(streams, stream)
+			}
+		}
+
 	default:
 		return fmt.Errorf("invalid playlist")
 	}
@@ -255,6 +419,9 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		case streamTracks := <-stream.chTracks:
 			tracks = append(tracks, streamTracks...)
 
+		case err = <-stream.chProcessorError:
+			return err
+
 		case <-ctx.Done():
 			return fmt.Errorf("terminated")
 		}
@@ -0,0 +1,132 @@
+package gohlslib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+func isSeekable(pl *playlist.Media) bool {
+	return pl.Endlist || (pl.PlaylistType != nil &&
+		(*pl.PlaylistType == playlist.MediaPlaylistTypeVOD || *pl.PlaylistType == playlist.MediaPlaylistTypeEvent))
+}
+
+// findSegmentWithPosition returns the index of the segment that contains a position,
+// obtained by summing durations of previous segments.
+func findSegmentWithPosition(segments []*playlist.MediaSegment, pos time.Duration) (int, bool) {
+	var start time.Duration
+
+	for i, seg := range segments {
+		if pos < start+seg.Duration {
+			return i, true
+		}
+		start += seg.Duration
+	}
+
+	return 0, false
+}
+
+// findSegmentWithDateTime returns the index of the segment that contains an absolute time.
+// The date/time of segments without EXT-X-PROGRAM-DATE-TIME is obtained from previous segments.
+func findSegmentWithDateTime(segments []*playlist.MediaSegment, t time.Time) (int, bool) {
+	var cur *time.Time
+
+	for i, seg := range segments {
+		if seg.DateTime != nil {
+			cur = seg.DateTime
+		}
+
+		if cur != nil {
+			end := cur.Add(seg.Duration)
+			if !t.Before(*cur) && t.Before(end) {
+				return i, true
+			}
+			cur = &end
+		}
+	}
+
+	return 0, false
+}
+
+type clientSeekRequest struct {
+	position time.Duration
+	dateTime *time.Time
+}
+
+// findSegment returns the index of the segment that contains the requested position.
+func (r *clientSeekRequest) findSegment(segments []*playlist.MediaSegment) (int, bool) {
+	if r.dateTime != nil {
+		return findSegmentWithDateTime(segments, *r.dateTime)
+	}
+	return findSegmentWithPosition(segments, r.position)
+}
+
+// clientSeeker dispatches seek requests to stream downloaders and tracks.
+// Each request starts a new generation; data of previous generations is discarded.
+type clientSeeker struct {
+	onDiscontinuity ClientOnDiscontinuityFunc
+
+	mutex       sync.Mutex
+	playlist    *playlist.Media
+	gen         int
+	request     *clientSeekRequest
+	chSeek      chan struct{}
+	notifiedGen int
+}
+
+func (s *clientSeeker) initialize() {
+	s.chSeek = make(chan struct{})
+}
+
+// setPlaylist sets the playlist of the leading stream, that is used to validate requests.
+func (s *clientSeeker) setPlaylist(pl *playlist.Media) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.playlist = pl
+}
+
+func (s *clientSeeker) seek(req *clientSeekRequest) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.playlist == nil || !isSeekable(s.playlist) {
+		return fmt.Errorf("stream is not seekable")
+	}
+
+	if _, ok := req.findSegment(s.playlist.Segments); !ok {
+		return fmt.Errorf("position is out of range")
+	}
+
+	s.gen++
+	s.request = req
+
+	close(s.chSeek)
+	s.chSeek = make(chan struct{})
+
+	return nil
+}
+
+// current returns the current generation and a channel that is closed when a new seek is requested.
+func (s *clientSeeker) current() (int, *clientSeekRequest, chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.gen, s.request, s.chSeek
+}
+
+// onFirstData is called by tracks before passing the first data of a generation to callbacks.
+func (s *clientSeeker) onFirstData(gen int) {
+	s.mutex.Lock()
+	notify := gen > s.notifiedGen
+	if notify {
+		s.notifiedGen = gen
+	}
+	s.mutex.Unlock()
+
+	if notify {
+		s.onDiscontinuity()
+	}
+}
@@ -0,0 +1,131 @@
+package gohlslib
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+type clientSegmentDownload struct {
+	ctxCancel func()
+	done      chan struct{}
+	payload   []byte
+	err       error
+}
+
+// clientSegmentPrefetcher downloads multiple segments in parallel.
+type clientSegmentPrefetcher struct {
+	concurrency       int
+	onDownloadSegment ClientOnDownloadSegmentFunc
+	download          func(ctx context.Context, u *url.URL, start *uint64, length *uint64) ([]byte, error)
+	onDownloaded      func(size int, duration time.Duration)
+
+	wg        sync.WaitGroup
+	downloads map[string]*clientSegmentDownload
+}
+
+func (p *clientSegmentPrefetcher) initialize() {
+	p.downloads = make(map[string]*clientSegmentDownload)
+}
+
+func (p *clientSegmentPrefetcher) close() {
+	for _, dl := range p.downloads {
+		dl.ctxCancel()
+	}
+	p.wg.Wait()
+}
+
+// get returns the segment in the given position and
+// starts downloading the following ones.
+func (p *clientSegmentPrefetcher) get(
+	ctx context.Context,
+	base *url.URL,
+	segments []*playlist.MediaSegment,
+	pos int,
+) ([]byte, error) {
+	window := segments[pos:min(pos+p.concurrency, len(segments))]
+	windowURLs := make([]*url.URL, len(window))
+	windowKeys := make(map[string]struct{}, len(window))
+
+	for i, seg := range window {
+		u, err := clientAbsoluteURL(base, seg.URI)
+		if err != nil {
+			return nil, err
+		}
+
+		windowURLs[i] = u
+		windowKeys[prefetchKey(u, seg)] = struct{}{}
+	}
+
+	// cancel downloads that are not needed anymore, after a seek or a variant switch
+	for key, dl := range p.downloads {
+		if _, ok := windowKeys[key]; !ok {
+			dl.ctxCancel()
+			delete(p.downloads, key)
+		}
+	}
+
+	for i, seg := range window {
+		key := prefetchKey(windowURLs[i], seg)
+		if _, ok := p.downloads[key]; ok {
+			continue
+		}
+
+		dlCtx, dlCtxCancel := context.WithCancel(ctx)
+
+		dl := &clientSegmentDownload{
+			ctxCancel: dlCtxCancel,
+			done:      make(chan struct{}),
+		}
+		p.downloads[key] = dl
+
+		u := windowURLs[i]
+
+		// the callback is called here instead of inside the download routine,
+		// in order to call it in order and never concurrently.
+		p.onDownloadSegment(u.String())
+
+		p.wg.Go(func() {
+			defer close(dl.done)
+
+			// duration is measured here, since get() usually returns
+			// segments whose download has already been completed.
+			start := time.Now()
+			dl.payload, dl.err = p.download(dlCtx, u, seg.ByteRangeStart, seg.ByteRangeLength)
+			if dl.err == nil {
+				p.onDownloaded(len(dl.payload), time.Since(start))
+			}
+		})
+	}
+
+	key := prefetchKey(windowURLs[0], window[0])
+	dl := p.downloads[key]
+	delete(p.downloads, key)
+
+	select {
+	case <-dl.done:
+	case <-ctx.Done():
+		dl.ctxCancel()
+		return nil, fmt.Errorf("terminated")
+	}
+
+	dl.ctxCancel()
+
+	return dl.payload, dl.err
+}
+
+func prefetchKey(u *url.URL, seg *playlist.MediaSegment) string {
+	key := u.String()
+	if seg.ByteRangeLength != nil {
+		if seg.ByteRangeStart != nil {
+			key += "@" + strconv.FormatUint(*seg.ByteRangeStart, 10)
+		}
+		key += "#" + strconv.FormatUint(*seg.ByteRangeLength, 10)
+	}
+	return key
+}
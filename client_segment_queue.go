@@ -9,7 +9,13 @@ import (
 type segmentData struct {
 	dateTime *time.Time
 	payload  []byte
+	key      *clientSegmentKey // SAMPLE-AES only
+	gen      int               // seek generation
 	err      error
+
+	// set in the first segment after a variant switch
+	switched bool
+	initFile []byte
 }
 
 type clientSegmentQueue struct {
@@ -38,7 +44,22 @@ func (q *clientSegmentQueue) push(seg *segmentData) {
 	q.mutex.Unlock()
 }
 
-func (q *clientSegmentQueue) waitUntilSizeIsBelow(ctx context.Context, n int) bool {
+// flush removes all segments from the queue and returns them.
+func (q *clientSegmentQueue) flush() []*segmentData {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ret := q.queue
+	q.queue = nil
+
+	close(q.didPull)
+	q.didPull = make(chan struct{})
+
+	return ret
+}
+
+// waitUntilSizeIsBelow waits until the queue size is below n or interrupt is closed.
+func (q *clientSegmentQueue) waitUntilSizeIsBelow(ctx context.Context, n int, interrupt chan struct{}) bool {
 	q.mutex.Lock()
 
 	for len(q.queue) > n {
@@ -48,6 +69,8 @@ func (q *clientSegmentQueue) waitUntilSizeIsBelow(ctx context.Context, n int) bo
 
 		select {
 		case <-didPullCopy:
+		case <-interrupt:
+			return true
 		case <-ctx.Done():
 			return false
 		}
@@ -2,6 +2,7 @@ package gohlslib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,6 +31,36 @@ func findSegmentWithID(seqNo int, segments []*playlist.MediaSegment, id int) (*p
 	return segments[index], index, len(segments) - index
 }
 
+func hasInitFile(pl *playlist.Media) bool {
+	return pl.Map != nil && pl.Map.URI != ""
+}
+
+func isLowLatency(pl *playlist.Media) bool {
+	return pl.ServerControl != nil && pl.ServerControl.CanBlockReload && pl.PreloadHint != nil
+}
+
+// preloadHintKey returns the EXT-X-KEY that applies to the preload hint.
+func preloadHintKey(pl *playlist.Media) *playlist.MediaKey {
+	if pl.PartsKey != nil {
+		return pl.PartsKey
+	}
+
+	if len(pl.Segments) == 0 {
+		return nil
+	}
+
+	return pl.Segments[len(pl.Segments)-1].Key
+}
+
+// preloadHintSeqNo returns the media sequence number of the segment the preload hint belongs to.
+func preloadHintSeqNo(pl *playlist.Media) int {
+	seqNo := pl.MediaSequence + len(pl.Segments)
+	if pl.Skip != nil {
+		seqNo += pl.Skip.SkippedSegments
+	}
+	return seqNo
+}
+
 func dateTimeOfPreloadHint(pl *playlist.Media) *time.Time {
 	if len(pl.Segments) == 0 {
 		return nil
@@ -58,20 +89,33 @@ type clientStreamDownloader struct {
 	isLeading                bool
 	startDistance            int
 	maxDistance              int
+	downloadConcurrency      int
 	httpClient               *http.Client
+	keyLoader                *clientKeyLoader
 	onRequest                ClientOnRequestFunc
 	onDownloadStreamPlaylist ClientOnDownloadStreamPlaylistFunc
 	onDownloadSegment        ClientOnDownloadSegmentFunc
 	onDownloadPart           ClientOnDownloadPartFunc
 	onDecodeError            ClientOnDecodeErrorFunc
 	playlistURL              *url.URL
+	variables                map[string]string
 	rendition                *playlist.MultivariantRendition
 	firstPlaylist            *playlist.Media
+	switcher                 *clientVariantSwitcher
+	steering                 *clientContentSteering
+	seeker                   *clientSeeker
+	cueScheduler             *clientCueScheduler // leading only
 	rp                       *clientRoutinePool
 	client                   clientStreamDownloaderClient
 
 	segmentQueue *clientSegmentQueue
 	curSegmentID *int
+	initFile     []byte
+	switchedInit []byte
+	switched     bool
+	seekGen      int
+	pathwayGen   int
+	prefetcher   *clientSegmentPrefetcher
 
 	// out
 	chTracks         chan []*Track
@@ -99,12 +143,22 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 	d.segmentQueue = &clientSegmentQueue{}
 	d.segmentQueue.initialize()
 
-	if d.firstPlaylist.Map != nil && d.firstPlaylist.Map.URI != "" {
-		initFile, err := d.downloadSegment(
-			ctx,
-			d.firstPlaylist.Map.URI,
-			d.firstPlaylist.Map.ByteRangeStart,
-			d.firstPlaylist.Map.ByteRangeLength)
+	if d.rendition != nil && d.rendition.Type == playlist.MultivariantRenditionTypeSubtitles {
+		if hasInitFile(d.firstPlaylist) {
+			return fmt.Errorf("fMP4 subtitle renditions are not supported")
+		}
+
+		proc := &clientStreamProcessorWebVTT{
+			rendition:        d.rendition,
+			segmentQueue:     d.segmentQueue,
+			streamDownloader: d,
+			client:           d.client,
+		}
+		proc.initialize()
+		d.rp.add(proc)
+	} else if hasInitFile(d.firstPlaylist) {
+		var err error
+		d.initFile, err = d.downloadInitFile(ctx, d.firstPlaylist)
 		if err != nil {
 			return err
 		}
@@ -112,8 +166,9 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 		proc := &clientStreamProcessorFMP4{
 			ctx:              ctx,
 			isLeading:        d.isLeading,
+			cueScheduler:     d.cueScheduler,
 			rendition:        d.rendition,
-			initFile:         initFile,
+			initFile:         d.initFile,
 			segmentQueue:     d.segmentQueue,
 			rp:               d.rp,
 			streamDownloader: d,
@@ -125,6 +180,7 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 		proc := &clientStreamProcessorMPEGTS{
 			onDecodeError:    d.onDecodeError,
 			isLeading:        d.isLeading,
+			cueScheduler:     d.cueScheduler,
 			segmentQueue:     d.segmentQueue,
 			rp:               d.rp,
 			streamDownloader: d,
@@ -136,9 +192,7 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 
 	var err error
 
-	if d.firstPlaylist.ServerControl != nil &&
-		d.firstPlaylist.ServerControl.CanBlockReload &&
-		d.firstPlaylist.PreloadHint != nil {
+	if isLowLatency(d.firstPlaylist) {
 		err = d.runLowLatency(ctx)
 	} else {
 		err = d.runTraditional(ctx)
@@ -156,7 +210,33 @@ func (d *clientStreamDownloader) runLowLatency(ctx context.Context) error {
 	pl := d.firstPlaylist
 
 	for {
+		if pl.PreloadHint == nil {
+			return fmt.Errorf("preload hint disappeared")
+		}
+
+		d.processDateRanges(pl)
+
+		// parts cannot be decrypted independently from the rest of the segment
+		partKey := preloadHintKey(pl)
+		if partKey != nil && partKey.Method == playlist.MediaKeyMethodAES128 {
+			return fmt.Errorf("AES-128 encryption of Low-Latency streams is not supported")
+		}
+
 		byts, err := d.downloadPreloadHint(ctx, pl.PreloadHint)
+		if err != nil {
+			if !d.failover(ctx, err) {
+				return err
+			}
+
+			// download the part again from another pathway
+			pl, err = d.downloadNextPlaylist(ctx, false)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		byts, key, err := d.decryptSegment(ctx, partKey, preloadHintSeqNo(pl), byts)
 		if err != nil {
 			return err
 		}
@@ -164,43 +244,259 @@ func (d *clientStreamDownloader) runLowLatency(ctx context.Context) error {
 		d.segmentQueue.push(&segmentData{
 			dateTime: dateTimeOfPreloadHint(pl),
 			payload:  byts,
+			key:      key,
+			switched: d.switched,
+			initFile: d.switchedInit,
 		})
+		d.switched = false
+		d.switchedInit = nil
 
-		pl, err = d.downloadPlaylist(ctx, d.firstPlaylist.ServerControl.CanSkipUntil != nil)
+		pl, err = d.downloadNextPlaylist(ctx, d.firstPlaylist.ServerControl.CanSkipUntil != nil)
 		if err != nil {
 			return err
 		}
-
-		if pl.PreloadHint == nil {
-			return fmt.Errorf("preload hint disappeared")
-		}
 	}
 }
 
 func (d *clientStreamDownloader) runTraditional(ctx context.Context) error {
 	pl := d.firstPlaylist
 
+	if d.downloadConcurrency > 1 {
+		d.prefetcher = &clientSegmentPrefetcher{
+			concurrency:       d.downloadConcurrency,
+			onDownloadSegment: d.onDownloadSegment,
+			download:          d.downloadSegmentURL,
+			onDownloaded:      d.onSegmentDownloaded,
+		}
+		d.prefetcher.initialize()
+		defer d.prefetcher.close()
+	}
+
 	for {
-		seg, payload, err := d.downloadNextSegment(ctx, pl)
+		if d.isLeading {
+			d.seeker.setPlaylist(pl)
+		}
+
+		d.processDateRanges(pl)
+
+		gen, req, chSeek := d.seeker.current()
+		if gen != d.seekGen {
+			d.seek(pl, gen, req)
+		}
+
+		prevSegmentID := d.curSegmentID
+
+		seg, payload, key, err := d.downloadNextSegment(ctx, pl)
 		if err != nil {
-			return err
+			if !d.failover(ctx, err) {
+				return err
+			}
+
+			// download the segment again from another pathway
+			d.curSegmentID = prevSegmentID
+
+			pl, err = d.downloadNextPlaylist(ctx, false)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
 		d.segmentQueue.push(&segmentData{
 			dateTime: seg.DateTime,
 			payload:  payload,
+			key:      key,
+			gen:      d.seekGen,
+			switched: d.switched,
+			initFile: d.switchedInit,
 		})
+		d.switched = false
+		d.switchedInit = nil
 
-		ok := d.segmentQueue.waitUntilSizeIsBelow(ctx, 1)
+		ok := d.segmentQueue.waitUntilSizeIsBelow(ctx, 1, chSeek)
 		if !ok {
 			return fmt.Errorf("terminated")
 		}
 
-		pl, err = d.downloadPlaylist(ctx, false)
+		pl, err = d.downloadNextPlaylist(ctx, false)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *clientStreamDownloader) processDateRanges(pl *playlist.Media) {
+	if d.cueScheduler != nil {
+		d.cueScheduler.pushDateRanges(pl.AllDateRanges())
+	}
+}
+
+// seek moves the next segment to the one that contains the requested position
+// and discards segments that have not been processed yet.
+func (d *clientStreamDownloader) seek(pl *playlist.Media, gen int, req *clientSeekRequest) {
+	index, ok := req.findSegment(pl.Segments)
+	if !ok {
+		// playlists of renditions may be shorter than the leading one
+		index = len(pl.Segments) - 1
+	}
+
+	d.curSegmentID = ptrOf(pl.MediaSequence + index - 1)
+	d.seekGen = gen
+
+	// preserve pending variant switches
+	for _, seg := range d.segmentQueue.flush() {
+		if seg.switched {
+			d.switched = true
+			if seg.initFile != nil {
+				d.switchedInit = seg.initFile
+			}
+		}
+	}
+}
+
+func (d *clientStreamDownloader) downloadNextPlaylist(
+	ctx context.Context,
+	skipUntil bool,
+) (*playlist.Media, error) {
+	for {
+		if d.steering != nil {
+			err := d.followPathway()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		pl, err := d.downloadNextPlaylistFromCurrentPathway(ctx, skipUntil)
+		if err != nil {
+			if d.failover(ctx, err) {
+				continue
+			}
+			return nil, err
+		}
+
+		return pl, nil
+	}
+}
+
+func (d *clientStreamDownloader) downloadNextPlaylistFromCurrentPathway(
+	ctx context.Context,
+	skipUntil bool,
+) (*playlist.Media, error) {
+	if d.switcher != nil {
+		if v := d.switcher.nextVariant(); v != nil {
+			pl, ok, err := d.switchVariant(ctx, v)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return pl, nil
+			}
+		}
+	}
+
+	return d.downloadPlaylist(ctx, skipUntil)
+}
+
+// followPathway moves the stream to the current pathway of content steering, when it changes.
+// The leading stream downloads variants from the new pathway, while renditions
+// download the equivalent rendition.
+func (d *clientStreamDownloader) followPathway() error {
+	p, gen := d.steering.currentPathway()
+	if gen == d.pathwayGen {
+		return nil
+	}
+	d.pathwayGen = gen
+
+	if d.rendition != nil {
+		r := d.steering.equivalentRendition(d.rendition, p)
+		if r == nil || r.URI == nil {
+			return fmt.Errorf("rendition '%s' not found in pathway '%s'", d.rendition.Name, p.id)
+		}
+
+		u, err := clientAbsoluteURL(d.steering.primaryPlaylistURL, *r.URI)
 		if err != nil {
 			return err
 		}
+
+		d.rendition = r
+		d.playlistURL = u
+		return nil
 	}
+
+	pathwayVariants := make(map[*playlist.MultivariantVariant]*playlist.MultivariantVariant)
+
+	for _, v := range d.switcher.allVariants() {
+		if v2 := d.steering.equivalentVariant(v, p); v2 != nil {
+			pathwayVariants[v] = v2
+		}
+	}
+
+	if _, ok := pathwayVariants[d.switcher.currentVariant()]; !ok {
+		return fmt.Errorf("variant not found in pathway '%s'", p.id)
+	}
+
+	d.switcher.setPathwayVariants(pathwayVariants)
+	return nil
+}
+
+// failover switches to another pathway of content steering after a download error.
+// It returns false when there are no other pathways available.
+func (d *clientStreamDownloader) failover(ctx context.Context, err error) bool {
+	if d.steering == nil || ctx.Err() != nil || errors.Is(err, ErrClientEOS) {
+		return false
+	}
+
+	return d.steering.failover(d.pathwayGen)
+}
+
+// switchVariant downloads the playlist of another variant and prepares the next segment,
+// or the next part in case of Low-Latency streams, to be pushed into the queue.
+// It returns false if the variant is not compatible.
+func (d *clientStreamDownloader) switchVariant(
+	ctx context.Context,
+	v *playlist.MultivariantVariant,
+) (*playlist.Media, bool, error) {
+	u, err := d.switcher.variantURL(v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	prevURL := d.playlistURL
+	d.playlistURL = u
+
+	pl, err := d.downloadPlaylist(ctx, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if hasInitFile(pl) != hasInitFile(d.firstPlaylist) || isLowLatency(pl) != isLowLatency(d.firstPlaylist) {
+		d.playlistURL = prevURL
+		d.switcher.exclude(v)
+		return nil, false, nil
+	}
+
+	var initFile []byte
+
+	if hasInitFile(pl) {
+		initFile, err = d.downloadInitFile(ctx, pl)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !fmp4InitFilesAreCompatible(d.initFile, initFile) {
+			d.playlistURL = prevURL
+			d.switcher.exclude(v)
+			return nil, false, nil
+		}
+
+		d.initFile = initFile
+	}
+
+	d.switched = true
+	d.switchedInit = initFile
+	d.switcher.setCurrent(v)
+
+	return pl, true, nil
 }
 
 func (d *clientStreamDownloader) downloadPlaylist(
@@ -219,7 +515,7 @@ func (d *clientStreamDownloader) downloadPlaylist(
 
 	d.onDownloadStreamPlaylist(ur.String())
 
-	finalURL, pl, err := downloadPlaylist(ctx, d.httpClient, d.onRequest, ur)
+	finalURL, pl, err := downloadPlaylist(ctx, d.httpClient, d.onRequest, ur, d.variables)
 	if err != nil {
 		return nil, err
 	}
@@ -273,14 +569,28 @@ func (d *clientStreamDownloader) downloadPreloadHint(
 		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
 	}
 
+	// preload hints are blocking requests, whose response is sent when the part is available.
+	// Exclude the time spent waiting for the part from the bandwidth estimation.
+	start := time.Now()
+
 	byts, err := io.ReadAll(&customLimitReader{res.Body, clientMaxInboundPartSize})
 	if err != nil {
 		return nil, err
 	}
 
+	d.onSegmentDownloaded(len(byts), time.Since(start))
+
 	return byts, nil
 }
 
+func (d *clientStreamDownloader) downloadInitFile(ctx context.Context, pl *playlist.Media) ([]byte, error) {
+	return d.downloadSegment(
+		ctx,
+		pl.Map.URI,
+		pl.Map.ByteRangeStart,
+		pl.Map.ByteRangeLength)
+}
+
 func (d *clientStreamDownloader) downloadSegment(
 	ctx context.Context,
 	uri string,
@@ -294,6 +604,15 @@ func (d *clientStreamDownloader) downloadSegment(
 
 	d.onDownloadSegment(u.String())
 
+	return d.downloadSegmentURL(ctx, u, start, length)
+}
+
+func (d *clientStreamDownloader) downloadSegmentURL(
+	ctx context.Context,
+	u *url.URL,
+	start *uint64,
+	length *uint64,
+) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
@@ -330,7 +649,7 @@ func (d *clientStreamDownloader) downloadSegment(
 func (d *clientStreamDownloader) downloadNextSegment(
 	ctx context.Context,
 	pl *playlist.Media,
-) (*playlist.MediaSegment, []byte, error) {
+) (*playlist.MediaSegment, []byte, *clientSegmentKey, error) {
 	var seg *playlist.MediaSegment
 	var segPos int
 
@@ -339,14 +658,14 @@ func (d *clientStreamDownloader) downloadNextSegment(
 			*d.firstPlaylist.PlaylistType == playlist.MediaPlaylistTypeVOD) || d.firstPlaylist.Endlist {
 			// VOD stream: start from the beginning
 			if len(pl.Segments) == 0 {
-				return nil, nil, fmt.Errorf("no segments found")
+				return nil, nil, nil, fmt.Errorf("no segments found")
 			}
 			seg = pl.Segments[0]
 		} else {
 			// live stream: start from clientLiveInitialDistance
 			seg, segPos = findSegmentWithInvPosition(pl.Segments, d.startDistance)
 			if seg == nil {
-				return nil, nil, fmt.Errorf("there aren't enough segments to fill the buffer")
+				return nil, nil, nil, fmt.Errorf("there aren't enough segments to fill the buffer")
 			}
 		}
 	} else {
@@ -354,24 +673,91 @@ func (d *clientStreamDownloader) downloadNextSegment(
 		seg, segPos, invPos = findSegmentWithID(pl.MediaSequence, pl.Segments, *d.curSegmentID+1)
 		if seg == nil {
 			if pl.Endlist {
-				return nil, nil, ErrClientEOS
+				return nil, nil, nil, ErrClientEOS
 			}
-			return nil, nil, fmt.Errorf("next segment not found or not ready yet")
+			return nil, nil, nil, fmt.Errorf("next segment not found or not ready yet")
 		}
 
-		if !pl.Endlist && invPos > d.maxDistance {
-			return nil, nil, fmt.Errorf("playback is too late")
+		// after a seek, playback is allowed to be behind the live edge
+		if !pl.Endlist && invPos > d.maxDistance && d.seekGen == 0 {
+			return nil, nil, nil, fmt.Errorf("playback is too late")
 		}
 	}
 
 	d.curSegmentID = ptrOf(pl.MediaSequence + segPos)
 
-	byts, err := d.downloadSegment(ctx, seg.URI, seg.ByteRangeStart, seg.ByteRangeLength)
+	var byts []byte
+	var err error
+
+	if d.prefetcher != nil {
+		byts, err = d.prefetcher.get(ctx, d.playlistURL, pl.Segments, segPos)
+	} else {
+		start := time.Now()
+		byts, err = d.downloadSegment(ctx, seg.URI, seg.ByteRangeStart, seg.ByteRangeLength)
+		if err == nil {
+			d.onSegmentDownloaded(len(byts), time.Since(start))
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	byts, key, err := d.decryptSegment(ctx, seg.Key, pl.MediaSequence+segPos, byts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return seg, byts, key, nil
+}
+
+func (d *clientStreamDownloader) onSegmentDownloaded(size int, duration time.Duration) {
+	if d.switcher != nil {
+		d.switcher.onSegmentDownloaded(size, duration)
+	}
+}
+
+func (d *clientStreamDownloader) decryptSegment(
+	ctx context.Context,
+	segKey *playlist.MediaKey,
+	seqNo int,
+	payload []byte,
+) ([]byte, *clientSegmentKey, error) {
+	if segKey == nil || segKey.Method == playlist.MediaKeyMethodNone {
+		return payload, nil, nil
+	}
+
+	if segKey.Method != playlist.MediaKeyMethodAES128 && segKey.Method != playlist.MediaKeyMethodSampleAES {
+		return nil, nil, fmt.Errorf("unsupported encryption method: %v", segKey.Method)
+	}
+
+	u, err := clientAbsoluteURL(d.playlistURL, segKey.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// samples are decrypted by the stream processor,
+	// since the key ID is stored inside the segment.
+	if segKey.Method == playlist.MediaKeyMethodSampleAES {
+		return payload, &clientSegmentKey{
+			loader: d.keyLoader,
+			url:    u,
+			key:    segKey,
+			seqNo:  seqNo,
+		}, nil
+	}
+
+	key, err := d.keyLoader.load(ctx, u, segKey, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iv, err := aes128IV(segKey, seqNo)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return seg, byts, nil
+	payload, err = decryptAES128(payload, key, iv)
+	return payload, nil, err
 }
 
 func (d *clientStreamDownloader) setTracks(ctx context.Context, tracks []*Track) ([]*clientTrack, bool) {
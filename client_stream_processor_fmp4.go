@@ -2,12 +2,17 @@ package gohlslib
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/cipher"
 	"fmt"
+	"reflect"
+	"slices"
 	"sync"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
@@ -43,9 +48,61 @@ func findTimeScaleOfLeadingTrack(tracks []*fmp4.InitTrack, leadingTrackID int) u
 	return 0
 }
 
+func fmp4InitsAreCompatible(a *fmp4.Init, b *fmp4.Init) bool {
+	if len(a.Tracks) != len(b.Tracks) {
+		return false
+	}
+
+	for i, track := range a.Tracks {
+		if reflect.TypeOf(track.Codec) != reflect.TypeOf(b.Tracks[i].Codec) ||
+			track.TimeScale != b.Tracks[i].TimeScale {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fmp4UnmarshalInit decodes an initialization file, that may be protected.
+// It returns protection parameters of each track ID, or nil if the file is not protected.
+func fmp4UnmarshalInit(byts []byte) (*fmp4.Init, map[int]*fmp4TrackProtection, error) {
+	var protections map[int]*fmp4TrackProtection
+
+	if bytes.Contains(byts, []byte("sinf")) {
+		var err error
+		byts, protections, err = fmp4UnprotectInit(byts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var init fmp4.Init
+	err := init.Unmarshal(bytes.NewReader(byts))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &init, protections, nil
+}
+
+func fmp4InitFilesAreCompatible(a []byte, b []byte) bool {
+	initA, _, err := fmp4UnmarshalInit(a)
+	if err != nil {
+		return false
+	}
+
+	initB, _, err := fmp4UnmarshalInit(b)
+	if err != nil {
+		return false
+	}
+
+	return fmp4InitsAreCompatible(initA, initB)
+}
+
 type clientStreamProcessorFMP4 struct {
 	ctx              context.Context
 	isLeading        bool
+	cueScheduler     *clientCueScheduler
 	rendition        *playlist.MultivariantRendition
 	initFile         []byte
 	segmentQueue     *clientSegmentQueue
@@ -54,8 +111,10 @@ type clientStreamProcessorFMP4 struct {
 	client           clientStreamDownloaderClient
 
 	init               fmp4.Init
+	protections        map[int]*fmp4TrackProtection
 	leadingTrackID     int
 	trackProcessors    map[int]*clientTrackProcessorFMP4
+	id3TrackProcessor  *clientTrackProcessorFMP4
 	clientStreamTracks []*clientTrack
 	timeConv           *clientTimeConvFMP4
 	segmentWaitGroup   *sync.WaitGroup
@@ -65,11 +124,14 @@ func (p *clientStreamProcessorFMP4) initialize() {
 }
 
 func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
-	err := p.init.Unmarshal(bytes.NewReader(p.initFile))
+	init, protections, err := fmp4UnmarshalInit(p.initFile)
 	if err != nil {
 		return err
 	}
 
+	p.init = *init
+	p.protections = protections
+
 	if !p.isLeading && len(p.init.Tracks) != 1 {
 		return fmt.Errorf("rendition playlists with multiple tracks are not supported")
 	}
@@ -103,11 +165,32 @@ func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
 		}
 	}
 
+	var ok bool
+	var firstSeg *segmentData
+
+	// ID3 tags are not declared by the initialization file.
+	// Look for them in the first segment of the leading stream.
+	if p.isLeading {
+		firstSeg, ok = p.segmentQueue.pull(ctx)
+		if !ok {
+			return fmt.Errorf("terminated")
+		}
+
+		if firstSeg.err == nil {
+			emsgs, err2 := fmp4ReadID3Emsgs(firstSeg.payload, 0, 1)
+			if err2 == nil && len(emsgs) != 0 {
+				tracks = append(tracks, &Track{
+					Codec:     &codecs.ID3{},
+					ClockRate: 90000,
+				})
+			}
+		}
+	}
+
 	if len(tracks) > clientMaxTracksPerStream {
 		return fmt.Errorf("too many tracks per stream")
 	}
 
-	var ok bool
 	p.clientStreamTracks, ok = p.streamDownloader.setTracks(p.ctx, tracks)
 	if !ok {
 		return fmt.Errorf("terminated")
@@ -115,9 +198,14 @@ func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
 
 	for {
 		var seg *segmentData
-		seg, ok = p.segmentQueue.pull(ctx)
-		if !ok {
-			return fmt.Errorf("terminated")
+
+		if firstSeg != nil {
+			seg, firstSeg = firstSeg, nil
+		} else {
+			seg, ok = p.segmentQueue.pull(ctx)
+			if !ok {
+				return fmt.Errorf("terminated")
+			}
 		}
 
 		if seg.err != nil {
@@ -126,6 +214,13 @@ func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
 			return fmt.Errorf("terminated")
 		}
 
+		if seg.initFile != nil {
+			err = p.switchInit(seg.initFile)
+			if err != nil {
+				return err
+			}
+		}
+
 		err = p.processSegment(ctx, seg)
 		if err != nil {
 			return err
@@ -133,6 +228,37 @@ func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
 	}
 }
 
+// switchInit replaces the initialization file after a variant switch,
+// routing new track IDs to existing track processors.
+func (p *clientStreamProcessorFMP4) switchInit(initFile []byte) error {
+	init, protections, err := fmp4UnmarshalInit(initFile)
+	if err != nil {
+		return err
+	}
+
+	if !fmp4InitsAreCompatible(&p.init, init) {
+		return fmt.Errorf("tracks of the new variant are not compatible with current ones")
+	}
+
+	if p.trackProcessors != nil {
+		trackProcessors := make(map[int]*clientTrackProcessorFMP4)
+
+		for i, track := range init.Tracks {
+			trackProc := p.trackProcessors[p.init.Tracks[i].ID]
+			trackProc.setParams(fromFMP4(track.Codec))
+			trackProcessors[track.ID] = trackProc
+		}
+
+		p.trackProcessors = trackProcessors
+	}
+
+	p.init = *init
+	p.protections = protections
+	p.leadingTrackID = fmp4PickLeadingTrack(&p.init)
+
+	return nil
+}
+
 func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *segmentData) error {
 	var parts fmp4.Parts
 	err := parts.Unmarshal(seg.payload)
@@ -140,6 +266,13 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 		return err
 	}
 
+	if p.protections != nil {
+		err = p.decryptParts(ctx, seg, parts)
+		if err != nil {
+			return err
+		}
+	}
+
 	leadingPartTrack := findFirstPartTrackOfLeadingTrack(parts, p.leadingTrackID)
 	if leadingPartTrack == nil {
 		return fmt.Errorf("could not find data of leading track")
@@ -183,6 +316,7 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 			p.segmentWaitGroup.Add(1)
 
 			err = trackProc.push(ctx, &procEntryFMP4{
+				gen:       seg.gen,
 				partTrack: partTrack,
 				dts:       dts,
 				ntp:       ntp,
@@ -194,11 +328,134 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 		}
 	}
 
+	if p.id3TrackProcessor != nil {
+		err = p.processID3(ctx, seg, leadingPartTrack)
+		if err != nil {
+			return err
+		}
+	}
+
 	p.segmentWaitGroup.Wait()
 
 	return nil
 }
 
+// processID3 passes ID3 tags carried by Event Message boxes to the ID3 track.
+func (p *clientStreamProcessorFMP4) processID3(
+	ctx context.Context,
+	seg *segmentData,
+	leadingPartTrack *fmp4.PartTrack,
+) error {
+	emsgs, err := fmp4ReadID3Emsgs(seg.payload, leadingPartTrack.BaseTime,
+		findTimeScaleOfLeadingTrack(p.init.Tracks, p.leadingTrackID))
+	if err != nil {
+		return err
+	}
+
+	if len(emsgs) == 0 {
+		return nil
+	}
+
+	clockRate := int64(p.id3TrackProcessor.track.track.ClockRate)
+
+	emsgTime := func(emsg *fmp4Emsg) int64 {
+		return multiplyAndDivide(int64(emsg.presentationTime), clockRate, int64(emsg.timeScale))
+	}
+
+	slices.SortStableFunc(emsgs, func(a, b *fmp4Emsg) int {
+		return cmp.Compare(emsgTime(a), emsgTime(b))
+	})
+
+	// tags are converted into samples, whose duration is the distance from the next one
+	partTrack := &fmp4.PartTrack{
+		BaseTime: uint64(emsgTime(emsgs[0])),
+	}
+
+	for i, emsg := range emsgs {
+		sample := &fmp4.Sample{
+			Payload: emsg.messageData,
+		}
+		if i != (len(emsgs) - 1) {
+			sample.Duration = uint32(emsgTime(emsgs[i+1]) - emsgTime(emsg))
+		}
+		partTrack.Samples = append(partTrack.Samples, sample)
+	}
+
+	dts := p.timeConv.convert(int64(partTrack.BaseTime), int(clockRate))
+	ntp := p.timeConv.getNTP(ctx, dts, int(clockRate))
+
+	p.segmentWaitGroup.Add(1)
+
+	err = p.id3TrackProcessor.push(ctx, &procEntryFMP4{
+		gen:       seg.gen,
+		partTrack: partTrack,
+		dts:       dts,
+		ntp:       ntp,
+	})
+	if err != nil {
+		p.segmentWaitGroup.Done()
+		return err
+	}
+
+	return nil
+}
+
+// decryptParts decrypts samples of protected tracks in place.
+func (p *clientStreamProcessorFMP4) decryptParts(ctx context.Context, seg *segmentData, parts fmp4.Parts) error {
+	if seg.key == nil {
+		return fmt.Errorf("segment is protected but the playlist does not provide a SAMPLE-AES key")
+	}
+
+	encs, err := fmp4ReadSampleEncryptions(seg.payload, p.protections)
+	if err != nil {
+		return err
+	}
+
+	if len(encs) != len(parts) {
+		return fmt.Errorf("unable to read sample encryption parameters")
+	}
+
+	blocks := make(map[string]cipher.Block)
+
+	for i, part := range parts {
+		for _, partTrack := range part.Tracks {
+			prot, ok := p.protections[partTrack.ID]
+			if !ok || !prot.isProtected {
+				continue
+			}
+
+			block, ok := blocks[string(prot.kid)]
+			if !ok {
+				block, err = seg.key.load(ctx, prot.kid)
+				if err != nil {
+					return err
+				}
+				blocks[string(prot.kid)] = block
+			}
+
+			trackEncs, ok := encs[i][partTrack.ID]
+			if ok && len(trackEncs) != len(partTrack.Samples) {
+				return fmt.Errorf("sample count of senc box (%d) does not match the one of trun box (%d)",
+					len(trackEncs), len(partTrack.Samples))
+			}
+
+			for j, sample := range partTrack.Samples {
+				var enc *fmp4SampleEncryption
+				if ok {
+					enc = trackEncs[j]
+				}
+
+				err = fmp4DecryptSample(block, prot, enc, sample.Payload)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func (p *clientStreamProcessorFMP4) onPartTrackProcessed() {
 	p.segmentWaitGroup.Done()
 }
@@ -217,9 +474,19 @@ func (p *clientStreamProcessorFMP4) initializeTrackProcessors() error {
 		}
 		p.rp.add(trackProc)
 
+		// the ID3 track is the only one that is not declared by the initialization file
+		if i >= len(p.init.Tracks) {
+			p.id3TrackProcessor = trackProc
+			continue
+		}
+
 		p.trackProcessors[p.init.Tracks[i].ID] = trackProc
 	}
 
+	if p.isLeading {
+		p.trackProcessors[p.leadingTrackID].track.cueScheduler = p.cueScheduler
+	}
+
 	return nil
 }
 
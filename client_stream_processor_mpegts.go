@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
 	tscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
@@ -16,14 +17,17 @@ import (
 func mpegtsPickLeadingTrack(mpegtsTracks []*mpegts.Track) int {
 	// pick first video track
 	for i, track := range mpegtsTracks {
-		if _, ok := track.Codec.(*tscodecs.H264); ok {
+		switch track.Codec.(type) {
+		case *tscodecs.H265, *tscodecs.H264, *tscodecs.MPEG4Video, *tscodecs.MPEG1Video:
 			return i
 		}
 	}
 
-	// pick first non-KLV track
+	// pick first non-KLV, non-ID3 track
 	for i, track := range mpegtsTracks {
-		if _, ok := track.Codec.(*tscodecs.KLV); !ok {
+		switch track.Codec.(type) {
+		case *tscodecs.KLV, *tscodecs.Unsupported:
+		default:
 			return i
 		}
 	}
@@ -48,6 +52,7 @@ type clientStreamProcessorStreamDownloader interface {
 type clientStreamProcessorMPEGTS struct {
 	onDecodeError    ClientOnDecodeErrorFunc
 	isLeading        bool
+	cueScheduler     *clientCueScheduler
 	segmentQueue     *clientSegmentQueue
 	rp               *clientRoutinePool
 	streamDownloader clientStreamProcessorStreamDownloader
@@ -57,10 +62,13 @@ type clientStreamProcessorMPEGTS struct {
 	reader            *mpegts.Reader
 	trackProcessors   map[*Track]*clientTrackProcessorMPEGTS
 	curSegment        *segmentData
+	decryptor         *sampleAESDecryptor
+	id3PIDs           map[uint16]struct{}
 	leadingTrackFound bool
 	dateTimeProcessed bool
 	streamTracks      []*clientTrack
 	timeConv          *clientTimeConvMPEGTS
+	scte35Sections    [][]byte
 	queuedSamples     []func() error
 
 	chTrackProcessorDone chan struct{}
@@ -91,12 +99,29 @@ func (p *clientStreamProcessorMPEGTS) run(ctx context.Context) error {
 }
 
 func (p *clientStreamProcessorMPEGTS) processSegment(ctx context.Context, seg *segmentData) error {
-	if p.switchableReader == nil {
+	p.decryptor = nil
+
+	if seg.key != nil {
+		err := p.initializeDecryptor(ctx, seg)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case p.switchableReader == nil:
 		err := p.initializeReader(ctx, seg.payload)
 		if err != nil {
 			return err
 		}
-	} else {
+
+	case seg.switched:
+		err := p.reinitializeReader(ctx, seg.payload)
+		if err != nil {
+			return err
+		}
+
+	default:
 		p.switchableReader.r = bytes.NewReader(seg.payload)
 	}
 
@@ -104,6 +129,10 @@ func (p *clientStreamProcessorMPEGTS) processSegment(ctx context.Context, seg *s
 	p.leadingTrackFound = false
 	p.dateTimeProcessed = false
 
+	if p.cueScheduler != nil && p.cueScheduler.onSCTE35 != nil {
+		p.scte35Sections = mpegtsSCTE35Sections(seg.payload)
+	}
+
 	for {
 		err := p.reader.Read()
 		if err != nil {
@@ -121,6 +150,30 @@ func (p *clientStreamProcessorMPEGTS) processSegment(ctx context.Context, seg *s
 	return p.joinTrackProcessors(ctx)
 }
 
+func (p *clientStreamProcessorMPEGTS) initializeDecryptor(ctx context.Context, seg *segmentData) error {
+	err := mpegtsUnprotectPMT(seg.payload)
+	if err != nil {
+		return err
+	}
+
+	block, err := seg.key.load(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	iv, err := aes128IV(seg.key.key, seg.key.seqNo)
+	if err != nil {
+		return err
+	}
+
+	p.decryptor = &sampleAESDecryptor{
+		block: block,
+		iv:    iv,
+	}
+
+	return nil
+}
+
 func (p *clientStreamProcessorMPEGTS) joinTrackProcessors(ctx context.Context) error {
 	for _, proc := range p.trackProcessors {
 		err := proc.push(ctx, nil)
@@ -147,39 +200,56 @@ func (p *clientStreamProcessorMPEGTS) onPartProcessorDone(ctx context.Context) {
 	}
 }
 
-func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firstPayload []byte) error {
-	p.switchableReader = &switchableReader{bytes.NewReader(firstPayload)}
+func (p *clientStreamProcessorMPEGTS) createReader(payload []byte) ([]*mpegts.Track, error) {
+	p.switchableReader = &switchableReader{bytes.NewReader(payload)}
 
 	p.reader = &mpegts.Reader{R: p.switchableReader}
 	err := p.reader.Initialize()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	p.reader.OnDecodeError(func(err error) {
 		p.onDecodeError(err)
 	})
 
+	// timed ID3 tracks are not recognized by the reader, find them manually
+	p.id3PIDs = mpegtsID3PIDs(payload)
+
 	var supportedTracks []*mpegts.Track
 
 	for _, track := range p.reader.Tracks() {
 		switch track.Codec.(type) {
-		case *tscodecs.H264, *tscodecs.MPEG4Audio, *tscodecs.KLV:
+		case *tscodecs.H265, *tscodecs.H264, *tscodecs.MPEG4Video, *tscodecs.MPEG1Video,
+			*tscodecs.Opus, *tscodecs.MPEG4Audio, *tscodecs.MPEG4AudioLATM,
+			*tscodecs.MPEG1Audio, *tscodecs.AC3, *tscodecs.EAC3, *tscodecs.KLV:
 			supportedTracks = append(supportedTracks, track)
+
+		case *tscodecs.Unsupported:
+			if _, ok := p.id3PIDs[track.PID]; ok {
+				supportedTracks = append(supportedTracks, track)
+			}
 		}
 	}
 
 	if len(supportedTracks) == 0 {
-		return fmt.Errorf("no supported tracks found")
+		return nil, fmt.Errorf("no supported tracks found")
 	}
 
-	leadingTrackID := mpegtsPickLeadingTrack(supportedTracks)
+	return supportedTracks, nil
+}
+
+func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firstPayload []byte) error {
+	supportedTracks, err := p.createReader(firstPayload)
+	if err != nil {
+		return err
+	}
 
 	tracks := make([]*Track, len(supportedTracks))
 
 	for i, mpegtsTrack := range supportedTracks {
 		tracks[i] = &Track{
-			Codec:     fromMPEGTS(mpegtsTrack.Codec),
+			Codec:     p.trackCodec(mpegtsTrack),
 			ClockRate: 90000,
 		}
 	}
@@ -199,30 +269,117 @@ func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firs
 		return err
 	}
 
+	p.setReaderCallbacks(ctx, supportedTracks)
+
+	return nil
+}
+
+// reinitializeReader creates a new reader after a variant switch,
+// routing its tracks to existing track processors.
+func (p *clientStreamProcessorMPEGTS) reinitializeReader(ctx context.Context, payload []byte) error {
+	supportedTracks, err := p.createReader(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(supportedTracks) != len(p.streamTracks) {
+		return fmt.Errorf("tracks of the new variant are not compatible with current ones")
+	}
+
+	for i, mpegtsTrack := range supportedTracks {
+		if reflect.TypeOf(p.trackCodec(mpegtsTrack)) != reflect.TypeOf(p.streamTracks[i].track.Codec) {
+			return fmt.Errorf("tracks of the new variant are not compatible with current ones")
+		}
+	}
+
+	p.setReaderCallbacks(ctx, supportedTracks)
+
+	return nil
+}
+
+func (p *clientStreamProcessorMPEGTS) trackCodec(mpegtsTrack *mpegts.Track) codecs.Codec {
+	if _, ok := p.id3PIDs[mpegtsTrack.PID]; ok {
+		return &codecs.ID3{}
+	}
+	return fromMPEGTS(mpegtsTrack.Codec)
+}
+
+func (p *clientStreamProcessorMPEGTS) setReaderCallbacks(ctx context.Context, supportedTracks []*mpegts.Track) {
+	leadingTrackID := mpegtsPickLeadingTrack(supportedTracks)
+
 	for i, mpegtsTrack := range supportedTracks {
 		track := p.streamTracks[i]
 		isLeadingTrack := (i == leadingTrackID)
 		trackProc := p.trackProcessors[track.track]
 
+		if p.isLeading && isLeadingTrack {
+			track.cueScheduler = p.cueScheduler
+		}
+
 		switch track.track.Codec.(type) {
+		case *codecs.H265:
+			p.reader.OnDataH265(mpegtsTrack, func(pts int64, dts int64, au [][]byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, dts, au)
+			})
+
 		case *codecs.H264:
 			p.reader.OnDataH264(mpegtsTrack, func(pts int64, dts int64, au [][]byte) error {
+				if p.decryptor != nil {
+					au = p.decryptor.decryptH264(au)
+				}
 				return p.processSample(ctx, isLeadingTrack, trackProc, pts, dts, au)
 			})
 
+		case *codecs.MPEG4Video, *codecs.MPEG1Video:
+			p.reader.OnDataMPEGxVideo(mpegtsTrack, func(pts int64, frame []byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, [][]byte{frame})
+			})
+
+		case *codecs.Opus:
+			p.reader.OnDataOpus(mpegtsTrack, func(pts int64, packets [][]byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, packets)
+			})
+
 		case *codecs.MPEG4Audio:
 			p.reader.OnDataMPEG4Audio(mpegtsTrack, func(pts int64, aus [][]byte) error {
+				if p.decryptor != nil {
+					aus = p.decryptor.decryptMPEG4Audio(aus)
+				}
 				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, aus)
 			})
 
+		case *codecs.MPEG4AudioLATM:
+			p.reader.OnDataMPEG4AudioLATM(mpegtsTrack, func(pts int64, els [][]byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, els)
+			})
+
+		case *codecs.MPEG1Audio:
+			p.reader.OnDataMPEG1Audio(mpegtsTrack, func(pts int64, frames [][]byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, frames)
+			})
+
+		case *codecs.AC3:
+			p.reader.OnDataAC3(mpegtsTrack, func(pts int64, frame []byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, [][]byte{frame})
+			})
+
+		case *codecs.EAC3:
+			p.reader.OnDataEAC3(mpegtsTrack, func(pts int64, frame []byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, [][]byte{frame})
+			})
+
 		case *codecs.KLV:
 			p.reader.OnDataKLV(mpegtsTrack, func(pts int64, data []byte) error {
 				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, [][]byte{data})
 			})
+
+		case *codecs.ID3:
+			// timed ID3 PES packets have the same format of DVB subtitle ones.
+			p.reader.OnDataDVBSubtitle(mpegtsTrack, func(pts int64, tag []byte) error {
+				return p.processSample(ctx, isLeadingTrack, trackProc, pts, pts, [][]byte{tag})
+			})
 		}
 	}
-
-	return nil
 }
 
 func (p *clientStreamProcessorMPEGTS) processSample(
@@ -291,11 +448,14 @@ func (p *clientStreamProcessorMPEGTS) processSample2(
 			p.timeConv.setNTP(*p.curSegment.dateTime, dts)
 		}
 		p.timeConv.setLeadingNTPReceived()
+
+		p.processSCTE35Sections(dts)
 	}
 
 	ntp := p.timeConv.getNTP(ctx, dts)
 
 	return trackProc.push(ctx, &procEntryMPEGTS{
+		gen:  p.curSegment.gen,
 		pts:  pts,
 		dts:  dts,
 		ntp:  ntp,
@@ -303,6 +463,21 @@ func (p *clientStreamProcessorMPEGTS) processSample2(
 	})
 }
 
+// processSCTE35Sections passes SCTE-35 sections of the current segment to the cue scheduler.
+// Sections without a splice time are scheduled at the start of the segment.
+func (p *clientStreamProcessorMPEGTS) processSCTE35Sections(segmentStartDTS int64) {
+	for _, section := range p.scte35Sections {
+		pts := segmentStartDTS
+		if rawPTS, ok := scte35SpliceTime(section); ok {
+			pts = p.timeConv.convert(rawPTS)
+		}
+
+		p.cueScheduler.pushSCTE35(p.curSegment.gen, pts, section)
+	}
+
+	p.scte35Sections = nil
+}
+
 func (p *clientStreamProcessorMPEGTS) initializeTrackProcessors() error {
 	p.trackProcessors = make(map[*Track]*clientTrackProcessorMPEGTS)
 
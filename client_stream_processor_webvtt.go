@@ -0,0 +1,159 @@
+package gohlslib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// clientWebVTTCueKey identifies a cue, in order to discard
+// cues that are repeated in consecutive segments.
+type clientWebVTTCueKey struct {
+	start int64
+	end   int64
+	text  string
+}
+
+// subtitles are passed to tracks as text followed by the end timestamp.
+func encodeSubtitle(end int64, text string) [][]byte {
+	return [][]byte{[]byte(text), binary.BigEndian.AppendUint64(nil, uint64(end))}
+}
+
+func decodeSubtitle(data [][]byte) (int64, string) {
+	return int64(binary.BigEndian.Uint64(data[1])), string(data[0])
+}
+
+type clientStreamProcessorWebVTT struct {
+	rendition        *playlist.MultivariantRendition
+	segmentQueue     *clientSegmentQueue
+	streamDownloader clientStreamProcessorStreamDownloader
+	client           clientStreamDownloaderClient
+
+	track    *clientTrack
+	timeConv clientTimeConv
+	prevCues map[clientWebVTTCueKey]struct{}
+}
+
+func (p *clientStreamProcessorWebVTT) initialize() {
+}
+
+func (p *clientStreamProcessorWebVTT) run(ctx context.Context) error {
+	tracks := []*Track{{
+		Codec:     &codecs.WebVTT{},
+		ClockRate: 1000,
+		Name:      p.rendition.Name,
+		Language:  p.rendition.Language,
+		IsDefault: p.rendition.Default,
+		IsForced:  p.rendition.Forced,
+	}}
+
+	streamTracks, ok := p.streamDownloader.setTracks(ctx, tracks)
+	if !ok {
+		return fmt.Errorf("terminated")
+	}
+	p.track = streamTracks[0]
+
+	for {
+		var seg *segmentData
+		seg, ok = p.segmentQueue.pull(ctx)
+		if !ok {
+			return fmt.Errorf("terminated")
+		}
+
+		if seg.err != nil {
+			p.streamDownloader.onProcessorError(ctx, seg.err)
+			<-ctx.Done()
+			return fmt.Errorf("terminated")
+		}
+
+		err := p.processSegment(ctx, seg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *clientStreamProcessorWebVTT) processSegment(ctx context.Context, seg *segmentData) error {
+	tm, cues, err := unmarshalWebVTT(seg.payload)
+	if err != nil {
+		return err
+	}
+
+	if p.timeConv == nil {
+		var ok bool
+		p.timeConv, ok = p.client.waitTimeConv(ctx)
+		if !ok {
+			return fmt.Errorf("terminated")
+		}
+	}
+
+	curCues := make(map[clientWebVTTCueKey]struct{})
+
+	for _, cue := range cues {
+		key := clientWebVTTCueKey{
+			start: p.convert(tm, cue.start),
+			end:   p.convert(tm, cue.end),
+			text:  cue.text,
+		}
+		curCues[key] = struct{}{}
+
+		if _, ok := p.prevCues[key]; ok {
+			continue
+		}
+
+		// discard cues that end before the first sample of the leading track,
+		// and shorten the ones that start before.
+		if key.end <= 0 {
+			continue
+		}
+		start := max(key.start, 0)
+
+		err = p.track.handleData(
+			ctx,
+			seg.gen,
+			start,
+			start,
+			p.getNTP(ctx, start),
+			encodeSubtitle(key.end, key.text))
+		if err != nil {
+			return err
+		}
+	}
+
+	p.prevCues = curCues
+
+	return nil
+}
+
+// convert converts a local time into a timestamp of the track,
+// by using X-TIMESTAMP-MAP and timestamps of the leading stream.
+func (p *clientStreamProcessorWebVTT) convert(tm *webVTTTimestampMap, local time.Duration) int64 {
+	v := tm.mpegts + durationToTimestamp(local-tm.local, 90000)
+	clockRate := int64(p.track.track.ClockRate)
+
+	switch timeConv := p.timeConv.(type) {
+	case *clientTimeConvMPEGTS:
+		return multiplyAndDivide(timeConv.convert(v&0x1FFFFFFFF), clockRate, 90000)
+
+	case *clientTimeConvFMP4:
+		return timeConv.convert(multiplyAndDivide(v, clockRate, 90000), int(clockRate))
+	}
+
+	return 0
+}
+
+func (p *clientStreamProcessorWebVTT) getNTP(ctx context.Context, pts int64) *time.Time {
+	switch timeConv := p.timeConv.(type) {
+	case *clientTimeConvMPEGTS:
+		return timeConv.getNTP(ctx, multiplyAndDivide(pts, 90000, int64(p.track.track.ClockRate)))
+
+	case *clientTimeConvFMP4:
+		return timeConv.getNTP(ctx, pts, p.track.track.ClockRate)
+	}
+
+	return nil
+}
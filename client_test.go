@@ -3,12 +3,21 @@ package gohlslib
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/tls"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -25,6 +34,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
 var serverCert = []byte(`-----BEGIN CERTIFICATE-----
@@ -1204,8 +1214,11 @@ func TestClientCodecs(t *testing.T) {
 		"av1",
 		"vp9",
 		"mpeg4audio",
+		"mpeg1audio",
 		"opus",
 		"flac",
+		"ac3",
+		"eac3",
 	} {
 		t.Run(ca, func(t *testing.T) {
 			httpServ := &http.Server{
@@ -1341,6 +1354,55 @@ func TestClientCodecs(t *testing.T) {
 								},
 							}, w)
 							require.NoError(t, err)
+
+						case "ac3":
+							err := mp4ToWriter(&fmp4.Init{
+								Tracks: []*fmp4.InitTrack{
+									{
+										ID:        1,
+										TimeScale: 48000,
+										Codec: &mp4codecs.AC3{
+											SampleRate:   48000,
+											ChannelCount: 2,
+											Bsid:         8,
+											Acmod:        2,
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+
+						case "mpeg1audio":
+							err := mp4ToWriter(&fmp4.Init{
+								Tracks: []*fmp4.InitTrack{
+									{
+										ID:        1,
+										TimeScale: 32000,
+										Codec: &mp4codecs.MPEG1Audio{
+											SampleRate:   32000,
+											ChannelCount: 2,
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+
+						case "eac3":
+							err := mp4ToWriter(&fmp4.Init{
+								Tracks: []*fmp4.InitTrack{
+									{
+										ID:        1,
+										TimeScale: 48000,
+										Codec: &mp4codecs.EAC3{
+											SampleRate:   48000,
+											ChannelCount: 2,
+											DataRate:     32,
+											Acmod:        2,
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
 						}
 
 					case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
@@ -1440,6 +1502,45 @@ func TestClientCodecs(t *testing.T) {
 								},
 							}, w)
 							require.NoError(t, err)
+
+						case "ac3":
+							err := mp4ToWriter(&fmp4.Part{
+								Tracks: []*fmp4.PartTrack{
+									{
+										ID: 1,
+										Samples: []*fmp4.Sample{
+											{Payload: testAC3Frame},
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+
+						case "mpeg1audio":
+							err := mp4ToWriter(&fmp4.Part{
+								Tracks: []*fmp4.PartTrack{
+									{
+										ID: 1,
+										Samples: []*fmp4.Sample{
+											{Payload: testMPEG1AudioFrame},
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+
+						case "eac3":
+							err := mp4ToWriter(&fmp4.Part{
+								Tracks: []*fmp4.PartTrack{
+									{
+										ID: 1,
+										Samples: []*fmp4.Sample{
+											{Payload: testEAC3Frame},
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
 						}
 					}
 				}),
@@ -1537,6 +1638,49 @@ func TestClientCodecs(t *testing.T) {
 						c.OnDataFLAC(tracks[0], func(_ int64, _ []byte) {
 							close(recv)
 						})
+
+					case "ac3":
+						require.Equal(t, []*Track{{
+							Codec: &codecs.AC3{
+								SampleRate:   48000,
+								ChannelCount: 2,
+								Bsid:         8,
+								Acmod:        2,
+							},
+							ClockRate: 48000,
+						}}, tracks)
+						c.OnDataAC3(tracks[0], func(_ int64, frame []byte) {
+							require.Equal(t, testAC3Frame, frame)
+							close(recv)
+						})
+
+					case "mpeg1audio":
+						require.Equal(t, []*Track{{
+							Codec: &codecs.MPEG1Audio{
+								SampleRate:   32000,
+								ChannelCount: 2,
+							},
+							ClockRate: 32000,
+						}}, tracks)
+						c.OnDataMPEG1Audio(tracks[0], func(_ int64, frames [][]byte) {
+							require.Equal(t, [][]byte{testMPEG1AudioFrame}, frames)
+							close(recv)
+						})
+
+					case "eac3":
+						require.Equal(t, []*Track{{
+							Codec: &codecs.EAC3{
+								SampleRate:   48000,
+								ChannelCount: 2,
+								DataRate:     32,
+								Acmod:        2,
+							},
+							ClockRate: 48000,
+						}}, tracks)
+						c.OnDataEAC3(tracks[0], func(_ int64, frame []byte) {
+							require.Equal(t, testEAC3Frame, frame)
+							close(recv)
+						})
 					}
 
 					return nil
@@ -1640,248 +1784,664 @@ func TestClientKLVMPEGTS(t *testing.T) {
 	<-recv
 }
 
-func TestClientKLVSynchronousMPEGTS(t *testing.T) {
-	httpServ := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			switch {
-			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
-				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-				w.Write([]byte("#EXTM3U\n" +
-					"#EXT-X-VERSION:3\n" +
-					"#EXT-X-ALLOW-CACHE:NO\n" +
-					"#EXT-X-TARGETDURATION:2\n" +
-					"#EXT-X-MEDIA-SEQUENCE:0\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n"))
-
-			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
-				w.Header().Set("Content-Type", `video/MP2T`)
+func TestClientMPEGTSCodecs(t *testing.T) {
+	for _, ca := range []string{
+		"h265+opus",
+		"mpeg4video+mpeg4audiolatm",
+		"mpeg1video+opus",
+		"opus",
+		"mpeg1audio",
+		"ac3",
+		"eac3",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			var videoTrack *Track
+			switch ca {
+			case "h265+opus":
+				videoTrack = &Track{
+					Codec:     &codecs.H265{VPS: testH265VPS, SPS: testH265SPS, PPS: testH265PPS},
+					ClockRate: 90000,
+				}
+			case "mpeg4video+mpeg4audiolatm":
+				videoTrack = &Track{
+					Codec:     &codecs.MPEG4Video{},
+					ClockRate: 90000,
+				}
+			case "mpeg1video+opus":
+				videoTrack = &Track{
+					Codec:     &codecs.MPEG1Video{},
+					ClockRate: 90000,
+				}
+			}
 
-				h264Track := &mpegts.Track{
-					Codec: &tscodecs.H264{},
+			var audioTrack *Track
+			switch ca {
+			case "mpeg4video+mpeg4audiolatm":
+				audioTrack = &Track{
+					Codec:     &codecs.MPEG4AudioLATM{},
+					ClockRate: 90000,
 				}
-				klvTrack := &mpegts.Track{
-					Codec: &tscodecs.KLV{Synchronous: true},
+			case "h265+opus", "mpeg1video+opus", "opus":
+				audioTrack = &Track{
+					Codec:     &codecs.Opus{ChannelCount: 2},
+					ClockRate: 48000,
 				}
-				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track, klvTrack}}
-				err := mw.Initialize()
-				require.NoError(t, err)
+			case "mpeg1audio":
+				audioTrack = &Track{
+					Codec:     &codecs.MPEG1Audio{SampleRate: 32000, ChannelCount: 2, Layer: 3},
+					ClockRate: 32000,
+				}
+			case "ac3":
+				audioTrack = &Track{
+					Codec:     &codecs.AC3{SampleRate: 48000, ChannelCount: 2},
+					ClockRate: 48000,
+				}
+			case "eac3":
+				audioTrack = &Track{
+					Codec:     &codecs.EAC3{SampleRate: 48000, ChannelCount: 2},
+					ClockRate: 48000,
+				}
+			}
 
-				err = mw.WriteH264(
-					h264Track,
-					90000,
-					90000,
-					[][]byte{
-						{7, 1, 2, 3}, // SPS
-						{8},          // PPS
-						{5},          // IDR
-					},
-				)
+			var tracks []*Track
+			if videoTrack != nil {
+				tracks = []*Track{videoTrack, audioTrack}
+			} else {
+				tracks = []*Track{audioTrack}
+			}
+
+			m := &Muxer{
+				Variant:            MuxerVariantMPEGTS,
+				SegmentCount:       7,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             tracks,
+			}
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			idr := []byte{0x26, 0x01, 0xaf, 0x08, 0x42, 0x23, 0x48, 0x8a, 0x43, 0xe2}
+			mpeg4VideoFrame := []byte{0, 0, 1, 0xb3, 0x00, 0x10, 0x07, 0, 0, 1, 0xb6, 0x10, 0x60}
+			mpeg1VideoFrame := []byte{0, 0, 1, 0xb8, 0x00, 0x08, 0x00, 0, 0, 1, 0x00, 0x00, 0x0f}
+
+			for i := range 10 {
+				ntp := testTime.Add(time.Duration(i*2) * time.Second)
+
+				switch ca {
+				case "h265+opus":
+					err = m.WriteH265(videoTrack, ntp, int64(i)*2*90000,
+						[][]byte{testH265VPS, testH265SPS, testH265PPS, idr})
+				case "mpeg4video+mpeg4audiolatm":
+					err = m.WriteMPEG4Video(videoTrack, ntp, int64(i)*2*90000, mpeg4VideoFrame)
+				case "mpeg1video+opus":
+					err = m.WriteMPEG1Video(videoTrack, ntp, int64(i)*2*90000, mpeg1VideoFrame)
+				}
 				require.NoError(t, err)
 
-				err = mw.WriteKLV(
-					klvTrack,
-					90000,
-					[]byte{0x06, 0x0e, 0x2b, 0x34},
-				)
+				switch ca {
+				case "mpeg4video+mpeg4audiolatm":
+					err = m.WriteMPEG4AudioLATM(audioTrack, ntp, int64(i)*2*90000, [][]byte{{0x01, 0x02, 0x03}})
+				case "h265+opus", "mpeg1video+opus", "opus":
+					err = m.WriteOpus(audioTrack, ntp, int64(i)*2*48000, [][]byte{{0xf8, byte(i)}})
+				case "mpeg1audio":
+					err = m.WriteMPEG1Audio(audioTrack, ntp, int64(i)*2*32000, [][]byte{testMPEG1AudioFrame})
+				case "ac3":
+					err = m.WriteAC3(audioTrack, ntp, int64(i)*2*48000, testAC3Frame)
+				case "eac3":
+					err = m.WriteEAC3(audioTrack, ntp, int64(i)*2*48000, testEAC3Frame)
+				}
 				require.NoError(t, err)
 			}
-		}),
-	}
 
-	ln, err := net.Listen("tcp", "localhost:5780")
-	require.NoError(t, err)
+			httpServ := &http.Server{Handler: http.HandlerFunc(m.Handle)}
 
-	go httpServ.Serve(ln)
-	defer httpServ.Shutdown(context.Background())
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
 
-	recv := make(chan struct{})
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
 
-	var c *Client
-	c = &Client{
-		URI: "http://localhost:5780/stream.m3u8",
-		OnTracks: func(tracks []*Track) error {
-			require.Equal(t, 2, len(tracks))
-			require.Equal(t, &codecs.KLV{Synchronous: true}, tracks[1].Codec)
+			videoRecv := make(chan [][]byte, 10)
+			audioRecv := make(chan [][]byte, 10)
 
-			c.OnDataKLV(tracks[1], func(_ int64, data []byte) {
-				require.Equal(t, []byte{0x06, 0x0e, 0x2b, 0x34}, data)
-				select {
-				case <-recv:
-				default:
-					close(recv)
-				}
-			})
-			return nil
-		},
-	}
+			var c *Client
+			c = &Client{
+				URI:           "http://localhost:5780/index.m3u8",
+				DisablePacing: true,
+				OnTracks: func(tracks []*Track) error {
+					switch ca {
+					case "h265+opus":
+						require.Len(t, tracks, 2)
+						require.Equal(t, &Track{
+							Codec:     &codecs.H265{},
+							ClockRate: 90000,
+						}, tracks[0])
 
-	err = c.Start()
-	require.NoError(t, err)
-	defer c.Close()
+						c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+							videoRecv <- au
+						})
 
-	<-recv
-}
+					case "mpeg4video+mpeg4audiolatm", "mpeg1video+opus":
+						require.Len(t, tracks, 2)
+						require.Equal(t, &Track{
+							Codec:     videoTrack.Codec,
+							ClockRate: 90000,
+						}, tracks[0])
 
-func TestClientSleepInCallbackMPEGTS(t *testing.T) {
-	httpServ := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			switch {
-			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
-				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-				w.Write([]byte("#EXTM3U\n" +
-					"#EXT-X-VERSION:3\n" +
-					"#EXT-X-ALLOW-CACHE:NO\n" +
-					"#EXT-X-TARGETDURATION:2\n" +
-					"#EXT-X-MEDIA-SEQUENCE:0\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n"))
+						c.OnDataMPEGxVideo(tracks[0], func(_ int64, frame []byte) {
+							videoRecv <- [][]byte{frame}
+						})
 
-			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
-				w.Header().Set("Content-Type", `video/MP2T`)
+					default:
+						require.Len(t, tracks, 1)
+					}
 
-				h264Track := &mpegts.Track{
-					Codec: &tscodecs.H264{},
-				}
-				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
-				err := mw.Initialize()
-				require.NoError(t, err)
+					audio := tracks[len(tracks)-1]
 
-				err = mw.WriteH264(
-					h264Track,
-					90000,
-					90000,
-					[][]byte{
-						{7, 1, 2, 3}, // SPS
-						{8},          // PPS
-						{5},          // IDR
-					},
-				)
-				require.NoError(t, err)
+					switch ca {
+					case "mpeg4video+mpeg4audiolatm":
+						require.Equal(t, &codecs.MPEG4AudioLATM{}, audio.Codec)
+						c.OnDataMPEG4AudioLATM(audio, func(_ int64, els [][]byte) {
+							audioRecv <- els
+						})
+
+					case "h265+opus", "mpeg1video+opus", "opus":
+						require.Equal(t, &codecs.Opus{ChannelCount: 2}, audio.Codec)
+						c.OnDataOpus(audio, func(_ int64, packets [][]byte) {
+							audioRecv <- packets
+						})
+
+					case "mpeg1audio":
+						require.Equal(t, &codecs.MPEG1Audio{}, audio.Codec)
+						c.OnDataMPEG1Audio(audio, func(_ int64, frames [][]byte) {
+							audioRecv <- frames
+						})
+
+					case "ac3":
+						require.Equal(t, &codecs.AC3{SampleRate: 48000, ChannelCount: 2}, audio.Codec)
+						c.OnDataAC3(audio, func(_ int64, frame []byte) {
+							audioRecv <- [][]byte{frame}
+						})
+
+					case "eac3":
+						require.Equal(t, &codecs.EAC3{SampleRate: 48000, ChannelCount: 2}, audio.Codec)
+						c.OnDataEAC3(audio, func(_ int64, frame []byte) {
+							audioRecv <- [][]byte{frame}
+						})
+					}
+
+					return nil
+				},
 			}
-		}),
-	}
 
-	ln, err := net.Listen("tcp", "localhost:5780")
-	require.NoError(t, err)
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
 
-	go httpServ.Serve(ln)
-	defer httpServ.Shutdown(context.Background())
+			switch ca {
+			case "h265+opus":
+				au := <-videoRecv
+				require.Equal(t, [][]byte{testH265VPS, testH265SPS, testH265PPS, idr}, au)
 
-	recv := make(chan struct{})
+			case "mpeg4video+mpeg4audiolatm":
+				frame := <-videoRecv
+				require.Equal(t, [][]byte{mpeg4VideoFrame}, frame)
+
+			case "mpeg1video+opus":
+				frame := <-videoRecv
+				require.Equal(t, [][]byte{mpeg1VideoFrame}, frame)
+			}
+
+			data := <-audioRecv
+
+			switch ca {
+			case "mpeg4video+mpeg4audiolatm":
+				require.Equal(t, [][]byte{{0x01, 0x02, 0x03}}, data)
+
+			case "h265+opus", "mpeg1video+opus", "opus":
+				require.Len(t, data, 1)
+				require.Equal(t, byte(0xf8), data[0][0])
+
+			case "mpeg1audio":
+				require.Equal(t, [][]byte{testMPEG1AudioFrame}, data)
+
+			case "ac3":
+				require.Equal(t, [][]byte{testAC3Frame}, data)
+
+			case "eac3":
+				require.Equal(t, [][]byte{testEAC3Frame}, data)
+			}
+		})
+	}
+}
+
+func TestClientSubtitles(t *testing.T) {
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack := &Track{
+				Codec:     &codecs.H264{SPS: testH264SPS, PPS: testH264PPS},
+				ClockRate: 90000,
+			}
+
+			subtitleTrack1 := &Track{
+				Codec:     &codecs.WebVTT{},
+				ClockRate: 1000,
+				Name:      "English",
+				Language:  "en-US",
+				IsDefault: true,
+			}
+
+			subtitleTrack2 := &Track{
+				Codec:     &codecs.WebVTT{},
+				ClockRate: 1000,
+				Name:      "Italiano",
+				Language:  "it",
+			}
+
+			m := &Muxer{
+				SegmentCount:       7,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack, subtitleTrack1, subtitleTrack2},
+			}
+
+			if ca == "mpegts" {
+				m.Variant = MuxerVariantMPEGTS
+			} else {
+				m.Variant = MuxerVariantFMP4
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 10 {
+				err = m.WriteSubtitle(subtitleTrack1, int64(i)*2000+500, int64(i)*2000+1500,
+					"cue "+strconv.FormatInt(int64(i), 10))
+				require.NoError(t, err)
+
+				err = m.WriteSubtitle(subtitleTrack2, int64(i)*2000+500, int64(i)*2000+1500, "sottotitolo")
+				require.NoError(t, err)
+
+				err = m.WriteH264(videoTrack, testTime.Add(time.Duration(i*2)*time.Second), int64(i)*2*90000,
+					[][]byte{
+						testH264SPS,
+						testH264PPS,
+						{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, // IDR
+					})
+				require.NoError(t, err)
+			}
+
+			httpServ := &http.Server{Handler: http.HandlerFunc(m.Handle)}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			videoRecv := make(chan int64, 10)
+
+			type cue struct {
+				pts  int64
+				end  int64
+				text string
+			}
+			cueRecv := make(chan cue, 10)
+
+			var c *Client
+			c = &Client{
+				URI:               "http://localhost:5780/index.m3u8",
+				DisablePacing:     true,
+				SubtitleLanguages: []string{"en"},
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 2)
+
+					require.Equal(t, &Track{
+						Codec:     &codecs.WebVTT{},
+						ClockRate: 1000,
+						Name:      "English",
+						Language:  "en-US",
+						IsDefault: true,
+					}, tracks[1])
+
+					c.OnDataH26x(tracks[0], func(pts int64, _ int64, _ [][]byte) {
+						videoRecv <- pts
+					})
+
+					c.OnDataSubtitle(tracks[1], func(pts int64, end int64, text string) {
+						cueRecv <- cue{pts, end, text}
+					})
+
+					return nil
+				},
+			}
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			videoPTS := <-videoRecv
+			recv := <-cueRecv
+
+			// first cue of the first downloaded segment is placed 500ms after the first video sample
+			require.Equal(t, int64(500), recv.pts-videoPTS/90)
+			require.Equal(t, int64(1000), recv.end-recv.pts)
+			require.Regexp(t, `^cue \d$`, recv.text)
+		})
+	}
+}
+
+func testCaptionsSEI(triplets ...[3]byte) []byte {
+	payload := []byte{0xb5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x40 | byte(len(triplets)), 0xff}
+	for _, t := range triplets {
+		payload = append(payload, t[:]...)
+	}
+	payload = append(payload, 0xff)
+
+	return append(append([]byte{6, 4, byte(len(payload))}, payload...), 0x80)
+}
+
+func TestClientCaptions(t *testing.T) {
+	cea608 := func(b1 byte, b2 byte) []byte {
+		return testCaptionsSEI([3]byte{0xfc, b1, b2})
+	}
+
+	seis := [][]byte{
+		cea608(0x14, 0x20), // RCL
+		cea608(0x14, 0x20),
+		cea608(0x14, 0x70), // PAC, row 15
+		cea608('H', 'E'),
+		cea608('L', 'L'),
+		cea608('O', 0x00),
+		cea608(0x14, 0x2f), // EOC
+		cea608(0x14, 0x2f),
+		testCaptionsSEI(
+			[3]byte{0xff, 0x06, 0x29}, // DTVCC packet header, service 1 block header
+			[3]byte{0xfe, 0x98, 0x20}, // DF0, visible
+			[3]byte{0xfe, 0x00, 0x00},
+			[3]byte{0xfe, 0x00, 0x1f}, // 1 row, 32 columns
+			[3]byte{0xfe, 0x00, 'H'},
+			[3]byte{0xfe, 'I', 0x00},
+			[3]byte{0xfa, 0x00, 0x00}, // invalid triplet
+			[3]byte{0xfd, 0x80, 0x80}, // CEA-608 field 2 padding
+		),
+		cea608(0x14, 0x2c), // EDM
+	}
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXTINF:2,\n" +
+					"segment.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				for i, sei := range seis {
+					au := [][]byte{sei, {1}}
+					if i == 0 {
+						au = [][]byte{sei, {7, 1, 2, 3}, {8}, {5}}
+					}
+
+					err = mw.WriteH264(h264Track, 90000+int64(i)*3000, 90000+int64(i)*3000, au)
+					require.NoError(t, err)
+				}
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	type caption struct {
+		id   string
+		pts  int64
+		text string
+	}
+
+	recv := make(chan caption, 10)
 
 	var c *Client
 	c = &Client{
-		URI: "http://localhost:5780/stream.m3u8",
+		URI:           "http://localhost:5780/index.m3u8",
+		DisablePacing: true,
 		OnTracks: func(tracks []*Track) error {
-			c.OnDataH26x(tracks[0], func(_, _ int64, _ [][]byte) {
-				time.Sleep(100 * time.Millisecond)
-				select {
-				case <-recv:
-				default:
-					close(recv)
-				}
-			})
+			for _, id := range []string{"CC1", "CC3", "SERVICE1"} {
+				err2 := c.OnDataCaptions(tracks[0], id, func(pts int64, text string) {
+					recv <- caption{id, pts, text}
+				})
+				require.NoError(t, err2)
+			}
+
+			err2 := c.OnDataCaptions(tracks[0], "CC5", func(_ int64, _ string) {})
+			require.EqualError(t, err2, "invalid INSTREAM-ID: CC5")
+
 			return nil
 		},
 	}
-	require.NoError(t, err)
 
 	err = c.Start()
 	require.NoError(t, err)
 	defer c.Close()
 
-	<-recv
+	var caps []caption
+	for range 3 {
+		caps = append(caps, <-recv)
+	}
+
+	require.Equal(t, []caption{
+		{"CC1", 6 * 3000, "HELLO"},
+		{"SERVICE1", 8 * 3000, "HI"},
+		{"CC1", 9 * 3000, ""},
+	}, caps)
 }
 
-func TestClientSkipUnsupportedTracks(t *testing.T) {
+func TestClientID3(t *testing.T) {
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack := &Track{
+				Codec:     &codecs.H264{SPS: testH264SPS, PPS: testH264PPS},
+				ClockRate: 90000,
+			}
+
+			id3Track := &Track{
+				Codec:     &codecs.ID3{},
+				ClockRate: 90000,
+			}
+
+			m := &Muxer{
+				SegmentCount:       7,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack, id3Track},
+			}
+
+			if ca == "mpegts" {
+				m.Variant = MuxerVariantMPEGTS
+			} else {
+				m.Variant = MuxerVariantFMP4
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 10 {
+				err = m.WriteH264(videoTrack, testTime.Add(time.Duration(i*2)*time.Second), int64(i)*2*90000,
+					[][]byte{
+						testH264SPS,
+						testH264PPS,
+						{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, // IDR
+					})
+				require.NoError(t, err)
+
+				err = m.WriteID3(id3Track, testTime.Add(time.Duration(i*2)*time.Second), int64(i)*2*90000+45000,
+					[]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 'P', 'R', 'I', 'V', byte(i)})
+				require.NoError(t, err)
+			}
+
+			httpServ := &http.Server{Handler: http.HandlerFunc(m.Handle)}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			videoRecv := make(chan int64, 10)
+
+			type tag struct {
+				pts  int64
+				data []byte
+			}
+			tagRecv := make(chan tag, 10)
+
+			var c *Client
+			c = &Client{
+				URI:           "http://localhost:5780/index.m3u8",
+				DisablePacing: true,
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 2)
+
+					require.Equal(t, &Track{
+						Codec:     &codecs.ID3{},
+						ClockRate: 90000,
+					}, tracks[1])
+
+					c.OnDataH26x(tracks[0], func(pts int64, _ int64, _ [][]byte) {
+						videoRecv <- pts
+					})
+
+					c.OnDataID3(tracks[1], func(pts int64, data []byte) {
+						tagRecv <- tag{pts, data}
+					})
+
+					return nil
+				},
+			}
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			videoPTS := <-videoRecv
+			recv := <-tagRecv
+
+			// tags are placed 500ms after video samples
+			require.Equal(t, int64(45000), recv.pts-videoPTS)
+			require.Equal(t, []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 'P', 'R', 'I', 'V'}, recv.data[:14])
+		})
+	}
+}
+
+func TestClientDateRangesAndSCTE35(t *testing.T) {
+	// splice_insert() with splice time
+	section := []byte{
+		0xfc, 0x30, 0x20, // table ID, section length
+		0x00,                         // protocol version
+		0x00, 0x00, 0x00, 0x00, 0x00, // PTS adjustment
+		0xff,             // CW index
+		0xff, 0xf0, 0x0f, // tier, splice command length
+		0x05,                   // splice_insert()
+		0x00, 0x00, 0x00, 0x01, // splice event ID
+		0x7f,                         // splice event cancel indicator
+		0xcf,                         // out of network, program splice
+		0xfe, 0x00, 0x01, 0x9a, 0x28, // splice time (105000)
+		0x00, 0x01, 0x00, 0x00, // unique program ID, avail num, avails expected
+		0x00, 0x00, // descriptor loop length
+	}
+	section = binary.BigEndian.AppendUint32(section, mpegtsCRC32(section))
+
 	httpServ := &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8" {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
 				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
 				w.Write([]byte("#EXTM3U\n" +
 					"#EXT-X-VERSION:3\n" +
-					"#EXT-X-ALLOW-CACHE:NO\n" +
 					"#EXT-X-TARGETDURATION:2\n" +
-					"#EXT-X-MEDIA-SEQUENCE:2\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
-					"#EXTINF:2,\n" +
-					"segment1.ts\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-DATERANGE:ID=\"old\",START-DATE=\"2009-12-31T23:59:00Z\",DURATION=1.000\n" +
+					"#EXT-X-DATERANGE:ID=\"ad1\",CLASS=\"ad\",START-DATE=\"2010-01-01T00:00:00.100Z\"," +
+					"PLANNED-DURATION=30.000,SCTE35-OUT=0xFC30\n" +
+					"#EXT-X-DATERANGE:ID=\"ad1\",CLASS=\"ad\",START-DATE=\"2010-01-01T00:00:00.100Z\"," +
+					"X-AD-ID=\"1234\"\n" +
+					"#EXT-X-PROGRAM-DATE-TIME:2010-01-01T00:00:00Z\n" +
 					"#EXTINF:2,\n" +
-					"segment1.ts\n"))
-			} else if r.Method == http.MethodGet && r.URL.Path == "/segment1.ts" {
+					"segment.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment.ts":
 				w.Header().Set("Content-Type", `video/MP2T`)
 
 				var buf bytes.Buffer
 				mux := astits.NewMuxer(context.Background(), &buf)
 
 				err := mux.AddElementaryStream(astits.PMTElementaryStream{
-					ElementaryPID: 120,
+					ElementaryPID: 256,
 					StreamType:    astits.StreamTypeH264Video,
 				})
 				require.NoError(t, err)
 
 				err = mux.AddElementaryStream(astits.PMTElementaryStream{
-					ElementaryPID: 121,
-					StreamType:    astits.StreamTypeDIRACVideo,
+					ElementaryPID: 257,
+					StreamType:    astits.StreamTypeSCTE35,
 				})
 				require.NoError(t, err)
 
-				mux.SetPCRPID(120)
+				mux.SetPCRPID(256)
 
-				data, err := h264.AnnexB([][]byte{
-					{7}, // SPS
-					{8}, // PPS
-					{5}, // IDR
-				}).Marshal()
-				require.NoError(t, err)
+				for i := range 10 {
+					au := [][]byte{{1}}
+					if i == 0 {
+						au = [][]byte{{7, 1, 2, 3}, {8}, {5}}
+					}
 
-				_, err = mux.WriteData(&astits.MuxerData{
-					PID: 120,
-					AdaptationField: &astits.PacketAdaptationField{
-						RandomAccessIndicator: true,
-					},
-					PES: &astits.PESData{
-						Header: &astits.PESHeader{
-							OptionalHeader: &astits.PESOptionalHeader{
-								MarkerBits:      2,
-								PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
-								PTS:             &astits.ClockReference{Base: 0},
-							},
-							StreamID: 222,
-						},
-						Data: data,
-					},
-				})
-				require.NoError(t, err)
+					data, err2 := h264.AnnexB(au).Marshal()
+					require.NoError(t, err2)
 
-				_, err = mux.WriteData(&astits.MuxerData{
-					PID: 121,
-					AdaptationField: &astits.PacketAdaptationField{
-						RandomAccessIndicator: true,
-					},
-					PES: &astits.PESData{
-						Header: &astits.PESHeader{
-							OptionalHeader: &astits.PESOptionalHeader{
-								MarkerBits:      2,
-								PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
-								PTS:             &astits.ClockReference{Base: 0},
+					_, err2 = mux.WriteData(&astits.MuxerData{
+						PID: 256,
+						AdaptationField: &astits.PacketAdaptationField{
+							RandomAccessIndicator: i == 0,
+						},
+						PES: &astits.PESData{
+							Header: &astits.PESHeader{
+								OptionalHeader: &astits.PESOptionalHeader{
+									MarkerBits:      2,
+									PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+									PTS:             &astits.ClockReference{Base: 90000 + int64(i)*3000},
+								},
+								StreamID: 224,
 							},
-							StreamID: 222,
+							Data: data,
 						},
-						Data: []byte{1, 2, 3, 4},
-					},
-				})
-				require.NoError(t, err)
+					})
+					require.NoError(t, err2)
+
+					if i == 1 {
+						pkt := append([]byte{0x47, 0x41, 0x01, 0x10, 0x00}, section...)
+						pkt = append(pkt, bytes.Repeat([]byte{0xff}, 188-len(pkt))...)
+						buf.Write(pkt)
+					}
+				}
 
 				w.Write(buf.Bytes())
 			}
@@ -1890,242 +2450,82 @@ func TestClientSkipUnsupportedTracks(t *testing.T) {
 
 	ln, err := net.Listen("tcp", "localhost:5780")
 	require.NoError(t, err)
+	defer ln.Close()
 
 	go httpServ.Serve(ln)
 	defer httpServ.Shutdown(context.Background())
 
-	tr := &http.Transport{}
-	defer tr.CloseIdleConnections()
+	type cue struct {
+		absoluteTime time.Time
+		dateRange    *playlist.MediaDateRange
+		pts          int64
+		section      []byte
+	}
 
-	recv := make(chan struct{})
+	recv := make(chan cue, 10)
 
 	var c *Client
+	var track *Track
 	c = &Client{
-		URI:        "http://localhost:5780/stream.m3u8",
-		HTTPClient: &http.Client{Transport: tr},
+		URI:           "http://localhost:5780/index.m3u8",
+		DisablePacing: true,
 		OnTracks: func(tracks []*Track) error {
-			require.Equal(t, []*Track{{
-				Codec:     &codecs.H264{},
-				ClockRate: 90000,
-			}}, tracks)
-
-			c.OnDataH26x(tracks[0], func(_, _ int64, au [][]byte) {
-				select {
-				case <-recv:
-					return
-				default:
-				}
-				require.Equal(t, [][]byte{{7}, {8}, {5}}, au)
-				close(recv)
-			})
+			track = tracks[0]
+			c.OnDataH26x(track, func(_, _ int64, _ [][]byte) {})
 			return nil
 		},
+		OnDateRange: func(dr *playlist.MediaDateRange) {
+			at, _ := c.AbsoluteTime(track)
+			recv <- cue{absoluteTime: at, dateRange: dr}
+		},
+		OnSCTE35: func(pts int64, section []byte) {
+			at, _ := c.AbsoluteTime(track)
+			recv <- cue{absoluteTime: at, pts: pts, section: section}
+		},
 	}
-	require.NoError(t, err)
 
 	err = c.Start()
 	require.NoError(t, err)
 	defer c.Close()
 
-	<-recv
-
-	err = c.Wait2()
-	require.EqualError(t, err, "next segment not found or not ready yet")
-}
-
-func TestClientErrors(t *testing.T) {
-	for _, ca := range []string{
-		"invalid sequence id",
-		"multiple tracks in rendition",
-	} {
-		t.Run(ca, func(t *testing.T) {
-			first := true
-
-			httpServ := &http.Server{
-				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch ca {
-					case "invalid sequence id":
-						switch {
-						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							if first {
-								first = false
-								w.Write([]byte("#EXTM3U\n" +
-									"#EXT-X-VERSION:3\n" +
-									"#EXT-X-ALLOW-CACHE:NO\n" +
-									"#EXT-X-TARGETDURATION:2\n" +
-									"#EXT-X-MEDIA-SEQUENCE:2\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n"))
-							} else {
-								w.Write([]byte("#EXTM3U\n" +
-									"#EXT-X-VERSION:3\n" +
-									"#EXT-X-ALLOW-CACHE:NO\n" +
-									"#EXT-X-TARGETDURATION:2\n" +
-									"#EXT-X-MEDIA-SEQUENCE:4\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n" +
-									"#EXTINF:2,\n" +
-									"segment1.ts\n"))
-							}
-						case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
-							w.Header().Set("Content-Type", `video/MP2T`)
-
-							h264Track := &mpegts.Track{
-								Codec: &tscodecs.H264{},
-							}
-							mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
-							err := mw.Initialize()
-							require.NoError(t, err)
-
-							err = mw.WriteH264(
-								h264Track,
-								90000,               // +1 sec
-								0x1FFFFFFFF-90000+1, // -1 sec
-								[][]byte{
-									{7, 1, 2, 3}, // SPS
-									{8},          // PPS
-									{5},          // IDR
-								},
-							)
-							require.NoError(t, err)
-						}
-
-					case "multiple tracks in rendition":
-						switch {
-						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
-								"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
-								"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
-								"video.m3u8\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/video.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:7\n" +
-								"#EXT-X-MEDIA-SEQUENCE:20\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MAP:URI=\"init_video.mp4\"\n" +
-								"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
-								"#EXTINF:2,\n" +
-								"segment_video.mp4\n" +
-								"#EXT-X-ENDLIST\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/audio.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:7\n" +
-								"#EXT-X-MEDIA-SEQUENCE:20\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MAP:URI=\"init_audio.mp4\"\n" +
-								"#EXT-X-PROGRAM-DATE-TIME:2014-02-05T01:02:02Z\n" +
-								"#EXTINF:2,\n" +
-								"segment_audio.mp4\n" +
-								"#EXT-X-ENDLIST"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/init_video.mp4":
-							w.Header().Set("Content-Type", `video/mp4`)
-							err := mp4ToWriter(&fmp4.Init{
-								Tracks: []*fmp4.InitTrack{
-									{
-										ID:        1,
-										TimeScale: 90000,
-										Codec: &mp4codecs.H264{
-											SPS: testH264SPS,
-											PPS: testH264PPS,
-										},
-									},
-								},
-							}, w)
-							require.NoError(t, err)
-
-						case r.Method == http.MethodGet && r.URL.Path == "/init_audio.mp4":
-							w.Header().Set("Content-Type", `video/mp4`)
-							err := mp4ToWriter(&fmp4.Init{
-								Tracks: []*fmp4.InitTrack{
-									{
-										ID:        1,
-										TimeScale: 44100,
-										Codec: &mp4codecs.MPEG4Audio{
-											Config: testAACConfig,
-										},
-									},
-									{
-										ID:        2,
-										TimeScale: 44100,
-										Codec: &mp4codecs.MPEG4Audio{
-											Config: testAACConfig,
-										},
-									},
-								},
-							}, w)
-							require.NoError(t, err)
-						}
-					}
-				}),
-			}
-
-			ln, err := net.Listen("tcp", "localhost:5780")
-			require.NoError(t, err)
-
-			go httpServ.Serve(ln)
-			defer httpServ.Shutdown(context.Background())
-
-			tr := &http.Transport{}
-			defer tr.CloseIdleConnections()
-
-			c := &Client{
-				URI:        "http://localhost:5780/index.m3u8",
-				HTTPClient: &http.Client{Transport: tr},
-			}
-			require.NoError(t, err)
-
-			err = c.Start()
-			require.NoError(t, err)
-			defer c.Close()
-
-			err = c.Wait2()
+	require.Equal(t, cue{
+		absoluteTime: time.Date(2010, 1, 1, 0, 0, 0, 100000000, time.UTC),
+		dateRange: &playlist.MediaDateRange{
+			ID:              "ad1",
+			Class:           "ad",
+			StartDate:       time.Date(2010, 1, 1, 0, 0, 0, 100000000, time.UTC),
+			PlannedDuration: ptrOf(30 * time.Second),
+			SCTE35Out:       []byte{0xfc, 0x30},
+			ClientAttributes: map[string]playlist.MediaDateRangeClientAttribute{
+				"X-AD-ID": {Value: "1234", Quoted: true},
+			},
+		},
+	}, <-recv)
 
-			switch ca {
-			case "invalid sequence id":
-				require.EqualError(t, err, "next segment not found or not ready yet")
-			case "multiple tracks in rendition":
-				require.EqualError(t, err, "rendition playlists with multiple tracks are not supported")
-			}
-		})
-	}
+	require.Equal(t, cue{
+		absoluteTime: time.Date(2010, 1, 1, 0, 0, 0, 166666666, time.UTC),
+		pts:          15000,
+		section:      section,
+	}, <-recv)
 }
 
-func TestClientVODWithoutPlaylistType(t *testing.T) {
-	videoRecv := make(chan struct{})
-
+func TestClientKLVSynchronousMPEGTS(t *testing.T) {
 	httpServ := &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch {
-			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
 				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
 				w.Write([]byte("#EXTM3U\n" +
 					"#EXT-X-VERSION:3\n" +
 					"#EXT-X-ALLOW-CACHE:NO\n" +
 					"#EXT-X-TARGETDURATION:2\n" +
 					"#EXT-X-MEDIA-SEQUENCE:0\n" +
-					"#EXTINF:1,\n" +
+					"#EXTINF:2,\n" +
 					"segment1.ts\n" +
-					"#EXTINF:1,\n" +
-					"segment2.ts\n" +
-					"#EXT-X-ENDLIST\n"))
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n"))
 
 			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
 				w.Header().Set("Content-Type", `video/MP2T`)
@@ -2133,7 +2533,10 @@ func TestClientVODWithoutPlaylistType(t *testing.T) {
 				h264Track := &mpegts.Track{
 					Codec: &tscodecs.H264{},
 				}
-				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				klvTrack := &mpegts.Track{
+					Codec: &tscodecs.KLV{Synchronous: true},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track, klvTrack}}
 				err := mw.Initialize()
 				require.NoError(t, err)
 
@@ -2149,21 +2552,10 @@ func TestClientVODWithoutPlaylistType(t *testing.T) {
 				)
 				require.NoError(t, err)
 
-			case r.Method == http.MethodGet && r.URL.Path == "/segment2.ts":
-				w.Header().Set("Content-Type", `video/MP2T`)
-
-				h264Track := &mpegts.Track{
-					Codec: &tscodecs.H264{},
-				}
-				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
-				err := mw.Initialize()
-				require.NoError(t, err)
-
-				err = mw.WriteH264(
-					h264Track,
-					180000,
-					180000,
-					[][]byte{{1}},
+				err = mw.WriteKLV(
+					klvTrack,
+					90000,
+					[]byte{0x06, 0x0e, 0x2b, 0x34},
 				)
 				require.NoError(t, err)
 			}
@@ -2172,55 +2564,55 @@ func TestClientVODWithoutPlaylistType(t *testing.T) {
 
 	ln, err := net.Listen("tcp", "localhost:5780")
 	require.NoError(t, err)
-	defer ln.Close()
 
 	go httpServ.Serve(ln)
 	defer httpServ.Shutdown(context.Background())
 
-	tr := &http.Transport{}
-	defer tr.CloseIdleConnections()
+	recv := make(chan struct{})
 
 	var c *Client
 	c = &Client{
-		URI:        "http://localhost:5780/index.m3u8",
-		HTTPClient: &http.Client{Transport: tr},
+		URI: "http://localhost:5780/stream.m3u8",
 		OnTracks: func(tracks []*Track) error {
-			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
-				require.Equal(t, []byte{7, 1, 2, 3}, au[0])
-				close(videoRecv)
-			})
-
-			return nil
-		},
-	}
+			require.Equal(t, 2, len(tracks))
+			require.Equal(t, &codecs.KLV{Synchronous: true}, tracks[1].Codec)
 
-	err = c.Start()
-	require.NoError(t, err)
-	defer c.Close()
+			c.OnDataKLV(tracks[1], func(_ int64, data []byte) {
+				require.Equal(t, []byte{0x06, 0x0e, 0x2b, 0x34}, data)
+				select {
+				case <-recv:
+				default:
+					close(recv)
+				}
+			})
+			return nil
+		},
+	}
 
-	<-videoRecv
-}
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
 
-func TestClientCookie(t *testing.T) {
-	segmentOk := make(chan struct{})
+	<-recv
+}
 
+func TestClientSleepInCallbackMPEGTS(t *testing.T) {
 	httpServ := &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch {
-			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-				w.Header().Set("Set-Cookie", "testcookie=value; Path=/; Max-Age=3600")
+			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
 				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
 				w.Write([]byte("#EXTM3U\n" +
 					"#EXT-X-VERSION:3\n" +
-					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
 					"#EXT-X-ALLOW-CACHE:NO\n" +
 					"#EXT-X-TARGETDURATION:2\n" +
 					"#EXT-X-MEDIA-SEQUENCE:0\n" +
-					"#EXTINF:1,\n" +
+					"#EXTINF:2,\n" +
 					"segment1.ts\n" +
-					"#EXTINF:1,\n" +
-					"segment2.ts\n" +
-					"#EXT-X-ENDLIST\n"))
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n"))
 
 			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
 				w.Header().Set("Content-Type", `video/MP2T`)
@@ -2232,41 +2624,2320 @@ func TestClientCookie(t *testing.T) {
 				err := mw.Initialize()
 				require.NoError(t, err)
 
-				err = mw.WriteH264(
-					h264Track,
-					90000,
-					90000,
-					[][]byte{
-						{7, 1, 2, 3}, // SPS
-						{8},          // PPS
-						{5},          // IDR
-					},
-				)
-				require.NoError(t, err)
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	recv := make(chan struct{})
+
+	var c *Client
+	c = &Client{
+		URI: "http://localhost:5780/stream.m3u8",
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_, _ int64, _ [][]byte) {
+				time.Sleep(100 * time.Millisecond)
+				select {
+				case <-recv:
+				default:
+					close(recv)
+				}
+			})
+			return nil
+		},
+	}
+	require.NoError(t, err)
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-recv
+}
+
+func TestClientSkipUnsupportedTracks(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8" {
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-ALLOW-CACHE:NO\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:2\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n"))
+			} else if r.Method == http.MethodGet && r.URL.Path == "/segment1.ts" {
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				var buf bytes.Buffer
+				mux := astits.NewMuxer(context.Background(), &buf)
+
+				err := mux.AddElementaryStream(astits.PMTElementaryStream{
+					ElementaryPID: 120,
+					StreamType:    astits.StreamTypeH264Video,
+				})
+				require.NoError(t, err)
+
+				err = mux.AddElementaryStream(astits.PMTElementaryStream{
+					ElementaryPID: 121,
+					StreamType:    astits.StreamTypeDIRACVideo,
+				})
+				require.NoError(t, err)
+
+				mux.SetPCRPID(120)
+
+				data, err := h264.AnnexB([][]byte{
+					{7}, // SPS
+					{8}, // PPS
+					{5}, // IDR
+				}).Marshal()
+				require.NoError(t, err)
+
+				_, err = mux.WriteData(&astits.MuxerData{
+					PID: 120,
+					AdaptationField: &astits.PacketAdaptationField{
+						RandomAccessIndicator: true,
+					},
+					PES: &astits.PESData{
+						Header: &astits.PESHeader{
+							OptionalHeader: &astits.PESOptionalHeader{
+								MarkerBits:      2,
+								PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+								PTS:             &astits.ClockReference{Base: 0},
+							},
+							StreamID: 222,
+						},
+						Data: data,
+					},
+				})
+				require.NoError(t, err)
+
+				_, err = mux.WriteData(&astits.MuxerData{
+					PID: 121,
+					AdaptationField: &astits.PacketAdaptationField{
+						RandomAccessIndicator: true,
+					},
+					PES: &astits.PESData{
+						Header: &astits.PESHeader{
+							OptionalHeader: &astits.PESOptionalHeader{
+								MarkerBits:      2,
+								PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+								PTS:             &astits.ClockReference{Base: 0},
+							},
+							StreamID: 222,
+						},
+						Data: []byte{1, 2, 3, 4},
+					},
+				})
+				require.NoError(t, err)
+
+				w.Write(buf.Bytes())
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	recv := make(chan struct{})
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/stream.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			require.Equal(t, []*Track{{
+				Codec:     &codecs.H264{},
+				ClockRate: 90000,
+			}}, tracks)
+
+			c.OnDataH26x(tracks[0], func(_, _ int64, au [][]byte) {
+				select {
+				case <-recv:
+					return
+				default:
+				}
+				require.Equal(t, [][]byte{{7}, {8}, {5}}, au)
+				close(recv)
+			})
+			return nil
+		},
+	}
+	require.NoError(t, err)
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-recv
+
+	err = c.Wait2()
+	require.EqualError(t, err, "next segment not found or not ready yet")
+}
+
+func TestClientErrors(t *testing.T) {
+	for _, ca := range []string{
+		"invalid sequence id",
+		"multiple tracks in rendition",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			first := true
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch ca {
+					case "invalid sequence id":
+						switch {
+						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							if first {
+								first = false
+								w.Write([]byte("#EXTM3U\n" +
+									"#EXT-X-VERSION:3\n" +
+									"#EXT-X-ALLOW-CACHE:NO\n" +
+									"#EXT-X-TARGETDURATION:2\n" +
+									"#EXT-X-MEDIA-SEQUENCE:2\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n"))
+							} else {
+								w.Write([]byte("#EXTM3U\n" +
+									"#EXT-X-VERSION:3\n" +
+									"#EXT-X-ALLOW-CACHE:NO\n" +
+									"#EXT-X-TARGETDURATION:2\n" +
+									"#EXT-X-MEDIA-SEQUENCE:4\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n" +
+									"#EXTINF:2,\n" +
+									"segment1.ts\n"))
+							}
+						case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+							w.Header().Set("Content-Type", `video/MP2T`)
+
+							h264Track := &mpegts.Track{
+								Codec: &tscodecs.H264{},
+							}
+							mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+							err := mw.Initialize()
+							require.NoError(t, err)
+
+							err = mw.WriteH264(
+								h264Track,
+								90000,               // +1 sec
+								0x1FFFFFFFF-90000+1, // -1 sec
+								[][]byte{
+									{7, 1, 2, 3}, // SPS
+									{8},          // PPS
+									{5},          // IDR
+								},
+							)
+							require.NoError(t, err)
+						}
+
+					case "multiple tracks in rendition":
+						switch {
+						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
+								"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
+								"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
+								"video.m3u8\n"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/video.m3u8":
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-VERSION:7\n" +
+								"#EXT-X-MEDIA-SEQUENCE:20\n" +
+								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+								"#EXT-X-TARGETDURATION:2\n" +
+								"#EXT-X-MAP:URI=\"init_video.mp4\"\n" +
+								"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
+								"#EXTINF:2,\n" +
+								"segment_video.mp4\n" +
+								"#EXT-X-ENDLIST\n"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/audio.m3u8":
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-VERSION:7\n" +
+								"#EXT-X-MEDIA-SEQUENCE:20\n" +
+								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+								"#EXT-X-TARGETDURATION:2\n" +
+								"#EXT-X-MAP:URI=\"init_audio.mp4\"\n" +
+								"#EXT-X-PROGRAM-DATE-TIME:2014-02-05T01:02:02Z\n" +
+								"#EXTINF:2,\n" +
+								"segment_audio.mp4\n" +
+								"#EXT-X-ENDLIST"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/init_video.mp4":
+							w.Header().Set("Content-Type", `video/mp4`)
+							err := mp4ToWriter(&fmp4.Init{
+								Tracks: []*fmp4.InitTrack{
+									{
+										ID:        1,
+										TimeScale: 90000,
+										Codec: &mp4codecs.H264{
+											SPS: testH264SPS,
+											PPS: testH264PPS,
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+
+						case r.Method == http.MethodGet && r.URL.Path == "/init_audio.mp4":
+							w.Header().Set("Content-Type", `video/mp4`)
+							err := mp4ToWriter(&fmp4.Init{
+								Tracks: []*fmp4.InitTrack{
+									{
+										ID:        1,
+										TimeScale: 44100,
+										Codec: &mp4codecs.MPEG4Audio{
+											Config: testAACConfig,
+										},
+									},
+									{
+										ID:        2,
+										TimeScale: 44100,
+										Codec: &mp4codecs.MPEG4Audio{
+											Config: testAACConfig,
+										},
+									},
+								},
+							}, w)
+							require.NoError(t, err)
+						}
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			c := &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+			}
+			require.NoError(t, err)
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+
+			switch ca {
+			case "invalid sequence id":
+				require.EqualError(t, err, "next segment not found or not ready yet")
+			case "multiple tracks in rendition":
+				require.EqualError(t, err, "rendition playlists with multiple tracks are not supported")
+			}
+		})
+	}
+}
+
+func TestClientVODWithoutPlaylistType(t *testing.T) {
+	videoRecv := make(chan struct{})
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-ALLOW-CACHE:NO\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:1,\n" +
+					"segment2.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment2.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					180000,
+					180000,
+					[][]byte{{1}},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				require.Equal(t, []byte{7, 1, 2, 3}, au[0])
+				close(videoRecv)
+			})
+
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-videoRecv
+}
+
+func TestClientCookie(t *testing.T) {
+	segmentOk := make(chan struct{})
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Set-Cookie", "testcookie=value; Path=/; Max-Age=3600")
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-ALLOW-CACHE:NO\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:1,\n" +
+					"segment2.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment2.ts":
+				require.Equal(t, "testcookie=value", r.Header.Get("Cookie"))
+
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					180000,
+					180000,
+					[][]byte{{1}},
+				)
+				require.NoError(t, err)
+
+				close(segmentOk)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	c := &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: nil,
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-segmentOk
+}
+
+func TestClientDefine(t *testing.T) {
+	segmentOk := make(chan struct{})
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				require.Equal(t, "abc", r.URL.Query().Get("token"))
+
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:8\n" +
+					"#EXT-X-DEFINE:NAME=\"path\",VALUE=\"stream\"\n" +
+					"#EXT-X-DEFINE:QUERYPARAM=\"token\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\"\n" +
+					"{$path}.m3u8?token={$token}\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
+				require.Equal(t, "abc", r.URL.Query().Get("token"))
+
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:8\n" +
+					"#EXT-X-DEFINE:IMPORT=\"token\"\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts?token={$token}\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				require.Equal(t, "abc", r.URL.Query().Get("token"))
+
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+
+				close(segmentOk)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	c := &Client{
+		URI: "http://localhost:5780/index.m3u8?token=abc",
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-segmentOk
+}
+
+func TestClientSessionData(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Example title\",LANGUAGE=\"en\"\n" +
+					"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.channel\",URI=\"channel.json\"\n" +
+					"#EXT-X-SESSION-KEY:METHOD=AES-128,URI=\"key.bin\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\"\n" +
+					"stream.m3u8\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	sessionDataRecv := make(chan struct{})
+	tracksRecv := make(chan struct{})
+
+	c := &Client{
+		URI: "http://localhost:5780/index.m3u8",
+		OnSessionData: func(data []*playlist.MultivariantSessionData, keys []*playlist.MultivariantSessionKey) {
+			require.Equal(t, []*playlist.MultivariantSessionData{
+				{
+					DataID:   "com.example.title",
+					Value:    ptrOf("Example title"),
+					Language: "en",
+				},
+				{
+					DataID: "com.example.channel",
+					URI:    ptrOf("http://localhost:5780/channel.json"),
+				},
+			}, data)
+			require.Equal(t, []*playlist.MultivariantSessionKey{{
+				Method: playlist.MediaKeyMethodAES128,
+				URI:    "http://localhost:5780/key.bin",
+			}}, keys)
+			close(sessionDataRecv)
+		},
+		OnTracks: func(_ []*Track) error {
+			select {
+			case <-sessionDataRecv:
+			default:
+				t.Error("OnTracks called before OnSessionData")
+			}
+			close(tracksRecv)
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	<-tracksRecv
+}
+
+func TestClientRedirect(t *testing.T) {
+	writeRedirectSegment := func(t *testing.T, w http.ResponseWriter) {
+		w.Header().Set("Content-Type", `video/MP2T`)
+		h264Track := &mpegts.Track{Codec: &tscodecs.H264{}}
+		mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+		err := mw.Initialize()
+		require.NoError(t, err)
+		err = mw.WriteH264(h264Track, 90000, 90000, [][]byte{{7, 1, 2, 3}, {8}, {5}})
+		require.NoError(t, err)
+	}
+
+	for _, ca := range []string{
+		"redirected media playlist",
+		"multivariant with redirected media playlist",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			var originalCount atomic.Int32
+			var redirectedCount atomic.Int32
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch ca {
+					case "redirected media playlist":
+						switch {
+						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+							originalCount.Add(1)
+							http.Redirect(w, r, "/redirected/index.m3u8", http.StatusFound)
+
+						case r.Method == http.MethodGet && r.URL.Path == "/redirected/index.m3u8":
+							redirectedCount.Add(1)
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-VERSION:3\n" +
+								"#EXT-X-TARGETDURATION:2\n" +
+								"#EXT-X-MEDIA-SEQUENCE:0\n" +
+								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+								"#EXTINF:1,\n" +
+								"segment.ts\n" +
+								"#EXT-X-ENDLIST\n"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/redirected/segment.ts":
+							writeRedirectSegment(t, w)
+
+						default:
+							w.WriteHeader(http.StatusNotFound)
+						}
+
+					case "multivariant with redirected media playlist":
+						switch {
+						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.640015\"\n" +
+								"stream.m3u8\n"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
+							originalCount.Add(1)
+							http.Redirect(w, r, "/redirected/stream.m3u8", http.StatusFound)
+
+						case r.Method == http.MethodGet && r.URL.Path == "/redirected/stream.m3u8":
+							redirectedCount.Add(1)
+							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+							w.Write([]byte("#EXTM3U\n" +
+								"#EXT-X-VERSION:3\n" +
+								"#EXT-X-TARGETDURATION:2\n" +
+								"#EXT-X-MEDIA-SEQUENCE:0\n" +
+								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+								"#EXTINF:1,\n" +
+								"segment.ts\n" +
+								"#EXT-X-ENDLIST\n"))
+
+						case r.Method == http.MethodGet && r.URL.Path == "/redirected/segment.ts":
+							writeRedirectSegment(t, w)
+
+						default:
+							w.WriteHeader(http.StatusNotFound)
+						}
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			videoRecv := make(chan struct{})
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					c.OnDataH26x(tracks[0], func(_ int64, _ int64, _ [][]byte) {
+						select {
+						case <-videoRecv:
+						default:
+							close(videoRecv)
+						}
+					})
+					return nil
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			<-videoRecv
+
+			require.Equal(t, ErrClientEOS, c.Wait2())
+
+			require.LessOrEqual(t, originalCount.Load(), int32(1),
+				"original URL must not be called more than once")
+			require.GreaterOrEqual(t, redirectedCount.Load(), int32(2),
+				"redirected URL must be called at least twice")
+		})
+	}
+}
+
+func TestClientABRPolicyThroughput(t *testing.T) {
+	variants := []*playlist.MultivariantVariant{
+		{Bandwidth: 1000000, URI: "low.m3u8"},
+		{Bandwidth: 2000000, URI: "mid.m3u8"},
+		{Bandwidth: 4000000, URI: "high.m3u8"},
+	}
+
+	for _, ca := range []struct {
+		name      string
+		current   int
+		bandwidth int
+		selected  int
+	}{
+		{
+			"switch up",
+			0,
+			3000000,
+			1,
+		},
+		{
+			"switch up to greatest",
+			0,
+			10000000,
+			2,
+		},
+		{
+			"keep when higher does not fit safety factor",
+			1,
+			4500000,
+			1,
+		},
+		{
+			"keep when current fits",
+			2,
+			4000000,
+			2,
+		},
+		{
+			"switch down",
+			2,
+			2500000,
+			1,
+		},
+		{
+			"switch down to lowest",
+			2,
+			100000,
+			0,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			p := &ClientABRPolicyThroughput{}
+			v := p.SelectVariant(variants, variants[ca.current], ca.bandwidth)
+			require.Equal(t, variants[ca.selected], v)
+		})
+	}
+}
+
+type testABRPolicyLowest struct{}
+
+func (testABRPolicyLowest) SelectVariant(
+	variants []*playlist.MultivariantVariant,
+	_ *playlist.MultivariantVariant,
+	_ int,
+) *playlist.MultivariantVariant {
+	return variants[0]
+}
+
+var testH264SPS720p = []byte{
+	0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+	0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+	0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+	0xcb,
+}
+
+// createVariantsHandler returns a handler that serves a VOD multivariant playlist with
+// a high and a low variant. Samples of the high variant are marked with 1,
+// samples of the low variant are marked with 2.
+func createVariantsHandler(t *testing.T) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+			w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:7\n" +
+				"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=4000000,CODECS=\"avc1.640028\",RESOLUTION=1920x1080\n" +
+				"high/stream.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.64001f\",RESOLUTION=1280x720\n" +
+				"low/stream.m3u8\n"))
+
+		case r.Method == http.MethodGet && (r.URL.Path == "/high/stream.m3u8" || r.URL.Path == "/low/stream.m3u8"):
+			w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:7\n" +
+				"#EXT-X-MEDIA-SEQUENCE:10\n" +
+				"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+				"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-TARGETDURATION:1\n" +
+				"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+				"#EXTINF:1,\n" +
+				"segment0.mp4\n" +
+				"#EXTINF:1,\n" +
+				"segment1.mp4\n" +
+				"#EXTINF:1,\n" +
+				"segment2.mp4\n" +
+				"#EXT-X-ENDLIST\n"))
+
+		case r.Method == http.MethodGet && (r.URL.Path == "/high/init.mp4" || r.URL.Path == "/low/init.mp4"):
+			sps := testH264SPS
+			if r.URL.Path == "/low/init.mp4" {
+				sps = testH264SPS720p
+			}
+
+			w.Header().Set("Content-Type", `video/mp4`)
+			err := mp4ToWriter(&fmp4.Init{
+				Tracks: []*fmp4.InitTrack{
+					{
+						ID:        1,
+						TimeScale: 90000,
+						Codec: &mp4codecs.H264{
+							SPS: sps,
+							PPS: testH264PPS,
+						},
+					},
+				},
+			}, w)
+			require.NoError(t, err)
+
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".mp4"):
+			var i int
+			_, err := fmt.Sscanf(path.Base(r.URL.Path), "segment%d.mp4", &i)
+			require.NoError(t, err)
+
+			marker := byte(1)
+			if strings.HasPrefix(r.URL.Path, "/low/") {
+				marker = 2
+			}
+
+			w.Header().Set("Content-Type", `video/mp4`)
+			err = mp4ToWriter(&fmp4.Part{
+				Tracks: []*fmp4.PartTrack{
+					{
+						ID:       1,
+						BaseTime: uint64(i) * 90000 / 10,
+						Samples: []*fmp4.Sample{
+							{
+								Duration: 90000 / 10,
+								Payload: mustMarshalAVCC([][]byte{
+									{5, marker},
+								}),
+							},
+						},
+					},
+				},
+			}, w)
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestClientVariantSwitch(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: createVariantsHandler(t),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	tracksCount := 0
+	var switches [][2]string
+	recv := make(chan [][]byte, 3)
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		ABRPolicy:  testABRPolicyLowest{},
+		OnTracks: func(tracks []*Track) error {
+			tracksCount++
+
+			require.Equal(t, []*Track{{
+				Codec: &codecs.H264{
+					SPS: testH264SPS,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}}, tracks)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				recv <- au
+			})
+			return nil
+		},
+		OnVariantSwitch: func(prev *playlist.MultivariantVariant, next *playlist.MultivariantVariant) {
+			switches = append(switches, [2]string{prev.URI, next.URI})
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, [][]byte{{5, 1}}, <-recv)
+	require.Equal(t, [][]byte{testH264SPS720p, testH264PPS, {5, 2}}, <-recv)
+	require.Equal(t, [][]byte{{5, 2}}, <-recv)
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, 1, tracksCount)
+	require.Equal(t, [][2]string{{"high/stream.m3u8", "low/stream.m3u8"}}, switches)
+}
+
+func TestClientSelectVariant(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: createVariantsHandler(t),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var high *playlist.MultivariantVariant
+	var switches [][2]string
+	recv := make(chan [][]byte, 3)
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnSelectVariant: func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+			require.Len(t, variants, 2)
+			high = variants[0]
+			return variants[1]
+		},
+		OnTracks: func(tracks []*Track) error {
+			require.Equal(t, []*Track{{
+				Codec: &codecs.H264{
+					SPS: testH264SPS720p,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}}, tracks)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				recv <- au
+			})
+			return nil
+		},
+		OnDownloadSegment: func(u string) {
+			if strings.HasSuffix(u, "/low/segment0.mp4") {
+				err2 := c.SetVariant(&playlist.MultivariantVariant{URI: "other.m3u8"})
+				require.EqualError(t, err2, "variant other.m3u8 cannot be selected")
+
+				err2 = c.SetVariant(high)
+				require.NoError(t, err2)
+			}
+		},
+		OnVariantSwitch: func(prev *playlist.MultivariantVariant, next *playlist.MultivariantVariant) {
+			switches = append(switches, [2]string{prev.URI, next.URI})
+		},
+	}
+
+	err = c.SetVariant(nil)
+	require.EqualError(t, err, "client is not started")
+
+	err = c.Seek(0)
+	require.EqualError(t, err, "client is not started")
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, [][]byte{{5, 2}}, <-recv)
+	require.Equal(t, [][]byte{testH264SPS, testH264PPS, {5, 1}}, <-recv)
+	require.Equal(t, [][]byte{{5, 1}}, <-recv)
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, [][2]string{{"low/stream.m3u8", "high/stream.m3u8"}}, switches)
+}
+
+// startTestFrameWriter writes a H264 IDR frame into each track every 100ms,
+// until the returned function is called.
+// The last byte of frames is the index of the track, starting from 1.
+func startTestFrameWriter(t *testing.T, m *Muxer, tracks []*Track) func() {
+	terminate := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		start := time.Now()
+
+		for i := 0; ; i++ {
+			for j, track := range tracks {
+				err := m.WriteH264(track, start.Add(time.Duration(i)*100*time.Millisecond), int64(i)*9000, [][]byte{
+					track.Codec.(*codecs.H264).SPS,
+					{8}, // PPS
+					{0x65, 0x88, 0x84, 0x00, 0x33, 0xff, byte(j + 1)}, // IDR
+				})
+				require.NoError(t, err)
+			}
+
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-terminate:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(terminate)
+		<-done
+	}
+}
+
+func TestClientSelectVariantLowLatency(t *testing.T) {
+	videoTrack1 := &Track{
+		Codec: &codecs.H264{
+			SPS: testH264SPS,
+			PPS: testH264PPS,
+		},
+		ClockRate: 90000,
+	}
+
+	videoTrack2 := &Track{
+		Codec: &codecs.H264{
+			SPS: testH264SPS720p,
+			PPS: testH264PPS,
+		},
+		ClockRate: 90000,
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantLowLatency,
+		SegmentCount:       7,
+		SegmentMinDuration: 1 * time.Second,
+		PartMinDuration:    100 * time.Millisecond,
+		Tracks:             []*Track{videoTrack1, videoTrack2},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(m.Handle),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	// samples of the first variant are marked with 1,
+	// samples of the second variant are marked with 2
+	stopWriter := startTestFrameWriter(t, m, []*Track{videoTrack1, videoTrack2})
+	defer stopWriter()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var second *playlist.MultivariantVariant
+	recv := make(chan byte, 100)
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnSelectVariant: func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+			require.Len(t, variants, 2)
+			second = variants[1]
+			return variants[0]
+		},
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				idr := au[len(au)-1]
+				recv <- idr[len(idr)-1]
+			})
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, byte(1), <-recv)
+
+	err = c.SetVariant(second)
+	require.NoError(t, err)
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case marker := <-recv:
+			if marker == 2 {
+				return
+			}
+
+		case <-timeout:
+			t.Fatal("variant has not been switched")
+		}
+	}
+}
+
+type testABRPolicyFunc func(
+	variants []*playlist.MultivariantVariant,
+	current *playlist.MultivariantVariant,
+	estimatedBandwidth int,
+) *playlist.MultivariantVariant
+
+func (f testABRPolicyFunc) SelectVariant(
+	variants []*playlist.MultivariantVariant,
+	current *playlist.MultivariantVariant,
+	estimatedBandwidth int,
+) *playlist.MultivariantVariant {
+	return f(variants, current, estimatedBandwidth)
+}
+
+func TestClientVariantSwitchLowLatency(t *testing.T) {
+	videoTrack1 := &Track{
+		Codec: &codecs.H264{
+			SPS: testH264SPS,
+			PPS: testH264PPS,
+		},
+		ClockRate: 90000,
+	}
+
+	videoTrack2 := &Track{
+		Codec: &codecs.H264{
+			SPS: testH264SPS720p,
+			PPS: testH264PPS,
+		},
+		ClockRate: 90000,
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantLowLatency,
+		SegmentCount:       7,
+		SegmentMinDuration: 1 * time.Second,
+		PartMinDuration:    100 * time.Millisecond,
+		Tracks:             []*Track{videoTrack1, videoTrack2},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(m.Handle),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	// samples of the first variant are marked with 1,
+	// samples of the second variant are marked with 2
+	stopWriter := startTestFrameWriter(t, m, []*Track{videoTrack1, videoTrack2})
+	defer stopWriter()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var second *playlist.MultivariantVariant
+	recv := make(chan byte, 100)
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnSelectVariant: func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+			require.Len(t, variants, 2)
+			second = variants[1]
+			return variants[0]
+		},
+		// the policy is called only after bandwidth has been estimated from downloads of parts
+		ABRPolicy: testABRPolicyFunc(func(
+			_ []*playlist.MultivariantVariant,
+			_ *playlist.MultivariantVariant,
+			estimatedBandwidth int,
+		) *playlist.MultivariantVariant {
+			require.Greater(t, estimatedBandwidth, 0)
+			return second
+		}),
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				idr := au[len(au)-1]
+				recv <- idr[len(idr)-1]
+			})
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case marker := <-recv:
+			if marker == 2 {
+				return
+			}
+
+		case <-timeout:
+			t.Fatal("variant has not been switched")
+		}
+	}
+}
+
+func TestClientContentSteering(t *testing.T) {
+	for _, ca := range []string{
+		"steering",
+		"failover",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			var mutex sync.Mutex
+			var steeringQuery url.Values
+			steeringServed := make(chan struct{})
+			segmentFromNewPathway := make(chan string, 10)
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:9\n" +
+							"#EXT-X-CONTENT-STEERING:SERVER-URI=\"/steering\",PATHWAY-ID=\"CDN-A\"\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-A\"\n" +
+							"a/stream.m3u8\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-B\"\n" +
+							"b/stream.m3u8\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/steering":
+						if ca == "failover" {
+							w.WriteHeader(http.StatusNotFound)
+							return
+						}
+
+						mutex.Lock()
+						steeringQuery = r.URL.Query()
+						mutex.Unlock()
+
+						w.Header().Set("Content-Type", `application/json`)
+						w.Write([]byte(`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["CDN-C","CDN-A"],` +
+							`"PATHWAY-CLONES":[{"BASE-ID":"CDN-A","ID":"CDN-C",` +
+							`"URI-REPLACEMENT":{"HOST":"127.0.0.1:5780","PARAMS":{"cdn":"c"}}}]}`))
+						close(steeringServed)
+
+					case r.Method == http.MethodGet && (r.URL.Path == "/a/stream.m3u8" || r.URL.Path == "/b/stream.m3u8"):
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:3\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXTINF:1,\n" +
+							"segment0.ts\n" +
+							"#EXTINF:1,\n" +
+							"segment1.ts\n" +
+							"#EXTINF:1,\n" +
+							"segment2.ts\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && (strings.HasPrefix(r.URL.Path, "/a/segment") ||
+						strings.HasPrefix(r.URL.Path, "/b/segment")):
+						if ca == "failover" && strings.HasPrefix(r.URL.Path, "/a/segment") &&
+							r.URL.Path != "/a/segment0.ts" {
+							w.WriteHeader(http.StatusNotFound)
+							return
+						}
+
+						// make sure that the steering manifest is applied before the next segment is requested
+						if ca == "steering" && r.URL.Path == "/a/segment0.ts" {
+							<-steeringServed
+							time.Sleep(100 * time.Millisecond)
+						}
+
+						switch {
+						case ca == "steering" && r.Host == "127.0.0.1:5780":
+							segmentFromNewPathway <- r.URL.Path
+
+						case ca == "failover" && strings.HasPrefix(r.URL.Path, "/b/segment"):
+							segmentFromNewPathway <- r.URL.Path
+						}
+
+						var i int64
+						switch r.URL.Path[len(r.URL.Path)-len("0.ts")] {
+						case '1':
+							i = 1
+						case '2':
+							i = 2
+						}
+
+						w.Header().Set("Content-Type", `video/MP2T`)
+
+						h264Track := &mpegts.Track{
+							Codec: &tscodecs.H264{},
+						}
+						mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+						err := mw.Initialize()
+						require.NoError(t, err)
+
+						err = mw.WriteH264(
+							h264Track,
+							90000*(i+1),
+							90000*(i+1),
+							[][]byte{
+								{7, 1, 2, 3}, // SPS
+								{8},          // PPS
+								{5},          // IDR
+							},
+						)
+						require.NoError(t, err)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			c := &Client{
+				URI: "http://localhost:5780/index.m3u8",
+				OnSelectVariant: func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+					require.Len(t, variants, 1)
+					require.Equal(t, "CDN-A", variants[0].PathwayID)
+					return variants[0]
+				},
+				OnDecodeError: func(_ error) {},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			if ca == "steering" {
+				require.Equal(t, "/a/segment1.ts", <-segmentFromNewPathway)
+
+				mutex.Lock()
+				require.Equal(t, "CDN-A", steeringQuery.Get("_HLS_pathway"))
+				mutex.Unlock()
+			} else {
+				require.Equal(t, "/b/segment1.ts", <-segmentFromNewPathway)
+			}
+		})
+	}
+}
+
+func TestClientContentSteeringSelectVariant(t *testing.T) {
+	steeringServed := make(chan struct{})
+	segments := make(chan string, 10)
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:9\n" +
+					"#EXT-X-CONTENT-STEERING:SERVER-URI=\"/steering\",PATHWAY-ID=\"CDN-A\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-A\"," +
+					"STABLE-VARIANT-ID=\"hi\"\n" +
+					"a/hi/stream.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=100000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-A\"," +
+					"STABLE-VARIANT-ID=\"lo\"\n" +
+					"a/lo/stream.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=100000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-B\"," +
+					"STABLE-VARIANT-ID=\"lo\"\n" +
+					"b/lo/stream.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-B\"," +
+					"STABLE-VARIANT-ID=\"hi\"\n" +
+					"b/hi/stream.m3u8\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/steering":
+				w.Header().Set("Content-Type", `application/json`)
+				w.Write([]byte(`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["CDN-B","CDN-A"]}`))
+				close(steeringServed)
+
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/stream.m3u8"):
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:1,\n" +
+					"segment0.ts\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:1,\n" +
+					"segment2.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".ts"):
+				// make sure that the steering manifest is applied before the next segment is requested
+				if r.URL.Path == "/a/hi/segment0.ts" {
+					<-steeringServed
+					time.Sleep(100 * time.Millisecond)
+				}
+
+				segments <- r.URL.Path
+
+				var i int64
+				_, err := fmt.Sscanf(path.Base(r.URL.Path), "segment%d.ts", &i)
+				require.NoError(t, err)
+
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err = mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000*(i+1),
+					90000*(i+1),
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	var hi *playlist.MultivariantVariant
+	var lo *playlist.MultivariantVariant
+	var switches [][2]*playlist.MultivariantVariant
+
+	var c *Client
+	c = &Client{
+		URI: "http://localhost:5780/index.m3u8",
+		OnSelectVariant: func(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+			require.Len(t, variants, 2)
+			hi = variants[0]
+			lo = variants[1]
+			return hi
+		},
+		OnDownloadSegment: func(u string) {
+			// variants received by OnSelectVariant can be selected after a pathway change
+			switch {
+			case strings.HasSuffix(u, "/a/hi/segment0.ts"):
+				err2 := c.SetVariant(lo)
+				require.NoError(t, err2)
+
+			case strings.HasSuffix(u, "/b/lo/segment1.ts"):
+				err2 := c.SetVariant(hi)
+				require.NoError(t, err2)
+			}
+		},
+		OnVariantSwitch: func(prev *playlist.MultivariantVariant, next *playlist.MultivariantVariant) {
+			switches = append(switches, [2]*playlist.MultivariantVariant{prev, next})
+		},
+		OnDecodeError: func(_ error) {},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, "/a/hi/segment0.ts", <-segments)
+	require.Equal(t, "/b/lo/segment1.ts", <-segments)
+	require.Equal(t, "/b/hi/segment2.ts", <-segments)
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Len(t, switches, 2)
+	require.Same(t, hi, switches[0][0])
+	require.Same(t, lo, switches[0][1])
+	require.Same(t, lo, switches[1][0])
+	require.Same(t, hi, switches[1][1])
+}
+
+func TestClientContentSteeringLowLatency(t *testing.T) {
+	for _, ca := range []string{
+		"steering",
+		"failover",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			m := &Muxer{
+				Variant:            MuxerVariantLowLatency,
+				SegmentCount:       7,
+				SegmentMinDuration: 1 * time.Second,
+				PartMinDuration:    100 * time.Millisecond,
+				Tracks:             []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			stopWriter := startTestFrameWriter(t, m, []*Track{testVideoTrack})
+			defer stopWriter()
+
+			var partsFromA atomic.Int64
+			partFromNewPathway := make(chan struct{}, 100)
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:9\n" +
+							"#EXT-X-CONTENT-STEERING:SERVER-URI=\"/steering\",PATHWAY-ID=\"CDN-A\"\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-A\"\n" +
+							"a/video1_stream.m3u8\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",PATHWAY-ID=\"CDN-B\"\n" +
+							"b/video1_stream.m3u8\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/steering":
+						// wait for the client to download some parts from the initial pathway
+						for partsFromA.Load() < 2 {
+							time.Sleep(10 * time.Millisecond)
+						}
+
+						if ca == "failover" {
+							w.WriteHeader(http.StatusNotFound)
+							return
+						}
+
+						w.Header().Set("Content-Type", `application/json`)
+						w.Write([]byte(`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["CDN-B","CDN-A"]}`))
+
+					case strings.HasPrefix(r.URL.Path, "/a/") || strings.HasPrefix(r.URL.Path, "/b/"):
+						isPart := strings.Contains(r.URL.Path, "_part")
+
+						if strings.HasPrefix(r.URL.Path, "/a/") {
+							if isPart && partsFromA.Add(1) > 2 && ca == "failover" {
+								w.WriteHeader(http.StatusNotFound)
+								return
+							}
+						} else if isPart {
+							partFromNewPathway <- struct{}{}
+						}
+
+						r.URL.Path = r.URL.Path[len("/a"):]
+						m.Handle(w, r)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			c := &Client{
+				URI:           "http://localhost:5780/index.m3u8",
+				HTTPClient:    &http.Client{Transport: tr},
+				OnDecodeError: func(_ error) {},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			select {
+			case <-partFromNewPathway:
+			case <-time.After(5 * time.Second):
+				t.Fatal("pathway has not been changed")
+			}
+		})
+	}
+}
+
+func TestClientContentSteeringServerStatus(t *testing.T) {
+	require.Equal(t, 5*time.Second, parseRetryAfter("5", time.Now()))
+	require.Equal(t, 10*time.Second, parseRetryAfter("Wed, 21 Oct 2015 07:28:10 GMT",
+		time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)))
+	require.Equal(t, time.Duration(0), parseRetryAfter("", time.Now()))
+
+	var requests atomic.Int64
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// first request: try again in one second.
+		// second request: stop polling.
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL + "/index.m3u8")
+	require.NoError(t, err)
+
+	st := &clientContentSteering{
+		httpClient:         http.DefaultClient,
+		onRequest:          func(_ *http.Request) {},
+		onDecodeError:      func(err error) { t.Errorf("unexpected error: %v", err) },
+		primaryPlaylistURL: u,
+		serverURI:          "/steering",
+		pathways:           []*clientPathway{{id: "CDN-A"}},
+		switcher:           &clientVariantSwitcher{},
+	}
+	st.initialize()
+
+	start := time.Now()
+
+	err = st.run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), requests.Load())
+	require.GreaterOrEqual(t, time.Since(start), 1*time.Second)
+}
+
+func encryptAES128(t *testing.T, payload []byte, key []byte, iv []byte) []byte {
+	padding := aes.BlockSize - len(payload)%aes.BlockSize
+	payload = append(payload, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	enc := make([]byte, len(payload))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, payload)
+	return enc
+}
+
+func TestClientAES128(t *testing.T) {
+	key1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	key2 := []byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	iv2 := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	for _, ca := range []string{"http", "resolver"} {
+		t.Run(ca, func(t *testing.T) {
+			var keyRequests []string
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:3\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:5\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-KEY:METHOD=AES-128,URI=\"key1.bin\"\n" +
+							"#EXTINF:1,\n" +
+							"segment1.ts\n" +
+							"#EXTINF:1,\n" +
+							"segment2.ts\n" +
+							"#EXT-X-KEY:METHOD=AES-128,URI=\"key2.bin\",IV=0x000102030405060708090a0b0c0d0e0f\n" +
+							"#EXTINF:1,\n" +
+							"segment3.ts\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && (r.URL.Path == "/key1.bin" || r.URL.Path == "/key2.bin"):
+						keyRequests = append(keyRequests, r.URL.Path)
+						if r.URL.Path == "/key1.bin" {
+							w.Write(key1)
+						} else {
+							w.Write(key2)
+						}
+
+					case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".ts"):
+						var i int
+						_, err := fmt.Sscanf(r.URL.Path, "/segment%d.ts", &i)
+						require.NoError(t, err)
+
+						var buf bytes.Buffer
+						h264Track := &mpegts.Track{
+							Codec: &tscodecs.H264{},
+						}
+						mw := &mpegts.Writer{W: &buf, Tracks: []*mpegts.Track{h264Track}}
+						err = mw.Initialize()
+						require.NoError(t, err)
+
+						err = mw.WriteH264(
+							h264Track,
+							90000+int64(i)*9000,
+							90000+int64(i)*9000,
+							[][]byte{
+								{7, 1, 2, 3}, // SPS
+								{8},          // PPS
+								{5, byte(i)}, // IDR
+							},
+						)
+						require.NoError(t, err)
+
+						var enc []byte
+						switch i {
+						case 1, 2:
+							iv := make([]byte, 16)
+							iv[15] = byte(4 + i)
+							enc = encryptAES128(t, buf.Bytes(), key1, iv)
+
+						default:
+							enc = encryptAES128(t, buf.Bytes(), key2, iv2)
+						}
+
+						w.Header().Set("Content-Type", `video/MP2T`)
+						w.Write(enc)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			recv := make(chan [][]byte, 3)
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+						recv <- au
+					})
+					return nil
+				},
+			}
+
+			if ca == "resolver" {
+				c.KeyResolver = func(uri string, key *playlist.MediaKey, kid []byte) ([]byte, error) {
+					require.Nil(t, kid)
+					keyRequests = append(keyRequests, uri)
+					if key.URI == "key1.bin" {
+						return key1, nil
+					}
+					return key2, nil
+				}
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			for i := 1; i <= 3; i++ {
+				require.Equal(t, [][]byte{{7, 1, 2, 3}, {8}, {5, byte(i)}}, <-recv)
+			}
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
+
+			if ca == "http" {
+				require.Equal(t, []string{"/key1.bin", "/key2.bin"}, keyRequests)
+			} else {
+				require.Equal(t, []string{
+					"http://localhost:5780/key1.bin",
+					"http://localhost:5780/key2.bin",
+				}, keyRequests)
+			}
+		})
+	}
+}
+
+// encryptSampleAESNALU encrypts a NALU with the MPEG-TS SAMPLE-AES scheme,
+// then inserts emulation prevention bytes.
+func encryptSampleAESNALU(block cipher.Block, iv []byte, nalu []byte) []byte {
+	enc := append([]byte(nil), nalu...)
+	mode := cipher.NewCBCEncrypter(block, iv)
+
+	for pos := 32; (len(enc) - pos) > 0; {
+		if (len(enc) - pos) > 16 {
+			mode.CryptBlocks(enc[pos:pos+16], enc[pos:pos+16])
+			pos += 16
+		}
+		pos += min(144, len(enc)-pos)
+	}
+
+	var ret []byte
+	zeros := 0
+
+	for _, b := range enc {
+		if zeros >= 2 && b <= 3 {
+			ret = append(ret, 3)
+			zeros = 0
+		}
+		ret = append(ret, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return ret
+}
+
+// testEditSinf sets scheme and KID of the sinf boxes written by cbcsProtectInit.
+func testEditSinf(init []byte, scheme string, kid []byte) {
+	for pos := 0; ; {
+		i := bytes.Index(init[pos:], []byte("schm"))
+		if i < 0 {
+			return
+		}
+		pos += i
+		copy(init[pos+8:], scheme)
+
+		pos += bytes.Index(init[pos:], []byte("tenc"))
+		if scheme == fmp4SchemeCENC {
+			init[pos+4] = 0 // version 0 has no pattern
+			init[pos+9] = 0
+		}
+		copy(init[pos+12:], kid)
+	}
+}
+
+func TestClientSampleAES(t *testing.T) {
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	kid := []byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	constantIV := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	idr := func(i int) []byte {
+		return append([]byte{5, byte(i)}, bytes.Repeat([]byte{1, 2, 3, 4}, 100)...)
+	}
+
+	audio := func(i int) []byte {
+		return append([]byte{byte(i)}, bytes.Repeat([]byte{5, 6, 7}, 30)...)
+	}
+
+	for _, ca := range []string{
+		"mpegts",
+		"fmp4 cbcs",
+		"fmp4 cenc",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8" && ca == "mpegts":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:5\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:5\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"key.bin\"\n" +
+							"#EXTINF:1,\n" +
+							"segment1.ts\n" +
+							"#EXTINF:1,\n" +
+							"segment2.ts\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:5\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+							"#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\",KEYFORMAT=\"com.example.drm\"\n" +
+							"#EXTINF:1,\n" +
+							"segment1.mp4\n" +
+							"#EXTINF:1,\n" +
+							"segment2.mp4\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/key.bin":
+						w.Write(key)
+
+					case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".ts"):
+						var i int
+						_, err2 := fmt.Sscanf(r.URL.Path, "/segment%d.ts", &i)
+						require.NoError(t, err2)
+
+						// IV is the media sequence number
+						iv := make([]byte, 16)
+						iv[15] = byte(4 + i)
+
+						var buf bytes.Buffer
+						mux := astits.NewMuxer(context.Background(), &buf)
+
+						err2 = mux.AddElementaryStream(astits.PMTElementaryStream{
+							ElementaryPID: 256,
+							StreamType:    mpegtsStreamTypeH264SampleAES,
+						})
+						require.NoError(t, err2)
+
+						err2 = mux.AddElementaryStream(astits.PMTElementaryStream{
+							ElementaryPID: 257,
+							StreamType:    mpegtsStreamTypeAACSampleAES,
+						})
+						require.NoError(t, err2)
+
+						mux.SetPCRPID(256)
+
+						videoData, err2 := h264.AnnexB([][]byte{
+							testH264SPS,
+							testH264PPS,
+							encryptSampleAESNALU(block, iv, idr(i)),
+						}).Marshal()
+						require.NoError(t, err2)
+
+						encAudio := audio(i)
+						cipher.NewCBCEncrypter(block, iv).CryptBlocks(encAudio[16:80], encAudio[16:80])
+
+						audioData, err2 := mpeg4audio.ADTSPackets{{
+							Type:          2,
+							SampleRate:    44100,
+							ChannelConfig: 2,
+							AU:            encAudio,
+						}}.Marshal()
+						require.NoError(t, err2)
+
+						for _, d := range []struct {
+							pid      uint16
+							streamID uint8
+							data     []byte
+						}{
+							{256, 224, videoData},
+							{257, 192, audioData},
+						} {
+							_, err2 = mux.WriteData(&astits.MuxerData{
+								PID: d.pid,
+								AdaptationField: &astits.PacketAdaptationField{
+									RandomAccessIndicator: true,
+								},
+								PES: &astits.PESData{
+									Header: &astits.PESHeader{
+										OptionalHeader: &astits.PESOptionalHeader{
+											MarkerBits:      2,
+											PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+											PTS:             &astits.ClockReference{Base: int64(i) * 90000},
+										},
+										StreamID: d.streamID,
+									},
+									Data: d.data,
+								},
+							})
+							require.NoError(t, err2)
+						}
+
+						w.Header().Set("Content-Type", `video/MP2T`)
+						w.Write(buf.Bytes())
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+						var buf seekablebuffer.Buffer
+						err2 := (&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{
+								{
+									ID:        1,
+									TimeScale: 90000,
+									Codec: &mp4codecs.H264{
+										SPS: testH264SPS,
+										PPS: testH264PPS,
+									},
+								},
+								{
+									ID:        2,
+									TimeScale: 44100,
+									Codec: &mp4codecs.MPEG4Audio{
+										Config: testAACConfig,
+									},
+								},
+							},
+						}).Marshal(&buf)
+						require.NoError(t, err2)
+
+						byts, err2 := cbcsProtectInit(buf.Bytes(), constantIV)
+						require.NoError(t, err2)
+
+						testEditSinf(byts, strings.TrimPrefix(ca, "fmp4 "), kid)
+
+						w.Header().Set("Content-Type", `video/mp4`)
+						w.Write(byts)
+
+					case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".mp4"):
+						var i int
+						_, err2 := fmt.Sscanf(r.URL.Path, "/segment%d.mp4", &i)
+						require.NoError(t, err2)
+
+						videoPayload := mustMarshalAVCC([][]byte{testH264SPS, testH264PPS, idr(i)})
+						audioPayload := audio(i)
+						var subsamples []cbcsSubsample
+
+						if ca == "fmp4 cbcs" {
+							videoPayload, subsamples, err2 = cbcsEncryptVideoSample(block, constantIV, videoPayload, false)
+							require.NoError(t, err2)
+
+							audioPayload = cbcsEncryptAudioSample(block, constantIV, audioPayload)
+						} else {
+							clearBytes := len(videoPayload) - len(idr(i)) + 1
+							cipher.NewCTR(block, constantIV).XORKeyStream(videoPayload[clearBytes:], videoPayload[clearBytes:])
+							subsamples = []cbcsSubsample{{
+								clearBytes:     uint16(clearBytes),
+								protectedBytes: uint32(len(videoPayload) - clearBytes),
+							}}
+
+							cipher.NewCTR(block, constantIV).XORKeyStream(audioPayload, audioPayload)
+						}
+
+						var buf seekablebuffer.Buffer
+						err2 = (&fmp4.Part{
+							Tracks: []*fmp4.PartTrack{
+								{
+									ID:       1,
+									BaseTime: uint64(i) * 90000,
+									Samples: []*fmp4.Sample{{
+										Duration: 90000,
+										Payload:  videoPayload,
+									}},
+								},
+								{
+									ID:       2,
+									BaseTime: uint64(i) * 44100,
+									Samples: []*fmp4.Sample{{
+										Duration: 1024,
+										Payload:  audioPayload,
+									}},
+								},
+							},
+						}).Marshal(&buf)
+						require.NoError(t, err2)
+
+						byts, err2 := cbcsProtectPart(buf.Bytes(), map[int][][]cbcsSubsample{1: {subsamples}})
+						require.NoError(t, err2)
+
+						w.Header().Set("Content-Type", `video/mp4`)
+						w.Write(byts)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			videoRecv := make(chan [][]byte, 2)
+			audioRecv := make(chan [][]byte, 2)
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 2)
+
+					c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+						videoRecv <- au
+					})
+					c.OnDataMPEG4Audio(tracks[1], func(_ int64, aus [][]byte) {
+						audioRecv <- aus
+					})
+					return nil
+				},
+			}
+
+			if ca != "mpegts" {
+				c.KeyResolver = func(uri string, mediaKey *playlist.MediaKey, kid2 []byte) ([]byte, error) {
+					require.Equal(t, "skd://key", uri)
+					require.Equal(t, "com.example.drm", mediaKey.KeyFormat)
+					require.Equal(t, kid, kid2)
+					return key, nil
+				}
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			for i := 1; i <= 2; i++ {
+				require.Equal(t, [][]byte{testH264SPS, testH264PPS, idr(i)}, <-videoRecv)
+				require.Equal(t, [][]byte{audio(i)}, <-audioRecv)
+			}
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
+		})
+	}
+}
+
+func TestClientSampleAESPerSampleIV(t *testing.T) {
+	block, err := aes.NewCipher([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	require.NoError(t, err)
 
-			case r.Method == http.MethodGet && r.URL.Path == "/segment2.ts":
-				require.Equal(t, "testcookie=value", r.Header.Get("Cookie"))
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	sample := append([]byte{1, 2}, bytes.Repeat([]byte{3, 4, 5}, 20)...)
 
-				w.Header().Set("Content-Type", `video/MP2T`)
+	enc := append([]byte(nil), sample...)
+	cipher.NewCTR(block, append(append([]byte(nil), iv...), make([]byte, 8)...)).XORKeyStream(enc[2:], enc[2:])
 
-				h264Track := &mpegts.Track{
-					Codec: &tscodecs.H264{},
-				}
-				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
-				err := mw.Initialize()
-				require.NoError(t, err)
+	senc := []byte{0, 0, 0, 2, 0, 0, 0, 1}
+	senc = append(senc, iv...)
+	senc = append(senc, 0, 1, 0, 2)
+	senc = binary.BigEndian.AppendUint32(senc, uint32(len(sample)-2))
 
-				err = mw.WriteH264(
-					h264Track,
-					180000,
-					180000,
-					[][]byte{{1}},
-				)
-				require.NoError(t, err)
+	encs, err := fmp4UnmarshalSenc(senc, len(iv))
+	require.NoError(t, err)
+	require.Equal(t, []*fmp4SampleEncryption{{
+		iv:         iv,
+		subsamples: []cbcsSubsample{{clearBytes: 2, protectedBytes: uint32(len(sample) - 2)}},
+	}}, encs)
 
-				close(segmentOk)
-			}
-		}),
+	err = fmp4DecryptSample(block, &fmp4TrackProtection{scheme: fmp4SchemeCENC}, encs[0], enc)
+	require.NoError(t, err)
+	require.Equal(t, sample, enc)
+}
+
+func TestClientSampleAESLowLatency(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantLowLatency,
+		SegmentCount:       7,
+		SegmentMinDuration: 1 * time.Second,
+		PartMinDuration:    100 * time.Millisecond,
+		Tracks:             []*Track{testVideoTrack},
+		Encryption: &MuxerEncryption{
+			Method:      MuxerEncryptionMethodSampleAES,
+			KeyProvider: &testKeyProvider{},
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(m.Handle),
 	}
 
 	ln, err := net.Listen("tcp", "localhost:5780")
@@ -2276,95 +4947,131 @@ func TestClientCookie(t *testing.T) {
 	go httpServ.Serve(ln)
 	defer httpServ.Shutdown(context.Background())
 
-	c := &Client{
+	// IDRs are long enough to be partially encrypted
+	idr := func(i int) []byte {
+		return append([]byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, bytes.Repeat([]byte{byte(i)}, 100)...)
+	}
+
+	terminate := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		start := time.Now()
+
+		for i := 0; ; i++ {
+			err2 := m.WriteH264(testVideoTrack, start.Add(time.Duration(i)*100*time.Millisecond), int64(i)*9000, [][]byte{
+				testH264SPS,
+				{8}, // PPS
+				idr(i),
+			})
+			require.NoError(t, err2)
+
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-terminate:
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		close(terminate)
+		<-done
+	}()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	recv := make(chan []byte, 100)
+
+	var c *Client
+	c = &Client{
 		URI:        "http://localhost:5780/index.m3u8",
-		HTTPClient: nil,
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				recv <- au[len(au)-1]
+			})
+			return nil
+		},
 	}
 
 	err = c.Start()
 	require.NoError(t, err)
 	defer c.Close()
 
-	<-segmentOk
-}
+	timeout := time.After(5 * time.Second)
 
-func TestClientRedirect(t *testing.T) {
-	writeRedirectSegment := func(t *testing.T, w http.ResponseWriter) {
-		w.Header().Set("Content-Type", `video/MP2T`)
-		h264Track := &mpegts.Track{Codec: &tscodecs.H264{}}
-		mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
-		err := mw.Initialize()
-		require.NoError(t, err)
-		err = mw.WriteH264(h264Track, 90000, 90000, [][]byte{{7, 1, 2, 3}, {8}, {5}})
-		require.NoError(t, err)
+	// wait for samples received through parts
+	for n := 0; n < 15; n++ {
+		select {
+		case byts := <-recv:
+			require.Equal(t, idr(int(byts[len(byts)-1])), byts)
+
+		case <-timeout:
+			t.Fatal("samples have not been received")
+		}
 	}
+}
 
+func TestClientSeek(t *testing.T) {
 	for _, ca := range []string{
-		"redirected media playlist",
-		"multivariant with redirected media playlist",
+		"position",
+		"date/time",
+		"event backward",
 	} {
 		t.Run(ca, func(t *testing.T) {
-			var originalCount atomic.Int32
-			var redirectedCount atomic.Int32
-
 			httpServ := &http.Server{
 				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch ca {
-					case "redirected media playlist":
-						switch {
-						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-							originalCount.Add(1)
-							http.Redirect(w, r, "/redirected/index.m3u8", http.StatusFound)
-
-						case r.Method == http.MethodGet && r.URL.Path == "/redirected/index.m3u8":
-							redirectedCount.Add(1)
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:3\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MEDIA-SEQUENCE:0\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXTINF:1,\n" +
-								"segment.ts\n" +
-								"#EXT-X-ENDLIST\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/redirected/segment.ts":
-							writeRedirectSegment(t, w)
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
 
-						default:
-							w.WriteHeader(http.StatusNotFound)
+						pl := "#EXTM3U\n" +
+							"#EXT-X-VERSION:3\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:10\n"
+						if ca == "event backward" {
+							pl += "#EXT-X-PLAYLIST-TYPE:EVENT\n"
+						} else {
+							pl += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+						}
+						pl += "#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n"
+						for i := range 6 {
+							pl += "#EXTINF:1,\n" +
+								"segment" + strconv.FormatInt(int64(i), 10) + ".ts\n"
+						}
+						if ca != "event backward" {
+							pl += "#EXT-X-ENDLIST\n"
 						}
 
-					case "multivariant with redirected media playlist":
-						switch {
-						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.640015\"\n" +
-								"stream.m3u8\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
-							originalCount.Add(1)
-							http.Redirect(w, r, "/redirected/stream.m3u8", http.StatusFound)
-
-						case r.Method == http.MethodGet && r.URL.Path == "/redirected/stream.m3u8":
-							redirectedCount.Add(1)
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:3\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MEDIA-SEQUENCE:0\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXTINF:1,\n" +
-								"segment.ts\n" +
-								"#EXT-X-ENDLIST\n"))
+						w.Write([]byte(pl))
 
-						case r.Method == http.MethodGet && r.URL.Path == "/redirected/segment.ts":
-							writeRedirectSegment(t, w)
+					case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".ts"):
+						var i int
+						_, err := fmt.Sscanf(r.URL.Path, "/segment%d.ts", &i)
+						require.NoError(t, err)
 
-						default:
-							w.WriteHeader(http.StatusNotFound)
+						h264Track := &mpegts.Track{
+							Codec: &tscodecs.H264{},
 						}
+						mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+						err = mw.Initialize()
+						require.NoError(t, err)
+
+						err = mw.WriteH264(
+							h264Track,
+							90000+int64(i)*90000,
+							90000+int64(i)*90000,
+							[][]byte{
+								{7, 1, 2, 3}, // SPS
+								{8},          // PPS
+								{5, byte(i)}, // IDR
+							},
+						)
+						require.NoError(t, err)
 					}
 				}),
 			}
@@ -2379,36 +5086,223 @@ func TestClientRedirect(t *testing.T) {
 			tr := &http.Transport{}
 			defer tr.CloseIdleConnections()
 
-			videoRecv := make(chan struct{})
+			type recvEntry struct {
+				pts int64
+				idr byte
+			}
+
+			recv := make(chan recvEntry, 10)
+			discontinuity := make(chan struct{}, 1)
 
 			var c *Client
 			c = &Client{
 				URI:        "http://localhost:5780/index.m3u8",
 				HTTPClient: &http.Client{Transport: tr},
 				OnTracks: func(tracks []*Track) error {
-					c.OnDataH26x(tracks[0], func(_ int64, _ int64, _ [][]byte) {
-						select {
-						case <-videoRecv:
-						default:
-							close(videoRecv)
-						}
+					c.OnDataH26x(tracks[0], func(pts int64, _ int64, au [][]byte) {
+						recv <- recvEntry{pts, au[2][1]}
 					})
 					return nil
 				},
+				OnDiscontinuity: func() {
+					discontinuity <- struct{}{}
+				},
 			}
 
 			err = c.Start()
 			require.NoError(t, err)
 			defer c.Close()
 
-			<-videoRecv
+			if ca == "event backward" {
+				// the client starts three segments before the end of the playlist
+				require.Equal(t, recvEntry{0, 3}, <-recv)
 
-			require.Equal(t, ErrClientEOS, c.Wait2())
+				err = c.Seek(1500 * time.Millisecond)
+				require.NoError(t, err)
 
-			require.LessOrEqual(t, originalCount.Load(), int32(1),
-				"original URL must not be called more than once")
-			require.GreaterOrEqual(t, redirectedCount.Load(), int32(2),
-				"redirected URL must be called at least twice")
+				<-discontinuity
+
+				// timestamps precede the initial position
+				require.Equal(t, recvEntry{-2 * 90000, 1}, <-recv)
+				require.Equal(t, recvEntry{-1 * 90000, 2}, <-recv)
+				require.Equal(t, recvEntry{0, 3}, <-recv)
+				return
+			}
+
+			require.Equal(t, recvEntry{0, 0}, <-recv)
+
+			err = c.Seek(10 * time.Second)
+			require.EqualError(t, err, "position is out of range")
+
+			if ca == "position" {
+				err = c.Seek(4500 * time.Millisecond)
+			} else {
+				err = c.SeekToDateTime(time.Date(2015, 2, 5, 1, 2, 6, 500000000, time.UTC))
+			}
+			require.NoError(t, err)
+
+			<-discontinuity
+
+			require.Equal(t, recvEntry{4 * 90000, 4}, <-recv)
+			require.Equal(t, recvEntry{5 * 90000, 5}, <-recv)
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
 		})
 	}
 }
+
+func TestClientDisablePacing(t *testing.T) {
+	var curRequests int64
+	var maxRequests int64
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+
+				pl := "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:20\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n"
+				for i := range 8 {
+					pl += "#EXTINF:20,\n" +
+						"segment" + strconv.FormatInt(int64(i), 10) + ".ts\n"
+				}
+				pl += "#EXT-X-ENDLIST\n"
+
+				w.Write([]byte(pl))
+
+			case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".ts"):
+				cur := atomic.AddInt64(&curRequests, 1)
+				defer atomic.AddInt64(&curRequests, -1)
+
+				for {
+					prev := atomic.LoadInt64(&maxRequests)
+					if cur <= prev || atomic.CompareAndSwapInt64(&maxRequests, prev, cur) {
+						break
+					}
+				}
+
+				time.Sleep(50 * time.Millisecond)
+
+				var i int
+				_, err := fmt.Sscanf(r.URL.Path, "/segment%d.ts", &i)
+				require.NoError(t, err)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err = mw.Initialize()
+				require.NoError(t, err)
+
+				// segments are 20 seconds long
+				err = mw.WriteH264(
+					h264Track,
+					int64(i)*20*90000,
+					int64(i)*20*90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5, byte(i)}, // IDR
+					},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var recv []byte
+	var downloaded []string
+
+	var c *Client
+	c = &Client{
+		URI:                 "http://localhost:5780/index.m3u8",
+		HTTPClient:          &http.Client{Transport: tr},
+		DisablePacing:       true,
+		DownloadConcurrency: 3,
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				recv = append(recv, au[2][1])
+			})
+			return nil
+		},
+		// the callback is not thread safe, since it is never called concurrently
+		OnDownloadSegment: func(u string) {
+			downloaded = append(downloaded, path.Base(u))
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7}, recv)
+	require.Equal(t, []string{
+		"segment0.ts", "segment1.ts", "segment2.ts", "segment3.ts",
+		"segment4.ts", "segment5.ts", "segment6.ts", "segment7.ts",
+	}, downloaded)
+	require.Greater(t, atomic.LoadInt64(&maxRequests), int64(1))
+	require.LessOrEqual(t, atomic.LoadInt64(&maxRequests), int64(3))
+}
+
+func TestClientSegmentPrefetcherDuration(t *testing.T) {
+	var mutex sync.Mutex
+	var durations []time.Duration
+
+	p := &clientSegmentPrefetcher{
+		concurrency:       3,
+		onDownloadSegment: func(_ string) {},
+		download: func(_ context.Context, _ *url.URL, _ *uint64, _ *uint64) ([]byte, error) {
+			time.Sleep(50 * time.Millisecond)
+			return []byte{1, 2, 3, 4}, nil
+		},
+		onDownloaded: func(size int, duration time.Duration) {
+			require.Equal(t, 4, size)
+			mutex.Lock()
+			defer mutex.Unlock()
+			durations = append(durations, duration)
+		},
+	}
+	p.initialize()
+	defer p.close()
+
+	base, err := url.Parse("http://localhost/index.m3u8")
+	require.NoError(t, err)
+
+	segments := []*playlist.MediaSegment{
+		{URI: "seg0.ts"},
+		{URI: "seg1.ts"},
+		{URI: "seg2.ts"},
+	}
+
+	for i := range segments {
+		_, err = p.get(context.Background(), base, segments, i)
+		require.NoError(t, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// durations of segments returned from the cache are the ones of their download
+	require.Len(t, durations, 3)
+	for _, d := range durations {
+		require.GreaterOrEqual(t, d, 50*time.Millisecond)
+	}
+}
@@ -8,9 +8,14 @@ import (
 
 type clientTrack struct {
 	track            *Track
+	disablePacing    bool
+	seeker           *clientSeeker
 	onData           func(pts int64, dts int64, data [][]byte)
+	captions         *clientCaptionExtractor // h264 and h265 only
+	cueScheduler     *clientCueScheduler     // leading track only
 	lastAbsoluteTime *time.Time
 	startSystem      time.Time
+	gen              int
 }
 
 func (t *clientTrack) absoluteTime() (time.Time, bool) {
@@ -22,20 +27,41 @@ func (t *clientTrack) absoluteTime() (time.Time, bool) {
 
 func (t *clientTrack) handleData(
 	ctx context.Context,
+	gen int,
 	pts int64,
 	dts int64,
 	ntp *time.Time,
 	data [][]byte,
 ) error {
-	// silently discard packets prior to the first packet of the leading track
-	if pts < 0 {
+	curGen, _, chSeek := t.seeker.current()
+
+	// silently discard packets that precede a seek
+	if gen != curGen {
+		return nil
+	}
+
+	// silently discard packets prior to the first packet of the leading track.
+	// After a backward seek, timestamps are negative and must be kept.
+	if gen == 0 && pts < 0 {
 		return nil
 	}
 
+	dtsDuration := timestampToDuration(dts, t.track.ClockRate)
+
+	// after a seek, restart time synchronization from the first packet
+	if gen != t.gen {
+		t.gen = gen
+		t.startSystem = time.Now().Add(-dtsDuration)
+		t.seeker.onFirstData(gen)
+
+		if t.captions != nil {
+			t.captions.reset()
+		}
+	}
+
 	// synchronize time
 	elapsed := time.Since(t.startSystem)
-	dtsDuration := timestampToDuration(dts, t.track.ClockRate)
-	if dtsDuration > elapsed {
+	if !t.disablePacing && dtsDuration > elapsed {
 		diff := dtsDuration - elapsed
 		if diff > clientMaxDTSSystemDiff {
 			return fmt.Errorf("difference between DTS and system time is too big")
@@ -43,6 +69,8 @@ func (t *clientTrack) handleData(
 
 		select {
 		case <-time.After(diff):
+		case <-chSeek:
+			return nil
 		case <-ctx.Done():
 			return fmt.Errorf("terminated")
 		}
@@ -50,5 +78,14 @@ func (t *clientTrack) handleData(
 
 	t.lastAbsoluteTime = ntp
 	t.onData(pts, dts, data)
+
+	if t.captions != nil {
+		t.captions.process(pts, dts, data)
+	}
+
+	if t.cueScheduler != nil {
+		t.cueScheduler.process(gen, pts, ntp)
+	}
+
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 )
 
 type procEntryFMP4 struct {
+	gen       int
 	partTrack *fmp4.PartTrack
 	dts       int64
 	ntp       *time.Time
@@ -25,6 +26,7 @@ type clientTrackProcessorFMP4 struct {
 	streamProcessor clientTrackProcessorFMP4StreamProcessor
 
 	decodePayload func(sample *fmp4.Sample) ([][]byte, error)
+	params        [][]byte
 
 	// in
 	queue chan *procEntryFMP4
@@ -57,12 +59,12 @@ func (t *clientTrackProcessorFMP4) initialize() error {
 			return [][]byte{sample.Payload}, nil
 		}
 
-	case *codecs.MPEG4Audio:
+	case *codecs.MPEG4Audio, *codecs.MPEG1Audio:
 		t.decodePayload = func(sample *fmp4.Sample) ([][]byte, error) {
 			return [][]byte{sample.Payload}, nil
 		}
 
-	case *codecs.FLAC:
+	case *codecs.FLAC, *codecs.AC3, *codecs.EAC3, *codecs.ID3:
 		t.decodePayload = func(sample *fmp4.Sample) ([][]byte, error) {
 			return [][]byte{sample.Payload}, nil
 		}
@@ -73,6 +75,18 @@ func (t *clientTrackProcessorFMP4) initialize() error {
 	return nil
 }
 
+// setParams prepends parameters of a new variant to the next sample,
+// since they are not transmitted in-band.
+func (t *clientTrackProcessorFMP4) setParams(codec codecs.Codec) {
+	switch codec := codec.(type) {
+	case *codecs.H265:
+		t.params = [][]byte{codec.VPS, codec.SPS, codec.PPS}
+
+	case *codecs.H264:
+		t.params = [][]byte{codec.SPS, codec.PPS}
+	}
+}
+
 func (t *clientTrackProcessorFMP4) run(ctx context.Context) error {
 	for {
 		select {
@@ -99,6 +113,11 @@ func (t *clientTrackProcessorFMP4) process(ctx context.Context, entry *procEntry
 			return err
 		}
 
+		if t.params != nil {
+			data = append(t.params, data...)
+			t.params = nil
+		}
+
 		pts := dts + int64(sample.PTSOffset)
 
 		var ntp *time.Time
@@ -106,7 +125,7 @@ func (t *clientTrackProcessorFMP4) process(ctx context.Context, entry *procEntry
 			ntp = ptrOf(entry.ntp.Add(timestampToDuration(dts-entry.dts, t.track.track.ClockRate)))
 		}
 
-		err = t.track.handleData(ctx, pts, dts, ntp, data)
+		err = t.track.handleData(ctx, entry.gen, pts, dts, ntp, data)
 		if err != nil {
 			return err
 		}
@@ -7,6 +7,7 @@ import (
 )
 
 type procEntryMPEGTS struct {
+	gen  int
 	pts  int64
 	dts  int64
 	ntp  *time.Time
@@ -49,7 +50,7 @@ func (t *clientTrackProcessorMPEGTS) process(ctx context.Context, entry *procEnt
 		return nil
 	}
 
-	return t.track.handleData(ctx, entry.pts, entry.dts, entry.ntp, entry.data)
+	return t.track.handleData(ctx, entry.gen, entry.pts, entry.dts, entry.ntp, entry.data)
 }
 
 func (t *clientTrackProcessorMPEGTS) push(ctx context.Context, entry *procEntryMPEGTS) error {
@@ -0,0 +1,243 @@
+package gohlslib
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+func codecFamily(codec string) string {
+	family, _, _ := strings.Cut(codec, ".")
+	if family == "hev1" {
+		return "hvc1"
+	}
+	return family
+}
+
+func variantsAreCompatible(a *playlist.MultivariantVariant, b *playlist.MultivariantVariant) bool {
+	if a.Audio != b.Audio || len(a.Codecs) != len(b.Codecs) {
+		return false
+	}
+
+	for i, codec := range a.Codecs {
+		if codecFamily(codec) != codecFamily(b.Codecs[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getSwitchableVariants returns variants that can replace the leading one
+// without changing tracks, sorted by ascending bandwidth.
+func getSwitchableVariants(
+	variants []*playlist.MultivariantVariant,
+	leading *playlist.MultivariantVariant,
+) []*playlist.MultivariantVariant {
+	var ret []*playlist.MultivariantVariant
+
+	for _, v := range variants {
+		if variantsAreCompatible(v, leading) {
+			ret = append(ret, v)
+		}
+	}
+
+	slices.SortStableFunc(ret, func(a, b *playlist.MultivariantVariant) int {
+		return a.Bandwidth - b.Bandwidth
+	})
+
+	return ret
+}
+
+type clientVariantSwitcher struct {
+	policy          ClientABRPolicy
+	onVariantSwitch ClientOnVariantSwitchFunc
+
+	estimator          clientBandwidthEstimator
+	mutex              sync.Mutex
+	primaryPlaylistURL *url.URL
+	variants           []*playlist.MultivariantVariant
+	pathwayVariants    map[*playlist.MultivariantVariant]*playlist.MultivariantVariant
+	pathwayChanged     bool
+	current            *playlist.MultivariantVariant
+	requested          *playlist.MultivariantVariant
+	pinned             bool
+}
+
+func (s *clientVariantSwitcher) initialize() {
+}
+
+func (s *clientVariantSwitcher) setVariants(
+	primaryPlaylistURL *url.URL,
+	variants []*playlist.MultivariantVariant,
+	current *playlist.MultivariantVariant,
+) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.primaryPlaylistURL = primaryPlaylistURL
+	s.variants = variants
+	s.current = current
+}
+
+// setPathwayVariants sets, for each variant, the equivalent variant of another pathway,
+// from which the variant is downloaded, and asks to download the current variant again.
+// Variants themselves are left untouched, therefore pointers received by the user
+// and the variant requested by the user are still valid.
+func (s *clientVariantSwitcher) setPathwayVariants(
+	pathwayVariants map[*playlist.MultivariantVariant]*playlist.MultivariantVariant,
+) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pathwayVariants = pathwayVariants
+	s.pathwayChanged = true
+}
+
+func (s *clientVariantSwitcher) allVariants() []*playlist.MultivariantVariant {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.variants)
+}
+
+// availableVariants returns variants that are available in the current pathway.
+func (s *clientVariantSwitcher) availableVariants() []*playlist.MultivariantVariant {
+	if s.pathwayVariants == nil {
+		return s.variants
+	}
+
+	var ret []*playlist.MultivariantVariant
+
+	for _, v := range s.variants {
+		if _, ok := s.pathwayVariants[v]; ok {
+			ret = append(ret, v)
+		}
+	}
+
+	return ret
+}
+
+func (s *clientVariantSwitcher) currentVariant() *playlist.MultivariantVariant {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.current
+}
+
+// requestVariant asks to switch to a variant and disables automatic switching.
+// A nil variant enables automatic switching again.
+func (s *clientVariantSwitcher) requestVariant(v *playlist.MultivariantVariant) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.variants == nil {
+		return fmt.Errorf("variants are not available")
+	}
+
+	if v == nil {
+		s.requested = nil
+		s.pinned = false
+		return nil
+	}
+
+	if !slices.Contains(s.availableVariants(), v) {
+		return fmt.Errorf("variant %v cannot be selected", v.URI)
+	}
+
+	s.requested = v
+	s.pinned = true
+	return nil
+}
+
+func (s *clientVariantSwitcher) onSegmentDownloaded(size int, duration time.Duration) {
+	s.estimator.addSample(size, duration)
+}
+
+// nextVariant returns the variant to switch to, or nil when the current one has to be kept.
+func (s *clientVariantSwitcher) nextVariant() *playlist.MultivariantVariant {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v := s.selectVariant()
+
+	// the current variant has to be downloaded again from the new pathway
+	if v == nil && s.pathwayChanged {
+		v = s.current
+	}
+	s.pathwayChanged = false
+
+	return v
+}
+
+func (s *clientVariantSwitcher) selectVariant() *playlist.MultivariantVariant {
+	variants := s.availableVariants()
+
+	if s.requested != nil {
+		v := s.requested
+		s.requested = nil
+		if v == s.current || !slices.Contains(variants, v) {
+			return nil
+		}
+		return v
+	}
+
+	if s.pinned || s.policy == nil || len(variants) < 2 {
+		return nil
+	}
+
+	bw, ok := s.estimator.estimate()
+	if !ok {
+		return nil
+	}
+
+	v := s.policy.SelectVariant(variants, s.current, bw)
+	if v == nil || v == s.current || !slices.Contains(variants, v) {
+		return nil
+	}
+
+	return v
+}
+
+// variantURL returns the URL of a variant in the current pathway.
+func (s *clientVariantSwitcher) variantURL(v *playlist.MultivariantVariant) (*url.URL, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pathwayVariants != nil {
+		pv, ok := s.pathwayVariants[v]
+		if !ok {
+			return nil, fmt.Errorf("variant %v is not available in the current pathway", v.URI)
+		}
+		v = pv
+	}
+
+	return clientAbsoluteURL(s.primaryPlaylistURL, v.URI)
+}
+
+func (s *clientVariantSwitcher) setCurrent(v *playlist.MultivariantVariant) {
+	s.mutex.Lock()
+	prev := s.current
+	s.current = v
+	s.mutex.Unlock()
+
+	// the variant is the same when only the pathway has been changed
+	if v != prev {
+		s.onVariantSwitch(prev, v)
+	}
+}
+
+// exclude removes a variant that turned out to be incompatible with the current one.
+func (s *clientVariantSwitcher) exclude(v *playlist.MultivariantVariant) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.variants = slices.DeleteFunc(s.variants, func(v2 *playlist.MultivariantVariant) bool {
+		return v2 == v
+	})
+}
@@ -0,0 +1,816 @@
+package gohlslib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// SAMPLE-AES encryption of fMP4 segments, performed with the CENC 'cbcs' scheme
+// (ISO/IEC 23001-7) and a constant IV.
+
+const (
+	// bytes at the beginning of each video NALU that are left in clear.
+	cbcsVideoClearLeader = 32
+
+	// video is encrypted with a 1:9 pattern, audio is fully encrypted.
+	cbcsVideoCryptBlocks = 1
+	cbcsVideoSkipBlocks  = 9
+)
+
+type cbcsSubsample struct {
+	clearBytes     uint16
+	protectedBytes uint32
+}
+
+// cbcsApplyPattern encrypts or decrypts a protected range in place.
+// The CBC chain continues through encrypted blocks, skipped blocks and
+// the trailing partial block are left in clear.
+func cbcsApplyPattern(mode cipher.BlockMode, data []byte, cryptBlocks int, skipBlocks int) {
+	if cryptBlocks == 0 && skipBlocks == 0 {
+		l := len(data) - len(data)%aes.BlockSize
+		mode.CryptBlocks(data[:l], data[:l])
+		return
+	}
+
+	for pos := 0; (len(data) - pos) >= aes.BlockSize; pos += (cryptBlocks + skipBlocks) * aes.BlockSize {
+		l := min(cryptBlocks*aes.BlockSize, (len(data)-pos)-(len(data)-pos)%aes.BlockSize)
+		mode.CryptBlocks(data[pos:pos+l], data[pos:pos+l])
+	}
+}
+
+func cbcsIsVCLNALU(typ byte, isH265 bool) bool {
+	if isH265 {
+		return ((typ >> 1) & 0b111111) < 32
+	}
+	typ &= 0x1F
+	return typ >= 1 && typ <= 5
+}
+
+// cbcsVideoSubsamples computes subsamples of a sample in AVCC format.
+// Slice data of VCL NALUs is protected, while everything else is left in clear.
+func cbcsVideoSubsamples(payload []byte, isH265 bool) ([]cbcsSubsample, error) {
+	var ret []cbcsSubsample
+	pendingClear := 0
+
+	addClear := func(clearBytes int, protectedBytes int) {
+		for clearBytes > 0xFFFF {
+			ret = append(ret, cbcsSubsample{clearBytes: 0xFFFF})
+			clearBytes -= 0xFFFF
+		}
+		ret = append(ret, cbcsSubsample{
+			clearBytes:     uint16(clearBytes),
+			protectedBytes: uint32(protectedBytes),
+		})
+	}
+
+	for pos := 0; pos < len(payload); {
+		if (len(payload) - pos) < 4 {
+			return nil, fmt.Errorf("invalid NALU length")
+		}
+
+		l := int(binary.BigEndian.Uint32(payload[pos:]))
+		if l == 0 || (len(payload)-pos-4) < l {
+			return nil, fmt.Errorf("invalid NALU length")
+		}
+
+		protected := 0
+		if cbcsIsVCLNALU(payload[pos+4], isH265) && l > (cbcsVideoClearLeader+aes.BlockSize) {
+			protected = l - cbcsVideoClearLeader
+			protected -= protected % aes.BlockSize
+		}
+
+		if protected != 0 {
+			addClear(pendingClear+4+l-protected, protected)
+			pendingClear = 0
+		} else {
+			pendingClear += 4 + l
+		}
+
+		pos += 4 + l
+	}
+
+	if pendingClear != 0 {
+		addClear(pendingClear, 0)
+	}
+
+	return ret, nil
+}
+
+func cbcsEncryptVideoSample(
+	block cipher.Block,
+	iv []byte,
+	payload []byte,
+	isH265 bool,
+) ([]byte, []cbcsSubsample, error) {
+	subsamples, err := cbcsVideoSubsamples(payload, isH265)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := make([]byte, len(payload))
+	copy(enc, payload)
+
+	pos := 0
+	for _, ss := range subsamples {
+		pos += int(ss.clearBytes)
+
+		// IV is reset at the beginning of each subsample
+		mode := cipher.NewCBCEncrypter(block, iv)
+		cbcsApplyPattern(mode, enc[pos:pos+int(ss.protectedBytes)], cbcsVideoCryptBlocks, cbcsVideoSkipBlocks)
+		pos += int(ss.protectedBytes)
+	}
+
+	return enc, subsamples, nil
+}
+
+func cbcsEncryptAudioSample(block cipher.Block, iv []byte, payload []byte) []byte {
+	enc := make([]byte, len(payload))
+	copy(enc, payload)
+
+	cbcsApplyPattern(cipher.NewCBCEncrypter(block, iv), enc, 0, 0)
+
+	return enc
+}
+
+type mp4Box struct {
+	typ   string
+	start int
+	end   int
+}
+
+func (b mp4Box) payload(buf []byte) []byte {
+	return buf[b.start+8 : b.end]
+}
+
+func mp4ParseBoxes(buf []byte) ([]mp4Box, error) {
+	var ret []mp4Box
+
+	for pos := 0; pos < len(buf); {
+		if (len(buf) - pos) < 8 {
+			return nil, fmt.Errorf("invalid box header")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[pos:]))
+		if size < 8 || (len(buf)-pos) < size {
+			return nil, fmt.Errorf("invalid box size")
+		}
+
+		ret = append(ret, mp4Box{
+			typ:   string(buf[pos+4 : pos+8]),
+			start: pos,
+			end:   pos + size,
+		})
+		pos += size
+	}
+
+	return ret, nil
+}
+
+func mp4AppendBox(buf []byte, typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, typ...)
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+
+	return buf
+}
+
+func cbcsSinf(originalFormat string, isVideo bool, constantIV []byte) []byte {
+	frma := mp4AppendBox(nil, "frma", []byte(originalFormat))
+
+	schm := mp4AppendBox(nil, "schm",
+		[]byte{0, 0, 0, 0}, // version and flags
+		[]byte("cbcs"),
+		[]byte{0, 1, 0, 0}) // scheme version
+
+	pattern := byte(0)
+	if isVideo {
+		pattern = cbcsVideoCryptBlocks<<4 | cbcsVideoSkipBlocks
+	}
+
+	tenc := mp4AppendBox(nil, "tenc",
+		[]byte{1, 0, 0, 0},       // version and flags
+		[]byte{0, pattern, 1, 0}, // reserved, pattern, is protected, per-sample IV size
+		make([]byte, 16),         // KID
+		[]byte{byte(len(constantIV))},
+		constantIV)
+
+	schi := mp4AppendBox(nil, "schi", tenc)
+
+	return mp4AppendBox(nil, "sinf", frma, schm, schi)
+}
+
+func cbcsProtectSampleEntries(buf []byte, constantIV []byte) ([]byte, error) {
+	entries, err := mp4ParseBoxes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []byte
+
+	for _, entry := range entries {
+		var isVideo bool
+
+		switch entry.typ {
+		case "avc1", "hvc1", "hev1":
+			isVideo = true
+
+		case "mp4a", "Opus", "fLaC":
+			isVideo = false
+
+		default:
+			return nil, fmt.Errorf("SAMPLE-AES encryption is not supported with sample entry '%s'", entry.typ)
+		}
+
+		typ := "enca"
+		if isVideo {
+			typ = "encv"
+		}
+
+		ret = mp4AppendBox(ret, typ, entry.payload(buf), cbcsSinf(entry.typ, isVideo, constantIV))
+	}
+
+	return ret, nil
+}
+
+func cbcsProtectBoxes(buf []byte, constantIV []byte) ([]byte, error) {
+	boxes, err := mp4ParseBoxes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []byte
+
+	for _, box := range boxes {
+		switch box.typ {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			children, err := cbcsProtectBoxes(box.payload(buf), constantIV)
+			if err != nil {
+				return nil, err
+			}
+			ret = mp4AppendBox(ret, box.typ, children)
+
+		case "stsd":
+			// version, flags and entry count are followed by sample entries
+			pl := box.payload(buf)
+			if len(pl) < 8 {
+				return nil, fmt.Errorf("invalid stsd box")
+			}
+
+			entries, err := cbcsProtectSampleEntries(pl[8:], constantIV)
+			if err != nil {
+				return nil, err
+			}
+			ret = mp4AppendBox(ret, box.typ, pl[:8], entries)
+
+		default:
+			ret = append(ret, buf[box.start:box.end]...)
+		}
+	}
+
+	return ret, nil
+}
+
+// cbcsProtectInit converts an initialization segment into a protected one,
+// by replacing sample entries with encv / enca ones.
+func cbcsProtectInit(init []byte, constantIV []byte) ([]byte, error) {
+	return cbcsProtectBoxes(init, constantIV)
+}
+
+func cbcsSencBoxes(subsamples [][]cbcsSubsample) ([]byte, []byte, error) {
+	saizPayload := []byte{
+		0, 0, 0, 0, // version and flags
+		0, // default sample info size
+	}
+	saizPayload = binary.BigEndian.AppendUint32(saizPayload, uint32(len(subsamples)))
+
+	sencPayload := []byte{0, 0, 0, 2} // version and flags (use subsamples)
+	sencPayload = binary.BigEndian.AppendUint32(sencPayload, uint32(len(subsamples)))
+
+	for _, sampleSubsamples := range subsamples {
+		infoSize := 2 + 6*len(sampleSubsamples)
+		if infoSize > 0xFF {
+			return nil, nil, fmt.Errorf("too many subsamples")
+		}
+		saizPayload = append(saizPayload, byte(infoSize))
+
+		sencPayload = binary.BigEndian.AppendUint16(sencPayload, uint16(len(sampleSubsamples)))
+		for _, ss := range sampleSubsamples {
+			sencPayload = binary.BigEndian.AppendUint16(sencPayload, ss.clearBytes)
+			sencPayload = binary.BigEndian.AppendUint32(sencPayload, ss.protectedBytes)
+		}
+	}
+
+	return mp4AppendBox(nil, "saiz", saizPayload), mp4AppendBox(nil, "senc", sencPayload), nil
+}
+
+// cbcsProtectPart adds subsample informations to the tracks of a part.
+// subsamples contains, for each track ID, subsamples of each sample.
+func cbcsProtectPart(part []byte, subsamples map[int][][]cbcsSubsample) ([]byte, error) {
+	boxes, err := mp4ParseBoxes(part)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(boxes) == 0 || boxes[0].typ != "moof" {
+		return nil, fmt.Errorf("moof box not found")
+	}
+
+	moof := boxes[0]
+	moofChildren, err := mp4ParseBoxes(moof.payload(part))
+	if err != nil {
+		return nil, err
+	}
+
+	moofPayload := moof.payload(part)
+	newMoofPayload := []byte(nil)
+	var trunOffsets []int // relative to the moof start
+
+	for _, child := range moofChildren {
+		if child.typ != "traf" {
+			newMoofPayload = append(newMoofPayload, moofPayload[child.start:child.end]...)
+			continue
+		}
+
+		trafStart := 8 + len(newMoofPayload)
+		trafPayload := child.payload(moofPayload)
+
+		var trafChildren []mp4Box
+		trafChildren, err = mp4ParseBoxes(trafPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		trackID := 0
+		for _, tc := range trafChildren {
+			switch tc.typ {
+			case "tfhd":
+				if (tc.end - tc.start) < 16 {
+					return nil, fmt.Errorf("invalid tfhd box")
+				}
+				trackID = int(binary.BigEndian.Uint32(trafPayload[tc.start+12:]))
+
+			case "trun":
+				trunOffsets = append(trunOffsets, trafStart+8+tc.start)
+			}
+		}
+
+		trackSubsamples, ok := subsamples[trackID]
+		if !ok {
+			newMoofPayload = append(newMoofPayload, moofPayload[child.start:child.end]...)
+			continue
+		}
+
+		var saiz, senc []byte
+		saiz, senc, err = cbcsSencBoxes(trackSubsamples)
+		if err != nil {
+			return nil, err
+		}
+
+		// saio points to the first sample information inside senc
+		saioOffset := trafStart + 8 + len(trafPayload) + len(saiz) + 20 + 16
+		saioPayload := []byte{
+			0, 0, 0, 0, // version and flags
+			0, 0, 0, 1, // entry count
+		}
+		saioPayload = binary.BigEndian.AppendUint32(saioPayload, uint32(saioOffset))
+		saio := mp4AppendBox(nil, "saio", saioPayload)
+
+		newMoofPayload = mp4AppendBox(newMoofPayload, "traf", trafPayload, saiz, saio, senc)
+	}
+
+	ret := mp4AppendBox(nil, "moof", newMoofPayload)
+
+	// data offsets are relative to the moof start, therefore they must be shifted
+	growth := len(ret) - (moof.end - moof.start)
+	for _, trunOffset := range trunOffsets {
+		flags := binary.BigEndian.Uint32(ret[trunOffset+8:]) & 0xFFFFFF
+		if (flags & 0x01) != 0 {
+			dataOffset := binary.BigEndian.Uint32(ret[trunOffset+16:])
+			binary.BigEndian.PutUint32(ret[trunOffset+16:], dataOffset+uint32(growth))
+		}
+	}
+
+	return append(ret, part[moof.end:]...), nil
+}
+
+// Decryption of fMP4 segments, used by the Client. Besides the 'cbcs' scheme,
+// the 'cenc' scheme (AES-CTR with per-sample IVs) is supported too.
+
+const (
+	fmp4SchemeCBCS = "cbcs"
+	fmp4SchemeCENC = "cenc"
+)
+
+// fmp4SampleEncryption contains encryption parameters of a sample (senc box).
+type fmp4SampleEncryption struct {
+	iv         []byte // present when the per-sample IV size is not zero
+	subsamples []cbcsSubsample
+}
+
+// fmp4TrackProtection contains protection parameters of a track (sinf box).
+type fmp4TrackProtection struct {
+	scheme          string
+	isProtected     bool
+	perSampleIVSize int
+	kid             []byte
+	constantIV      []byte
+	cryptBlocks     int
+	skipBlocks      int
+}
+
+func (p *fmp4TrackProtection) unmarshalTenc(pl []byte) error {
+	if len(pl) < 24 {
+		return fmt.Errorf("invalid tenc box")
+	}
+
+	if pl[0] >= 1 {
+		p.cryptBlocks = int(pl[5] >> 4)
+		p.skipBlocks = int(pl[5] & 0x0F)
+	}
+
+	p.isProtected = pl[6] != 0
+	p.perSampleIVSize = int(pl[7])
+	p.kid = pl[8:24]
+
+	if p.perSampleIVSize != 0 && p.perSampleIVSize != 8 && p.perSampleIVSize != 16 {
+		return fmt.Errorf("invalid per-sample IV size: %d", p.perSampleIVSize)
+	}
+
+	if p.isProtected && p.perSampleIVSize == 0 {
+		if len(pl) < 25 || len(pl) < (25+int(pl[24])) {
+			return fmt.Errorf("invalid tenc box")
+		}
+		p.constantIV = pl[25 : 25+int(pl[24])]
+	}
+
+	return nil
+}
+
+// unmarshalSinf decodes a sinf box and returns the original format.
+func (p *fmp4TrackProtection) unmarshalSinf(pl []byte) (string, error) {
+	boxes, err := mp4ParseBoxes(pl)
+	if err != nil {
+		return "", err
+	}
+
+	var originalFormat string
+
+	for _, box := range boxes {
+		bpl := box.payload(pl)
+
+		switch box.typ {
+		case "frma":
+			if len(bpl) != 4 {
+				return "", fmt.Errorf("invalid frma box")
+			}
+			originalFormat = string(bpl)
+
+		case "schm":
+			if len(bpl) < 8 {
+				return "", fmt.Errorf("invalid schm box")
+			}
+			p.scheme = string(bpl[4:8])
+
+		case "schi":
+			var schiBoxes []mp4Box
+			schiBoxes, err = mp4ParseBoxes(bpl)
+			if err != nil {
+				return "", err
+			}
+
+			for _, schiBox := range schiBoxes {
+				if schiBox.typ == "tenc" {
+					err = p.unmarshalTenc(schiBox.payload(bpl))
+					if err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+	}
+
+	if originalFormat == "" || p.scheme == "" || p.kid == nil {
+		return "", fmt.Errorf("invalid sinf box")
+	}
+
+	return originalFormat, nil
+}
+
+// fmp4DecryptSample decrypts a sample in place.
+func fmp4DecryptSample(
+	block cipher.Block,
+	prot *fmp4TrackProtection,
+	enc *fmp4SampleEncryption,
+	payload []byte,
+) error {
+	iv := prot.constantIV
+	if enc != nil && enc.iv != nil {
+		iv = enc.iv
+	}
+
+	// 8-byte IVs are padded with zeros
+	if len(iv) != aes.BlockSize {
+		if len(iv) != 8 {
+			return fmt.Errorf("invalid IV size: %d", len(iv))
+		}
+		iv = append(append([]byte(nil), iv...), make([]byte, 8)...)
+	}
+
+	// find out protected ranges
+	var ranges [][]byte
+
+	if enc == nil || enc.subsamples == nil {
+		ranges = [][]byte{payload}
+	} else {
+		pos := 0
+		for _, ss := range enc.subsamples {
+			pos += int(ss.clearBytes)
+			end := pos + int(ss.protectedBytes)
+			if end > len(payload) {
+				return fmt.Errorf("subsamples exceed sample size")
+			}
+			ranges = append(ranges, payload[pos:end])
+			pos = end
+		}
+	}
+
+	switch prot.scheme {
+	case fmp4SchemeCBCS:
+		// IV is reset at the beginning of each subsample
+		for _, r := range ranges {
+			cbcsApplyPattern(cipher.NewCBCDecrypter(block, iv), r, prot.cryptBlocks, prot.skipBlocks)
+		}
+
+	case fmp4SchemeCENC:
+		// the key stream continues through subsamples
+		stream := cipher.NewCTR(block, iv)
+		for _, r := range ranges {
+			stream.XORKeyStream(r, r)
+		}
+
+	default:
+		return fmt.Errorf("unsupported protection scheme: %v", prot.scheme)
+	}
+
+	return nil
+}
+
+type fmp4Unprotector struct {
+	curTrackID  int
+	protections map[int]*fmp4TrackProtection
+}
+
+func (u *fmp4Unprotector) unprotectSampleEntries(buf []byte) ([]byte, error) {
+	entries, err := mp4ParseBoxes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []byte
+
+	for _, entry := range entries {
+		var fixedSize int
+
+		switch entry.typ {
+		case "encv":
+			fixedSize = 78
+
+		case "enca":
+			fixedSize = 28
+
+		default:
+			ret = append(ret, buf[entry.start:entry.end]...)
+			continue
+		}
+
+		pl := entry.payload(buf)
+		if len(pl) < fixedSize {
+			return nil, fmt.Errorf("invalid sample entry")
+		}
+
+		children, err := mp4ParseBoxes(pl[fixedSize:])
+		if err != nil {
+			return nil, err
+		}
+
+		newPayload := append([]byte(nil), pl[:fixedSize]...)
+		prot := &fmp4TrackProtection{}
+		var originalFormat string
+
+		for _, child := range children {
+			if child.typ == "sinf" {
+				originalFormat, err = prot.unmarshalSinf(child.payload(pl[fixedSize:]))
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				newPayload = append(newPayload, pl[fixedSize+child.start:fixedSize+child.end]...)
+			}
+		}
+
+		if originalFormat == "" {
+			return nil, fmt.Errorf("sinf box not found")
+		}
+
+		u.protections[u.curTrackID] = prot
+		ret = mp4AppendBox(ret, originalFormat, newPayload)
+	}
+
+	return ret, nil
+}
+
+func (u *fmp4Unprotector) unprotectBoxes(buf []byte) ([]byte, error) {
+	boxes, err := mp4ParseBoxes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []byte
+
+	for _, box := range boxes {
+		switch box.typ {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			children, err := u.unprotectBoxes(box.payload(buf))
+			if err != nil {
+				return nil, err
+			}
+			ret = mp4AppendBox(ret, box.typ, children)
+
+		case "tkhd":
+			pl := box.payload(buf)
+			if len(pl) < 24 {
+				return nil, fmt.Errorf("invalid tkhd box")
+			}
+
+			if pl[0] == 0 {
+				u.curTrackID = int(binary.BigEndian.Uint32(pl[12:]))
+			} else {
+				u.curTrackID = int(binary.BigEndian.Uint32(pl[20:]))
+			}
+			ret = append(ret, buf[box.start:box.end]...)
+
+		case "stsd":
+			pl := box.payload(buf)
+			if len(pl) < 8 {
+				return nil, fmt.Errorf("invalid stsd box")
+			}
+
+			entries, err := u.unprotectSampleEntries(pl[8:])
+			if err != nil {
+				return nil, err
+			}
+			ret = mp4AppendBox(ret, box.typ, pl[:8], entries)
+
+		default:
+			ret = append(ret, buf[box.start:box.end]...)
+		}
+	}
+
+	return ret, nil
+}
+
+// fmp4UnprotectInit converts a protected initialization file into a clear one,
+// by restoring original sample entries. It returns protection parameters of each track ID.
+func fmp4UnprotectInit(init []byte) ([]byte, map[int]*fmp4TrackProtection, error) {
+	u := &fmp4Unprotector{
+		protections: make(map[int]*fmp4TrackProtection),
+	}
+
+	ret, err := u.unprotectBoxes(init)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ret, u.protections, nil
+}
+
+func fmp4UnmarshalSenc(pl []byte, perSampleIVSize int) ([]*fmp4SampleEncryption, error) {
+	if len(pl) < 8 {
+		return nil, fmt.Errorf("invalid senc box")
+	}
+
+	useSubsamples := (pl[3] & 0x02) != 0
+	sampleCount := int(binary.BigEndian.Uint32(pl[4:]))
+	pos := 8
+
+	if sampleCount > len(pl) {
+		return nil, fmt.Errorf("invalid senc box")
+	}
+
+	ret := make([]*fmp4SampleEncryption, sampleCount)
+
+	for i := range sampleCount {
+		enc := &fmp4SampleEncryption{}
+
+		if perSampleIVSize != 0 {
+			if (len(pl) - pos) < perSampleIVSize {
+				return nil, fmt.Errorf("invalid senc box")
+			}
+			enc.iv = pl[pos : pos+perSampleIVSize]
+			pos += perSampleIVSize
+		}
+
+		if useSubsamples {
+			if (len(pl) - pos) < 2 {
+				return nil, fmt.Errorf("invalid senc box")
+			}
+			count := int(binary.BigEndian.Uint16(pl[pos:]))
+			pos += 2
+
+			if (len(pl) - pos) < 6*count {
+				return nil, fmt.Errorf("invalid senc box")
+			}
+
+			enc.subsamples = make([]cbcsSubsample, count)
+			for j := range count {
+				enc.subsamples[j] = cbcsSubsample{
+					clearBytes:     binary.BigEndian.Uint16(pl[pos:]),
+					protectedBytes: binary.BigEndian.Uint32(pl[pos+2:]),
+				}
+				pos += 6
+			}
+		}
+
+		ret[i] = enc
+	}
+
+	return ret, nil
+}
+
+// fmp4ReadSampleEncryptions reads sample encryption parameters of one or more parts.
+// It returns, for each part, parameters of each sample of each track ID.
+func fmp4ReadSampleEncryptions(
+	byts []byte,
+	protections map[int]*fmp4TrackProtection,
+) ([]map[int][]*fmp4SampleEncryption, error) {
+	boxes, err := mp4ParseBoxes(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []map[int][]*fmp4SampleEncryption
+
+	for _, box := range boxes {
+		if box.typ != "moof" {
+			continue
+		}
+
+		moofPayload := box.payload(byts)
+		partEncs := make(map[int][]*fmp4SampleEncryption)
+		ret = append(ret, partEncs)
+
+		var moofChildren []mp4Box
+		moofChildren, err = mp4ParseBoxes(moofPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range moofChildren {
+			if child.typ != "traf" {
+				continue
+			}
+
+			trafPayload := child.payload(moofPayload)
+
+			var trafChildren []mp4Box
+			trafChildren, err = mp4ParseBoxes(trafPayload)
+			if err != nil {
+				return nil, err
+			}
+
+			trackID := 0
+
+			for _, tc := range trafChildren {
+				switch tc.typ {
+				case "tfhd":
+					if (tc.end - tc.start) < 16 {
+						return nil, fmt.Errorf("invalid tfhd box")
+					}
+					trackID = int(binary.BigEndian.Uint32(trafPayload[tc.start+12:]))
+
+				case "senc":
+					prot, ok := protections[trackID]
+					if !ok {
+						continue
+					}
+
+					partEncs[trackID], err = fmp4UnmarshalSenc(tc.payload(trafPayload), prot.perSampleIVSize)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
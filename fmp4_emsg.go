@@ -0,0 +1,159 @@
+package gohlslib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Timed ID3 metadata in fMP4 segments, carried by Event Message boxes (ISO/IEC 23009-1),
+// as described in the "Carriage of ID3 Timed Metadata in the Common Media Application Format" specification.
+
+const (
+	fmp4EmsgSchemeID3 = "https://aomedia.org/emsg/ID3"
+)
+
+// fmp4Emsg is an Event Message box (emsg).
+type fmp4Emsg struct {
+	schemeIDURI      string
+	value            string
+	timeScale        uint32
+	presentationTime uint64
+	eventDuration    uint32
+	id               uint32
+	messageData      []byte
+}
+
+// marshal encodes the box with version 1, that contains an absolute presentation time.
+func (e fmp4Emsg) marshal() []byte {
+	pl := []byte{1, 0, 0, 0} // version, flags
+	pl = binary.BigEndian.AppendUint32(pl, e.timeScale)
+	pl = binary.BigEndian.AppendUint64(pl, e.presentationTime)
+	pl = binary.BigEndian.AppendUint32(pl, e.eventDuration)
+	pl = binary.BigEndian.AppendUint32(pl, e.id)
+	pl = append(pl, e.schemeIDURI...)
+	pl = append(pl, 0)
+	pl = append(pl, e.value...)
+	pl = append(pl, 0)
+
+	return mp4AppendBox(nil, "emsg", pl, e.messageData)
+}
+
+// unmarshal decodes the payload of the box.
+// Version 0 boxes contain a presentation time relative to the earliest
+// presentation time of the segment, that is passed as parameter.
+func (e *fmp4Emsg) unmarshal(pl []byte, segmentStart uint64, segmentTimeScale uint32) error {
+	if len(pl) < 4 {
+		return fmt.Errorf("invalid emsg box")
+	}
+
+	version := pl[0]
+	pl = pl[4:]
+
+	readString := func() (string, error) {
+		i := bytes.IndexByte(pl, 0)
+		if i < 0 {
+			return "", fmt.Errorf("invalid emsg box")
+		}
+		ret := string(pl[:i])
+		pl = pl[i+1:]
+		return ret, nil
+	}
+
+	var err error
+
+	switch version {
+	case 0:
+		e.schemeIDURI, err = readString()
+		if err != nil {
+			return err
+		}
+
+		e.value, err = readString()
+		if err != nil {
+			return err
+		}
+
+		if len(pl) < 16 {
+			return fmt.Errorf("invalid emsg box")
+		}
+
+		e.timeScale = binary.BigEndian.Uint32(pl)
+		if e.timeScale == 0 || segmentTimeScale == 0 {
+			return fmt.Errorf("invalid emsg box")
+		}
+		e.presentationTime = uint64(multiplyAndDivide(int64(segmentStart), int64(e.timeScale),
+			int64(segmentTimeScale))) + uint64(binary.BigEndian.Uint32(pl[4:]))
+		e.eventDuration = binary.BigEndian.Uint32(pl[8:])
+		e.id = binary.BigEndian.Uint32(pl[12:])
+		e.messageData = pl[16:]
+
+	case 1:
+		if len(pl) < 20 {
+			return fmt.Errorf("invalid emsg box")
+		}
+
+		e.timeScale = binary.BigEndian.Uint32(pl)
+		if e.timeScale == 0 {
+			return fmt.Errorf("invalid emsg box")
+		}
+		e.presentationTime = binary.BigEndian.Uint64(pl[4:])
+		e.eventDuration = binary.BigEndian.Uint32(pl[12:])
+		e.id = binary.BigEndian.Uint32(pl[16:])
+		pl = pl[20:]
+
+		e.schemeIDURI, err = readString()
+		if err != nil {
+			return err
+		}
+
+		e.value, err = readString()
+		if err != nil {
+			return err
+		}
+
+		e.messageData = pl
+
+	default:
+		return fmt.Errorf("unsupported emsg version: %d", version)
+	}
+
+	return nil
+}
+
+// fmp4ReadID3Emsgs returns the Event Message boxes of a segment that carry ID3 tags.
+// segmentStart and segmentTimeScale are the base time and time scale of the leading track,
+// and are used to decode boxes with a relative presentation time.
+func fmp4ReadID3Emsgs(byts []byte, segmentStart uint64, segmentTimeScale uint32) ([]*fmp4Emsg, error) {
+	boxes, err := mp4ParseBoxes(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*fmp4Emsg
+
+	for _, box := range boxes {
+		if box.typ != "emsg" {
+			continue
+		}
+
+		pl := box.payload(byts)
+
+		// skip boxes with future versions
+		if len(pl) != 0 && pl[0] > 1 {
+			continue
+		}
+
+		var emsg fmp4Emsg
+		err = emsg.unmarshal(pl, segmentStart, segmentTimeScale)
+		if err != nil {
+			return nil, err
+		}
+
+		if emsg.schemeIDURI == fmp4EmsgSchemeID3 {
+			ret = append(ret, &emsg)
+		}
+	}
+
+	return ret, nil
+}
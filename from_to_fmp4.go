@@ -46,10 +46,40 @@ func fromFMP4(in mp4codecs.Codec) codecs.Codec { //nolint:dupl
 			Config: in.Config,
 		}
 
+	case *mp4codecs.MPEG1Audio:
+		return &codecs.MPEG1Audio{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *mp4codecs.FLAC:
 		return &codecs.FLAC{
 			StreamInfo: in.StreamInfo,
 		}
+
+	case *mp4codecs.AC3:
+		return &codecs.AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+			Bsid:         in.Bsid,
+			Bsmod:        in.Bsmod,
+			Acmod:        in.Acmod,
+			LfeOn:        in.LfeOn,
+			BitRateCode:  in.BitRateCode,
+		}
+
+	case *mp4codecs.EAC3:
+		return &codecs.EAC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+			DataRate:     in.DataRate,
+			Asvc:         in.Asvc,
+			Bsmod:        in.Bsmod,
+			Acmod:        in.Acmod,
+			LfeOn:        in.LfeOn,
+			NumDepSub:    in.NumDepSub,
+			ChanLoc:      in.ChanLoc,
+		}
 	}
 
 	return nil
@@ -95,10 +125,40 @@ func toFMP4(in codecs.Codec) mp4codecs.Codec { //nolint:dupl
 			Config: in.Config,
 		}
 
+	case *codecs.MPEG1Audio:
+		return &mp4codecs.MPEG1Audio{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *codecs.FLAC:
 		return &mp4codecs.FLAC{
 			StreamInfo: in.StreamInfo,
 		}
+
+	case *codecs.AC3:
+		return &mp4codecs.AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+			Bsid:         in.Bsid,
+			Bsmod:        in.Bsmod,
+			Acmod:        in.Acmod,
+			LfeOn:        in.LfeOn,
+			BitRateCode:  in.BitRateCode,
+		}
+
+	case *codecs.EAC3:
+		return &mp4codecs.EAC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+			DataRate:     in.DataRate,
+			Asvc:         in.Asvc,
+			Bsmod:        in.Bsmod,
+			Acmod:        in.Acmod,
+			LfeOn:        in.LfeOn,
+			NumDepSub:    in.NumDepSub,
+			ChanLoc:      in.ChanLoc,
+		}
 	}
 
 	return nil
@@ -2,20 +2,53 @@ package gohlslib
 
 import (
 	tscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/substructs"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 )
 
 func fromMPEGTS(in tscodecs.Codec) codecs.Codec {
 	switch in := in.(type) {
+	case *tscodecs.H265:
+		return &codecs.H265{}
+
 	case *tscodecs.H264:
 		return &codecs.H264{}
 
+	case *tscodecs.MPEG4Video:
+		return &codecs.MPEG4Video{}
+
+	case *tscodecs.MPEG1Video:
+		return &codecs.MPEG1Video{}
+
+	case *tscodecs.Opus:
+		return &codecs.Opus{
+			ChannelCount: in.Desc.ChannelCount(),
+		}
+
 	case *tscodecs.MPEG4Audio:
 		return &codecs.MPEG4Audio{
 			Config: in.Config,
 		}
 
+	case *tscodecs.MPEG4AudioLATM:
+		return &codecs.MPEG4AudioLATM{}
+
+	case *tscodecs.MPEG1Audio:
+		return &codecs.MPEG1Audio{}
+
+	case *tscodecs.AC3:
+		return &codecs.AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
+	case *tscodecs.EAC3:
+		return &codecs.EAC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *tscodecs.KLV:
 		return &codecs.KLV{
 			Synchronous: in.Synchronous,
@@ -27,14 +60,48 @@ func fromMPEGTS(in tscodecs.Codec) codecs.Codec {
 
 func toMPEGTS(in codecs.Codec) tscodecs.Codec {
 	switch in := in.(type) {
+	case *codecs.H265:
+		return &tscodecs.H265{}
+
 	case *codecs.H264:
 		return &tscodecs.H264{}
 
+	case *codecs.MPEG4Video:
+		return &tscodecs.MPEG4Video{}
+
+	case *codecs.MPEG1Video:
+		return &tscodecs.MPEG1Video{}
+
+	case *codecs.Opus:
+		return &tscodecs.Opus{
+			Desc: &substructs.OpusAudioDescriptor{
+				ChannelConfigCode: uint8(in.ChannelCount),
+			},
+		}
+
 	case *codecs.MPEG4Audio:
 		return &tscodecs.MPEG4Audio{
 			Config: in.Config,
 		}
 
+	case *codecs.MPEG4AudioLATM:
+		return &tscodecs.MPEG4AudioLATM{}
+
+	case *codecs.MPEG1Audio:
+		return &tscodecs.MPEG1Audio{}
+
+	case *codecs.AC3:
+		return &tscodecs.AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
+	case *codecs.EAC3:
+		return &tscodecs.EAC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *codecs.KLV:
 		return &tscodecs.KLV{
 			Synchronous: in.Synchronous,
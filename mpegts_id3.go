@@ -0,0 +1,190 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+)
+
+// Timed ID3 metadata in MPEG-TS segments, as described in the
+// "Timed Metadata for HTTP Live Streaming" specification.
+// Tags are carried by PES packets of a metadata stream, that is not supported by mpegts.Reader and
+// mpegts.Writer; therefore PMTs are edited in order to declare or recognize these streams.
+
+const (
+	mpegtsStreamTypePrivateData        = 0x06
+	mpegtsStreamTypeMetadata           = 0x15
+	mpegtsDescriptorTagMetadataPointer = 0x25
+	mpegtsDescriptorTagMetadata        = 0x26
+)
+
+// metadata_application_format and metadata_format of timed ID3 metadata, followed by their identifiers.
+var mpegtsID3MetadataFormat = []byte{0xff, 0xff, 'I', 'D', '3', ' ', 0xff, 'I', 'D', '3', ' '}
+
+func mpegtsID3MetadataPointerDescriptor(programNumber uint16) []byte {
+	ret := []byte{mpegtsDescriptorTagMetadataPointer, 15}
+	ret = append(ret, mpegtsID3MetadataFormat...)
+	ret = append(ret, 0x00, 0x1f) // metadata_service_id, metadata_locator_record_flag, MPEG_carriage_flags
+	return binary.BigEndian.AppendUint16(ret, programNumber)
+}
+
+func mpegtsID3MetadataDescriptor() []byte {
+	ret := []byte{mpegtsDescriptorTagMetadata, 13}
+	ret = append(ret, mpegtsID3MetadataFormat...)
+	return append(ret, 0x00, 0x0f) // metadata_service_id, decoder_config_flags, DSM-CC_flag
+}
+
+// mpegtsIsID3Stream checks whether an elementary stream contains timed ID3 metadata,
+// given its stream type and descriptors.
+func mpegtsIsID3Stream(streamType uint8, descriptors []byte) bool {
+	if streamType != mpegtsStreamTypeMetadata {
+		return false
+	}
+
+	for pos := 0; (len(descriptors) - pos) >= 2; pos += 2 + int(descriptors[pos+1]) {
+		tag := descriptors[pos]
+		desc := descriptors[pos+2 : min(len(descriptors), pos+2+int(descriptors[pos+1]))]
+
+		if tag == mpegtsDescriptorTagMetadata &&
+			len(desc) >= len(mpegtsID3MetadataFormat) &&
+			string(desc[:len(mpegtsID3MetadataFormat)]) == string(mpegtsID3MetadataFormat) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mpegtsDeclareID3Streams rewrites, in place, a packet that contains a PMT section,
+// in order to declare the streams with the given PIDs as timed ID3 metadata streams.
+// Since the PMT is generated by mpegts.Writer, its layout is checked explicitly
+// and any mismatch is reported as an error, instead of producing a corrupted PMT.
+func mpegtsDeclareID3Streams(pkt []byte, start int, end int, pids []uint16) error {
+	section := pkt[start:end]
+
+	streams, err := mpegtsPMTStreams(section)
+	if err != nil {
+		return err
+	}
+
+	pointerDesc := mpegtsID3MetadataPointerDescriptor(binary.BigEndian.Uint16(section[3:]))
+
+	ret := make([]byte, 0, mpegtsPacketSize)
+	ret = append(ret, section[:12+int(binary.BigEndian.Uint16(section[10:])&0x0fff)]...)
+	ret = append(ret, pointerDesc...)
+
+	found := make(map[uint16]struct{})
+
+	for _, es := range streams {
+		pid := mpegtsPMTStreamPID(es)
+
+		if slices.Contains(pids, pid) {
+			// streams are written by mpegts.Writer as DVB subtitles
+			if es[0] != mpegtsStreamTypePrivateData {
+				return fmt.Errorf("unexpected stream type of PID %d: 0x%x", pid, es[0])
+			}
+
+			desc := mpegtsID3MetadataDescriptor()
+			ret = append(ret, mpegtsStreamTypeMetadata, es[1], es[2])
+			ret = binary.BigEndian.AppendUint16(ret, 0xf000|uint16(len(desc)))
+			ret = append(ret, desc...)
+			found[pid] = struct{}{}
+		} else {
+			ret = append(ret, es...)
+		}
+	}
+
+	for _, pid := range pids {
+		if _, ok := found[pid]; !ok {
+			return fmt.Errorf("PID %d not found in PMT", pid)
+		}
+	}
+
+	if (start + len(ret) + 4) > mpegtsPacketSize {
+		return fmt.Errorf("PMT is too big")
+	}
+
+	// program_info_length
+	binary.BigEndian.PutUint16(ret[10:],
+		binary.BigEndian.Uint16(ret[10:])+uint16(len(pointerDesc)))
+
+	// section_length
+	binary.BigEndian.PutUint16(ret[1:],
+		(binary.BigEndian.Uint16(ret[1:])&0xf000)|uint16(len(ret)+4-3))
+
+	ret = binary.BigEndian.AppendUint32(ret, mpegtsCRC32(ret))
+
+	copy(pkt[start:], ret)
+
+	for i := start + len(ret); i < mpegtsPacketSize; i++ {
+		pkt[i] = 0xff
+	}
+
+	return nil
+}
+
+// mpegtsID3Writer is placed between mpegts.Writer and the output,
+// and declares timed ID3 metadata tracks in PMTs.
+type mpegtsID3Writer struct {
+	w      io.Writer
+	tracks []*mpegts.Track
+
+	buf    []byte
+	tables mpegtsTableReader
+}
+
+func (w *mpegtsID3Writer) initialize() {
+	w.tables.initialize()
+}
+
+func (w *mpegtsID3Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	n := len(w.buf) - (len(w.buf) % mpegtsPacketSize)
+
+	for i := 0; i < n; i += mpegtsPacketSize {
+		err := w.processPacket(w.buf[i : i+mpegtsPacketSize])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := w.w.Write(w.buf[:n])
+	if err != nil {
+		return 0, err
+	}
+
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+
+	return len(p), nil
+}
+
+func (w *mpegtsID3Writer) processPacket(pkt []byte) error {
+	start, end, err := w.tables.readPacket(pkt)
+	if err != nil || start == 0 {
+		return err
+	}
+
+	pids := make([]uint16, len(w.tracks))
+	for i, track := range w.tracks {
+		pids[i] = track.PID
+	}
+
+	return mpegtsDeclareID3Streams(pkt, start, end, pids)
+}
+
+// mpegtsID3PIDs returns PIDs of timed ID3 metadata streams declared in MPEG-TS data.
+func mpegtsID3PIDs(payload []byte) map[uint16]struct{} {
+	ret := make(map[uint16]struct{})
+
+	// invalid tables are reported by the demuxer
+	mpegtsForEachPMTStream(payload, func(es []byte) { //nolint:errcheck
+		if mpegtsIsID3Stream(es[0], es[5:]) {
+			ret[mpegtsPMTStreamPID(es)] = struct{}{}
+		}
+	})
+
+	return ret
+}
@@ -0,0 +1,107 @@
+package gohlslib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+)
+
+// SAMPLE-AES encryption of MPEG-TS segments, as described in the
+// "MPEG-2 Stream Encryption Format for HTTP Live Streaming" specification.
+
+const (
+	mpegtsStreamTypeH264          = 0x1b
+	mpegtsStreamTypeAAC           = 0x0f
+	mpegtsStreamTypeH264SampleAES = 0xdb
+	mpegtsStreamTypeAACSampleAES  = 0xcf
+
+	// NALUs up to this size are left in clear.
+	sampleAESVideoMinSize = 48
+
+	// bytes at the beginning of each NALU or audio frame that are left in clear.
+	sampleAESVideoClearLeader = 32
+	sampleAESAudioClearLeader = 16
+
+	// maximum bytes that are left in clear after each encrypted video block.
+	sampleAESVideoSkipBytes = 144
+)
+
+// mpegtsUnprotectPMT replaces, in place, stream types of tracks encrypted with SAMPLE-AES
+// with the ones of the original codecs, in order to allow the demuxer to recognize them.
+func mpegtsUnprotectPMT(payload []byte) error {
+	return mpegtsForEachPMTStream(payload, func(es []byte) {
+		switch es[0] {
+		case mpegtsStreamTypeH264SampleAES:
+			es[0] = mpegtsStreamTypeH264
+
+		case mpegtsStreamTypeAACSampleAES:
+			es[0] = mpegtsStreamTypeAAC
+		}
+	})
+}
+
+type sampleAESDecryptor struct {
+	block cipher.Block
+	iv    []byte
+}
+
+func (d *sampleAESDecryptor) decryptH264(au [][]byte) [][]byte {
+	ret := make([][]byte, len(au))
+
+	for i, nalu := range au {
+		ret[i] = d.decryptH264NALU(nalu)
+	}
+
+	return ret
+}
+
+func (d *sampleAESDecryptor) decryptH264NALU(nalu []byte) []byte {
+	if len(nalu) == 0 {
+		return nalu
+	}
+
+	typ := h264.NALUType(nalu[0] & 0x1f)
+	if typ != h264.NALUTypeNonIDR && typ != h264.NALUTypeIDR {
+		return nalu
+	}
+
+	// emulation prevention bytes are inserted after encryption.
+	// Decrypted data already contains the original ones.
+	dec := h264.EmulationPreventionRemove(nalu)
+	if len(dec) <= sampleAESVideoMinSize {
+		return nalu
+	}
+
+	mode := cipher.NewCBCDecrypter(d.block, d.iv)
+	pos := sampleAESVideoClearLeader
+
+	for (len(dec) - pos) > 0 {
+		if (len(dec) - pos) > aes.BlockSize {
+			mode.CryptBlocks(dec[pos:pos+aes.BlockSize], dec[pos:pos+aes.BlockSize])
+			pos += aes.BlockSize
+		}
+		pos += min(sampleAESVideoSkipBytes, len(dec)-pos)
+	}
+
+	return dec
+}
+
+func (d *sampleAESDecryptor) decryptMPEG4Audio(aus [][]byte) [][]byte {
+	ret := make([][]byte, len(aus))
+
+	for i, au := range aus {
+		if len(au) <= sampleAESAudioClearLeader {
+			ret[i] = au
+			continue
+		}
+
+		dec := append([]byte(nil), au...)
+		enc := dec[sampleAESAudioClearLeader:]
+		l := len(enc) - len(enc)%aes.BlockSize
+		cipher.NewCBCDecrypter(d.block, d.iv).CryptBlocks(enc[:l], enc[:l])
+		ret[i] = dec
+	}
+
+	return ret
+}
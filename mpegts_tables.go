@@ -0,0 +1,198 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PATs and PMTs of MPEG-TS streams are read or edited directly in some cases,
+// since they contain information that is not exposed by mpegts.Reader and mpegts.Writer.
+
+const (
+	mpegtsPacketSize = 188
+
+	mpegtsTableIDPAT = 0x00
+	mpegtsTableIDPMT = 0x02
+)
+
+func mpegtsCRC32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if (crc & 0x80000000) != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// mpegtsSection returns the position of the section that starts in a packet,
+// excluding the CRC, or zero if the packet does not start a section.
+func mpegtsSection(pkt []byte) (int, int, error) {
+	if (pkt[1] & 0x40) == 0 { // payload_unit_start_indicator
+		return 0, 0, nil
+	}
+
+	pos := 4
+
+	adaptationFieldControl := (pkt[3] >> 4) & 0x03
+	if (adaptationFieldControl & 0x02) != 0 {
+		pos += 1 + int(pkt[4])
+	}
+	if (adaptationFieldControl&0x01) == 0 || pos >= mpegtsPacketSize {
+		return 0, 0, nil
+	}
+
+	pos += 1 + int(pkt[pos]) // pointer_field
+	if (pos + 3) > mpegtsPacketSize {
+		return 0, 0, fmt.Errorf("invalid section")
+	}
+
+	sectionLength := int(binary.BigEndian.Uint16(pkt[pos+1:]) & 0x0fff)
+	if sectionLength < 4 || (pos+3+sectionLength) > mpegtsPacketSize {
+		return 0, 0, fmt.Errorf("sections that span multiple packets are not supported")
+	}
+
+	return pos, pos + 3 + sectionLength - 4, nil
+}
+
+// mpegtsTableSection returns the position of the PAT or PMT section that starts in a packet,
+// excluding the CRC, or zero if the packet does not start a section.
+// Table ID, length and CRC of the section are checked.
+func mpegtsTableSection(pkt []byte, tableID uint8) (int, int, error) {
+	start, end, err := mpegtsSection(pkt)
+	if err != nil || start == 0 {
+		return 0, 0, err
+	}
+
+	name, minLength := "PAT", 8
+	if tableID == mpegtsTableIDPMT {
+		name, minLength = "PMT", 12
+	}
+
+	section := pkt[start:end]
+	if len(section) < minLength || section[0] != tableID {
+		return 0, 0, fmt.Errorf("invalid %s table ID or length", name)
+	}
+
+	if mpegtsCRC32(section) != binary.BigEndian.Uint32(pkt[end:]) {
+		return 0, 0, fmt.Errorf("%s CRC mismatch", name)
+	}
+
+	return start, end, nil
+}
+
+// mpegtsPMTStreams returns the elementary stream entries of a PMT section, excluding the CRC.
+// Each entry contains stream_type, elementary_PID, ES_info_length and descriptors,
+// and can be edited in place.
+func mpegtsPMTStreams(section []byte) ([][]byte, error) {
+	pos := 12 + int(binary.BigEndian.Uint16(section[10:])&0x0fff)
+	if pos > len(section) {
+		return nil, fmt.Errorf("invalid PMT program info length")
+	}
+
+	var ret [][]byte
+
+	for pos != len(section) {
+		if (len(section) - pos) < 5 {
+			return nil, fmt.Errorf("PMT section length does not match elementary streams")
+		}
+
+		esEnd := pos + 5 + int(binary.BigEndian.Uint16(section[pos+3:])&0x0fff)
+		if esEnd > len(section) {
+			return nil, fmt.Errorf("PMT section length does not match elementary streams")
+		}
+
+		ret = append(ret, section[pos:esEnd])
+		pos = esEnd
+	}
+
+	return ret, nil
+}
+
+// mpegtsPMTStreamPID returns the PID of an elementary stream entry of a PMT.
+func mpegtsPMTStreamPID(es []byte) uint16 {
+	return binary.BigEndian.Uint16(es[1:]) & 0x1fff
+}
+
+// mpegtsTableReader reads PATs in order to find PMTs.
+type mpegtsTableReader struct {
+	pmtPIDs map[uint16]struct{}
+}
+
+func (r *mpegtsTableReader) initialize() {
+	r.pmtPIDs = make(map[uint16]struct{})
+}
+
+// readPacket reads a packet and returns the position of the PMT section that starts in it,
+// excluding the CRC, or zero if the packet does not start a PMT.
+func (r *mpegtsTableReader) readPacket(pkt []byte) (int, int, error) {
+	pid := binary.BigEndian.Uint16(pkt[1:]) & 0x1fff
+
+	if pid == 0 {
+		start, end, err := mpegtsTableSection(pkt, mpegtsTableIDPAT)
+		if err != nil || start == 0 {
+			return 0, 0, err
+		}
+
+		section := pkt[start:end]
+
+		for pos := 8; (len(section) - pos) >= 4; pos += 4 {
+			programNumber := binary.BigEndian.Uint16(section[pos:])
+			if programNumber != 0 {
+				r.pmtPIDs[binary.BigEndian.Uint16(section[pos+2:])&0x1fff] = struct{}{}
+			}
+		}
+
+		return 0, 0, nil
+	}
+
+	if _, ok := r.pmtPIDs[pid]; !ok {
+		return 0, 0, nil
+	}
+
+	return mpegtsTableSection(pkt, mpegtsTableIDPMT)
+}
+
+// mpegtsForEachPMTStream calls cb for each elementary stream entry of the PMTs contained in MPEG-TS data.
+// Entries can be edited in place by cb, in which case the CRC of the PMT is updated.
+func mpegtsForEachPMTStream(payload []byte, cb func(es []byte)) error {
+	var r mpegtsTableReader
+	r.initialize()
+
+	for i := 0; (len(payload) - i) >= mpegtsPacketSize; i += mpegtsPacketSize {
+		pkt := payload[i : i+mpegtsPacketSize]
+		if pkt[0] != 0x47 {
+			return fmt.Errorf("invalid sync byte")
+		}
+
+		start, end, err := r.readPacket(pkt)
+		if err != nil {
+			return err
+		}
+		if start == 0 {
+			continue
+		}
+
+		section := pkt[start:end]
+
+		streams, err := mpegtsPMTStreams(section)
+		if err != nil {
+			return err
+		}
+
+		for _, es := range streams {
+			cb(es)
+		}
+
+		if crc := mpegtsCRC32(section); crc != binary.BigEndian.Uint32(pkt[end:]) {
+			binary.BigEndian.PutUint32(pkt[end:], crc)
+		}
+	}
+
+	return nil
+}
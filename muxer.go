@@ -8,8 +8,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -94,6 +93,16 @@ func areAllAudio(tracks []*muxerTrack) bool {
 	return true
 }
 
+func isSubtitle(c codecs.Codec) bool {
+	_, ok := c.(*codecs.WebVTT)
+	return ok
+}
+
+func isID3(c codecs.Codec) bool {
+	_, ok := c.(*codecs.ID3)
+	return ok
+}
+
 // a prefix is needed to prevent usage of cached segments
 // from previous muxing sessions.
 func generatePrefix() (string, error) {
@@ -131,17 +140,41 @@ func codecRequiredClockRate(c codecs.Codec) int {
 	case *codecs.H264:
 		return 90000
 
+	case *codecs.MPEG4Video:
+		return 90000
+
+	case *codecs.MPEG1Video:
+		return 90000
+
 	case *codecs.Opus:
 		return 48000
 
 	case *codecs.MPEG4Audio:
 		return codec.Config.SampleRate
 
+	case *codecs.MPEG4AudioLATM:
+		return 90000
+
+	case *codecs.MPEG1Audio:
+		return codec.SampleRate
+
 	case *codecs.FLAC:
 		return int(codec.StreamInfo.SampleRate)
 
+	case *codecs.AC3:
+		return codec.SampleRate
+
+	case *codecs.EAC3:
+		return codec.SampleRate
+
 	case *codecs.KLV:
 		return 90000
+
+	case *codecs.ID3:
+		return 90000
+
+	case *codecs.WebVTT:
+		return 1000
 	}
 
 	return 0
@@ -164,6 +197,15 @@ type Muxer struct {
 	// parameters (all optional except Tracks).
 	//
 	// tracks.
+	// With the fMP4 and Low-Latency variants, each video track is published
+	// as a separate variant of the multivariant playlist, while audio tracks
+	// are published as renditions shared by all variants.
+	// WebVTT tracks are published as subtitle renditions, with segments
+	// aligned to the ones of the other tracks.
+	// ID3 tracks are embedded into segments of the leading track.
+	// The first video track drives segmentation of all the others, therefore
+	// random access frames of all video tracks must be aligned, otherwise
+	// writing a sample to a video track returns an error.
 	Tracks []*Track
 	// Variant to use.
 	// It defaults to MuxerVariantLowLatency
@@ -193,6 +235,31 @@ type Muxer struct {
 	// - offload segments from RAM to disk
 	// - produce self-contained folders to pass to a CDN (only in case of non low-latency)
 	Directory string
+	// Storage of segments and parts.
+	// This can be used to back segments with custom implementations.
+	// It defaults to a RAM-backed factory, or to a disk-backed one when Directory is set.
+	// When the factory implements storage.Publisher, playlists and init files
+	// are published through it too (only in case of non low-latency).
+	// When the factory implements storage.Closer, it must be closed by the caller after the Muxer.
+	StorageFactory storage.Factory
+	// Encryption settings.
+	// When present, segments and parts are encrypted and
+	// EXT-X-KEY tags are added to media playlists.
+	Encryption *MuxerEncryption
+	// Closed captions.
+	// When present, captions passed to WriteClosedCaptions() are embedded
+	// into H264 and H265 video tracks, and are advertised as
+	// CLOSED-CAPTIONS renditions in the multivariant playlist.
+	ClosedCaptions []*MuxerClosedCaptions
+	// Session data.
+	// When present, entries are published through EXT-X-SESSION-DATA tags
+	// of the multivariant playlist.
+	SessionData []*MuxerSessionData
+	// Content steering.
+	// When present, variants are announced once for each pathway (CDN)
+	// and a steering manifest is served, whose pathway priority
+	// can be changed with SetPathwayPriority().
+	ContentSteering *MuxerContentSteering
 
 	//
 	// callbacks (all optional)
@@ -209,9 +276,15 @@ type Muxer struct {
 	mtracks        []*muxerTrack
 	mtracksByTrack map[*Track]*muxerTrack
 	streams        []*muxerStream
+	webVTTStreams  []*muxerStreamWebVTT
 	leadingStream  *muxerStream
 	prefix         string
-	storageFactory storage.Factory
+	publisher      storage.Publisher
+	keyring        *muxerKeyring
+	captionWriter  *muxerCaptionWriter
+	sessionData    *muxerSessionDataWriter
+	steering       *muxerContentSteering
+	dateRanges     *muxerDateRanges
 	segmenter      *muxerSegmenter
 	server         *muxerServer
 	closed         bool
@@ -251,6 +324,13 @@ func (m *Muxer) Start() error {
 		if track.ClockRate != requiredClockRate {
 			return fmt.Errorf("track %d requires clock rate %d, but is %d", i, requiredClockRate, track.ClockRate)
 		}
+
+		// layer is needed to fill the CODECS attribute.
+		// When it is not provided, it is read from the first frame.
+		if codec, ok := track.Codec.(*codecs.MPEG1Audio); ok && codec.Layer != 0 &&
+			codec.Layer != 2 && codec.Layer != 3 {
+			return fmt.Errorf("track %d has an unsupported MPEG-1 Audio layer: %d", i, codec.Layer)
+		}
 	}
 
 	hasVideo := false
@@ -262,18 +342,27 @@ func (m *Muxer) Start() error {
 				if hasVideo {
 					return fmt.Errorf("the MPEG-TS variant of HLS supports a single video track only")
 				}
-				if _, ok := track.Codec.(*codecs.H264); !ok {
+				switch track.Codec.(type) {
+				case *codecs.H265, *codecs.H264, *codecs.MPEG4Video, *codecs.MPEG1Video:
+				default:
 					return fmt.Errorf(
-						"the MPEG-TS variant of HLS supports H264 video only")
+						"the MPEG-TS variant of HLS supports H265, H264, MPEG-4 Video and MPEG-1/2 Video only")
 				}
 				hasVideo = true
-			} else if _, isKLV := track.Codec.(*codecs.KLV); !isKLV {
+			} else if _, isKLV := track.Codec.(*codecs.KLV); !isKLV && !isSubtitle(track.Codec) && !isID3(track.Codec) {
 				if hasAudio {
 					return fmt.Errorf("the MPEG-TS variant of HLS supports a single audio track only")
 				}
-				if _, ok := track.Codec.(*codecs.MPEG4Audio); !ok {
+				switch codec := track.Codec.(type) {
+				case *codecs.MPEG4Audio, *codecs.MPEG4AudioLATM, *codecs.MPEG1Audio, *codecs.AC3, *codecs.EAC3:
+				case *codecs.Opus:
+					if codec.ChannelCount > 8 {
+						return fmt.Errorf(
+							"the MPEG-TS variant of HLS supports Opus with up to 8 channels only")
+					}
+				default:
 					return fmt.Errorf(
-						"the MPEG-TS variant of HLS supports MPEG-4 Audio only")
+						"the MPEG-TS variant of HLS supports MPEG-4 Audio, MPEG-4 Audio LATM, MPEG-1 Audio, Opus, AC-3 and E-AC-3 only")
 				}
 				hasAudio = true
 			}
@@ -289,30 +378,57 @@ func (m *Muxer) Start() error {
 		}
 	} else {
 		for _, track := range m.Tracks {
-			if track.Codec.IsVideo() {
-				if hasVideo {
-					return fmt.Errorf("only one video track is currently supported")
-				}
-				hasVideo = true
-			} else if _, ok := track.Codec.(*codecs.KLV); ok {
+			switch track.Codec.(type) {
+			case *codecs.KLV:
 				return fmt.Errorf("KLV tracks are only supported with the MPEG-TS muxer variant")
-			} else {
-				hasAudio = true //nolint:ineffassign,wastedassign
+
+			case *codecs.MPEG4Video, *codecs.MPEG1Video, *codecs.MPEG4AudioLATM:
+				return fmt.Errorf("MPEG-4 Video, MPEG-1/2 Video and MPEG-4 Audio LATM tracks " +
+					"are only supported with the MPEG-TS muxer variant")
 			}
 		}
 	}
 
+	hasMedia := false
 	hasDefaultAudio := false
+	hasDefaultSubtitle := false
+	hasID3 := false
 
 	for _, track := range m.Tracks {
-		if !track.Codec.IsVideo() && track.IsDefault {
+		switch {
+		case isID3(track.Codec):
+			if hasID3 {
+				return fmt.Errorf("multiple ID3 tracks are not supported")
+			}
+			hasID3 = true
+
+		case isSubtitle(track.Codec):
+			if track.IsDefault {
+				if hasDefaultSubtitle {
+					return fmt.Errorf("multiple default subtitle tracks are not supported")
+				}
+				hasDefaultSubtitle = true
+			}
+
+		case !track.Codec.IsVideo() && track.IsDefault:
 			if hasDefaultAudio {
 				return fmt.Errorf("multiple default audio tracks are not supported")
 			}
 			hasDefaultAudio = true
+			hasMedia = true
+
+		default:
+			hasMedia = true
 		}
 	}
 
+	if !hasMedia {
+		if hasID3 {
+			return fmt.Errorf("ID3 tracks require at least one video or audio track")
+		}
+		return fmt.Errorf("subtitle tracks require at least one video or audio track")
+	}
+
 	switch m.Variant {
 	case MuxerVariantLowLatency:
 		if m.SegmentCount < 7 {
@@ -325,13 +441,22 @@ func (m *Muxer) Start() error {
 		}
 	}
 
+	if m.Encryption != nil {
+		err := m.checkEncryption()
+		if err != nil {
+			return err
+		}
+	}
+
 	m.cond = sync.NewCond(&m.mutex)
 	m.mtracksByTrack = make(map[*Track]*muxerTrack)
+	m.dateRanges = &muxerDateRanges{}
 
 	m.segmenter = &muxerSegmenter{
 		variant:            m.Variant,
 		segmentMinDuration: m.SegmentMinDuration,
 		partMinDuration:    m.PartMinDuration,
+		dateRanges:         m.dateRanges,
 		parent:             m,
 	}
 	m.segmenter.initialize()
@@ -342,7 +467,7 @@ func (m *Muxer) Start() error {
 	m.server.registerPath("index.m3u8", m.handleMultivariantPlaylist)
 
 	// Find the leading track index
-	// Video tracks are preferred; if no video, use the first non-KLV track
+	// Video tracks are preferred; if no video, use the first non-KLV, non-subtitle, non-ID3 track
 	leadingTrackIndex := -1
 	for i, track := range m.Tracks {
 		if track.Codec.IsVideo() {
@@ -350,11 +475,15 @@ func (m *Muxer) Start() error {
 			break
 		}
 		_, isKLV := track.Codec.(*codecs.KLV)
-		if leadingTrackIndex == -1 && !isKLV {
+		if leadingTrackIndex == -1 && !isKLV && !isSubtitle(track.Codec) && !isID3(track.Codec) {
 			leadingTrackIndex = i
 		}
 	}
 
+	var mediaTracks []*muxerTrack
+	var subtitleTracks []*muxerTrack
+	var emsgTracks []*muxerTrack
+
 	for i, track := range m.Tracks {
 		mtrack := &muxerTrack{
 			Track:     track,
@@ -364,6 +493,29 @@ func (m *Muxer) Start() error {
 		mtrack.initialize()
 		m.mtracks = append(m.mtracks, mtrack)
 		m.mtracksByTrack[track] = mtrack
+
+		switch {
+		case isSubtitle(track.Codec):
+			subtitleTracks = append(subtitleTracks, mtrack)
+
+		// with fMP4, ID3 tags are carried by Event Message boxes of the leading stream
+		case isID3(track.Codec) && m.Variant != MuxerVariantMPEGTS:
+			emsgTracks = append(emsgTracks, mtrack)
+
+		default:
+			mediaTracks = append(mediaTracks, mtrack)
+		}
+	}
+
+	if len(m.ClosedCaptions) != 0 {
+		m.captionWriter = &muxerCaptionWriter{
+			closedCaptions: m.ClosedCaptions,
+			tracks:         m.mtracks,
+		}
+		err := m.captionWriter.initialize()
+		if err != nil {
+			return err
+		}
 	}
 
 	var err error
@@ -372,10 +524,61 @@ func (m *Muxer) Start() error {
 		return err
 	}
 
-	if m.Directory != "" {
-		m.storageFactory = storage.NewFactoryDisk(m.Directory)
-	} else {
-		m.storageFactory = storage.NewFactoryRAM()
+	if m.StorageFactory == nil {
+		if m.Directory != "" {
+			m.StorageFactory = storage.NewFactoryDisk(m.Directory)
+		} else {
+			m.StorageFactory = storage.NewFactoryRAM()
+		}
+	}
+
+	if m.Variant != MuxerVariantLowLatency {
+		m.publisher, _ = m.StorageFactory.(storage.Publisher)
+	}
+
+	if m.SessionData != nil {
+		m.sessionData = &muxerSessionDataWriter{
+			entries:   m.SessionData,
+			variant:   m.Variant,
+			prefix:    m.prefix,
+			directory: m.Directory,
+			publisher: m.publisher,
+			server:    m.server,
+		}
+		err = m.sessionData.initialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.ContentSteering != nil {
+		m.steering = &muxerContentSteering{
+			settings:  m.ContentSteering,
+			variant:   m.Variant,
+			prefix:    m.prefix,
+			directory: m.Directory,
+			publisher: m.publisher,
+			server:    m.server,
+		}
+		err = m.steering.initialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Encryption != nil {
+		m.keyring = &muxerKeyring{
+			encryption: m.Encryption,
+			variant:    m.Variant,
+			prefix:     m.prefix,
+			directory:  m.Directory,
+			publisher:  m.publisher,
+			server:     m.server,
+		}
+		err = m.keyring.initialize()
+		if err != nil {
+			return err
+		}
 	}
 
 	// add initial gaps, required by iOS LL-HLS
@@ -388,6 +591,7 @@ func (m *Muxer) Start() error {
 	case MuxerVariantMPEGTS:
 		stream := &muxerStream{
 			isLeading:      true,
+			isVariant:      true,
 			variant:        m.Variant,
 			segmentMaxSize: m.SegmentMaxSize,
 			segmentCount:   m.SegmentCount,
@@ -395,10 +599,13 @@ func (m *Muxer) Start() error {
 			mutex:          &m.mutex,
 			cond:           m.cond,
 			prefix:         m.prefix,
-			storageFactory: m.storageFactory,
+			storageFactory: m.StorageFactory,
+			keyring:        m.keyring,
+			dateRanges:     m.dateRanges,
 			directory:      m.Directory,
+			publisher:      m.publisher,
 			server:         m.server,
-			tracks:         m.mtracks,
+			tracks:         mediaTracks,
 			id:             "main",
 			nextSegmentID:  nextSegmentID,
 		}
@@ -412,6 +619,10 @@ func (m *Muxer) Start() error {
 		defaultAudioChosen := false
 
 		for i, track := range m.mtracks {
+			if isSubtitle(track.Codec) || isID3(track.Codec) {
+				continue
+			}
+
 			var id string
 			if track.Codec.IsVideo() {
 				id = "video" + strconv.FormatInt(int64(i+1), 10)
@@ -419,7 +630,10 @@ func (m *Muxer) Start() error {
 				id = "audio" + strconv.FormatInt(int64(i+1), 10)
 			}
 
-			isRendition := !track.isLeading || (!track.Codec.IsVideo() && len(m.Tracks) > 1)
+			// each video track is a variant, while audio tracks are renditions
+			// shared between all variants.
+			isVariant := track.isLeading || track.Codec.IsVideo()
+			isRendition := !track.Codec.IsVideo() && (!track.isLeading || len(mediaTracks) > 1)
 			isDefault := false
 			name := ""
 
@@ -448,12 +662,16 @@ func (m *Muxer) Start() error {
 				mutex:          &m.mutex,
 				cond:           m.cond,
 				prefix:         m.prefix,
-				storageFactory: m.storageFactory,
+				storageFactory: m.StorageFactory,
+				keyring:        m.keyring,
+				dateRanges:     m.dateRanges,
 				directory:      m.Directory,
+				publisher:      m.publisher,
 				server:         m.server,
 				tracks:         []*muxerTrack{track},
 				id:             id,
 				isLeading:      track.isLeading,
+				isVariant:      isVariant,
 				isRendition:    isRendition,
 				name:           name,
 				language:       track.Language,
@@ -466,6 +684,54 @@ func (m *Muxer) Start() error {
 			}
 			m.streams = append(m.streams, stream)
 		}
+
+		// in Low-Latency HLS, each variant reports the state of the other ones,
+		// in order to allow clients to switch between them.
+		if m.Variant == MuxerVariantLowLatency {
+			for _, stream := range m.streams {
+				if stream.isVariant {
+					for _, other := range m.streams {
+						if other != stream && other.isVariant {
+							stream.reportedStreams = append(stream.reportedStreams, other)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var timestampOffset time.Duration
+	if m.Variant != MuxerVariantMPEGTS {
+		timestampOffset = fmp4StartDTS
+	}
+
+	for _, track := range subtitleTracks {
+		id := "subtitles" + strconv.FormatInt(int64(slices.Index(m.mtracks, track)+1), 10)
+
+		name := track.Name
+		if name == "" {
+			name = id
+		}
+
+		stream := &muxerStreamWebVTT{
+			variant:         m.Variant,
+			segmentCount:    m.SegmentCount,
+			mutex:           &m.mutex,
+			cond:            m.cond,
+			prefix:          m.prefix,
+			storageFactory:  m.StorageFactory,
+			server:          m.server,
+			track:           track,
+			id:              id,
+			name:            name,
+			language:        track.Language,
+			isDefault:       track.IsDefault,
+			isForced:        track.IsForced,
+			nextSegmentID:   nextSegmentID,
+			timestampOffset: timestampOffset,
+		}
+		stream.initialize()
+		m.webVTTStreams = append(m.webVTTStreams, stream)
 	}
 
 	m.leadingStream = func() *muxerStream {
@@ -477,6 +743,45 @@ func (m *Muxer) Start() error {
 		return nil
 	}()
 
+	for _, track := range emsgTracks {
+		track.stream = m.leadingStream
+	}
+
+	return nil
+}
+
+func (m *Muxer) checkEncryption() error {
+	switch m.Encryption.Method {
+	case MuxerEncryptionMethodAES128:
+		// parts cannot be decrypted independently from the rest of the segment
+		if m.Variant == MuxerVariantLowLatency {
+			return fmt.Errorf("AES-128 encryption is not supported by the Low-Latency variant, use SAMPLE-AES")
+		}
+
+	case MuxerEncryptionMethodSampleAES:
+		if m.Variant == MuxerVariantMPEGTS {
+			return fmt.Errorf("SAMPLE-AES encryption is not supported by the MPEG-TS variant")
+		}
+
+		for i, track := range m.Tracks {
+			switch track.Codec.(type) {
+			case *codecs.AV1, *codecs.VP9:
+				return fmt.Errorf("track %d: SAMPLE-AES encryption is not supported with this codec", i)
+			}
+		}
+
+	default:
+		return fmt.Errorf("invalid encryption method")
+	}
+
+	if m.Encryption.KeyRotationInterval < 0 {
+		return fmt.Errorf("invalid key rotation interval")
+	}
+
+	if m.Encryption.KeyProvider == nil {
+		m.Encryption.KeyProvider = muxerKeyProviderRandom{}
+	}
+
 	return nil
 }
 
@@ -490,6 +795,10 @@ func (m *Muxer) Close() {
 		stream.close()
 	}
 
+	for _, stream := range m.webVTTStreams {
+		stream.close()
+	}
+
 	m.mutex.Unlock()
 
 	m.cond.Broadcast()
@@ -535,6 +844,32 @@ func (m *Muxer) WriteH264(
 	return m.segmenter.writeH264(m.mtracksByTrack[track], ntp, pts, au)
 }
 
+// WriteMPEG4Video writes a MPEG-4 Video frame.
+func (m *Muxer) WriteMPEG4Video(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	if _, ok := track.Codec.(*codecs.MPEG4Video); !ok {
+		return fmt.Errorf("WriteMPEG4Video called with a non-MPEG-4 Video track")
+	}
+	return m.segmenter.writeMPEGxVideo(m.mtracksByTrack[track], ntp, pts, frame)
+}
+
+// WriteMPEG1Video writes a MPEG-1/2 Video frame.
+func (m *Muxer) WriteMPEG1Video(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	if _, ok := track.Codec.(*codecs.MPEG1Video); !ok {
+		return fmt.Errorf("WriteMPEG1Video called with a non-MPEG-1/2 Video track")
+	}
+	return m.segmenter.writeMPEGxVideo(m.mtracksByTrack[track], ntp, pts, frame)
+}
+
 // WriteOpus writes Opus packets.
 func (m *Muxer) WriteOpus(
 	track *Track,
@@ -555,6 +890,32 @@ func (m *Muxer) WriteMPEG4Audio(
 	return m.segmenter.writeMPEG4Audio(m.mtracksByTrack[track], ntp, pts, aus)
 }
 
+// WriteMPEG4AudioLATM writes MPEG-4 Audio LATM audioMuxElements.
+func (m *Muxer) WriteMPEG4AudioLATM(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	els [][]byte,
+) error {
+	if _, ok := track.Codec.(*codecs.MPEG4AudioLATM); !ok {
+		return fmt.Errorf("WriteMPEG4AudioLATM called with a non-MPEG-4 Audio LATM track")
+	}
+	return m.segmenter.writeMPEG4AudioLATM(m.mtracksByTrack[track], ntp, pts, els)
+}
+
+// WriteMPEG1Audio writes MPEG-1 Audio frames.
+func (m *Muxer) WriteMPEG1Audio(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frames [][]byte,
+) error {
+	if _, ok := track.Codec.(*codecs.MPEG1Audio); !ok {
+		return fmt.Errorf("WriteMPEG1Audio called with a non-MPEG-1 Audio track")
+	}
+	return m.segmenter.writeMPEG1Audio(m.mtracksByTrack[track], ntp, pts, frames)
+}
+
 // WriteFLAC writes a FLAC audio frame.
 func (m *Muxer) WriteFLAC(
 	track *Track,
@@ -565,6 +926,26 @@ func (m *Muxer) WriteFLAC(
 	return m.segmenter.writeFLAC(m.mtracksByTrack[track], ntp, pts, frame)
 }
 
+// WriteAC3 writes an AC-3 frame.
+func (m *Muxer) WriteAC3(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	return m.segmenter.writeAC3(m.mtracksByTrack[track], ntp, pts, frame)
+}
+
+// WriteEAC3 writes an E-AC-3 frame.
+func (m *Muxer) WriteEAC3(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	return m.segmenter.writeEAC3(m.mtracksByTrack[track], ntp, pts, frame)
+}
+
 // WriteKLV writes KLV data.
 func (m *Muxer) WriteKLV(
 	track *Track,
@@ -584,6 +965,82 @@ func (m *Muxer) WriteKLV(
 	return m.segmenter.writeKLV(m.mtracksByTrack[track], ntp, pts, data)
 }
 
+// WriteID3 writes an ID3v2 tag.
+func (m *Muxer) WriteID3(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	tag []byte,
+) error {
+	if !isID3(track.Codec) {
+		return fmt.Errorf("WriteID3 called with a non-ID3 track")
+	}
+
+	return m.segmenter.writeID3(m.mtracksByTrack[track], ntp, pts, tag)
+}
+
+// WriteSubtitle writes a WebVTT cue.
+// Start and end are expressed in clock rate units, and share the time base of other tracks.
+func (m *Muxer) WriteSubtitle(
+	track *Track,
+	start int64,
+	end int64,
+	text string,
+) error {
+	if !isSubtitle(track.Codec) {
+		return fmt.Errorf("WriteSubtitle called with a non-WebVTT track")
+	}
+
+	return m.segmenter.writeWebVTT(m.mtracksByTrack[track], start, end, text)
+}
+
+// WriteClosedCaptions writes the text of a closed captions rendition.
+// Text is embedded into the access units of H264 and H265 tracks that
+// have a PTS equal or greater than the given one (expressed in 90kHz units),
+// and is displayed until the next call. An empty text clears captions.
+func (m *Muxer) WriteClosedCaptions(
+	cc *MuxerClosedCaptions,
+	pts int64,
+	text string,
+) error {
+	if m.captionWriter == nil {
+		return fmt.Errorf("closed captions are not enabled")
+	}
+
+	return m.captionWriter.writeText(cc, pts, text)
+}
+
+// WriteClosedCaptionsData writes raw closed caption data, made of cc_data() constructs
+// (3 bytes each, as described in CEA-708), that are embedded unchanged into the
+// access units of H264 and H265 tracks that have a PTS equal or greater than the given one.
+func (m *Muxer) WriteClosedCaptionsData(
+	pts int64,
+	data []byte,
+) error {
+	if m.captionWriter == nil {
+		return fmt.Errorf("closed captions are not enabled")
+	}
+
+	return m.captionWriter.writeData(pts, data)
+}
+
+// WriteDateRange writes a date range, that is announced by media playlists
+// as long as segments it refers to are available.
+// Writing again a date range with the same ID updates it.
+func (m *Muxer) WriteDateRange(dr *MuxerDateRange) error {
+	return m.dateRanges.write(dr)
+}
+
+// SetPathwayPriority sets the pathway priority announced by the steering manifest.
+// Pathways are identified by their IDs, and pathways that are not listed are not used by players.
+func (m *Muxer) SetPathwayPriority(priority []string) error {
+	if m.steering == nil {
+		return fmt.Errorf("content steering is not enabled")
+	}
+
+	return m.steering.setPriority(priority)
+}
+
 // Handle handles a HTTP request.
 // This can be safely called in parallel with Write*() and Close() methods.
 func (m *Muxer) Handle(w http.ResponseWriter, r *http.Request) {
@@ -598,6 +1055,13 @@ func (m *Muxer) createFirstSegment(nextDTS time.Duration, nextNTP time.Time) err
 		}
 	}
 
+	for _, stream := range m.webVTTStreams {
+		err := stream.createFirstSegment(nextDTS, nextNTP)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -622,7 +1086,8 @@ func (m *Muxer) rotatePartsInner(nextDTS time.Duration) error {
 	}
 
 	for _, stream := range m.streams {
-		if !stream.isLeading {
+		// parts of video variants are switched together with segments
+		if !stream.isLeading && stream.pendingBoundary == nil {
 			err = stream.rotateParts(nextDTS, true)
 			if err != nil {
 				return err
@@ -662,6 +1127,22 @@ func (m *Muxer) rotateSegmentsInner(
 
 	for _, stream := range m.streams {
 		if !stream.isLeading {
+			if stream.waitsForRandomAccess() {
+				// the previous boundary has not been reached, apply it now
+				if b := stream.pendingBoundary; b != nil {
+					err = m.rotateVariantSegmentsInner(stream, b.dts, b.ntp)
+					if err != nil {
+						return err
+					}
+				}
+
+				stream.pendingBoundary = &muxerSegmentBoundary{
+					dts: nextDTS,
+					ntp: nextNTP,
+				}
+				continue
+			}
+
 			err = stream.rotateSegments(nextDTS, nextNTP)
 			if err != nil {
 				return err
@@ -671,7 +1152,28 @@ func (m *Muxer) rotateSegmentsInner(
 		}
 	}
 
-	if m.Directory != "" && m.Variant != MuxerVariantLowLatency {
+	for _, stream := range m.webVTTStreams {
+		err = stream.rotateSegments(nextDTS, nextNTP)
+		if err != nil {
+			return err
+		}
+		stream.targetDuration = m.leadingStream.targetDuration
+	}
+
+	if m.keyring != nil {
+		m.keyring.prune(m.leadingStream.nextSegmentID - uint64(len(m.leadingStream.segments)))
+	}
+
+	// keep date ranges for an additional skip boundary,
+	// in order to report them as recently removed in delta updates.
+	for _, seg := range m.leadingStream.segments {
+		if start, ok := segmentStartNTP(seg); ok {
+			m.dateRanges.prune(start.Add(-time.Duration(m.leadingStream.targetDuration) * 6 * time.Second))
+			break
+		}
+	}
+
+	if (m.Directory != "" || m.publisher != nil) && m.Variant != MuxerVariantLowLatency {
 		err = m.savePlaylists()
 		if err != nil {
 			return err
@@ -681,14 +1183,76 @@ func (m *Muxer) rotateSegmentsInner(
 	return nil
 }
 
+// rotateVariantSegments switches segments of a video variant,
+// when the variant receives a random access sample after the leading stream has switched segments.
+func (m *Muxer) rotateVariantSegments(
+	stream *muxerStream,
+	nextDTS time.Duration,
+	nextNTP time.Time,
+) error {
+	m.mutex.Lock()
+	err := m.rotateVariantSegmentsInner(stream, nextDTS, nextNTP)
+	m.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	m.cond.Broadcast()
+
+	return nil
+}
+
+func (m *Muxer) rotateVariantSegmentsInner(
+	stream *muxerStream,
+	nextDTS time.Duration,
+	nextNTP time.Time,
+) error {
+	stream.pendingBoundary = nil
+
+	err := stream.rotateSegments(nextDTS, nextNTP)
+	if err != nil {
+		return err
+	}
+	stream.targetDuration = m.leadingStream.targetDuration
+	stream.partTargetDuration = m.leadingStream.partTargetDuration
+
+	if (m.Directory != "" || m.publisher != nil) && m.Variant != MuxerVariantLowLatency {
+		var byts []byte
+		byts, err = stream.generateMediaPlaylist(false, false, "")
+		if err != nil {
+			return err
+		}
+
+		err = saveFile(m.Directory, m.publisher, mediaPlaylistPath(stream.id), byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *Muxer) savePlaylists() error {
 	for _, stream := range m.streams {
-		byts, err := stream.generateMediaPlaylist(false, "")
+		byts, err := stream.generateMediaPlaylist(false, false, "")
+		if err != nil {
+			return err
+		}
+
+		err = saveFile(m.Directory, m.publisher, mediaPlaylistPath(stream.id), byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, stream := range m.webVTTStreams {
+		byts, err := stream.generateMediaPlaylist("")
 		if err != nil {
 			return err
 		}
 
-		err = os.WriteFile(filepath.Join(m.Directory, mediaPlaylistPath(stream.id)), byts, 0o644)
+		err = saveFile(m.Directory, m.publisher, mediaPlaylistPath(stream.id), byts)
 		if err != nil {
 			return err
 		}
@@ -699,7 +1263,7 @@ func (m *Muxer) savePlaylists() error {
 		return err
 	}
 
-	return os.WriteFile(filepath.Join(m.Directory, "index.m3u8"), byts, 0o644)
+	return saveFile(m.Directory, m.publisher, "index.m3u8", byts)
 }
 
 func (m *Muxer) handleMultivariantPlaylist(w http.ResponseWriter, r *http.Request) {
@@ -741,9 +1305,6 @@ func (m *Muxer) handleMultivariantPlaylist(w http.ResponseWriter, r *http.Reques
 }
 
 func (m *Muxer) generateMultivariantPlaylist(rawQuery string) ([]byte, error) {
-	// TODO: consider segments in all streams
-	maxBandwidth, averageBandwidth := bandwidth(m.streams[0].segments)
-
 	pl := &playlist.Multivariant{
 		Version: func() int {
 			if m.Variant == MuxerVariantMPEGTS {
@@ -752,18 +1313,53 @@ func (m *Muxer) generateMultivariantPlaylist(rawQuery string) ([]byte, error) {
 			return 10
 		}(),
 		IndependentSegments: true,
-		Variants: []*playlist.MultivariantVariant{{
-			Bandwidth:        maxBandwidth,
-			AverageBandwidth: &averageBandwidth,
-		}},
 	}
 
+	// audio rendition with the highest bitrate, among the ones with a dedicated media playlist
+	var audioRendition *muxerStream
+	var audioRenditionBandwidth int
+
 	for _, stream := range m.streams {
-		err := stream.populateMultivariantPlaylist(pl, rawQuery)
-		if err != nil {
-			return nil, err
+		if stream.isRendition && !stream.isLeading {
+			maxBandwidth, _ := bandwidth(stream.segments)
+			if audioRendition == nil || maxBandwidth > audioRenditionBandwidth {
+				audioRendition = stream
+				audioRenditionBandwidth = maxBandwidth
+			}
 		}
 	}
 
+	// variants must be created before renditions are attached to them
+	for _, stream := range m.streams {
+		if stream.isVariant {
+			err := stream.populateMultivariantPlaylistVariant(pl, rawQuery, audioRendition)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, stream := range m.streams {
+		if stream.isRendition {
+			stream.populateMultivariantPlaylistRendition(pl, rawQuery)
+		}
+	}
+
+	for _, stream := range m.webVTTStreams {
+		stream.populateMultivariantPlaylistRendition(pl, rawQuery)
+	}
+
+	if m.captionWriter != nil {
+		m.captionWriter.populateMultivariantPlaylist(pl)
+	}
+
+	if m.sessionData != nil {
+		m.sessionData.populateMultivariantPlaylist(pl, rawQuery)
+	}
+
+	if m.steering != nil {
+		m.steering.populateMultivariantPlaylist(pl, rawQuery)
+	}
+
 	return pl.Marshal()
 }
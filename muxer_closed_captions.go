@@ -0,0 +1,229 @@
+package gohlslib
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+const (
+	// maximum number of cc_data() constructs inserted into each access unit.
+	muxerMaxCCCount = 31
+)
+
+// MuxerClosedCaptions is a closed captions rendition,
+// embedded into H264 and H265 video tracks.
+type MuxerClosedCaptions struct {
+	// INSTREAM-ID.
+	// It can be CC1-CC4 (CEA-608) or SERVICE1-SERVICE63 (CEA-708).
+	InstreamID string
+
+	// Name.
+	// It defaults to InstreamID.
+	Name string
+
+	// Language.
+	Language string
+
+	// whether this is the default rendition.
+	IsDefault bool
+}
+
+type muxerCaptionEntry struct {
+	pts  int64
+	data []ccTriplet
+}
+
+// muxerCaptionInserter inserts closed captions into the access units of a track.
+type muxerCaptionInserter struct {
+	isH265 bool
+
+	mutex   sync.Mutex
+	entries []*muxerCaptionEntry
+}
+
+func (i *muxerCaptionInserter) push(pts int64, data []ccTriplet) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.entries = append(i.entries, &muxerCaptionEntry{
+		pts:  pts,
+		data: data,
+	})
+
+	slices.SortStableFunc(i.entries, func(a, b *muxerCaptionEntry) int {
+		return cmp.Compare(a.pts, b.pts)
+	})
+}
+
+// insert inserts pending captions with a timestamp equal or lower than the one of the access unit.
+// Captions that don't fit into the access unit are inserted into the following ones.
+func (i *muxerCaptionInserter) insert(pts int64, au [][]byte) [][]byte {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	var data []ccTriplet
+
+	for len(i.entries) > 0 && i.entries[0].pts <= pts && len(data) < muxerMaxCCCount {
+		entry := i.entries[0]
+		n := min(len(entry.data), muxerMaxCCCount-len(data))
+
+		data = append(data, entry.data[:n]...)
+		entry.data = entry.data[n:]
+
+		if len(entry.data) == 0 {
+			i.entries = i.entries[1:]
+		}
+	}
+
+	if data == nil {
+		return au
+	}
+
+	return insertCCData(au, data, i.isH265)
+}
+
+// muxerCaptionWriter encodes closed captions and routes them to video tracks.
+type muxerCaptionWriter struct {
+	closedCaptions []*MuxerClosedCaptions
+	tracks         []*muxerTrack
+
+	mutex       sync.Mutex
+	instreamIDs map[*MuxerClosedCaptions]captionsInstreamID
+	cea708      *cea708Encoder
+	inserters   []*muxerCaptionInserter
+}
+
+func (w *muxerCaptionWriter) initialize() error {
+	w.instreamIDs = make(map[*MuxerClosedCaptions]captionsInstreamID)
+
+	hasDefault := false
+
+	for _, cc := range w.closedCaptions {
+		id, err := parseCaptionsInstreamID(cc.InstreamID)
+		if err != nil {
+			return err
+		}
+
+		for _, cur := range w.instreamIDs {
+			if cur == id {
+				return fmt.Errorf("closed captions with INSTREAM-ID %v are defined multiple times", cc.InstreamID)
+			}
+		}
+
+		if cc.IsDefault {
+			if hasDefault {
+				return fmt.Errorf("multiple default closed captions are not supported")
+			}
+			hasDefault = true
+		}
+
+		w.instreamIDs[cc] = id
+	}
+
+	w.cea708 = &cea708Encoder{}
+	w.cea708.initialize()
+
+	for _, track := range w.tracks {
+		var isH265 bool
+
+		switch track.Codec.(type) {
+		case *codecs.H264:
+		case *codecs.H265:
+			isH265 = true
+		default:
+			continue
+		}
+
+		track.captions = &muxerCaptionInserter{isH265: isH265}
+		w.inserters = append(w.inserters, track.captions)
+	}
+
+	if len(w.inserters) == 0 {
+		return fmt.Errorf("closed captions require a H264 or H265 video track")
+	}
+
+	return nil
+}
+
+func (w *muxerCaptionWriter) writeText(cc *MuxerClosedCaptions, pts int64, text string) error {
+	id, ok := w.instreamIDs[cc]
+	if !ok {
+		return fmt.Errorf("closed captions rendition not found")
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var data []ccTriplet
+	if id.service != 0 {
+		data = w.cea708.encode(id.service, text)
+	} else {
+		data = cea608Encode(id.channel, text)
+	}
+
+	w.push(pts, data)
+	return nil
+}
+
+func (w *muxerCaptionWriter) writeData(pts int64, byts []byte) error {
+	if len(byts) == 0 || (len(byts)%3) != 0 {
+		return fmt.Errorf("closed caption data size must be a multiple of 3")
+	}
+
+	data := make([]ccTriplet, 0, len(byts)/3)
+
+	for i := 0; i < len(byts); i += 3 {
+		if (byts[i] & 0x04) == 0 { // cc_valid
+			continue
+		}
+
+		data = append(data, ccTriplet{
+			typ:  byts[i] & 0x03,
+			data: [2]byte{byts[i+1], byts[i+2]},
+		})
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.push(pts, data)
+	return nil
+}
+
+func (w *muxerCaptionWriter) push(pts int64, data []ccTriplet) {
+	for _, ins := range w.inserters {
+		ins.push(pts, data)
+	}
+}
+
+func (w *muxerCaptionWriter) populateMultivariantPlaylist(pl *playlist.Multivariant) {
+	for _, mv := range pl.Variants {
+		mv.ClosedCaptions = "cc"
+	}
+
+	for _, cc := range w.closedCaptions {
+		name := cc.Name
+		if name == "" {
+			name = cc.InstreamID
+		}
+
+		pl.Renditions = append(pl.Renditions, &playlist.MultivariantRendition{
+			Type:       playlist.MultivariantRenditionTypeClosedCaptions,
+			GroupID:    "cc",
+			Name:       name,
+			Language:   cc.Language,
+			Autoselect: true,
+			Default:    cc.IsDefault,
+			InStreamID: ptrOf(cc.InstreamID),
+		})
+	}
+}
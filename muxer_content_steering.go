@@ -0,0 +1,253 @@
+package gohlslib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+const (
+	muxerSteeringDefaultTTL = 300 * time.Second
+)
+
+func steeringManifestFilePath(prefix string) string {
+	return prefix + "_steering.json"
+}
+
+func isMuxerPathwayID(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, c := range v {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '.' && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MuxerPathway is a pathway, that is a CDN through which the stream is delivered.
+type MuxerPathway struct {
+	// ID (PATHWAY-ID). Required.
+	// It can contain only characters from the set [a-z], [A-Z], [0-9], '.', '-', and '_'.
+	ID string
+
+	// Base URL of media playlists, i.e. "https://cdn-a.example.com/mystream/".
+	// When empty, media playlists are referenced with relative URLs,
+	// and are therefore downloaded from the server that provides the multivariant playlist.
+	BaseURL string
+}
+
+// MuxerContentSteering contains content steering settings.
+// When present, variants and renditions are announced once for each pathway
+// and a steering manifest is served, that allows players to choose among pathways.
+type MuxerContentSteering struct {
+	// Pathways. Required.
+	// Their order is the initial pathway priority.
+	Pathways []*MuxerPathway
+
+	// How often players should reload the steering manifest.
+	// It defaults to 300 seconds.
+	TTL time.Duration
+}
+
+func (cs MuxerContentSteering) validate() error {
+	if len(cs.Pathways) == 0 {
+		return fmt.Errorf("content steering requires at least one pathway")
+	}
+
+	for i, p := range cs.Pathways {
+		if !isMuxerPathwayID(p.ID) {
+			return fmt.Errorf("invalid pathway ID: '%s'", p.ID)
+		}
+
+		for _, other := range cs.Pathways[:i] {
+			if other.ID == p.ID {
+				return fmt.Errorf("pathway '%s' is defined twice", p.ID)
+			}
+		}
+
+		if p.BaseURL != "" {
+			u, err := url.Parse(p.BaseURL)
+			if err != nil || u.Scheme == "" || u.Host == "" || strings.ContainsAny(p.BaseURL, "\"\r\n") {
+				return fmt.Errorf("invalid base URL of pathway '%s': '%s'", p.ID, p.BaseURL)
+			}
+		}
+	}
+
+	if cs.TTL != 0 && cs.TTL < time.Second {
+		return fmt.Errorf("invalid steering manifest TTL: %v", cs.TTL)
+	}
+
+	return nil
+}
+
+// muxerSteeringManifest is a content steering manifest,
+// as described in the "HLS Content Steering" specification.
+type muxerSteeringManifest struct {
+	Version         int      `json:"VERSION"`
+	TTL             int      `json:"TTL"`
+	PathwayPriority []string `json:"PATHWAY-PRIORITY"`
+}
+
+// muxerContentSteering serves the steering manifest
+// and announces variants and renditions of each pathway in multivariant playlists.
+type muxerContentSteering struct {
+	settings  *MuxerContentSteering
+	variant   MuxerVariant
+	prefix    string
+	directory string
+	publisher storage.Publisher
+	server    *muxerServer
+
+	ttl      time.Duration
+	mutex    sync.Mutex
+	priority []string
+}
+
+func (s *muxerContentSteering) initialize() error {
+	err := s.settings.validate()
+	if err != nil {
+		return err
+	}
+
+	s.ttl = s.settings.TTL
+	if s.ttl == 0 {
+		s.ttl = muxerSteeringDefaultTTL
+	}
+
+	for _, p := range s.settings.Pathways {
+		s.priority = append(s.priority, p.ID)
+	}
+
+	s.server.registerPath(steeringManifestFilePath(s.prefix), s.handleManifest)
+
+	return s.saveManifest()
+}
+
+func (s *muxerContentSteering) setPriority(priority []string) error {
+	if len(priority) == 0 {
+		return fmt.Errorf("pathway priority is empty")
+	}
+
+	for i, id := range priority {
+		if !slices.ContainsFunc(s.settings.Pathways, func(p *MuxerPathway) bool {
+			return p.ID == id
+		}) {
+			return fmt.Errorf("pathway '%s' not found", id)
+		}
+
+		if slices.Contains(priority[:i], id) {
+			return fmt.Errorf("pathway '%s' is listed twice", id)
+		}
+	}
+
+	s.mutex.Lock()
+	s.priority = slices.Clone(priority)
+	s.mutex.Unlock()
+
+	return s.saveManifest()
+}
+
+func (s *muxerContentSteering) generateManifest() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	byts, _ := json.Marshal(muxerSteeringManifest{
+		Version:         1,
+		TTL:             int(s.ttl / time.Second),
+		PathwayPriority: s.priority,
+	})
+	return byts
+}
+
+func (s *muxerContentSteering) saveManifest() error {
+	if (s.directory != "" || s.publisher != nil) && s.variant != MuxerVariantLowLatency {
+		return saveFile(s.directory, s.publisher, steeringManifestFilePath(s.prefix), s.generateManifest())
+	}
+	return nil
+}
+
+func (s *muxerContentSteering) handleManifest(w http.ResponseWriter, _ *http.Request) {
+	// the priority can be changed at any time
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.generateManifest())
+}
+
+// populateMultivariantPlaylist replaces variants and renditions with a copy for each pathway.
+// Rendition groups are duplicated too, since each pathway needs its own rendition URLs.
+func (s *muxerContentSteering) populateMultivariantPlaylist(pl *playlist.Multivariant, rawQuery string) {
+	s.mutex.Lock()
+	initialPathwayID := s.priority[0]
+	s.mutex.Unlock()
+
+	uri := steeringManifestFilePath(s.prefix)
+	if rawQuery != "" {
+		uri += "?" + rawQuery
+	}
+
+	pl.ContentSteering = &playlist.MultivariantContentSteering{
+		ServerURI: uri,
+		PathwayID: initialPathwayID,
+	}
+
+	variants := pl.Variants
+	renditions := pl.Renditions
+	pl.Variants = nil
+	pl.Renditions = nil
+
+	for _, p := range s.settings.Pathways {
+		groupID := func(id string) string {
+			if id == "" || len(s.settings.Pathways) == 1 {
+				return id
+			}
+			return id + "_" + p.ID
+		}
+
+		for _, r := range renditions {
+			// closed captions do not have URLs and can be shared among pathways
+			if r.Type == playlist.MultivariantRenditionTypeClosedCaptions {
+				if p == s.settings.Pathways[0] {
+					pl.Renditions = append(pl.Renditions, r)
+				}
+				continue
+			}
+
+			dup := *r
+			dup.GroupID = groupID(r.GroupID)
+			if r.URI != nil {
+				dup.URI = ptrOf(pathwayURL(p, *r.URI))
+			}
+			pl.Renditions = append(pl.Renditions, &dup)
+		}
+
+		for _, v := range variants {
+			dup := *v
+			dup.PathwayID = p.ID
+			dup.URI = pathwayURL(p, v.URI)
+			dup.Audio = groupID(v.Audio)
+			dup.Video = groupID(v.Video)
+			dup.Subtitles = groupID(v.Subtitles)
+			pl.Variants = append(pl.Variants, &dup)
+		}
+	}
+}
+
+func pathwayURL(p *MuxerPathway, uri string) string {
+	if p.BaseURL == "" {
+		return uri
+	}
+	return strings.TrimSuffix(p.BaseURL, "/") + "/" + uri
+}
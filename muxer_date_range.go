@@ -0,0 +1,238 @@
+package gohlslib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// MuxerDateRange is a date range, that associates metadata with a time interval
+// (a program, an ad break, ...). It is announced to players through EXT-X-DATERANGE tags.
+type MuxerDateRange struct {
+	// ID.
+	// It must be unique among date ranges.
+	ID string
+
+	// class.
+	Class string
+
+	// start.
+	// It shares the time base of NTP timestamps passed to Write*() methods.
+	Start time.Time
+
+	// duration.
+	// It can be left empty when unknown and set later by writing the date range again.
+	Duration *time.Duration
+
+	// expected duration.
+	PlannedDuration *time.Duration
+
+	// client attributes.
+	// Names must start with "X-".
+	// Values are written as quoted strings.
+	ClientAttributes map[string]string
+
+	// SCTE-35 splice_info_section() containing a splice command.
+	SCTE35Cmd []byte
+
+	// SCTE-35 splice_info_section() containing a splice out command.
+	SCTE35Out []byte
+
+	// SCTE-35 splice_info_section() containing a splice in command.
+	SCTE35In []byte
+
+	// whether the date range ends at the start of the following one with the same class.
+	EndOnNext bool
+
+	// whether to start a new segment at the first random access point after Start.
+	SegmentBoundary bool
+}
+
+func (dr MuxerDateRange) validate() error {
+	if dr.ID == "" {
+		return fmt.Errorf("date range ID is missing")
+	}
+
+	// ID and class are quoted-strings
+	if strings.ContainsAny(dr.ID, "\"\r\n") {
+		return fmt.Errorf("invalid date range ID: %v", dr.ID)
+	}
+
+	if strings.ContainsAny(dr.Class, "\"\r\n") {
+		return fmt.Errorf("invalid date range class: %v", dr.Class)
+	}
+
+	if dr.Start.IsZero() {
+		return fmt.Errorf("date range start is missing")
+	}
+
+	if (dr.Duration != nil && *dr.Duration < 0) || (dr.PlannedDuration != nil && *dr.PlannedDuration < 0) {
+		return fmt.Errorf("date range duration is negative")
+	}
+
+	if dr.EndOnNext {
+		if dr.Class == "" {
+			return fmt.Errorf("EndOnNext requires Class")
+		}
+
+		if dr.Duration != nil {
+			return fmt.Errorf("EndOnNext is incompatible with Duration")
+		}
+	}
+
+	for key := range dr.ClientAttributes {
+		if !playlist.IsClientAttributeName(key) {
+			return fmt.Errorf("invalid client attribute name: %v", key)
+		}
+	}
+
+	return nil
+}
+
+// muxerDateRanges stores date ranges until they are removed from media playlists.
+type muxerDateRanges struct {
+	mutex   sync.Mutex
+	entries []*MuxerDateRange
+}
+
+func (r *muxerDateRanges) write(dr *MuxerDateRange) error {
+	err := dr.validate()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// date ranges are copied in order to allow callers to reuse them.
+	dup := *dr
+
+	for i, cur := range r.entries {
+		if cur.ID == dr.ID {
+			// tags with the same ID must have the same attribute values,
+			// therefore only the ones that were initially missing can be updated.
+			if cur.Class != dr.Class || !cur.Start.Equal(dr.Start) {
+				return fmt.Errorf("class and start of date range '%s' cannot be changed", dr.ID)
+			}
+
+			r.entries[i] = &dup
+			return nil
+		}
+	}
+
+	r.entries = append(r.entries, &dup)
+	return nil
+}
+
+// end returns the end of a date range, or false if the date range is still ongoing.
+// Date ranges without a known end are considered to end at their start.
+func (r *muxerDateRanges) end(dr *MuxerDateRange) (time.Time, bool) {
+	switch {
+	case dr.Duration != nil:
+		return dr.Start.Add(*dr.Duration), true
+
+	case dr.EndOnNext:
+		var ret *time.Time
+		for _, other := range r.entries {
+			if other.Class == dr.Class && other.Start.After(dr.Start) &&
+				(ret == nil || other.Start.Before(*ret)) {
+				ret = &other.Start
+			}
+		}
+		if ret == nil {
+			return time.Time{}, false
+		}
+		return *ret, true
+
+	default:
+		return dr.Start, true
+	}
+}
+
+// segmentBoundary checks whether a date range that requires a segment boundary
+// starts after the start of the current segment and not after the given timestamp.
+func (r *muxerDateRanges) segmentBoundary(segmentStart time.Time, ntp time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, dr := range r.entries {
+		if dr.SegmentBoundary && dr.Start.After(segmentStart) && !dr.Start.After(ntp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prune removes date ranges that ended before the given timestamp.
+func (r *muxerDateRanges) prune(t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n := 0
+	for _, dr := range r.entries {
+		if end, ok := r.end(dr); !ok || !end.Before(t) {
+			r.entries[n] = dr
+			n++
+		}
+	}
+
+	clear(r.entries[n:])
+	r.entries = r.entries[:n]
+}
+
+// populateMediaPlaylist adds date ranges that end after the start of the playlist.
+// When date ranges are skipped, the ones that start before skipUntil are omitted,
+// and IDs of the ones that ended in the previous skipBoundary are reported.
+func (r *muxerDateRanges) populateMediaPlaylist(
+	pl *playlist.Media,
+	playlistStart time.Time,
+	skipDateRanges bool,
+	skipUntil time.Time,
+	skipBoundary time.Duration,
+) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, dr := range r.entries {
+		end, ok := r.end(dr)
+
+		if ok && end.Before(playlistStart) {
+			if skipDateRanges && !end.Before(playlistStart.Add(-skipBoundary)) {
+				pl.Skip.RecentlyRemovedDateRanges = append(pl.Skip.RecentlyRemovedDateRanges, dr.ID)
+			}
+			continue
+		}
+
+		if skipDateRanges && dr.Start.Before(skipUntil) {
+			continue
+		}
+
+		var clientAttributes map[string]playlist.MediaDateRangeClientAttribute
+		if dr.ClientAttributes != nil {
+			clientAttributes = make(map[string]playlist.MediaDateRangeClientAttribute, len(dr.ClientAttributes))
+			for key, val := range dr.ClientAttributes {
+				clientAttributes[key] = playlist.MediaDateRangeClientAttribute{
+					Value:  val,
+					Quoted: true,
+				}
+			}
+		}
+
+		pl.DateRanges = append(pl.DateRanges, &playlist.MediaDateRange{
+			ID:               dr.ID,
+			Class:            dr.Class,
+			StartDate:        dr.Start,
+			Duration:         dr.Duration,
+			PlannedDuration:  dr.PlannedDuration,
+			SCTE35Cmd:        dr.SCTE35Cmd,
+			SCTE35Out:        dr.SCTE35Out,
+			SCTE35In:         dr.SCTE35In,
+			EndOnNext:        dr.EndOnNext,
+			ClientAttributes: clientAttributes,
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package gohlslib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+// MuxerKeyIndexPlaceholder is replaced with the key index in MuxerEncryption.KeyURITemplate.
+const MuxerKeyIndexPlaceholder = "$KeyIndex$"
+
+// MuxerEncryptionMethod is an encryption method.
+type MuxerEncryptionMethod int
+
+// supported encryption methods.
+const (
+	// AES-128 encryption of whole segments.
+	// It is supported by the MPEG-TS and fMP4 variants.
+	MuxerEncryptionMethodAES128 MuxerEncryptionMethod = iota + 1
+
+	// SAMPLE-AES encryption of samples, in the CENC 'cbcs' scheme.
+	// It is supported by the fMP4 and Low-Latency variants, with H264, H265 and audio tracks.
+	MuxerEncryptionMethodSampleAES
+)
+
+// MuxerKeyProvider provides encryption keys to the Muxer.
+type MuxerKeyProvider interface {
+	// Key returns the 16-byte key with the given index.
+	// It is called once for each key, when the first segment encrypted with it is created.
+	Key(index uint64) ([]byte, error)
+}
+
+// MuxerEncryption contains encryption settings of the Muxer.
+type MuxerEncryption struct {
+	// Encryption method. Required.
+	Method MuxerEncryptionMethod
+	// Key provider.
+	// It defaults to a provider that generates random keys.
+	KeyProvider MuxerKeyProvider
+	// Number of segments encrypted with the same key.
+	// It defaults to 0, which means that keys are never rotated.
+	KeyRotationInterval int
+	// Template of the key URI, written into EXT-X-KEY tags.
+	// MuxerKeyIndexPlaceholder is replaced with the key index.
+	// When empty, keys are served by the Muxer itself and, in case of non low-latency,
+	// are written to Directory and published through StorageFactory, next to segments.
+	KeyURITemplate string
+}
+
+type muxerKeyProviderRandom struct{}
+
+func (muxerKeyProviderRandom) Key(_ uint64) ([]byte, error) {
+	key := make([]byte, aes.BlockSize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func keyFilePath(prefix string, index uint64) string {
+	return prefix + "_key" + strconv.FormatUint(index, 10) + ".key"
+}
+
+// aes128SegmentIV returns the IV of a segment encrypted with AES-128,
+// that is the media sequence number, as suggested by the specification.
+func aes128SegmentIV(segmentID uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], segmentID)
+	return iv
+}
+
+type muxerKey struct {
+	method MuxerEncryptionMethod
+	index  uint64
+	key    []byte
+	block  cipher.Block
+}
+
+type muxerKeyring struct {
+	encryption *MuxerEncryption
+	variant    MuxerVariant
+	prefix     string
+	directory  string
+	publisher  storage.Publisher
+	server     *muxerServer
+
+	constantIV []byte // sample-aes only
+	keys       map[uint64]*muxerKey
+}
+
+func (r *muxerKeyring) initialize() error {
+	r.keys = make(map[uint64]*muxerKey)
+
+	if r.encryption.Method == MuxerEncryptionMethodSampleAES {
+		r.constantIV = make([]byte, aes.BlockSize)
+		_, err := rand.Read(r.constantIV)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *muxerKeyring) keyIndex(segmentID uint64) uint64 {
+	if r.encryption.KeyRotationInterval == 0 {
+		return 0
+	}
+	return segmentID / uint64(r.encryption.KeyRotationInterval)
+}
+
+func (r *muxerKeyring) keyForSegment(segmentID uint64) (*muxerKey, error) {
+	index := r.keyIndex(segmentID)
+
+	if k, ok := r.keys[index]; ok {
+		return k, nil
+	}
+
+	byts, err := r.encryption.KeyProvider.Key(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(byts) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key size: %d", len(byts))
+	}
+
+	block, err := aes.NewCipher(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &muxerKey{
+		method: r.encryption.Method,
+		index:  index,
+		key:    byts,
+		block:  block,
+	}
+	r.keys[index] = k
+
+	if r.encryption.KeyURITemplate == "" {
+		r.server.registerPath(
+			keyFilePath(r.prefix, index),
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Cache-Control", "private, max-age="+segmentMaxAge)
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.WriteHeader(http.StatusOK)
+				w.Write(byts)
+			})
+
+		// playlists saved to disk or published refer to the key file,
+		// that must be stored next to them.
+		if r.isSaved() {
+			err = saveFile(r.directory, r.publisher, keyFilePath(r.prefix, index), byts)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return k, nil
+}
+
+func (r *muxerKeyring) isSaved() bool {
+	return (r.directory != "" || r.publisher != nil) && r.variant != MuxerVariantLowLatency
+}
+
+// prune removes keys that are not used by any segment anymore.
+func (r *muxerKeyring) prune(oldestSegmentID uint64) {
+	oldestIndex := r.keyIndex(oldestSegmentID)
+
+	for index := range r.keys {
+		if index < oldestIndex {
+			delete(r.keys, index)
+
+			if r.encryption.KeyURITemplate == "" {
+				r.server.unregisterPath(keyFilePath(r.prefix, index))
+
+				if r.isSaved() && r.directory != "" {
+					os.Remove(filepath.Join(r.directory, keyFilePath(r.prefix, index)))
+				}
+			}
+		}
+	}
+}
+
+func (r *muxerKeyring) playlistKey(k *muxerKey, rawQuery string) *playlist.MediaKey {
+	var uri string
+	if r.encryption.KeyURITemplate != "" {
+		uri = strings.ReplaceAll(r.encryption.KeyURITemplate,
+			MuxerKeyIndexPlaceholder, strconv.FormatUint(k.index, 10))
+	} else {
+		uri = keyFilePath(r.prefix, k.index)
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+	}
+
+	if r.encryption.Method == MuxerEncryptionMethodAES128 {
+		// IV is omitted since it is the media sequence number
+		return &playlist.MediaKey{
+			Method: playlist.MediaKeyMethodAES128,
+			URI:    uri,
+		}
+	}
+
+	return &playlist.MediaKey{
+		Method: playlist.MediaKeyMethodSampleAES,
+		URI:    uri,
+		IV:     "0x" + hex.EncodeToString(r.constantIV),
+	}
+}
+
+// aes128Writer encrypts a byte stream with AES-128 in CBC mode.
+// The destination can be switched at any time, allowing
+// to encrypt a segment that is split into multiple parts.
+type aes128Writer struct {
+	w    io.Writer
+	mode cipher.BlockMode
+	buf  []byte // pending bytes, shorter than a block
+}
+
+func newAES128Writer(key *muxerKey, segmentID uint64) *aes128Writer {
+	return &aes128Writer{
+		mode: cipher.NewCBCEncrypter(key.block, aes128SegmentIV(segmentID)),
+	}
+}
+
+func (w *aes128Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	l := len(w.buf) - len(w.buf)%aes.BlockSize
+	if l != 0 {
+		enc := make([]byte, l)
+		w.mode.CryptBlocks(enc, w.buf[:l])
+
+		_, err := w.w.Write(enc)
+		if err != nil {
+			return 0, err
+		}
+
+		w.buf = append(w.buf[:0], w.buf[l:]...)
+	}
+
+	return len(p), nil
+}
+
+// close writes the last block, with PKCS7 padding.
+func (w *aes128Writer) close() error {
+	padding := aes.BlockSize - len(w.buf)
+	for range padding {
+		w.buf = append(w.buf, byte(padding))
+	}
+
+	enc := make([]byte, aes.BlockSize)
+	w.mode.CryptBlocks(enc, w.buf)
+	w.buf = nil
+
+	_, err := w.w.Write(enc)
+	return err
+}
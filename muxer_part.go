@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 	"github.com/bluenviron/gohlslib/v2/pkg/storage"
 )
 
@@ -22,6 +24,7 @@ type muxerPart struct {
 
 	path          string
 	isIndependent bool
+	emsgs         []byte
 	endDTS        time.Duration // available after finalize()
 }
 
@@ -42,21 +45,74 @@ func (p *muxerPart) finalize(endDTS time.Duration) error {
 		SequenceNumber: uint32(p.id),
 	}
 
+	var subsamples map[int][][]cbcsSubsample
+	key := p.segment.key
+
 	for i, track := range p.streamTracks {
 		if track.fmp4Samples != nil {
+			samples := track.fmp4Samples
+
+			if key != nil && key.method == MuxerEncryptionMethodSampleAES {
+				var trackSubsamples [][]cbcsSubsample
+				var err error
+				samples, trackSubsamples, err = p.encryptSamples(track, samples)
+				if err != nil {
+					return err
+				}
+
+				if trackSubsamples != nil {
+					if subsamples == nil {
+						subsamples = make(map[int][][]cbcsSubsample)
+					}
+					subsamples[1+i] = trackSubsamples
+				}
+			}
+
 			part.Tracks = append(part.Tracks, &fmp4.PartTrack{
 				ID:       1 + i,
 				BaseTime: uint64(track.fmp4StartDTS),
-				Samples:  track.fmp4Samples,
+				Samples:  samples,
 			})
 
 			track.fmp4Samples = nil
 		}
 	}
 
-	err := part.Marshal(p.storage.Writer())
-	if err != nil {
-		return err
+	// event messages are placed before the moof box
+	if key == nil {
+		_, err := p.storage.Writer().Write(p.emsgs)
+		if err != nil {
+			return err
+		}
+
+		err = part.Marshal(p.storage.Writer())
+		if err != nil {
+			return err
+		}
+	} else {
+		var buf seekablebuffer.Buffer
+		err := part.Marshal(&buf)
+		if err != nil {
+			return err
+		}
+
+		byts := buf.Bytes()
+
+		if key.method == MuxerEncryptionMethodAES128 {
+			p.segment.encWriter.w = p.storage.Writer()
+			_, err = p.segment.encWriter.Write(append(p.emsgs, byts...))
+		} else {
+			if subsamples != nil {
+				byts, err = cbcsProtectPart(byts, subsamples)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = p.storage.Writer().Write(append(p.emsgs, byts...))
+		}
+		if err != nil {
+			return err
+		}
 	}
 
 	p.endDTS = endDTS
@@ -64,6 +120,46 @@ func (p *muxerPart) finalize(endDTS time.Duration) error {
 	return nil
 }
 
+// encryptSamples encrypts samples with SAMPLE-AES.
+// It returns subsamples of video samples too.
+func (p *muxerPart) encryptSamples(
+	track *muxerTrack,
+	samples []*fmp4.Sample,
+) ([]*fmp4.Sample, [][]cbcsSubsample, error) {
+	key := p.segment.key
+	iv := track.stream.keyring.constantIV
+	ret := make([]*fmp4.Sample, len(samples))
+
+	switch track.Codec.(type) {
+	case *codecs.H264, *codecs.H265:
+		_, isH265 := track.Codec.(*codecs.H265)
+		subsamples := make([][]cbcsSubsample, len(samples))
+
+		for i, sample := range samples {
+			payload, sampleSubsamples, err := cbcsEncryptVideoSample(key.block, iv, sample.Payload, isH265)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			enc := *sample
+			enc.Payload = payload
+			ret[i] = &enc
+			subsamples[i] = sampleSubsamples
+		}
+
+		return ret, subsamples, nil
+
+	default:
+		for i, sample := range samples {
+			enc := *sample
+			enc.Payload = cbcsEncryptAudioSample(key.block, iv, sample.Payload)
+			ret[i] = &enc
+		}
+
+		return ret, nil, nil
+	}
+}
+
 func (p *muxerPart) writeSample(track *muxerTrack, sample *fmp4AugmentedSample) error {
 	size := uint64(len(sample.Payload))
 	if (p.segment.size + size) > p.segmentMaxSize {
@@ -83,3 +179,29 @@ func (p *muxerPart) writeSample(track *muxerTrack, sample *fmp4AugmentedSample)
 
 	return nil
 }
+
+// writeID3 adds an Event Message box that carries an ID3 tag.
+func (p *muxerPart) writeID3(track *muxerTrack, pts int64, tag []byte) error {
+	if pts < 0 {
+		return fmt.Errorf("ID3 timestamp is impossible to handle")
+	}
+
+	size := uint64(len(tag))
+	if (p.segment.size + size) > p.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	p.segment.size += size
+
+	p.emsgs = append(p.emsgs, fmp4Emsg{
+		schemeIDURI:      fmp4EmsgSchemeID3,
+		timeScale:        uint32(track.ClockRate),
+		presentationTime: uint64(pts),
+		eventDuration:    0xffffffff,
+		id:               track.emsgID,
+		messageData:      tag,
+	}.marshal()...)
+
+	track.emsgID++
+
+	return nil
+}
@@ -4,10 +4,23 @@ import (
 	"fmt"
 	"io"
 	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
 )
 
+// removeFileAfter removes a file that is not referenced by playlists anymore.
+// Remote copies are kept for the given duration, since clients can still hold a playlist that refers to them.
+func removeFileAfter(f storage.File, d time.Duration) {
+	if r, ok := f.(storage.DelayedRemover); ok {
+		r.RemoveAfter(d)
+	} else {
+		f.Remove()
+	}
+}
+
 type muxerSegment interface {
 	close()
+	removeAfter(time.Duration)
 	finalize(time.Duration) error
 	getPath() string
 	getDuration() time.Duration
@@ -22,6 +35,9 @@ type muxerGap struct {
 func (muxerGap) close() {
 }
 
+func (muxerGap) removeAfter(time.Duration) {
+}
+
 func (muxerGap) finalize(time.Duration) error {
 	return nil
 }
@@ -11,15 +11,17 @@ type muxerSegmentFMP4 struct {
 	prefix         string
 	storageFactory storage.Factory
 	streamID       string
+	key            *muxerKey
 	id             uint64
 	startNTP       time.Time
 	startDTS       time.Duration
 
-	path    string
-	storage storage.File
-	size    uint64
-	parts   []*muxerPart
-	endDTS  time.Duration // available after finalize()
+	path      string
+	storage   storage.File
+	encWriter *aes128Writer // aes-128 only
+	size      uint64
+	parts     []*muxerPart
+	endDTS    time.Duration // available after finalize()
 }
 
 func (s *muxerSegmentFMP4) initialize() error {
@@ -31,6 +33,10 @@ func (s *muxerSegmentFMP4) initialize() error {
 		return err
 	}
 
+	if s.key != nil && s.key.method == MuxerEncryptionMethodAES128 {
+		s.encWriter = newAES128Writer(s.key, s.id)
+	}
+
 	return nil
 }
 
@@ -38,6 +44,10 @@ func (s *muxerSegmentFMP4) close() {
 	s.storage.Remove()
 }
 
+func (s *muxerSegmentFMP4) removeAfter(d time.Duration) {
+	removeFileAfter(s.storage, d)
+}
+
 func (s *muxerSegmentFMP4) getPath() string {
 	return s.path
 }
@@ -55,6 +65,14 @@ func (s *muxerSegmentFMP4) reader() (io.ReadCloser, error) {
 }
 
 func (s *muxerSegmentFMP4) finalize(endDTS time.Duration) error {
+	// padding is written into the last part
+	if s.encWriter != nil {
+		err := s.encWriter.close()
+		if err != nil {
+			return err
+		}
+	}
+
 	s.storage.Finalize()
 
 	s.endDTS = endDTS
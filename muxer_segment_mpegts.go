@@ -9,6 +9,7 @@ import (
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 	"github.com/bluenviron/gohlslib/v2/pkg/storage"
 )
 
@@ -18,12 +19,14 @@ type muxerSegmentMPEGTS struct {
 	storageFactory storage.Factory
 	streamID       string
 	mpegtsWriter   *mpegts.Writer
+	key            *muxerKey
 	id             uint64
 	startNTP       time.Time
 	startDTS       time.Duration
 
 	storage     storage.File
 	storagePart storage.Part
+	encWriter   *aes128Writer
 	bw          *bufio.Writer
 	size        uint64
 	path        string
@@ -40,7 +43,14 @@ func (s *muxerSegmentMPEGTS) initialize() error {
 	}
 
 	s.storagePart = s.storage.NewPart()
-	s.bw = bufio.NewWriter(s.storagePart.Writer())
+
+	if s.key != nil {
+		s.encWriter = newAES128Writer(s.key, s.id)
+		s.encWriter.w = s.storagePart.Writer()
+		s.bw = bufio.NewWriter(s.encWriter)
+	} else {
+		s.bw = bufio.NewWriter(s.storagePart.Writer())
+	}
 
 	return nil
 }
@@ -49,6 +59,10 @@ func (s *muxerSegmentMPEGTS) close() {
 	s.storage.Remove()
 }
 
+func (s *muxerSegmentMPEGTS) removeAfter(d time.Duration) {
+	removeFileAfter(s.storage, d)
+}
+
 func (s *muxerSegmentMPEGTS) getPath() string {
 	return s.path
 }
@@ -71,6 +85,13 @@ func (s *muxerSegmentMPEGTS) finalize(endDTS time.Duration) error {
 		return err
 	}
 
+	if s.encWriter != nil {
+		err = s.encWriter.close()
+		if err != nil {
+			return err
+		}
+	}
+
 	s.bw = nil
 	s.storage.Finalize()
 	s.endDTS = endDTS
@@ -78,7 +99,7 @@ func (s *muxerSegmentMPEGTS) finalize(endDTS time.Duration) error {
 	return nil
 }
 
-func (s *muxerSegmentMPEGTS) writeH264(
+func (s *muxerSegmentMPEGTS) writeH26x(
 	track *muxerTrack,
 	pts int64,
 	dts int64,
@@ -93,17 +114,61 @@ func (s *muxerSegmentMPEGTS) writeH264(
 	}
 	s.size += size
 
-	err := s.mpegtsWriter.WriteH264(
+	if _, ok := track.Codec.(*codecs.H265); ok {
+		return s.mpegtsWriter.WriteH265(
+			track.mpegtsTrack,
+			pts,
+			dts,
+			au,
+		)
+	}
+
+	return s.mpegtsWriter.WriteH264(
 		track.mpegtsTrack,
 		pts,
 		dts,
 		au,
 	)
-	if err != nil {
-		return err
+}
+
+// writeMPEGxVideo writes a MPEG-4 Video or MPEG-1/2 Video frame.
+func (s *muxerSegmentMPEGTS) writeMPEGxVideo(
+	track *muxerTrack,
+	pts int64,
+	frame []byte,
+) error {
+	size := uint64(len(frame))
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
 	}
+	s.size += size
 
-	return nil
+	if _, ok := track.Codec.(*codecs.MPEG4Video); ok {
+		return s.mpegtsWriter.WriteMPEG4Video(track.mpegtsTrack, pts, frame)
+	}
+
+	return s.mpegtsWriter.WriteMPEG1Video(track.mpegtsTrack, pts, frame)
+}
+
+func (s *muxerSegmentMPEGTS) writeOpus(
+	track *muxerTrack,
+	pts int64,
+	packets [][]byte,
+) error {
+	size := uint64(0)
+	for _, packet := range packets {
+		size += uint64(len(packet))
+	}
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	s.size += size
+
+	return s.mpegtsWriter.WriteOpus(
+		track.mpegtsTrack,
+		multiplyAndDivide(pts, 90000, int64(track.ClockRate)),
+		packets,
+	)
 }
 
 func (s *muxerSegmentMPEGTS) writeMPEG4Audio(
@@ -147,6 +212,69 @@ func (s *muxerSegmentMPEGTS) writeMPEG4Audio(
 	return nil
 }
 
+func (s *muxerSegmentMPEGTS) writeMPEG4AudioLATM(
+	track *muxerTrack,
+	pts int64,
+	els [][]byte,
+) error {
+	size := uint64(0)
+	for _, el := range els {
+		size += uint64(len(el))
+	}
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	s.size += size
+
+	return s.mpegtsWriter.WriteMPEG4AudioLATM(
+		track.mpegtsTrack,
+		multiplyAndDivide(pts, 90000, int64(track.ClockRate)),
+		els,
+	)
+}
+
+func (s *muxerSegmentMPEGTS) writeMPEG1Audio(
+	track *muxerTrack,
+	pts int64,
+	frames [][]byte,
+) error {
+	size := uint64(0)
+	for _, frame := range frames {
+		size += uint64(len(frame))
+	}
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	s.size += size
+
+	return s.mpegtsWriter.WriteMPEG1Audio(
+		track.mpegtsTrack,
+		multiplyAndDivide(pts, 90000, int64(track.ClockRate)),
+		frames,
+	)
+}
+
+// writeAC3 writes an AC-3 or E-AC-3 frame.
+func (s *muxerSegmentMPEGTS) writeAC3(
+	track *muxerTrack,
+	pts int64,
+	frame []byte,
+) error {
+	size := uint64(len(frame))
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	s.size += size
+
+	pts = multiplyAndDivide(pts, 90000, int64(track.ClockRate))
+
+	if _, ok := track.Codec.(*codecs.EAC3); ok {
+		return s.mpegtsWriter.WriteEAC3(track.mpegtsTrack, pts, frame)
+	}
+
+	return s.mpegtsWriter.WriteAC3(track.mpegtsTrack, pts, frame)
+}
+
 func (s *muxerSegmentMPEGTS) writeKLV(
 	track *muxerTrack,
 	pts int64,
@@ -164,3 +292,22 @@ func (s *muxerSegmentMPEGTS) writeKLV(
 		data,
 	)
 }
+
+func (s *muxerSegmentMPEGTS) writeID3(
+	track *muxerTrack,
+	pts int64,
+	tag []byte,
+) error {
+	size := uint64(len(tag))
+	if (s.size + size) > s.segmentMaxSize {
+		return fmt.Errorf("reached maximum segment size")
+	}
+	s.size += size
+
+	// timed ID3 PES packets have the same format of DVB subtitle ones.
+	return s.mpegtsWriter.WriteDVBSubtitle(
+		track.mpegtsTrack,
+		pts,
+		tag,
+	)
+}
@@ -0,0 +1,96 @@
+package gohlslib
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+func webVTTSegmentPath(prefix string, streamID string, segmentID uint64) string {
+	return prefix + "_" + streamID + "_seg" + strconv.FormatUint(segmentID, 10) + ".vtt"
+}
+
+type muxerSegmentWebVTT struct {
+	prefix          string
+	storageFactory  storage.Factory
+	streamID        string
+	timestampOffset time.Duration
+	id              uint64
+	startNTP        time.Time
+	startDTS        time.Duration
+
+	storage storage.File
+	path    string
+	cues    []*webVTTCue
+	endDTS  time.Duration // available after finalize()
+}
+
+func (s *muxerSegmentWebVTT) initialize() error {
+	s.path = webVTTSegmentPath(s.prefix, s.streamID, s.id)
+
+	var err error
+	s.storage, err = s.storageFactory.NewFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *muxerSegmentWebVTT) close() {
+	s.storage.Remove()
+}
+
+func (s *muxerSegmentWebVTT) removeAfter(d time.Duration) {
+	removeFileAfter(s.storage, d)
+}
+
+func (s *muxerSegmentWebVTT) getPath() string {
+	return s.path
+}
+
+func (s *muxerSegmentWebVTT) getDuration() time.Duration {
+	return s.endDTS - s.startDTS
+}
+
+func (s *muxerSegmentWebVTT) getSize() uint64 {
+	return s.storage.Size()
+}
+
+func (s *muxerSegmentWebVTT) reader() (io.ReadCloser, error) {
+	return s.storage.Reader()
+}
+
+func (s *muxerSegmentWebVTT) writeCue(cue *webVTTCue) {
+	s.cues = append(s.cues, cue)
+}
+
+// finalize writes cues that start before the end of the segment.
+// Cues that span multiple segments are written into all of them.
+func (s *muxerSegmentWebVTT) finalize(endDTS time.Duration) error {
+	var cues []*webVTTCue
+	for _, cue := range s.cues {
+		if (cue.start + s.timestampOffset) < endDTS {
+			cues = append(cues, cue)
+		}
+	}
+
+	slices.SortStableFunc(cues, func(a, b *webVTTCue) int {
+		return cmp.Compare(a.start, b.start)
+	})
+
+	_, err := s.storage.NewPart().Writer().Write(
+		marshalWebVTT(durationToTimestamp(s.timestampOffset, 90000), cues))
+	if err != nil {
+		return err
+	}
+
+	s.storage.Finalize()
+	s.endDTS = endDTS
+
+	return nil
+}
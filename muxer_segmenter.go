@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/ac3"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/eac3"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg1audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4video"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/opus"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/vp9"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
@@ -81,6 +85,7 @@ type fmp4AugmentedSample struct {
 type muxerSegmenterParent interface {
 	createFirstSegment(nextDTS time.Duration, nextNTP time.Time) error
 	rotateSegments(nextDTS time.Duration, nextNTP time.Time) error
+	rotateVariantSegments(stream *muxerStream, nextDTS time.Duration, nextNTP time.Time) error
 	rotateParts(nextDTS time.Duration) error
 }
 
@@ -88,6 +93,7 @@ type muxerSegmenter struct {
 	variant            MuxerVariant
 	segmentMinDuration time.Duration
 	partMinDuration    time.Duration
+	dateRanges         *muxerDateRanges
 	parent             muxerSegmenterParent
 
 	fmp4SampleDurations            map[time.Duration]struct{} // low-latency only
@@ -243,10 +249,17 @@ func (s *muxerSegmenter) writeH265(
 		track.h265DTSExtractor.Initialize()
 	}
 
+	if track.captions != nil {
+		au = track.captions.insert(pts, au)
+	}
+
 	dts, err := track.h265DTSExtractor.Extract(au, pts)
 	if err != nil {
 		return fmt.Errorf("unable to extract DTS: %w", err)
 	}
+	if s.variant == MuxerVariantMPEGTS {
+		return s.mpegtsWriteH26x(track, ntp, pts, dts, randomAccess, au)
+	}
 
 	ps := &fmp4.Sample{}
 	err = ps.FillH265(
@@ -313,37 +326,17 @@ func (s *muxerSegmenter) writeH264(
 		track.h264DTSExtractor.Initialize()
 	}
 
+	if track.captions != nil {
+		au = track.captions.insert(pts, au)
+	}
+
 	dts, err := track.h264DTSExtractor.Extract(au, pts)
 	if err != nil {
 		return fmt.Errorf("unable to extract DTS: %w", err)
 	}
 
 	if s.variant == MuxerVariantMPEGTS {
-		if track.stream.nextSegment == nil {
-			err = s.parent.createFirstSegment(timestampToDuration(dts, track.ClockRate), ntp)
-			if err != nil {
-				return err
-			}
-		} else if randomAccess && // switch segment
-			((timestampToDuration(dts, track.ClockRate) -
-				track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration) {
-			err = s.parent.rotateSegments(timestampToDuration(dts, track.ClockRate), ntp)
-			if err != nil {
-				return err
-			}
-		}
-
-		err = track.stream.nextSegment.(*muxerSegmentMPEGTS).writeH264(
-			track,
-			pts,
-			dts,
-			au,
-		)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return s.mpegtsWriteH26x(track, ntp, pts, dts, randomAccess, au)
 	}
 
 	ps := &fmp4.Sample{}
@@ -364,12 +357,57 @@ func (s *muxerSegmenter) writeH264(
 		})
 }
 
+// writeMPEGxVideo writes a MPEG-4 Video or MPEG-1/2 Video frame.
+// Frames do not carry decoding timestamps, therefore they are written in decoding order with DTS equal to PTS.
+func (s *muxerSegmenter) writeMPEGxVideo(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	if s.variant != MuxerVariantMPEGTS {
+		return fmt.Errorf("MPEG-4 Video and MPEG-1/2 Video tracks are only supported with MPEG-TS muxer variant")
+	}
+
+	var randomAccess bool
+	if _, ok := track.Codec.(*codecs.MPEG4Video); ok {
+		randomAccess = bytes.Contains(frame, []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+	} else {
+		// group of pictures start code
+		randomAccess = bytes.Contains(frame, []byte{0, 0, 1, 0xB8})
+	}
+
+	// skip samples silently until we find a random access one
+	if !track.firstRandomAccessReceived {
+		if !randomAccess {
+			return nil
+		}
+		track.firstRandomAccessReceived = true
+	}
+
+	seg, err := s.mpegtsSegment(track, ntp, pts, randomAccess)
+	if err != nil || seg == nil {
+		return err
+	}
+
+	return seg.writeMPEGxVideo(track, pts, frame)
+}
+
 func (s *muxerSegmenter) writeOpus(
 	track *muxerTrack,
 	ntp time.Time,
 	pts int64,
 	packets [][]byte,
 ) error {
+	if s.variant == MuxerVariantMPEGTS {
+		seg, err := s.mpegtsSegment(track, ntp, pts, true)
+		if err != nil || seg == nil {
+			return err
+		}
+
+		return seg.writeOpus(track, pts, packets)
+	}
+
 	for _, packet := range packets {
 		err := s.fmp4WriteSample(
 			track,
@@ -413,39 +451,167 @@ func (s *muxerSegmenter) writeFLAC(
 	)
 }
 
-func (s *muxerSegmenter) writeMPEG4Audio(
+func (s *muxerSegmenter) writeMPEG1Audio(
 	track *muxerTrack,
 	ntp time.Time,
 	pts int64,
-	aus [][]byte,
+	frames [][]byte,
 ) error {
-	if s.variant == MuxerVariantMPEGTS {
-		if track.isLeading {
-			if track.stream.nextSegment == nil {
-				err := s.parent.createFirstSegment(timestampToDuration(pts, track.ClockRate), ntp)
-				if err != nil {
-					return err
-				}
-			} else if (timestampToDuration(pts, track.ClockRate) -
-				track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration { // switch segment
-				err := s.parent.rotateSegments(timestampToDuration(pts, track.ClockRate), ntp)
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			// wait for the video track
-			if track.stream.nextSegment == nil {
-				return nil
+	codec := track.Codec.(*codecs.MPEG1Audio)
+
+	headers := make([]mpeg1audio.FrameHeader, len(frames))
+
+	for i, frame := range frames {
+		err := headers[i].Unmarshal(frame)
+		if err != nil {
+			return err
+		}
+
+		if codec.Layer == 0 {
+			if headers[i].Layer != 2 && headers[i].Layer != 3 {
+				return fmt.Errorf("unsupported MPEG-1 Audio layer: %d", headers[i].Layer)
 			}
+			codec.Layer = int(headers[i].Layer)
+		}
+
+		if int(headers[i].Layer) != codec.Layer {
+			return fmt.Errorf("frame layer (%d) does not match track layer (%d)", headers[i].Layer, codec.Layer)
+		}
+	}
+
+	if s.variant == MuxerVariantMPEGTS {
+		seg, err := s.mpegtsSegment(track, ntp, pts, true)
+		if err != nil || seg == nil {
+			return err
 		}
 
-		err := track.stream.nextSegment.(*muxerSegmentMPEGTS).writeMPEG4Audio(track, pts, aus)
+		return seg.writeMPEG1Audio(track, pts, frames)
+	}
+
+	for i, frame := range frames {
+		h := headers[i]
+
+		err := s.fmp4WriteSample(
+			track,
+			true,
+			&fmp4AugmentedSample{
+				Sample: fmp4.Sample{
+					Payload: frame,
+				},
+				dts: pts,
+				ntp: ntp,
+			},
+		)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		deltaT := int64(h.SampleCount())
+		ntp = ntp.Add(timestampToDuration(deltaT, track.ClockRate))
+		pts += deltaT
+	}
+
+	return nil
+}
+
+func (s *muxerSegmenter) writeAC3(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	var syncInfo ac3.SyncInfo
+	err := syncInfo.Unmarshal(frame)
+	if err != nil {
+		return err
+	}
+
+	var bsi ac3.BSI
+	err = bsi.Unmarshal(frame[5:])
+	if err != nil {
+		return err
+	}
+
+	if !track.stream.initFilePresent {
+		codec := track.Codec.(*codecs.AC3)
+		codec.Bsid = bsi.Bsid
+		codec.Bsmod = bsi.Bsmod
+		codec.Acmod = bsi.Acmod
+		codec.LfeOn = bsi.LfeOn
+		codec.BitRateCode = syncInfo.Frmsizecod >> 1
+	}
+
+	return s.writeAC3Frame(track, ntp, pts, frame)
+}
+
+func (s *muxerSegmenter) writeEAC3(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	var syncInfo eac3.SyncInfo
+	err := syncInfo.Unmarshal(frame)
+	if err != nil {
+		return err
+	}
+
+	if !track.stream.initFilePresent {
+		codec := track.Codec.(*codecs.EAC3)
+		codec.Acmod = syncInfo.Acmod
+		codec.LfeOn = syncInfo.Lfeon
+
+		if sampleRate := syncInfo.SampleRate(); sampleRate != 0 {
+			// data rate in kbit/s
+			codec.DataRate = uint16(syncInfo.FrameSize() * 8 * sampleRate /
+				(syncInfo.NumBlocks() * 256) / 1000)
+		}
+	}
+
+	return s.writeAC3Frame(track, ntp, pts, frame)
+}
+
+func (s *muxerSegmenter) writeAC3Frame(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	if s.variant == MuxerVariantMPEGTS {
+		seg, err := s.mpegtsSegment(track, ntp, pts, true)
+		if err != nil || seg == nil {
+			return err
+		}
+
+		return seg.writeAC3(track, pts, frame)
+	}
+
+	return s.fmp4WriteSample(
+		track,
+		true,
+		&fmp4AugmentedSample{
+			Sample: fmp4.Sample{
+				Payload: frame,
+			},
+			dts: pts,
+			ntp: ntp,
+		},
+	)
+}
+
+func (s *muxerSegmenter) writeMPEG4Audio(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	aus [][]byte,
+) error {
+	if s.variant == MuxerVariantMPEGTS {
+		seg, err := s.mpegtsSegment(track, ntp, pts, true)
+		if err != nil || seg == nil {
+			return err
+		}
+
+		return seg.writeMPEG4Audio(track, pts, aus)
 	}
 
 	for i, au := range aus {
@@ -472,6 +638,24 @@ func (s *muxerSegmenter) writeMPEG4Audio(
 	return nil
 }
 
+func (s *muxerSegmenter) writeMPEG4AudioLATM(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	els [][]byte,
+) error {
+	if s.variant != MuxerVariantMPEGTS {
+		return fmt.Errorf("MPEG-4 Audio LATM tracks are only supported with MPEG-TS muxer variant")
+	}
+
+	seg, err := s.mpegtsSegment(track, ntp, pts, true)
+	if err != nil || seg == nil {
+		return err
+	}
+
+	return seg.writeMPEG4AudioLATM(track, pts, els)
+}
+
 func (s *muxerSegmenter) writeKLV(
 	track *muxerTrack,
 	_ time.Time,
@@ -495,6 +679,103 @@ func (s *muxerSegmenter) writeKLV(
 	return seg.writeKLV(track, pts, data)
 }
 
+func (s *muxerSegmenter) writeID3(
+	track *muxerTrack,
+	_ time.Time,
+	pts int64,
+	tag []byte,
+) error {
+	// wait for the leading track to create the first segment
+	if track.stream.nextSegment == nil {
+		return nil
+	}
+
+	if s.variant == MuxerVariantMPEGTS {
+		return track.stream.nextSegment.(*muxerSegmentMPEGTS).writeID3(track, pts, tag)
+	}
+
+	return track.stream.nextPart.writeID3(track, pts+durationToTimestamp(fmp4StartDTS, track.ClockRate), tag)
+}
+
+func (s *muxerSegmenter) writeWebVTT(
+	track *muxerTrack,
+	start int64,
+	end int64,
+	text string,
+) error {
+	if start < 0 {
+		return fmt.Errorf("cue timestamp is negative")
+	}
+
+	if end <= start {
+		return fmt.Errorf("cue end must be greater than cue start")
+	}
+
+	text, err := webVTTCueText(text)
+	if err != nil {
+		return err
+	}
+
+	track.webVTTStream.writeCue(&webVTTCue{
+		start: timestampToDuration(start, track.ClockRate),
+		end:   timestampToDuration(end, track.ClockRate),
+		text:  text,
+	})
+
+	return nil
+}
+
+func (s *muxerSegmenter) mpegtsWriteH26x(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	dts int64,
+	randomAccess bool,
+	au [][]byte,
+) error {
+	seg, err := s.mpegtsSegment(track, ntp, dts, randomAccess)
+	if err != nil || seg == nil {
+		return err
+	}
+
+	return seg.writeH26x(track, pts, dts, au)
+}
+
+// mpegtsSegment returns the segment in which a sample must be written.
+// Segments are created and switched by the leading track only,
+// while other tracks wait for the first segment, in which case nil is returned.
+func (s *muxerSegmenter) mpegtsSegment(
+	track *muxerTrack,
+	ntp time.Time,
+	dts int64,
+	randomAccess bool,
+) (*muxerSegmentMPEGTS, error) {
+	if !track.isLeading {
+		if track.stream.nextSegment == nil {
+			return nil, nil
+		}
+		return track.stream.nextSegment.(*muxerSegmentMPEGTS), nil
+	}
+
+	dtsDuration := timestampToDuration(dts, track.ClockRate)
+
+	if track.stream.nextSegment == nil {
+		err := s.parent.createFirstSegment(dtsDuration, ntp)
+		if err != nil {
+			return nil, err
+		}
+	} else if randomAccess && // switch segment
+		((dtsDuration-track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration ||
+			s.dateRanges.segmentBoundary(track.stream.nextSegment.(*muxerSegmentMPEGTS).startNTP, ntp)) {
+		err := s.parent.rotateSegments(dtsDuration, ntp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return track.stream.nextSegment.(*muxerSegmentMPEGTS), nil
+}
+
 // iPhone iOS fails if part durations are less than 85% of maximum part duration.
 // find a part duration that is compatible with all sample durations
 func (s *muxerSegmenter) fmp4AdjustPartDuration(sampleDuration time.Duration) {
@@ -556,6 +837,22 @@ func (s *muxerSegmenter) fmp4WriteSample(
 		if track.stream.nextSegment == nil {
 			return nil
 		}
+
+		// switch segment of a video variant when its own random access sample is reached
+		if b := track.stream.pendingBoundary; b != nil {
+			sampleDTS := timestampToDuration(sample.dts, track.ClockRate)
+
+			if sampleDTS >= b.dts {
+				if sample.IsNonSyncSample {
+					return fmt.Errorf("random access samples of video variants are not aligned")
+				}
+
+				err := s.parent.rotateVariantSegments(track.stream, sampleDTS, sample.ntp)
+				if err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	if track.isLeading {
@@ -572,8 +869,10 @@ func (s *muxerSegmenter) fmp4WriteSample(
 
 	if track.isLeading {
 		// switch segment
-		if randomAccess && ((timestampToDuration(track.fmp4NextSample.dts, track.ClockRate) -
-			track.stream.nextSegment.(*muxerSegmentFMP4).startDTS) >= s.segmentMinDuration) {
+		if randomAccess && ((timestampToDuration(track.fmp4NextSample.dts, track.ClockRate)-
+			track.stream.nextSegment.(*muxerSegmentFMP4).startDTS) >= s.segmentMinDuration ||
+			s.dateRanges.segmentBoundary(track.stream.nextSegment.(*muxerSegmentFMP4).startNTP,
+				track.fmp4NextSample.ntp)) {
 			err = s.parent.rotateSegments(timestampToDuration(track.fmp4NextSample.dts, track.ClockRate),
 				track.fmp4NextSample.ntp)
 			if err != nil {
@@ -0,0 +1,133 @@
+package gohlslib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+func sessionDataFilePath(prefix string, index int) string {
+	return prefix + "_session" + strconv.FormatInt(int64(index), 10) + ".json"
+}
+
+// MuxerSessionData is a piece of data that applies to the whole stream
+// (a title, a channel ID, a JSON document, ...).
+// It is published through EXT-X-SESSION-DATA tags of the multivariant playlist.
+type MuxerSessionData struct {
+	// ID (DATA-ID). Required.
+	// It should use reverse DNS notation, i.e. "com.example.title".
+	ID string
+
+	// Value.
+	// It is written into the multivariant playlist.
+	Value string
+
+	// JSON document.
+	// It is served as a separate file, that is referenced by the multivariant playlist.
+	// It is mutually exclusive with Value.
+	JSON []byte
+
+	// Language.
+	Language string
+}
+
+func (sd MuxerSessionData) validate() error {
+	if sd.ID == "" {
+		return fmt.Errorf("session data ID is missing")
+	}
+
+	// values are written into quoted strings
+	if strings.ContainsAny(sd.ID+sd.Value+sd.Language, "\"\r\n") {
+		return fmt.Errorf("session data '%s' contains invalid characters", sd.ID)
+	}
+
+	if sd.JSON != nil {
+		if sd.Value != "" {
+			return fmt.Errorf("session data '%s' cannot have both Value and JSON", sd.ID)
+		}
+
+		if !json.Valid(sd.JSON) {
+			return fmt.Errorf("session data '%s' contains an invalid JSON document", sd.ID)
+		}
+	}
+
+	return nil
+}
+
+// muxerSessionDataWriter serves JSON documents of session data
+// and adds session data to multivariant playlists.
+type muxerSessionDataWriter struct {
+	entries   []*MuxerSessionData
+	variant   MuxerVariant
+	prefix    string
+	directory string
+	publisher storage.Publisher
+	server    *muxerServer
+}
+
+func (w *muxerSessionDataWriter) initialize() error {
+	for i, sd := range w.entries {
+		err := sd.validate()
+		if err != nil {
+			return err
+		}
+
+		for _, other := range w.entries[:i] {
+			if other.ID == sd.ID && other.Language == sd.Language {
+				return fmt.Errorf("session data '%s' is defined twice with the same language", sd.ID)
+			}
+		}
+	}
+
+	for i, sd := range w.entries {
+		if sd.JSON == nil {
+			continue
+		}
+
+		byts := sd.JSON
+
+		w.server.registerPath(
+			sessionDataFilePath(w.prefix, i),
+			func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Cache-Control", "public, max-age="+multivariantPlaylistMaxAge)
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusOK)
+				rw.Write(byts)
+			})
+
+		if (w.directory != "" || w.publisher != nil) && w.variant != MuxerVariantLowLatency {
+			err := saveFile(w.directory, w.publisher, sessionDataFilePath(w.prefix, i), byts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *muxerSessionDataWriter) populateMultivariantPlaylist(pl *playlist.Multivariant, rawQuery string) {
+	for i, sd := range w.entries {
+		entry := &playlist.MultivariantSessionData{
+			DataID:   sd.ID,
+			Language: sd.Language,
+		}
+
+		if sd.JSON != nil {
+			uri := sessionDataFilePath(w.prefix, i)
+			if rawQuery != "" {
+				uri += "?" + rawQuery
+			}
+			entry.URI = &uri
+		} else {
+			entry.Value = ptrOf(sd.Value)
+		}
+
+		pl.SessionData = append(pl.SessionData, entry)
+	}
+}
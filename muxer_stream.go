@@ -31,6 +31,22 @@ func mediaPlaylistPath(streamID string) string {
 	return streamID + "_stream.m3u8"
 }
 
+// saveFile writes a playlist or an init file into a directory and publishes it.
+func saveFile(directory string, publisher storage.Publisher, fileName string, byts []byte) error {
+	if directory != "" {
+		err := os.WriteFile(filepath.Join(directory, fileName), byts, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if publisher != nil {
+		return publisher.Publish(fileName, byts)
+	}
+
+	return nil
+}
+
 func initFilePath(prefix string, streamID string) string {
 	return prefix + "_" + streamID + "_init.mp4"
 }
@@ -63,6 +79,14 @@ func targetDuration(segments []muxerSegment) int {
 	return ret
 }
 
+func playlistDuration(segments []muxerSegment) time.Duration {
+	var ret time.Duration
+	for _, sog := range segments {
+		ret += sog.getDuration()
+	}
+	return ret
+}
+
 func partTargetDuration(
 	segments []muxerSegment,
 	nextSegmentParts []*muxerPart,
@@ -94,9 +118,30 @@ func partTargetDuration(
 
 type generateMediaPlaylistFunc func(
 	isDeltaUpdate bool,
+	skipDateRanges bool,
 	rawQuery string,
 ) ([]byte, error)
 
+// segmentStartNTP returns the NTP timestamp of the start of a segment, or false in case of gaps.
+func segmentStartNTP(sog muxerSegment) (time.Time, bool) {
+	switch seg := sog.(type) {
+	case *muxerSegmentMPEGTS:
+		return seg.startNTP, true
+
+	case *muxerSegmentFMP4:
+		return seg.startNTP, true
+	}
+
+	return time.Time{}, false
+}
+
+// muxerSegmentBoundary is a segment boundary of the leading stream
+// that has not been applied to a video variant yet.
+type muxerSegmentBoundary struct {
+	dts time.Duration
+	ntp time.Time
+}
+
 type muxerStream struct {
 	variant        MuxerVariant
 	segmentMaxSize uint64
@@ -106,11 +151,15 @@ type muxerStream struct {
 	cond           *sync.Cond
 	prefix         string
 	storageFactory storage.Factory
+	keyring        *muxerKeyring
+	dateRanges     *muxerDateRanges
 	directory      string
+	publisher      storage.Publisher
 	server         *muxerServer
 	tracks         []*muxerTrack
 	id             string
 	isLeading      bool
+	isVariant      bool
 	isRendition    bool
 	name           string
 	language       string
@@ -118,6 +167,8 @@ type muxerStream struct {
 	nextSegmentID  uint64
 	nextPartID     uint64
 
+	reportedStreams        []*muxerStream // low-latency only
+	pendingBoundary        *muxerSegmentBoundary
 	generateMediaPlaylist  generateMediaPlaylistFunc
 	mpegtsSwitchableWriter *switchableWriter // mpegts only
 	mpegtsWriter           *mpegts.Writer    // mpegts only
@@ -140,11 +191,23 @@ func (s *muxerStream) initialize() error {
 		s.generateMediaPlaylist = s.generateMediaPlaylistMPEGTS
 
 		tracks := make([]*mpegts.Track, len(s.tracks))
+		var id3Tracks []*mpegts.Track
 		for i, track := range s.tracks {
 			tracks[i] = track.mpegtsTrack
+			if isID3(track.Codec) {
+				id3Tracks = append(id3Tracks, track.mpegtsTrack)
+			}
 		}
 		s.mpegtsSwitchableWriter = &switchableWriter{}
-		s.mpegtsWriter = &mpegts.Writer{W: s.mpegtsSwitchableWriter, Tracks: tracks}
+
+		var w io.Writer = s.mpegtsSwitchableWriter
+		if id3Tracks != nil {
+			id3w := &mpegtsID3Writer{w: w, tracks: id3Tracks}
+			id3w.initialize()
+			w = id3w
+		}
+
+		s.mpegtsWriter = &mpegts.Writer{W: w, Tracks: tracks}
 		err := s.mpegtsWriter.Initialize()
 		if err != nil {
 			return err
@@ -157,6 +220,13 @@ func (s *muxerStream) initialize() error {
 	return nil
 }
 
+// waitsForRandomAccess returns whether segments are switched when
+// the stream receives its own random access sample, after the leading stream has switched them.
+// This is needed by video variants, whose segments must start with a random access sample.
+func (s *muxerStream) waitsForRandomAccess() bool {
+	return !s.isLeading && s.isVariant && s.variant != MuxerVariantMPEGTS && s.tracks[0].Codec.IsVideo()
+}
+
 func (s *muxerStream) close() {
 	s.closed = true
 
@@ -169,16 +239,47 @@ func (s *muxerStream) close() {
 	}
 
 	if s.nextSegment != nil {
-		s.nextSegment.finalize(0) //nolint:errcheck
+		// the next segment is not referenced by playlists,
+		// remove it before finalizing it in order to avoid uploading it to remote storages.
 		s.nextSegment.close()
+		s.nextSegment.finalize(0) //nolint:errcheck
+	}
+}
+
+func (s *muxerStream) mediaPlaylistURI(rawQuery string) string {
+	uri := mediaPlaylistPath(s.id)
+	if rawQuery != "" {
+		uri += "?" + rawQuery
 	}
+	return uri
 }
 
-func (s *muxerStream) populateMultivariantPlaylist(
+func (s *muxerStream) populateMultivariantPlaylistVariant(
 	pl *playlist.Multivariant,
 	rawQuery string,
+	audioRendition *muxerStream,
 ) error {
-	mv := pl.Variants[0]
+	maxBandwidth, averageBandwidth := bandwidth(s.segments)
+
+	// bandwidth must be the one of the most demanding combination of the variant and its renditions.
+	if audioRendition != nil {
+		audioMaxBandwidth, audioAverageBandwidth := bandwidth(audioRendition.segments)
+
+		if s.isRendition {
+			// in audio-only streams, the rendition replaces the variant
+			maxBandwidth = max(maxBandwidth, audioMaxBandwidth)
+			averageBandwidth = max(averageBandwidth, audioAverageBandwidth)
+		} else {
+			maxBandwidth += audioMaxBandwidth
+			averageBandwidth += audioAverageBandwidth
+		}
+	}
+
+	mv := &playlist.MultivariantVariant{
+		Bandwidth:        maxBandwidth,
+		AverageBandwidth: &averageBandwidth,
+		URI:              s.mediaPlaylistURI(rawQuery),
+	}
 
 	for _, track := range s.tracks {
 		codec := codecparams.Marshal(track.Codec)
@@ -233,40 +334,45 @@ func (s *muxerStream) populateMultivariantPlaylist(
 		}
 	}
 
-	uri := mediaPlaylistPath(s.id)
-	if rawQuery != "" {
-		uri += "?" + rawQuery
-	}
-
-	if s.isLeading {
-		mv.URI = uri
-	}
+	pl.Variants = append(pl.Variants, mv)
 
-	if s.isRendition {
-		mv.Audio = "audio"
+	return nil
+}
 
-		r := &playlist.MultivariantRendition{
-			Type:       playlist.MultivariantRenditionTypeAudio,
-			GroupID:    "audio",
-			Name:       s.name,
-			Language:   s.language,
-			Autoselect: true,
-			Default:    s.isDefault,
+func (s *muxerStream) populateMultivariantPlaylistRendition(
+	pl *playlist.Multivariant,
+	rawQuery string,
+) {
+	for _, mv := range pl.Variants {
+		for _, track := range s.tracks {
+			codec := codecparams.Marshal(track.Codec)
+			if codec != "" && !slices.Contains(mv.Codecs, codec) {
+				mv.Codecs = append(mv.Codecs, codec)
+			}
 		}
 
-		// draft-pantos-hls-rfc8216bis:
-		// If the media type is VIDEO or AUDIO, a missing URI attribute
-		// indicates that the media data for this Rendition is included in the
-		// Media Playlist of any EXT-X-STREAM-INF tag referencing this EXT-
-		// X-MEDIA tag.
-		if !s.isLeading {
-			r.URI = &uri
-		}
+		mv.Audio = "audio"
+	}
 
-		pl.Renditions = append(pl.Renditions, r)
+	r := &playlist.MultivariantRendition{
+		Type:       playlist.MultivariantRenditionTypeAudio,
+		GroupID:    "audio",
+		Name:       s.name,
+		Language:   s.language,
+		Autoselect: true,
+		Default:    s.isDefault,
 	}
 
-	return nil
+	// draft-pantos-hls-rfc8216bis:
+	// If the media type is VIDEO or AUDIO, a missing URI attribute
+	// indicates that the media data for this Rendition is included in the
+	// Media Playlist of any EXT-X-STREAM-INF tag referencing this EXT-
+	// X-MEDIA tag.
+	if !s.isLeading {
+		r.URI = ptrOf(s.mediaPlaylistURI(rawQuery))
+	}
+
+	pl.Renditions = append(pl.Renditions, r)
 }
 
 func (s *muxerStream) hasContent() bool {
@@ -319,9 +425,11 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 	skip := queryVal(q, "_HLS_skip")
 
 	isDeltaUpdate := false
+	skipDateRanges := false
 
 	if s.variant == MuxerVariantLowLatency {
 		isDeltaUpdate = skip == "YES" || skip == "v2"
+		skipDateRanges = skip == "v2"
 
 		msnint, partint, err := parseMSNPart(msn, part)
 		if err != nil {
@@ -361,6 +469,7 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 				var byts []byte
 				byts, err = s.generateMediaPlaylist(
 					isDeltaUpdate,
+					skipDateRanges,
 					r.URL.RawQuery,
 				)
 				if err != nil {
@@ -404,6 +513,7 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 
 		byts, err := s.generateMediaPlaylist(
 			isDeltaUpdate,
+			skipDateRanges,
 			r.URL.RawQuery,
 		)
 		if err != nil {
@@ -423,6 +533,7 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 }
 
 func (s *muxerStream) generateMediaPlaylistMPEGTS(
+	_ bool,
 	_ bool,
 	rawQuery string,
 ) ([]byte, error) {
@@ -433,26 +544,35 @@ func (s *muxerStream) generateMediaPlaylistMPEGTS(
 		MediaSequence:  s.segmentDeleteCount,
 	}
 
-	for _, s := range s.segments {
-		if seg, ok := s.(*muxerSegmentMPEGTS); ok {
+	for _, sog := range s.segments {
+		if seg, ok := sog.(*muxerSegmentMPEGTS); ok {
 			uri := seg.path
 			if rawQuery != "" {
 				uri += "?" + rawQuery
 			}
 
-			pl.Segments = append(pl.Segments, &playlist.MediaSegment{
+			plse := &playlist.MediaSegment{
 				DateTime: &seg.startNTP,
 				Duration: seg.getDuration(),
 				URI:      uri,
-			})
+			}
+
+			if seg.key != nil {
+				plse.Key = s.keyring.playlistKey(seg.key, rawQuery)
+			}
+
+			pl.Segments = append(pl.Segments, plse)
 		}
 	}
 
+	s.populateDateRanges(pl, 0, false, 0)
+
 	return pl.Marshal()
 }
 
 func (s *muxerStream) generateMediaPlaylistFMP4(
 	isDeltaUpdate bool,
+	skipDateRanges bool,
 	rawQuery string,
 ) ([]byte, error) {
 	skipBoundary := time.Duration(s.targetDuration) * 6 * time.Second
@@ -467,10 +587,13 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 	if s.variant == MuxerVariantLowLatency {
 		partHoldBack := (s.partTargetDuration * 25) / 10
 
+		// server control attributes must not change during the stream,
+		// therefore the skip of date ranges is advertised even before they are written.
 		pl.ServerControl = &playlist.MediaServerControl{
-			CanBlockReload: true,
-			PartHoldBack:   &partHoldBack,
-			CanSkipUntil:   &skipBoundary,
+			CanBlockReload:    true,
+			PartHoldBack:      &partHoldBack,
+			CanSkipUntil:      &skipBoundary,
+			CanSkipDateRanges: true,
 		}
 
 		pl.PartInf = &playlist.MediaPartInf{
@@ -527,6 +650,10 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 				plse.DateTime = &seg.startNTP
 			}
 
+			if seg.key != nil {
+				plse.Key = s.keyring.playlistKey(seg.key, rawQuery)
+			}
+
 			if s.variant == MuxerVariantLowLatency && (len(s.segments)-i) <= 2 {
 				for _, part := range seg.parts {
 					u = part.path
@@ -553,7 +680,13 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 		}
 	}
 
+	s.populateDateRanges(pl, skipped, isDeltaUpdate && skipDateRanges, skipBoundary)
+
 	if s.variant == MuxerVariantLowLatency {
+		if key := s.nextSegment.(*muxerSegmentFMP4).key; key != nil {
+			pl.PartsKey = s.keyring.playlistKey(key, rawQuery)
+		}
+
 		for _, part := range s.nextSegment.(*muxerSegmentFMP4).parts {
 			u := part.path
 			if rawQuery != "" {
@@ -576,11 +709,66 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 		pl.PreloadHint = &playlist.MediaPreloadHint{
 			URI: uri,
 		}
+
+		for _, other := range s.reportedStreams {
+			pl.RenditionReport = append(pl.RenditionReport, other.renditionReport(rawQuery))
+		}
 	}
 
 	return pl.Marshal()
 }
 
+// populateDateRanges adds date ranges whose segments are still in the playlist.
+func (s *muxerStream) populateDateRanges(
+	pl *playlist.Media,
+	skipped int,
+	skipDateRanges bool,
+	skipBoundary time.Duration,
+) {
+	var playlistStart time.Time
+	found := false
+
+	for _, seg := range s.segments {
+		if playlistStart, found = segmentStartNTP(seg); found {
+			break
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	// date ranges that start into skipped segments are skipped too
+	var skipUntil time.Time
+	if skipDateRanges && skipped > 0 && skipped < len(s.segments) {
+		skipUntil, _ = segmentStartNTP(s.segments[skipped])
+	}
+
+	s.dateRanges.populateMediaPlaylist(pl, playlistStart, skipDateRanges, skipUntil, skipBoundary)
+}
+
+func (s *muxerStream) renditionReport(rawQuery string) *playlist.MediaRenditionReport {
+	r := &playlist.MediaRenditionReport{
+		URI: s.mediaPlaylistURI(rawQuery),
+	}
+
+	// the last part is either in the next segment or in the last completed one
+	if parts := s.nextSegment.(*muxerSegmentFMP4).parts; len(parts) != 0 {
+		r.LastMSN = int(s.nextSegmentID)
+		r.LastPart = ptrOf(len(parts) - 1)
+	} else {
+		r.LastMSN = int(s.nextSegmentID) - 1
+
+		if len(s.segments) != 0 {
+			if seg, ok := s.segments[len(s.segments)-1].(*muxerSegmentFMP4); ok && len(seg.parts) != 0 {
+				r.LastPart = ptrOf(len(seg.parts) - 1)
+			}
+		}
+	}
+
+	return r
+}
+
 func (s *muxerStream) generateAndCacheInitFile() error {
 	var init fmp4.Init
 	trackID := 1
@@ -602,8 +790,15 @@ func (s *muxerStream) generateAndCacheInitFile() error {
 
 	initFile := w.Bytes()
 
-	if s.directory != "" && s.variant != MuxerVariantLowLatency {
-		err = os.WriteFile(filepath.Join(s.directory, initFilePath(s.prefix, s.id)), initFile, 0o644)
+	if s.keyring != nil && s.keyring.encryption.Method == MuxerEncryptionMethodSampleAES {
+		initFile, err = cbcsProtectInit(initFile, s.keyring.constantIV)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (s.directory != "" || s.publisher != nil) && s.variant != MuxerVariantLowLatency {
+		err = saveFile(s.directory, s.publisher, initFilePath(s.prefix, s.id), initFile)
 		if err != nil {
 			return err
 		}
@@ -628,10 +823,23 @@ func (s *muxerStream) generateAndCacheInitFile() error {
 	return nil
 }
 
+// segmentKey returns the key of the next segment, or nil when encryption is disabled.
+func (s *muxerStream) segmentKey() (*muxerKey, error) {
+	if s.keyring == nil {
+		return nil, nil
+	}
+	return s.keyring.keyForSegment(s.nextSegmentID)
+}
+
 func (s *muxerStream) createFirstSegment(
 	nextDTS time.Duration,
 	nextNTP time.Time,
 ) error {
+	key, err := s.segmentKey()
+	if err != nil {
+		return err
+	}
+
 	if s.variant == MuxerVariantMPEGTS { //nolint:dupl
 		seg := &muxerSegmentMPEGTS{
 			segmentMaxSize: s.segmentMaxSize,
@@ -639,11 +847,12 @@ func (s *muxerStream) createFirstSegment(
 			storageFactory: s.storageFactory,
 			streamID:       s.id,
 			mpegtsWriter:   s.mpegtsWriter,
+			key:            key,
 			id:             s.nextSegmentID,
 			startNTP:       nextNTP,
 			startDTS:       nextDTS,
 		}
-		err := seg.initialize()
+		err = seg.initialize()
 		if err != nil {
 			return err
 		}
@@ -655,11 +864,12 @@ func (s *muxerStream) createFirstSegment(
 			prefix:         s.prefix,
 			storageFactory: s.storageFactory,
 			streamID:       s.id,
+			key:            key,
 			id:             s.nextSegmentID,
 			startNTP:       nextNTP,
 			startDTS:       nextDTS,
 		}
-		err := seg.initialize()
+		err = seg.initialize()
 		if err != nil {
 			return err
 		}
@@ -857,7 +1067,9 @@ func (s *muxerStream) rotateSegments(
 			}
 		}
 
-		toDelete.close()
+		// RFC 8216, section 6.2.2: removed segments must stay available for their duration
+		// plus the duration of the playlist that contained them.
+		toDelete.removeAfter(toDelete.getDuration() + playlistDuration(s.segments))
 
 		s.server.unregisterPath(toDelete.getPath())
 
@@ -874,6 +1086,12 @@ func (s *muxerStream) rotateSegments(
 		s.initFilePresent = true
 	}
 
+	var key *muxerKey
+	key, err = s.segmentKey()
+	if err != nil {
+		return err
+	}
+
 	if s.variant == MuxerVariantMPEGTS { //nolint:dupl
 		seg := &muxerSegmentMPEGTS{
 			segmentMaxSize: s.segmentMaxSize,
@@ -881,6 +1099,7 @@ func (s *muxerStream) rotateSegments(
 			storageFactory: s.storageFactory,
 			streamID:       s.id,
 			mpegtsWriter:   s.mpegtsWriter,
+			key:            key,
 			id:             s.nextSegmentID,
 			startNTP:       nextNTP,
 			startDTS:       nextDTS,
@@ -897,6 +1116,7 @@ func (s *muxerStream) rotateSegments(
 			prefix:         s.prefix,
 			storageFactory: s.storageFactory,
 			streamID:       s.id,
+			key:            key,
 			id:             s.nextSegmentID,
 			startNTP:       nextNTP,
 			startDTS:       nextDTS,
@@ -0,0 +1,276 @@
+package gohlslib
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+// muxerStreamWebVTT is a stream that contains WebVTT subtitles.
+// Segments are created and switched together with the ones of the leading stream.
+type muxerStreamWebVTT struct {
+	variant         MuxerVariant
+	segmentCount    int
+	mutex           *sync.Mutex
+	cond            *sync.Cond
+	prefix          string
+	storageFactory  storage.Factory
+	server          *muxerServer
+	track           *muxerTrack
+	id              string
+	name            string
+	language        string
+	isDefault       bool
+	isForced        bool
+	nextSegmentID   uint64
+	timestampOffset time.Duration
+
+	pendingCues        []*webVTTCue // cues received before the first segment
+	segments           []muxerSegment
+	nextSegment        *muxerSegmentWebVTT
+	segmentDeleteCount int
+	closed             bool
+	targetDuration     int
+}
+
+func (s *muxerStreamWebVTT) initialize() {
+	s.track.webVTTStream = s
+
+	s.server.registerPath(mediaPlaylistPath(s.id), s.handleMediaPlaylist)
+}
+
+func (s *muxerStreamWebVTT) close() {
+	s.closed = true
+
+	for _, segment := range s.segments {
+		segment.close()
+	}
+
+	if s.nextSegment != nil {
+		// the next segment is not referenced by playlists,
+		// remove it before finalizing it in order to avoid uploading it to remote storages.
+		s.nextSegment.close()
+		s.nextSegment.finalize(0) //nolint:errcheck
+	}
+}
+
+func (s *muxerStreamWebVTT) mediaPlaylistURI(rawQuery string) string {
+	uri := mediaPlaylistPath(s.id)
+	if rawQuery != "" {
+		uri += "?" + rawQuery
+	}
+	return uri
+}
+
+func (s *muxerStreamWebVTT) populateMultivariantPlaylistRendition(
+	pl *playlist.Multivariant,
+	rawQuery string,
+) {
+	for _, mv := range pl.Variants {
+		mv.Subtitles = "subtitles"
+	}
+
+	pl.Renditions = append(pl.Renditions, &playlist.MultivariantRendition{
+		Type:       playlist.MultivariantRenditionTypeSubtitles,
+		GroupID:    "subtitles",
+		Name:       s.name,
+		Language:   s.language,
+		Autoselect: true,
+		Default:    s.isDefault,
+		Forced:     s.isForced,
+		URI:        ptrOf(s.mediaPlaylistURI(rawQuery)),
+	})
+}
+
+func (s *muxerStreamWebVTT) hasContent() bool {
+	if s.variant == MuxerVariantFMP4 {
+		return len(s.segments) >= 2
+	}
+	return len(s.segments) >= 1
+}
+
+func (s *muxerStreamWebVTT) handleMediaPlaylist(w http.ResponseWriter, r *http.Request) {
+	content := func() []byte {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		for {
+			if s.closed {
+				return nil
+			}
+
+			if s.hasContent() {
+				break
+			}
+
+			s.cond.Wait()
+		}
+
+		byts, err := s.generateMediaPlaylist(filterOutHLSParams(r.URL.RawQuery))
+		if err != nil {
+			return nil
+		}
+
+		return byts
+	}()
+
+	if content == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+func (s *muxerStreamWebVTT) generateMediaPlaylist(rawQuery string) ([]byte, error) {
+	pl := &playlist.Media{
+		Version: func() int {
+			if s.variant == MuxerVariantMPEGTS {
+				return 3
+			}
+			return 10
+		}(),
+		TargetDuration: s.targetDuration,
+		MediaSequence:  s.segmentDeleteCount,
+	}
+
+	for _, sog := range s.segments {
+		switch seg := sog.(type) {
+		case *muxerSegmentWebVTT:
+			uri := seg.path
+			if rawQuery != "" {
+				uri += "?" + rawQuery
+			}
+
+			pl.Segments = append(pl.Segments, &playlist.MediaSegment{
+				DateTime: &seg.startNTP,
+				Duration: seg.getDuration(),
+				URI:      uri,
+			})
+
+		case *muxerGap:
+			pl.Segments = append(pl.Segments, &playlist.MediaSegment{
+				Gap:      true,
+				Duration: seg.duration,
+				URI:      "gap.vtt",
+			})
+		}
+	}
+
+	return pl.Marshal()
+}
+
+func (s *muxerStreamWebVTT) writeCue(cue *webVTTCue) {
+	if s.nextSegment == nil {
+		s.pendingCues = append(s.pendingCues, cue)
+		return
+	}
+
+	// cues that end before the current segment cannot be published anymore
+	if (cue.end + s.timestampOffset) <= s.nextSegment.startDTS {
+		return
+	}
+
+	s.nextSegment.writeCue(cue)
+}
+
+func (s *muxerStreamWebVTT) createSegment(
+	nextDTS time.Duration,
+	nextNTP time.Time,
+	cues []*webVTTCue,
+) error {
+	seg := &muxerSegmentWebVTT{
+		prefix:          s.prefix,
+		storageFactory:  s.storageFactory,
+		streamID:        s.id,
+		timestampOffset: s.timestampOffset,
+		id:              s.nextSegmentID,
+		startNTP:        nextNTP,
+		startDTS:        nextDTS,
+	}
+	err := seg.initialize()
+	if err != nil {
+		return err
+	}
+	s.nextSegment = seg
+
+	for _, cue := range cues {
+		if (cue.end + s.timestampOffset) > nextDTS {
+			seg.writeCue(cue)
+		}
+	}
+
+	return nil
+}
+
+func (s *muxerStreamWebVTT) createFirstSegment(
+	nextDTS time.Duration,
+	nextNTP time.Time,
+) error {
+	cues := s.pendingCues
+	s.pendingCues = nil
+
+	return s.createSegment(nextDTS, nextNTP, cues)
+}
+
+func (s *muxerStreamWebVTT) rotateSegments(
+	nextDTS time.Duration,
+	nextNTP time.Time,
+) error {
+	s.nextSegmentID++
+
+	segment := s.nextSegment
+	s.nextSegment = nil
+
+	err := segment.finalize(nextDTS)
+	if err != nil {
+		segment.close()
+		return err
+	}
+
+	// add initial gaps, in order to keep segments aligned with the ones of other streams
+	if s.variant == MuxerVariantLowLatency && len(s.segments) == 0 {
+		for range 7 {
+			s.segments = append(s.segments, &muxerGap{
+				duration: segment.getDuration(),
+			})
+		}
+	}
+
+	s.segments = append(s.segments, segment)
+
+	s.server.registerPath(
+		segment.getPath(),
+		func(w http.ResponseWriter, _ *http.Request) {
+			r, err2 := segment.reader()
+			if err2 != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer r.Close()
+
+			w.Header().Set("Cache-Control", "public, max-age="+segmentMaxAge)
+			w.Header().Set("Content-Type", "text/vtt")
+			w.WriteHeader(http.StatusOK)
+			io.Copy(w, r)
+		})
+
+	// delete old segments
+	if len(s.segments) > s.segmentCount {
+		toDelete := s.segments[0]
+		toDelete.removeAfter(toDelete.getDuration() + playlistDuration(s.segments))
+		s.server.unregisterPath(toDelete.getPath())
+		s.segments = s.segments[1:]
+		s.segmentDeleteCount++
+	}
+
+	// cues that span multiple segments are carried over to the next one
+	return s.createSegment(nextDTS, nextNTP, segment.cues)
+}
@@ -3,28 +3,39 @@ package gohlslib
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/asticode/go-astits"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/flac"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 	mp4codecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mp4/codecs"
 	mcmpegts "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	tscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
 	"github.com/stretchr/testify/require"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
 )
 
 var testTime = time.Date(2010, 0o1, 0o1, 0o1, 0o1, 0o1, 0, time.UTC)
@@ -64,6 +75,21 @@ var testVP9KeyFrame = []byte{
 	0x03, 0x40, 0x5f, 0xb4,
 }
 
+// 48khz, stereo, 128 bytes
+var testAC3Frame = append([]byte{
+	0x0b, 0x77, 0x00, 0x00, 0x00, 0x40, 0x40,
+}, make([]byte, 121)...)
+
+// 48khz, stereo, 6 blocks, 128 bytes
+var testEAC3Frame = append([]byte{
+	0x0b, 0x77, 0x00, 0x3f, 0x34, 0x80,
+}, make([]byte, 122)...)
+
+// MPEG-1 layer 3, 32khz, joint stereo, 144 bytes
+var testMPEG1AudioFrame = append([]byte{
+	0xff, 0xfb, 0x18, 0x64,
+}, make([]byte, 140)...)
+
 var testAACConfig = mpeg4audio.AudioSpecificConfig{
 	Type:          2,
 	SampleRate:    44100,
@@ -434,7 +460,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"NAME=\"audio2\",AUTOSELECT=YES,DEFAULT=YES,URI=\"audio2_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=436,CODECS=\"avc1.42c028,mp4a.40.2\","+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1128,AVERAGE-BANDWIDTH=660,CODECS=\"avc1.42c028,mp4a.40.2\","+
 				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
@@ -446,7 +472,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"NAME=\"audio2\",AUTOSELECT=YES,DEFAULT=YES,URI=\"audio2_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=584,CODECS=\"avc1.42c028,mp4a.40.2\","+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1152,AVERAGE-BANDWIDTH=859,CODECS=\"avc1.42c028,mp4a.40.2\","+
 				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
@@ -511,7 +537,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"LANGUAGE=\"de\",NAME=\"German\",AUTOSELECT=YES,URI=\"audio3_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=403,"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1128,AVERAGE-BANDWIDTH=505,"+
 				"CODECS=\"avc1.42c028,mp4a.40.2\",RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
@@ -663,7 +689,7 @@ func TestMuxer(t *testing.T) {
 			re := regexp.MustCompile(`^#EXTM3U\n` +
 				`#EXT-X-VERSION:10\n` +
 				`#EXT-X-TARGETDURATION:4\n` +
-				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=5\.00000,CAN-SKIP-UNTIL=24\.00000\n` +
+				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=5\.00000,CAN-SKIP-UNTIL=24\.00000,CAN-SKIP-DATERANGES=YES\n` +
 				`#EXT-X-PART-INF:PART-TARGET=2\.00000\n` +
 				`#EXT-X-MEDIA-SEQUENCE:2\n` +
 				`#EXT-X-MAP:URI="(.*?_init\.mp4\?key=value)"\n` +
@@ -698,7 +724,7 @@ func TestMuxer(t *testing.T) {
 			re := regexp.MustCompile(`^#EXTM3U\n` +
 				`#EXT-X-VERSION:10\n` +
 				`#EXT-X-TARGETDURATION:4\n` +
-				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=10\.00000,CAN-SKIP-UNTIL=24\.00000\n` +
+				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=10\.00000,CAN-SKIP-UNTIL=24\.00000,CAN-SKIP-DATERANGES=YES\n` +
 				`#EXT-X-PART-INF:PART-TARGET=4\.00000\n` +
 				`#EXT-X-MEDIA-SEQUENCE:2\n` +
 				`#EXT-X-MAP:URI="(.*?_init\.mp4\?key=value)"\n` +
@@ -732,7 +758,7 @@ func TestMuxer(t *testing.T) {
 			re := regexp.MustCompile(`^#EXTM3U\n` +
 				`#EXT-X-VERSION:10\n` +
 				`#EXT-X-TARGETDURATION:2\n` +
-				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=4\.50000,CAN-SKIP-UNTIL=12\.00000\n` +
+				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=4\.50000,CAN-SKIP-UNTIL=12\.00000,CAN-SKIP-DATERANGES=YES\n` +
 				`#EXT-X-PART-INF:PART-TARGET=1\.80000\n` +
 				`#EXT-X-MEDIA-SEQUENCE:2\n` +
 				`#EXT-X-MAP:URI="(.*?_init\.mp4\?key=value)"\n` +
@@ -797,7 +823,7 @@ func TestMuxer(t *testing.T) {
 			re := regexp.MustCompile(`^#EXTM3U\n` +
 				`#EXT-X-VERSION:10\n` +
 				`#EXT-X-TARGETDURATION:4\n` +
-				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=5\.00000,CAN-SKIP-UNTIL=24\.00000\n` +
+				`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=5\.00000,CAN-SKIP-UNTIL=24\.00000,CAN-SKIP-DATERANGES=YES\n` +
 				`#EXT-X-PART-INF:PART-TARGET=2\.00000\n` +
 				`#EXT-X-MEDIA-SEQUENCE:2\n` +
 				`#EXT-X-MAP:URI="(.*?_init\.mp4\?key=value)"\n` +
@@ -869,7 +895,13 @@ func TestMuxerCodecs(t *testing.T) {
 		"vp9",
 		"opus",
 		"mpeg4_audio",
+		"mpeg1_audio",
 		"flac",
+		"ac3",
+		"eac3",
+		"mpeg4_video",
+		"mpeg1_video",
+		"mpeg4_audio_latm",
 	} {
 		t.Run(ca, func(t *testing.T) {
 			var track *Track
@@ -904,6 +936,11 @@ func TestMuxerCodecs(t *testing.T) {
 					Codec:     &codecs.MPEG4Audio{Config: testAACConfig},
 					ClockRate: 44100,
 				}
+			case "mpeg1_audio":
+				track = &Track{
+					Codec:     &codecs.MPEG1Audio{SampleRate: 32000, ChannelCount: 2, Layer: 3},
+					ClockRate: 32000,
+				}
 			case "flac":
 				track = &Track{
 					Codec: &codecs.FLAC{
@@ -917,10 +954,43 @@ func TestMuxerCodecs(t *testing.T) {
 					},
 					ClockRate: 44100,
 				}
+			case "ac3":
+				track = &Track{
+					Codec:     &codecs.AC3{SampleRate: 48000, ChannelCount: 2},
+					ClockRate: 48000,
+				}
+			case "eac3":
+				track = &Track{
+					Codec:     &codecs.EAC3{SampleRate: 48000, ChannelCount: 2},
+					ClockRate: 48000,
+				}
+			case "mpeg4_video":
+				track = &Track{
+					Codec:     &codecs.MPEG4Video{},
+					ClockRate: 90000,
+				}
+			case "mpeg1_video":
+				track = &Track{
+					Codec:     &codecs.MPEG1Video{},
+					ClockRate: 90000,
+				}
+			case "mpeg4_audio_latm":
+				track = &Track{
+					Codec:     &codecs.MPEG4AudioLATM{},
+					ClockRate: 90000,
+				}
+			}
+
+			// these codecs can be carried by MPEG-TS only
+			isMPEGTS := ca == "mpeg4_video" || ca == "mpeg1_video" || ca == "mpeg4_audio_latm"
+
+			variant := MuxerVariantFMP4
+			if isMPEGTS {
+				variant = MuxerVariantMPEGTS
 			}
 
 			m := &Muxer{
-				Variant:            MuxerVariantFMP4,
+				Variant:            variant,
 				SegmentCount:       3,
 				SegmentMinDuration: 1 * time.Second,
 				Tracks:             []*Track{track},
@@ -975,6 +1045,35 @@ func TestMuxerCodecs(t *testing.T) {
 					testTime.Add(time.Second), 44100, []byte{0x00, 0x01, 0x02, 0x03}))
 				require.NoError(t, m.WriteFLAC(track,
 					testTime.Add(2*time.Second), 88200, []byte{0x00, 0x01, 0x02, 0x03}))
+			case "mpeg1_audio":
+				require.NoError(t, m.WriteMPEG1Audio(track, testTime, 0, [][]byte{testMPEG1AudioFrame}))
+				require.NoError(t, m.WriteMPEG1Audio(track,
+					testTime.Add(time.Second), 32000, [][]byte{testMPEG1AudioFrame}))
+				require.NoError(t, m.WriteMPEG1Audio(track,
+					testTime.Add(2*time.Second), 64000, [][]byte{testMPEG1AudioFrame}))
+			case "ac3":
+				require.NoError(t, m.WriteAC3(track, testTime, 0, testAC3Frame))
+				require.NoError(t, m.WriteAC3(track, testTime.Add(time.Second), 48000, testAC3Frame))
+				require.NoError(t, m.WriteAC3(track, testTime.Add(2*time.Second), 96000, testAC3Frame))
+			case "eac3":
+				require.NoError(t, m.WriteEAC3(track, testTime, 0, testEAC3Frame))
+				require.NoError(t, m.WriteEAC3(track, testTime.Add(time.Second), 48000, testEAC3Frame))
+				require.NoError(t, m.WriteEAC3(track, testTime.Add(2*time.Second), 96000, testEAC3Frame))
+			case "mpeg4_video":
+				frame := []byte{0, 0, 1, 0xb3, 0x00, 0x10, 0x07, 0, 0, 1, 0xb6, 0x10, 0x60}
+				require.NoError(t, m.WriteMPEG4Video(track, testTime, 0, frame))
+				require.NoError(t, m.WriteMPEG4Video(track, testTime.Add(time.Second), 90000, frame))
+				require.NoError(t, m.WriteMPEG4Video(track, testTime.Add(2*time.Second), 180000, frame))
+			case "mpeg1_video":
+				frame := []byte{0, 0, 1, 0xb8, 0x00, 0x08, 0x00, 0, 0, 1, 0x00, 0x00, 0x0f}
+				require.NoError(t, m.WriteMPEG1Video(track, testTime, 0, frame))
+				require.NoError(t, m.WriteMPEG1Video(track, testTime.Add(time.Second), 90000, frame))
+				require.NoError(t, m.WriteMPEG1Video(track, testTime.Add(2*time.Second), 180000, frame))
+			case "mpeg4_audio_latm":
+				els := [][]byte{{0x01, 0x02, 0x03}}
+				require.NoError(t, m.WriteMPEG4AudioLATM(track, testTime, 0, els))
+				require.NoError(t, m.WriteMPEG4AudioLATM(track, testTime.Add(time.Second), 90000, els))
+				require.NoError(t, m.WriteMPEG4AudioLATM(track, testTime.Add(2*time.Second), 180000, els))
 			}
 
 			var codecStr string
@@ -991,8 +1090,20 @@ func TestMuxerCodecs(t *testing.T) {
 				codecStr = "opus"
 			case "mpeg4_audio":
 				codecStr = "mp4a.40.2"
+			case "mpeg1_audio":
+				codecStr = "mp4a.40.34"
 			case "flac":
 				codecStr = "flac"
+			case "ac3":
+				codecStr = "ac-3"
+			case "eac3":
+				codecStr = "ec-3"
+			case "mpeg4_video":
+				codecStr = "mp4v.20"
+			case "mpeg1_video":
+				codecStr = "mp4v.61"
+			case "mpeg4_audio_latm":
+				codecStr = "mp4a.40"
 			}
 
 			byts, _, err := doRequest(m, "index.m3u8")
@@ -1006,6 +1117,30 @@ func TestMuxerCodecs(t *testing.T) {
 			mediaPlaylist, _, err := doRequest(m, ma[1])
 			require.NoError(t, err)
 
+			if isMPEGTS {
+				re = regexp.MustCompile(`(.*?_seg0\.ts)`)
+				ma = re.FindStringSubmatch(string(mediaPlaylist))
+				require.NotNil(t, ma)
+
+				byts, _, err = doRequest(m, ma[1])
+				require.NoError(t, err)
+
+				r := &mcmpegts.Reader{R: bytes.NewReader(byts)}
+				err = r.Initialize()
+				require.NoError(t, err)
+				require.Len(t, r.Tracks(), 1)
+
+				switch ca {
+				case "mpeg4_video":
+					require.Equal(t, &tscodecs.MPEG4Video{}, r.Tracks()[0].Codec)
+				case "mpeg1_video":
+					require.Equal(t, &tscodecs.MPEG1Video{}, r.Tracks()[0].Codec)
+				case "mpeg4_audio_latm":
+					require.Equal(t, &tscodecs.MPEG4AudioLATM{}, r.Tracks()[0].Codec)
+				}
+				return
+			}
+
 			re = regexp.MustCompile(`#EXT-X-MAP:URI="(.*?)"`)
 			ma = re.FindStringSubmatch(string(mediaPlaylist))
 			require.NotNil(t, ma)
@@ -1059,6 +1194,25 @@ func TestMuxerCodecs(t *testing.T) {
 						BitDepth:     16,
 					},
 				}, init.Tracks[0].Codec)
+			case "mpeg1_audio":
+				require.Equal(t, &mp4codecs.MPEG1Audio{
+					SampleRate:   32000,
+					ChannelCount: 2,
+				}, init.Tracks[0].Codec)
+			case "ac3":
+				require.Equal(t, &mp4codecs.AC3{
+					SampleRate:   48000,
+					ChannelCount: 2,
+					Bsid:         8,
+					Acmod:        2,
+				}, init.Tracks[0].Codec)
+			case "eac3":
+				require.Equal(t, &mp4codecs.EAC3{
+					SampleRate:   48000,
+					ChannelCount: 2,
+					DataRate:     32,
+					Acmod:        2,
+				}, init.Tracks[0].Codec)
 			}
 
 			re = regexp.MustCompile(`(.*?_seg0\.mp4)`)
@@ -1137,6 +1291,74 @@ func TestMuxerRejectsInvalidClockRate(t *testing.T) {
 	}
 }
 
+func TestMuxerMPEG1AudioLayer(t *testing.T) {
+	// MPEG-1 layer 2, 32khz, joint stereo, 144 bytes
+	mp2Frame := append([]byte{0xff, 0xfd, 0x18, 0x64}, make([]byte, 140)...)
+
+	m := &Muxer{
+		Variant: MuxerVariantMPEGTS,
+		Tracks: []*Track{{
+			Codec:     &codecs.MPEG1Audio{SampleRate: 32000, ChannelCount: 2, Layer: 1},
+			ClockRate: 32000,
+		}},
+	}
+	err := m.Start()
+	require.EqualError(t, err, "track 0 has an unsupported MPEG-1 Audio layer: 1")
+
+	for _, ca := range []string{"mpegts", "fmp4", "mpegts from frame", "fmp4 from frame"} {
+		t.Run(ca, func(t *testing.T) {
+			fromFrame := strings.HasSuffix(ca, "from frame")
+
+			// tracks provided by Client do not have a layer
+			codec := &codecs.MPEG1Audio{SampleRate: 32000, ChannelCount: 2}
+			if !fromFrame {
+				codec.Layer = 2
+			}
+
+			track := &Track{
+				Codec:     codec,
+				ClockRate: 32000,
+			}
+
+			m2 := &Muxer{
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{track},
+			}
+
+			if strings.HasPrefix(ca, "mpegts") {
+				m2.Variant = MuxerVariantMPEGTS
+			} else {
+				m2.Variant = MuxerVariantFMP4
+			}
+
+			err2 := m2.Start()
+			require.NoError(t, err2)
+			defer m2.Close()
+
+			if !fromFrame {
+				err2 = m2.WriteMPEG1Audio(track, testTime, 0, [][]byte{testMPEG1AudioFrame})
+				require.EqualError(t, err2, "frame layer (3) does not match track layer (2)")
+			}
+
+			for i := range 3 {
+				err2 = m2.WriteMPEG1Audio(track, testTime.Add(time.Duration(i)*time.Second),
+					int64(i)*32000, [][]byte{mp2Frame})
+				require.NoError(t, err2)
+			}
+
+			require.Equal(t, 2, codec.Layer)
+
+			err2 = m2.WriteMPEG1Audio(track, testTime.Add(3*time.Second), 3*32000, [][]byte{testMPEG1AudioFrame})
+			require.EqualError(t, err2, "frame layer (3) does not match track layer (2)")
+
+			byts, _, err2 := doRequest(m2, "index.m3u8")
+			require.NoError(t, err2)
+			require.Contains(t, string(byts), `CODECS="mp4a.40.33"`)
+		})
+	}
+}
+
 func TestMuxerMPEGTSAACResync(t *testing.T) {
 	track := &Track{
 		Codec:     &codecs.MPEG4Audio{Config: testAACConfig},
@@ -1797,166 +2019,1636 @@ func TestMuxerKLVExceedsSegmentMaxSize(t *testing.T) {
 	require.Contains(t, err.Error(), "maximum segment size")
 }
 
-func TestMuxerCloseBeforeData(t *testing.T) {
-	m := &Muxer{
-		Variant:            MuxerVariantFMP4,
-		SegmentCount:       3,
-		SegmentMinDuration: 1 * time.Second,
-		Tracks:             []*Track{testVideoTrack},
-	}
+func TestMuxerID3(t *testing.T) {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 'P', 'R', 'I', 'V', 0}
 
-	err := m.Start()
-	require.NoError(t, err)
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			id3Track := &Track{
+				Codec:     &codecs.ID3{},
+				ClockRate: 90000,
+			}
 
-	m.Close()
+			m := &Muxer{
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack, id3Track},
+			}
 
-	b, _, _ := doRequest(m, "index.m3u8")
-	require.Equal(t, []byte(nil), b)
+			if ca == "mpegts" {
+				m.Variant = MuxerVariantMPEGTS
+			} else {
+				m.Variant = MuxerVariantFMP4
+			}
 
-	b, _, _ = doRequest(m, "video1_stream.m3u8")
-	require.Equal(t, []byte(nil), b)
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
 
-	b, _, _ = doRequest(m, m.prefix+"_init.mp4")
-	require.Equal(t, []byte(nil), b)
-}
+			for i := range 4 {
+				d := time.Duration(i) * time.Second
 
-func TestMuxerCloseWhileRequestStream(t *testing.T) {
-	m := &Muxer{
-		Variant:            MuxerVariantFMP4,
-		SegmentCount:       3,
-		SegmentMinDuration: 1 * time.Second,
-		Tracks:             []*Track{testVideoTrack},
-	}
+				err = m.WriteH264(testVideoTrack, testTime.Add(d), int64(i)*90000, [][]byte{
+					testH264SPS,
+					{8}, // PPS
+					{5}, // IDR
+				})
+				require.NoError(t, err)
 
-	err := m.Start()
-	require.NoError(t, err)
+				err = m.WriteID3(id3Track, testTime.Add(d), int64(i)*90000+45000, tag)
+				require.NoError(t, err)
+			}
 
-	done := make(chan struct{})
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
 
-	go func() {
-		b, _, _ := doRequest(m, "video1_stream.m3u8")
-		require.Equal(t, []byte(nil), b)
-		close(done)
-	}()
+			if ca == "mpegts" {
+				require.Contains(t, string(byts), "main_stream.m3u8")
 
-	time.Sleep(100 * time.Millisecond)
+				byts, _, err = doRequest(m, "main_stream.m3u8")
+				require.NoError(t, err)
+			} else {
+				require.NotContains(t, string(byts), "audio")
 
-	m.Close()
-	<-done
+				byts, _, err = doRequest(m, "video1_stream.m3u8")
+				require.NoError(t, err)
+			}
+
+			// with fMP4, samples are written with a delay of one sample,
+			// therefore the first tag is written before the first segment and is discarded.
+			var ma []string
+			if ca == "mpegts" {
+				ma = regexp.MustCompile(`\n(.*?_seg0\.ts)\n`).FindStringSubmatch(string(byts))
+			} else {
+				ma = regexp.MustCompile(`\n(.*?_seg1\.mp4)\n`).FindStringSubmatch(string(byts))
+			}
+			require.NotNil(t, ma)
+
+			seg, _, err := doRequest(m, ma[1])
+			require.NoError(t, err)
+
+			if ca == "mpegts" {
+				dem := astits.NewDemuxer(context.Background(), bytes.NewReader(seg))
+				var id3PID uint16
+
+				for {
+					data, err2 := dem.NextData()
+					require.NoError(t, err2)
+
+					if data.PMT != nil {
+						for _, es := range data.PMT.ElementaryStreams {
+							if es.StreamType == astits.StreamTypeMetadata {
+								id3PID = es.ElementaryPID
+							}
+						}
+						require.NotZero(t, id3PID)
+						require.Len(t, data.PMT.ProgramDescriptors, 1)
+						require.True(t, mpegtsIsID3Stream(uint8(astits.StreamTypeMetadata), append(
+							[]byte{0x26, 13}, data.PMT.ElementaryStreams[1].ElementaryStreamDescriptors[0].Unknown.Content...)))
+					}
+
+					if data.PES != nil && data.PID == id3PID {
+						require.Equal(t, uint8(0xbd), data.PES.Header.StreamID)
+						require.Equal(t, int64(45000), data.PES.Header.OptionalHeader.PTS.Base)
+						require.Equal(t, tag, data.PES.Data)
+						break
+					}
+				}
+			} else {
+				emsgs, err2 := fmp4ReadID3Emsgs(seg, 0, 1)
+				require.NoError(t, err2)
+				require.Equal(t, []*fmp4Emsg{{
+					schemeIDURI:      fmp4EmsgSchemeID3,
+					timeScale:        90000,
+					presentationTime: uint64(90000 + 45000 + 10*90000),
+					eventDuration:    0xffffffff,
+					messageData:      tag,
+				}}, emsgs)
+
+				var parts fmp4.Parts
+				err2 = parts.Unmarshal(seg)
+				require.NoError(t, err2)
+				require.Len(t, parts, 1)
+			}
+		})
+	}
 }
 
-func TestMuxerMaxSegmentSize(t *testing.T) {
-	m := &Muxer{
-		Variant:            MuxerVariantMPEGTS,
-		SegmentCount:       3,
-		SegmentMinDuration: 1 * time.Second,
-		SegmentMaxSize:     1,
-		Tracks:             []*Track{testVideoTrack},
+func TestMuxerID3Errors(t *testing.T) {
+	id3Track := &Track{
+		Codec:     &codecs.ID3{},
+		ClockRate: 90000,
 	}
 
+	m := &Muxer{
+		Variant: MuxerVariantMPEGTS,
+		Tracks:  []*Track{id3Track},
+	}
 	err := m.Start()
+	require.EqualError(t, err, "ID3 tracks require at least one video or audio track")
+
+	m = &Muxer{
+		Variant: MuxerVariantFMP4,
+		Tracks: []*Track{testVideoTrack, id3Track, {
+			Codec:     &codecs.ID3{},
+			ClockRate: 90000,
+		}},
+	}
+	err = m.Start()
+	require.EqualError(t, err, "multiple ID3 tracks are not supported")
+
+	m = &Muxer{
+		Variant: MuxerVariantFMP4,
+		Tracks:  []*Track{testVideoTrack, id3Track},
+	}
+	err = m.Start()
 	require.NoError(t, err)
 	defer m.Close()
 
-	err = m.WriteH264(
-		testVideoTrack,
-		testTime,
-		int64(2*time.Second)*int64(testVideoTrack.ClockRate)/int64(time.Second),
-		[][]byte{
-			testH264SPS,
-			{5}, // IDR
-		})
-	require.EqualError(t, err, "reached maximum segment size")
+	err = m.WriteID3(testVideoTrack, testTime, 0, []byte{1})
+	require.EqualError(t, err, "WriteID3 called with a non-ID3 track")
 }
 
-func TestMuxerDoubleRead(t *testing.T) {
-	m := &Muxer{
-		Variant:            MuxerVariantMPEGTS,
-		SegmentCount:       3,
-		SegmentMinDuration: 1 * time.Second,
-		Tracks:             []*Track{testVideoTrack},
-	}
+func TestMuxerID3PMTErrors(t *testing.T) {
+	videoTrack := &mcmpegts.Track{Codec: &tscodecs.H264{}}
+	id3Track := &mcmpegts.Track{Codec: &tscodecs.DVBSubtitle{}}
 
-	err := m.Start()
+	var buf bytes.Buffer
+	w := &mcmpegts.Writer{W: &buf, Tracks: []*mcmpegts.Track{videoTrack, id3Track}}
+	err := w.Initialize()
 	require.NoError(t, err)
-	defer m.Close()
 
-	err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
-		testH264SPS,
-		{5}, // IDR
-		{1},
-	})
+	err = w.WriteH264(videoTrack, 90000, 90000, [][]byte{testH264SPS, {8}, {5}})
 	require.NoError(t, err)
 
-	err = m.WriteH264(
-		testVideoTrack,
-		testTime,
-		int64(2*time.Second)*int64(testVideoTrack.ClockRate)/int64(time.Second),
-		[][]byte{
-			{5}, // IDR
-			{2},
+	pat := buf.Bytes()[:mpegtsPacketSize]
+	pmt := buf.Bytes()[mpegtsPacketSize : 2*mpegtsPacketSize]
+
+	for _, ca := range []struct {
+		name   string
+		pids   []uint16
+		change func(pkt []byte)
+		err    string
+	}{
+		{
+			"crc mismatch",
+			[]uint16{id3Track.PID},
+			func(pkt []byte) {
+				start, _, _ := mpegtsSection(pkt)
+				pkt[start+5] ^= 0x02 // version_number
+			},
+			"PMT CRC mismatch",
+		},
+		{
+			"missing pid",
+			[]uint16{id3Track.PID, 300},
+			nil,
+			"PID 300 not found in PMT",
+		},
+		{
+			"unexpected stream type",
+			[]uint16{videoTrack.PID},
+			nil,
+			fmt.Sprintf("unexpected stream type of PID %d: 0x1b", videoTrack.PID),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pkt := append([]byte(nil), pmt...)
+			if ca.change != nil {
+				ca.change(pkt)
+			}
+
+			var out bytes.Buffer
+			w2 := &mpegtsID3Writer{w: &out, tracks: make([]*mcmpegts.Track, len(ca.pids))}
+			for i, pid := range ca.pids {
+				w2.tracks[i] = &mcmpegts.Track{PID: pid}
+			}
+			w2.initialize()
+
+			_, err2 := w2.Write(append(append([]byte(nil), pat...), pkt...))
+			require.EqualError(t, err2, ca.err)
 		})
-	require.NoError(t, err)
+	}
 
-	byts, _, err := doRequest(m, "main_stream.m3u8")
+	var out bytes.Buffer
+	w2 := &mpegtsID3Writer{w: &out, tracks: []*mcmpegts.Track{id3Track}}
+	w2.initialize()
+
+	_, err = w2.Write(append(append([]byte(nil), pat...), pmt...))
 	require.NoError(t, err)
 
-	re := regexp.MustCompile(`^#EXTM3U\n` +
-		`#EXT-X-VERSION:3\n` +
-		`#EXT-X-ALLOW-CACHE:NO\n` +
-		`#EXT-X-TARGETDURATION:2\n` +
-		`#EXT-X-MEDIA-SEQUENCE:0\n` +
-		`#EXT-X-PROGRAM-DATE-TIME:(.*?)\n` +
-		`#EXTINF:2.00000,\n` +
-		`(.*?_seg0\.ts)\n$`)
-	require.Regexp(t, re, string(byts))
-	ma := re.FindStringSubmatch(string(byts))
+	pids := mpegtsID3PIDs(out.Bytes())
+	require.Equal(t, map[uint16]struct{}{id3Track.PID: {}}, pids)
+}
 
-	byts1, _, err := doRequest(m, ma[2])
+// mpegtsDeclareID3Streams edits PMTs generated by mpegts.Writer.
+// This checks that their layout is the expected one.
+func TestMuxerID3PMTLayout(t *testing.T) {
+	videoTrack := &mcmpegts.Track{Codec: &tscodecs.H264{}}
+	id3Track := &mcmpegts.Track{Codec: &tscodecs.DVBSubtitle{}}
+
+	var buf bytes.Buffer
+	w := &mcmpegts.Writer{W: &buf, Tracks: []*mcmpegts.Track{videoTrack, id3Track}}
+	err := w.Initialize()
 	require.NoError(t, err)
 
-	byts2, _, err := doRequest(m, ma[2])
+	err = w.WriteH264(videoTrack, 90000, 90000, [][]byte{testH264SPS, {8}, {5}})
 	require.NoError(t, err)
-	require.Equal(t, byts1, byts2)
-}
 
-func TestMuxerSaveToDisk(t *testing.T) {
-	for _, ca := range []string{
-		"mpegts",
-		"mp4",
-	} {
-		t.Run(ca, func(t *testing.T) {
-			dir, err := os.MkdirTemp("", "gohlslib")
-			require.NoError(t, err)
-			defer os.RemoveAll(dir)
+	// PAT and PMT are written at the beginning, in a packet each.
+	pat := buf.Bytes()[:mpegtsPacketSize]
+	pmt := buf.Bytes()[mpegtsPacketSize : 2*mpegtsPacketSize]
 
-			var v MuxerVariant
-			if ca == "mpegts" {
-				v = MuxerVariantMPEGTS
-			} else {
-				v = MuxerVariantFMP4
-			}
+	var r mpegtsTableReader
+	r.initialize()
 
-			m := &Muxer{
-				Variant:            v,
-				SegmentCount:       3,
-				SegmentMinDuration: 1 * time.Second,
-				Tracks:             []*Track{testVideoTrack},
-				Directory:          dir,
-			}
+	start, _, err := r.readPacket(pat)
+	require.NoError(t, err)
+	require.Equal(t, 0, start)
+	require.Len(t, r.pmtPIDs, 1)
 
-			err = m.Start()
-			require.NoError(t, err)
+	start, end, err := r.readPacket(pmt)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, start)
 
-			err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
-				testH264SPS,
-				{5}, // IDR
-				{1},
-			})
-			require.NoError(t, err)
+	section := pmt[start:end]
+	require.Equal(t, uint16(0), binary.BigEndian.Uint16(section[10:])&0x0fff) // program_info_length
 
-			err = m.WriteH264(testVideoTrack, testTime, 2*90000, [][]byte{
-				{5}, // IDR
+	streams, err := mpegtsPMTStreams(section)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{
+		{0x1b, 0xe0 | byte(videoTrack.PID>>8), byte(videoTrack.PID), 0xf0, 0x00},
+		{0x06, 0xe0 | byte(id3Track.PID>>8), byte(id3Track.PID), 0xf0, 0x02, 0x59, 0x00}, // subtitling_descriptor
+	}, streams)
+
+	err = mpegtsDeclareID3Streams(pmt, start, end, []uint16{id3Track.PID})
+	require.NoError(t, err)
+
+	pids := mpegtsID3PIDs(buf.Bytes())
+	require.Equal(t, map[uint16]struct{}{id3Track.PID: {}}, pids)
+}
+
+func TestMuxerMultipleVideoVariants(t *testing.T) {
+	testH264SPS720p := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+
+	for _, variant := range []string{"fmp4", "lowLatency"} {
+		t.Run(variant, func(t *testing.T) {
+			videoTrack1 := &Track{
+				Codec: &codecs.H264{
+					SPS: testH264SPS,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}
+
+			videoTrack2 := &Track{
+				Codec: &codecs.H264{
+					SPS: testH264SPS720p,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}
+
+			m := &Muxer{
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack1, videoTrack2, testAudioTrack},
+			}
+
+			if variant == "fmp4" {
+				m.Variant = MuxerVariantFMP4
+				m.SegmentCount = 3
+			} else {
+				m.Variant = MuxerVariantLowLatency
+				m.SegmentCount = 7
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 4 {
+				d := time.Duration(i) * time.Second
+				pts := int64(d) * 90000 / int64(time.Second)
+
+				for _, track := range []*Track{videoTrack1, videoTrack2} {
+					err = m.WriteH264(track, testTime.Add(d), pts, [][]byte{
+						track.Codec.(*codecs.H264).SPS,
+						{8},                                  // PPS
+						{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, // IDR
+					})
+					require.NoError(t, err)
+				}
+
+				err = m.WriteMPEG4Audio(testAudioTrack, testTime.Add(d),
+					int64(d)*int64(testAudioTrack.ClockRate)/int64(time.Second),
+					[][]byte{{1, 2, 3, 4}})
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+
+			require.Regexp(t, `^#EXTM3U\n`+
+				`#EXT-X-VERSION:\d+\n`+
+				`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+				`\n`+
+				`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="audio3",AUTOSELECT=YES,DEFAULT=YES,URI="audio3_stream.m3u8"\n`+
+				`\n`+
+				`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028,mp4a.40.2",`+
+				`RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO="audio"\n`+
+				`video1_stream.m3u8\n`+
+				`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.64001f,mp4a.40.2",`+
+				`RESOLUTION=1280x720,FRAME-RATE=30.000,AUDIO="audio"\n`+
+				`video2_stream.m3u8\n$`, string(byts))
+
+			if variant == "lowLatency" {
+				byts, _, err = doRequest(m, "video1_stream.m3u8")
+				require.NoError(t, err)
+				require.Regexp(t, `#EXT-X-RENDITION-REPORT:URI="video2_stream.m3u8",LAST-MSN=\d+,LAST-PART=\d+\n$`,
+					string(byts))
+
+				byts, _, err = doRequest(m, "video2_stream.m3u8")
+				require.NoError(t, err)
+				require.Regexp(t, `#EXT-X-RENDITION-REPORT:URI="video1_stream.m3u8",LAST-MSN=\d+,LAST-PART=\d+\n$`,
+					string(byts))
+			}
+		})
+	}
+}
+
+func TestMuxerMultipleVideoVariantsAlignment(t *testing.T) {
+	for _, ca := range []string{"aligned", "misaligned"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack1 := &Track{
+				Codec: &codecs.H264{
+					SPS: testH264SPS,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}
+
+			videoTrack2 := &Track{
+				Codec: &codecs.H264{
+					SPS: testH264SPS,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}
+
+			m := &Muxer{
+				Variant:            MuxerVariantFMP4,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack1, videoTrack2},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			// the second variant receives a random access frame two frames after the first one
+			isIDR := func(track *Track, i int) bool {
+				if ca == "misaligned" && track == videoTrack2 && i != 0 {
+					return i%10 == 2
+				}
+				return i%10 == 0
+			}
+
+			for i := range 40 {
+				d := time.Duration(i) * 100 * time.Millisecond
+
+				for _, track := range []*Track{videoTrack1, videoTrack2} {
+					au := [][]byte{{1}} // non-IDR
+					if isIDR(track, i) {
+						au = [][]byte{testH264SPS, {8}, {5}} // IDR
+					}
+
+					err = m.WriteH264(track, testTime.Add(d), int64(d)*90000/int64(time.Second), au)
+
+					if ca == "misaligned" && track == videoTrack2 && i == 11 {
+						require.EqualError(t, err, "random access samples of video variants are not aligned")
+						return
+					}
+					require.NoError(t, err)
+				}
+			}
+
+			byts, _, err := doRequest(m, "video2_stream.m3u8")
+			require.NoError(t, err)
+
+			var pl playlist.Media
+			err = pl.Unmarshal(byts)
+			require.NoError(t, err)
+			require.Len(t, pl.Segments, 3)
+
+			// segments of the second variant start with a random access frame
+			for _, seg := range pl.Segments {
+				byts, _, err = doRequest(m, seg.URI)
+				require.NoError(t, err)
+
+				var parts fmp4.Parts
+				err = parts.Unmarshal(byts)
+				require.NoError(t, err)
+
+				require.False(t, parts[0].Tracks[0].Samples[0].IsNonSyncSample)
+				require.Len(t, parts[0].Tracks[0].Samples, 10)
+			}
+		})
+	}
+}
+
+func TestMuxerSubtitles(t *testing.T) {
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack := &Track{
+				Codec: &codecs.H264{
+					SPS: testH264SPS,
+					PPS: testH264PPS,
+				},
+				ClockRate: 90000,
+			}
+
+			subtitleTrack := &Track{
+				Codec:     &codecs.WebVTT{},
+				ClockRate: 1000,
+				Name:      "English",
+				Language:  "en",
+				IsDefault: true,
+			}
+
+			m := &Muxer{
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack, subtitleTrack},
+			}
+
+			if ca == "mpegts" {
+				m.Variant = MuxerVariantMPEGTS
+			} else {
+				m.Variant = MuxerVariantFMP4
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			// cues can be written before the first segment
+			err = m.WriteSubtitle(subtitleTrack, 0, 1500, "first\n\ncue")
+			require.NoError(t, err)
+
+			for i := range 4 {
+				d := time.Duration(i) * time.Second
+
+				if i == 2 {
+					err = m.WriteSubtitle(subtitleTrack, 2500, 3000, "second cue")
+					require.NoError(t, err)
+				}
+
+				err = m.WriteH264(videoTrack, testTime.Add(d), int64(i)*90000, [][]byte{
+					testH264SPS,
+					{8},                                  // PPS
+					{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, // IDR
+				})
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+
+			if ca == "mpegts" {
+				require.Regexp(t, `^#EXTM3U\n`+
+					`#EXT-X-VERSION:3\n`+
+					`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+					`\n`+
+					`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subtitles",LANGUAGE="en",NAME="English",`+
+					`AUTOSELECT=YES,DEFAULT=YES,URI="subtitles2_stream.m3u8"\n`+
+					`\n`+
+					`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+					`RESOLUTION=1920x1080,FRAME-RATE=30.000,SUBTITLES="subtitles"\n`+
+					`main_stream.m3u8\n$`, string(byts))
+			} else {
+				require.Regexp(t, `^#EXTM3U\n`+
+					`#EXT-X-VERSION:10\n`+
+					`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+					`\n`+
+					`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subtitles",LANGUAGE="en",NAME="English",`+
+					`AUTOSELECT=YES,DEFAULT=YES,URI="subtitles2_stream.m3u8"\n`+
+					`\n`+
+					`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+					`RESOLUTION=1920x1080,FRAME-RATE=30.000,SUBTITLES="subtitles"\n`+
+					`video1_stream.m3u8\n$`, string(byts))
+			}
+
+			byts, _, err = doRequest(m, "subtitles2_stream.m3u8")
+			require.NoError(t, err)
+
+			re := regexp.MustCompile(`^#EXTM3U\n` +
+				`#EXT-X-VERSION:\d+\n` +
+				`#EXT-X-TARGETDURATION:1\n` +
+				`#EXT-X-MEDIA-SEQUENCE:0\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:.+?\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.+?_seg0\.vtt)\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:.+?\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.+?_seg1\.vtt)\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:.+?\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.+?_seg2\.vtt)\n$`)
+			ma := re.FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			var timestampMap string
+			if ca == "mpegts" {
+				timestampMap = "X-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000\n"
+			} else {
+				timestampMap = "X-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n"
+			}
+
+			byts, h, err := doRequest(m, ma[1])
+			require.NoError(t, err)
+			require.Equal(t, "text/vtt", h.Get("Content-Type"))
+			require.Equal(t, "WEBVTT\n"+
+				timestampMap+
+				"\n"+
+				"00:00:00.000 --> 00:00:01.500\n"+
+				"first\n"+
+				"cue\n", string(byts))
+
+			byts, _, err = doRequest(m, ma[2])
+			require.NoError(t, err)
+			require.Equal(t, "WEBVTT\n"+
+				timestampMap+
+				"\n"+
+				"00:00:00.000 --> 00:00:01.500\n"+
+				"first\n"+
+				"cue\n", string(byts))
+
+			byts, _, err = doRequest(m, ma[3])
+			require.NoError(t, err)
+			require.Equal(t, "WEBVTT\n"+
+				timestampMap+
+				"\n"+
+				"00:00:02.500 --> 00:00:03.000\n"+
+				"second cue\n", string(byts))
+		})
+	}
+}
+
+func TestMuxerSubtitlesErrors(t *testing.T) {
+	subtitleTrack := &Track{
+		Codec:     &codecs.WebVTT{},
+		ClockRate: 1000,
+	}
+
+	m := &Muxer{
+		Variant: MuxerVariantMPEGTS,
+		Tracks:  []*Track{subtitleTrack},
+	}
+	err := m.Start()
+	require.EqualError(t, err, "subtitle tracks require at least one video or audio track")
+
+	m = &Muxer{
+		Variant: MuxerVariantMPEGTS,
+		Tracks:  []*Track{testVideoTrack, subtitleTrack},
+	}
+	err = m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteSubtitle(subtitleTrack, 1000, 1000, "test")
+	require.EqualError(t, err, "cue end must be greater than cue start")
+
+	err = m.WriteSubtitle(subtitleTrack, 0, 1000, "a --> b")
+	require.EqualError(t, err, "cue text cannot contain '-->'")
+
+	err = m.WriteSubtitle(testVideoTrack, 0, 1000, "test")
+	require.EqualError(t, err, "WriteSubtitle called with a non-WebVTT track")
+}
+
+func TestMuxerClosedCaptions(t *testing.T) {
+	cc1 := &MuxerClosedCaptions{
+		InstreamID: "CC1",
+		Name:       "English",
+		Language:   "en",
+		IsDefault:  true,
+	}
+
+	service1 := &MuxerClosedCaptions{
+		InstreamID: "SERVICE1",
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		ClosedCaptions:     []*MuxerClosedCaptions{cc1, service1},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteClosedCaptions(cc1, 0, "Hello, World!\nÀ bientôt ♪")
+	require.NoError(t, err)
+
+	err = m.WriteClosedCaptions(service1, 0, "Hi there…")
+	require.NoError(t, err)
+
+	err = m.WriteClosedCaptions(cc1, 15000, "")
+	require.NoError(t, err)
+
+	err = m.WriteClosedCaptionsData(15000, []byte{0xfa, 0x00, 0x00})
+	require.NoError(t, err)
+
+	err = m.WriteClosedCaptionsData(15000, []byte{0xfa, 0x00})
+	require.EqualError(t, err, "closed caption data size must be a multiple of 3")
+
+	for i := range 40 {
+		err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second/30), int64(i)*3000, [][]byte{
+			testH264SPS,
+			{8},                                  // PPS
+			{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, // IDR
+		})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:3\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`\n`+
+		`#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",LANGUAGE="en",NAME="English",`+
+		`AUTOSELECT=YES,DEFAULT=YES,INSTREAM-ID="CC1"\n`+
+		`#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="SERVICE1",`+
+		`AUTOSELECT=YES,INSTREAM-ID="SERVICE1"\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+		`RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS="cc"\n`+
+		`main_stream.m3u8\n$`, string(byts))
+
+	byts, _, err = doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile(`(.*?_seg0\.ts)`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	segmentData, _, err := doRequest(m, ma[1])
+	require.NoError(t, err)
+
+	r := &mcmpegts.Reader{R: bytes.NewReader(segmentData)}
+	err = r.Initialize()
+	require.NoError(t, err)
+
+	type caption struct {
+		pts  int64
+		text string
+	}
+
+	var cc1Captions []caption
+	var service1Captions []caption
+
+	e := &clientCaptionExtractor{}
+	e.initialize()
+	e.callbacks[captionsInstreamID{channel: 1}] = func(pts int64, text string) {
+		cc1Captions = append(cc1Captions, caption{pts, text})
+	}
+	e.callbacks[captionsInstreamID{service: 1}] = func(pts int64, text string) {
+		service1Captions = append(service1Captions, caption{pts, text})
+	}
+
+	r.OnDataH264(r.Tracks()[0], func(pts int64, dts int64, au [][]byte) error {
+		e.process(pts, dts, au)
+		return nil
+	})
+
+	for {
+		err = r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []caption{
+		{0, "Hello, World!\nÀ bientôt ♪"},
+		{15000, ""},
+	}, cc1Captions)
+
+	require.Equal(t, []caption{
+		{3000, "Hi there…"},
+	}, service1Captions)
+}
+
+func TestMuxerClosedCaptionsErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		tracks []*Track
+		cc     []*MuxerClosedCaptions
+		err    string
+	}{
+		{
+			"invalid instream id",
+			[]*Track{testVideoTrack},
+			[]*MuxerClosedCaptions{{InstreamID: "CC5"}},
+			"invalid INSTREAM-ID: CC5",
+		},
+		{
+			"duplicate instream id",
+			[]*Track{testVideoTrack},
+			[]*MuxerClosedCaptions{{InstreamID: "CC1"}, {InstreamID: "CC1"}},
+			"closed captions with INSTREAM-ID CC1 are defined multiple times",
+		},
+		{
+			"multiple defaults",
+			[]*Track{testVideoTrack},
+			[]*MuxerClosedCaptions{{InstreamID: "CC1", IsDefault: true}, {InstreamID: "CC2", IsDefault: true}},
+			"multiple default closed captions are not supported",
+		},
+		{
+			"no video",
+			[]*Track{testAudioTrack},
+			[]*MuxerClosedCaptions{{InstreamID: "CC1"}},
+			"closed captions require a H264 or H265 video track",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:        MuxerVariantMPEGTS,
+				Tracks:         ca.tracks,
+				ClosedCaptions: ca.cc,
+			}
+			err := m.Start()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMuxerSessionData(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		SessionData: []*MuxerSessionData{
+			{
+				ID:       "com.example.title",
+				Value:    "Example title",
+				Language: "en",
+			},
+			{
+				ID:   "com.example.channel",
+				JSON: []byte(`{"channel_id":123}`),
+			},
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 2 {
+		err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second), int64(i)*90000, [][]byte{
+			testH264SPS,
+			{8}, // PPS
+			{5}, // IDR
+		})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:3\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="Example title",LANGUAGE="en"\n`+
+		`#EXT-X-SESSION-DATA:DATA-ID="com.example.channel",URI=".*?_session1\.json"\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+		`RESOLUTION=1920x1080,FRAME-RATE=30.000\n`+
+		`main_stream.m3u8\n$`, string(byts))
+
+	ma := regexp.MustCompile(`URI="(.*?_session1\.json)"`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	byts, h, err := doRequest(m, ma[1])
+	require.NoError(t, err)
+	require.Equal(t, "application/json", h.Get("Content-Type"))
+	require.Equal(t, `{"channel_id":123}`, string(byts))
+}
+
+func TestMuxerSessionDataErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		sd   []*MuxerSessionData
+		err  string
+	}{
+		{
+			"missing id",
+			[]*MuxerSessionData{{Value: "a"}},
+			"session data ID is missing",
+		},
+		{
+			"invalid characters",
+			[]*MuxerSessionData{{ID: "a", Value: "b\"c"}},
+			"session data 'a' contains invalid characters",
+		},
+		{
+			"value and json",
+			[]*MuxerSessionData{{ID: "a", Value: "b", JSON: []byte("{}")}},
+			"session data 'a' cannot have both Value and JSON",
+		},
+		{
+			"invalid json",
+			[]*MuxerSessionData{{ID: "a", JSON: []byte("{")}},
+			"session data 'a' contains an invalid JSON document",
+		},
+		{
+			"duplicate",
+			[]*MuxerSessionData{{ID: "a", Value: "b"}, {ID: "a", Value: "c"}},
+			"session data 'a' is defined twice with the same language",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:     MuxerVariantMPEGTS,
+				Tracks:      []*Track{testVideoTrack},
+				SessionData: ca.sd,
+			}
+			err := m.Start()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMuxerContentSteering(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		ContentSteering: &MuxerContentSteering{
+			Pathways: []*MuxerPathway{
+				{
+					ID:      "CDN-A",
+					BaseURL: "https://cdn-a.example.com/mystream/",
+				},
+				{
+					ID: "ORIGIN",
+				},
+			},
+			TTL: 10 * time.Second,
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 2 {
+		err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second), int64(i)*90000, [][]byte{
+			testH264SPS,
+			{8}, // PPS
+			{5}, // IDR
+		})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:3\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`#EXT-X-CONTENT-STEERING:SERVER-URI=".*?_steering\.json",PATHWAY-ID="CDN-A"\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+		`RESOLUTION=1920x1080,FRAME-RATE=30.000,PATHWAY-ID="CDN-A"\n`+
+		`https://cdn-a.example.com/mystream/main_stream.m3u8\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=\d+,AVERAGE-BANDWIDTH=\d+,CODECS="avc1.42c028",`+
+		`RESOLUTION=1920x1080,FRAME-RATE=30.000,PATHWAY-ID="ORIGIN"\n`+
+		`main_stream.m3u8\n$`, string(byts))
+
+	ma := regexp.MustCompile(`SERVER-URI="(.*?_steering\.json)"`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	byts, h, err := doRequest(m, ma[1])
+	require.NoError(t, err)
+	require.Equal(t, "application/json", h.Get("Content-Type"))
+	require.Equal(t, `{"VERSION":1,"TTL":10,"PATHWAY-PRIORITY":["CDN-A","ORIGIN"]}`, string(byts))
+
+	err = m.SetPathwayPriority([]string{"ORIGIN"})
+	require.NoError(t, err)
+
+	byts, _, err = doRequest(m, ma[1])
+	require.NoError(t, err)
+	require.Equal(t, `{"VERSION":1,"TTL":10,"PATHWAY-PRIORITY":["ORIGIN"]}`, string(byts))
+
+	byts, _, err = doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "PATHWAY-ID=\"ORIGIN\"\n\n")
+
+	err = m.SetPathwayPriority([]string{"CDN-B"})
+	require.EqualError(t, err, "pathway 'CDN-B' not found")
+
+	err = m.SetPathwayPriority([]string{"ORIGIN", "ORIGIN"})
+	require.EqualError(t, err, "pathway 'ORIGIN' is listed twice")
+}
+
+func TestMuxerContentSteeringErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		steering *MuxerContentSteering
+		err      string
+	}{
+		{
+			"no pathways",
+			&MuxerContentSteering{},
+			"content steering requires at least one pathway",
+		},
+		{
+			"invalid id",
+			&MuxerContentSteering{Pathways: []*MuxerPathway{{ID: "CDN A"}}},
+			"invalid pathway ID: 'CDN A'",
+		},
+		{
+			"duplicate id",
+			&MuxerContentSteering{Pathways: []*MuxerPathway{{ID: "A"}, {ID: "A"}}},
+			"pathway 'A' is defined twice",
+		},
+		{
+			"invalid base url",
+			&MuxerContentSteering{Pathways: []*MuxerPathway{{ID: "A", BaseURL: "/mystream/"}}},
+			"invalid base URL of pathway 'A': '/mystream/'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:         MuxerVariantMPEGTS,
+				Tracks:          []*Track{testVideoTrack},
+				ContentSteering: ca.steering,
+			}
+			err := m.Start()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMuxerDateRanges(t *testing.T) {
+	t.Run("segment boundary", func(t *testing.T) {
+		m := &Muxer{
+			Variant:            MuxerVariantMPEGTS,
+			SegmentCount:       3,
+			SegmentMinDuration: 1 * time.Second,
+			Tracks:             []*Track{testVideoTrack},
+		}
+
+		err := m.Start()
+		require.NoError(t, err)
+		defer m.Close()
+
+		err = m.WriteDateRange(&MuxerDateRange{
+			ID:               "ad1",
+			Class:            "com.example.ad",
+			Start:            testTime.Add(1500 * time.Millisecond),
+			PlannedDuration:  ptrOf(1 * time.Second),
+			ClientAttributes: map[string]string{"X-AD-ID": "1234"},
+			SCTE35Out:        []byte{0xfc, 0x30, 0x11},
+			SegmentBoundary:  true,
+		})
+		require.NoError(t, err)
+
+		for i := range 7 {
+			err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*500*time.Millisecond), int64(i)*45000,
+				[][]byte{
+					testH264SPS,
+					{8}, // PPS
+					{5}, // IDR
+				})
+			require.NoError(t, err)
+		}
+
+		// duration is known at the end of the ad
+		err = m.WriteDateRange(&MuxerDateRange{
+			ID:               "ad1",
+			Class:            "com.example.ad",
+			Start:            testTime.Add(1500 * time.Millisecond),
+			Duration:         ptrOf(1 * time.Second),
+			PlannedDuration:  ptrOf(1 * time.Second),
+			ClientAttributes: map[string]string{"X-AD-ID": "1234"},
+			SCTE35Out:        []byte{0xfc, 0x30, 0x11},
+			SCTE35In:         []byte{0xfc, 0x30, 0x12},
+		})
+		require.NoError(t, err)
+
+		byts, _, err := doRequest(m, "main_stream.m3u8")
+		require.NoError(t, err)
+		require.Regexp(t, `^#EXTM3U\n`+
+			`#EXT-X-VERSION:3\n`+
+			`#EXT-X-ALLOW-CACHE:NO\n`+
+			`#EXT-X-TARGETDURATION:1\n`+
+			`#EXT-X-MEDIA-SEQUENCE:0\n`+
+			`#EXT-X-DATERANGE:ID="ad1",CLASS="com.example.ad",START-DATE="2010-01-01T01:01:02.5Z",`+
+			`DURATION=1.00000,PLANNED-DURATION=1.00000,X-AD-ID="1234",SCTE35-OUT=0xFC3011,SCTE35-IN=0xFC3012\n`+
+			`#EXT-X-PROGRAM-DATE-TIME:.*?\n`+
+			`#EXTINF:1.00000,\n`+
+			`.*?_seg0\.ts\n`+
+			`#EXT-X-PROGRAM-DATE-TIME:.*?\n`+
+			`#EXTINF:0.50000,\n`+
+			`.*?_seg1\.ts\n`+
+			`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02.5Z\n`+
+			`#EXTINF:1.00000,\n`+
+			`.*?_seg2\.ts\n$`, string(byts))
+
+		err = m.WriteDateRange(&MuxerDateRange{
+			ID:    "ad1",
+			Class: "com.example.other",
+			Start: testTime,
+		})
+		require.EqualError(t, err, "class and start of date range 'ad1' cannot be changed")
+	})
+
+	t.Run("delta update", func(t *testing.T) {
+		m := &Muxer{
+			Variant:            MuxerVariantLowLatency,
+			SegmentCount:       7,
+			SegmentMinDuration: 1 * time.Second,
+			Tracks:             []*Track{testVideoTrack},
+		}
+
+		err := m.Start()
+		require.NoError(t, err)
+		defer m.Close()
+
+		for _, dr := range []*MuxerDateRange{
+			{ID: "removed", Start: testTime},
+			{ID: "skipped", Start: testTime.Add(3 * time.Second), Duration: ptrOf(10 * time.Second)},
+			{ID: "shown", Start: testTime.Add(8500 * time.Millisecond)},
+		} {
+			err = m.WriteDateRange(dr)
+			require.NoError(t, err)
+		}
+
+		for i := range 10 {
+			err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second), int64(i)*90000,
+				[][]byte{
+					testH264SPS,
+					{8}, // PPS
+					{5}, // IDR
+				})
+			require.NoError(t, err)
+		}
+
+		dateRangeIDs := func(pl *playlist.Media) []string {
+			var ret []string
+			for _, dr := range pl.DateRanges {
+				ret = append(ret, dr.ID)
+			}
+			return ret
+		}
+
+		byts, _, err := doRequest(m, "video1_stream.m3u8")
+		require.NoError(t, err)
+
+		pl, err := playlist.Unmarshal(byts)
+		require.NoError(t, err)
+		require.True(t, pl.(*playlist.Media).ServerControl.CanSkipDateRanges)
+		require.Equal(t, []string{"skipped", "shown"}, dateRangeIDs(pl.(*playlist.Media)))
+
+		byts, _, err = doRequest(m, "video1_stream.m3u8?_HLS_skip=v2")
+		require.NoError(t, err)
+
+		pl, err = playlist.Unmarshal(byts)
+		require.NoError(t, err)
+		require.Equal(t, &playlist.MediaSkip{
+			SkippedSegments:           2,
+			RecentlyRemovedDateRanges: []string{"removed"},
+		}, pl.(*playlist.Media).Skip)
+		require.Equal(t, []string{"shown"}, dateRangeIDs(pl.(*playlist.Media)))
+	})
+}
+
+func TestMuxerDateRangesErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		dr   *MuxerDateRange
+		err  string
+	}{
+		{
+			"missing id",
+			&MuxerDateRange{Start: testTime},
+			"date range ID is missing",
+		},
+		{
+			"invalid id",
+			&MuxerDateRange{ID: "a\"b", Start: testTime},
+			"invalid date range ID: a\"b",
+		},
+		{
+			"invalid class",
+			&MuxerDateRange{ID: "a", Class: "b\nc", Start: testTime},
+			"invalid date range class: b\nc",
+		},
+		{
+			"missing start",
+			&MuxerDateRange{ID: "a"},
+			"date range start is missing",
+		},
+		{
+			"end on next without class",
+			&MuxerDateRange{ID: "a", Start: testTime, EndOnNext: true},
+			"EndOnNext requires Class",
+		},
+		{
+			"invalid client attribute",
+			&MuxerDateRange{ID: "a", Start: testTime, ClientAttributes: map[string]string{"Y-A": "b"}},
+			"invalid client attribute name: Y-A",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant: MuxerVariantMPEGTS,
+				Tracks:  []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			err = m.WriteDateRange(ca.dr)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+type testKeyProvider struct {
+	requested []uint64
+}
+
+func (p *testKeyProvider) Key(index uint64) ([]byte, error) {
+	p.requested = append(p.requested, index)
+	return bytes.Repeat([]byte{byte(index + 1)}, 16), nil
+}
+
+func TestMuxerEncryptionAES128(t *testing.T) {
+	for _, ca := range []string{
+		"mpegts",
+		"fmp4",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			var v MuxerVariant
+			var streamID string
+			if ca == "mpegts" {
+				v = MuxerVariantMPEGTS
+				streamID = "main"
+			} else {
+				v = MuxerVariantFMP4
+				streamID = "video1"
+			}
+
+			kp := &testKeyProvider{}
+
+			m := &Muxer{
+				Variant:            v,
+				SegmentCount:       7,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack},
+				Encryption: &MuxerEncryption{
+					Method:              MuxerEncryptionMethodAES128,
+					KeyProvider:         kp,
+					KeyRotationInterval: 2,
+				},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			// IDRs are long enough to span multiple AES blocks
+			idr := func(i int) []byte {
+				return append([]byte{5}, bytes.Repeat([]byte{byte(i)}, 100)...)
+			}
+
+			for i := range 7 {
+				err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second),
+					int64(i)*90000, [][]byte{
+						testH264SPS,
+						{0x08},
+						idr(i),
+					})
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, []uint64{0, 1, 2, 3}, kp.requested)
+
+			byts, _, err := doRequest(m, streamID+"_stream.m3u8")
+			require.NoError(t, err)
+
+			var pl playlist.Media
+			err = pl.Unmarshal(byts)
+			require.NoError(t, err)
+			require.Len(t, pl.Segments, 6)
+
+			for i, seg := range pl.Segments {
+				require.Equal(t, playlist.MediaKeyMethodAES128, seg.Key.Method)
+				require.Equal(t, "", seg.Key.IV)
+				require.Regexp(t, "^[0-9a-f]+_key"+strconv.FormatInt(int64(i/2), 10)+`\.key$`, seg.Key.URI)
+			}
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(m.Handle),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			recv := make(chan [][]byte, 10)
+
+			var c *Client
+			c = &Client{
+				URI: "http://localhost:5780/index.m3u8",
+				OnTracks: func(tracks []*Track) error {
+					c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+						recv <- au
+					})
+					return nil
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			// the client starts three segments before the end of the playlist
+			for i := 3; i < 6; i++ {
+				au := <-recv
+				require.Equal(t, idr(i), au[len(au)-1])
+			}
+		})
+	}
+}
+
+func TestMuxerEncryptionSampleAES(t *testing.T) {
+	kp := &testKeyProvider{}
+
+	m := &Muxer{
+		Variant:            MuxerVariantLowLatency,
+		SegmentCount:       7,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		Encryption: &MuxerEncryption{
+			Method:         MuxerEncryptionMethodSampleAES,
+			KeyProvider:    kp,
+			KeyURITemplate: "https://keys.example.com/" + MuxerKeyIndexPlaceholder,
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	idr := append([]byte{5}, bytes.Repeat([]byte{1, 2, 3, 4}, 50)...)
+
+	for i := range 3 {
+		err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second),
+			int64(i)*90000, [][]byte{
+				testH264SPS,
+				{0x08},
+				idr,
+			})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "video1_stream.m3u8")
+	require.NoError(t, err)
+
+	var pl playlist.Media
+	err = pl.Unmarshal(byts)
+	require.NoError(t, err)
+
+	seg := pl.Segments[len(pl.Segments)-1]
+	require.Equal(t, playlist.MediaKeyMethodSampleAES, seg.Key.Method)
+	require.Equal(t, "https://keys.example.com/0", seg.Key.URI)
+	require.Regexp(t, "^0x[0-9a-f]{32}$", seg.Key.IV)
+
+	initFile, _, err := doRequest(m, pl.Map.URI)
+	require.NoError(t, err)
+
+	for _, box := range []string{"encv", "sinf", "frmaavc1", "schm", "cbcs", "tenc"} {
+		require.Contains(t, string(initFile), box)
+	}
+
+	partFile, _, err := doRequest(m, seg.Parts[0].URI)
+	require.NoError(t, err)
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(partFile)
+	require.NoError(t, err)
+
+	for _, box := range []string{"saiz", "saio", "senc"} {
+		require.Contains(t, string(partFile), box)
+	}
+
+	// decrypt the IDR with the constant IV
+	iv, err := hex.DecodeString(seg.Key.IV[2:])
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(bytes.Repeat([]byte{1}, 16))
+	require.NoError(t, err)
+
+	payload := parts[0].Tracks[0].Samples[0].Payload
+	var au h264.AVCC
+	err = au.Unmarshal(payload)
+	require.NoError(t, err)
+
+	enc := au[len(au)-1]
+	require.NotEqual(t, idr, enc)
+	require.Equal(t, idr[:cbcsVideoClearLeader], enc[:cbcsVideoClearLeader])
+
+	dec := append([]byte(nil), enc...)
+	protected := len(dec) - cbcsVideoClearLeader
+	protected -= protected % aes.BlockSize
+	cbcsApplyPattern(cipher.NewCBCDecrypter(block, iv),
+		dec[len(dec)-protected:], cbcsVideoCryptBlocks, cbcsVideoSkipBlocks)
+	require.Equal(t, idr, dec)
+}
+
+func TestMuxerEncryptionSaveToDisk(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gohlslib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		Directory:          dir,
+		Encryption: &MuxerEncryption{
+			Method:              MuxerEncryptionMethodAES128,
+			KeyProvider:         &testKeyProvider{},
+			KeyRotationInterval: 1,
+		},
+	}
+
+	err = m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 5 {
+		err = m.WriteH264(testVideoTrack, testTime.Add(time.Duration(i)*time.Second),
+			int64(i)*90000, [][]byte{
+				testH264SPS,
+				{5}, // IDR
+				{byte(i)},
+			})
+		require.NoError(t, err)
+	}
+
+	byts, err := os.ReadFile(filepath.Join(dir, "video1_stream.m3u8"))
+	require.NoError(t, err)
+
+	var pl playlist.Media
+	err = pl.Unmarshal(byts)
+	require.NoError(t, err)
+	require.Len(t, pl.Segments, 3)
+
+	for i, seg := range pl.Segments {
+		key, err2 := os.ReadFile(filepath.Join(dir, seg.Key.URI))
+		require.NoError(t, err2)
+		require.Equal(t, bytes.Repeat([]byte{byte(i + 2)}, 16), key)
+	}
+
+	// keys of segments that are not in the playlist anymore are removed,
+	// while the key of the segment being written is already available
+	matches, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	require.NoError(t, err)
+	require.Len(t, matches, 4)
+}
+
+func TestMuxerEncryptionErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		v      MuxerVariant
+		method MuxerEncryptionMethod
+		track  *Track
+		err    string
+	}{
+		{
+			"aes-128 low-latency",
+			MuxerVariantLowLatency,
+			MuxerEncryptionMethodAES128,
+			testVideoTrack,
+			"AES-128 encryption is not supported by the Low-Latency variant, use SAMPLE-AES",
+		},
+		{
+			"sample-aes mpegts",
+			MuxerVariantMPEGTS,
+			MuxerEncryptionMethodSampleAES,
+			testVideoTrack,
+			"SAMPLE-AES encryption is not supported by the MPEG-TS variant",
+		},
+		{
+			"sample-aes av1",
+			MuxerVariantFMP4,
+			MuxerEncryptionMethodSampleAES,
+			&Track{
+				Codec:     &codecs.AV1{SequenceHeader: testAV1SequenceHeader},
+				ClockRate: 90000,
+			},
+			"track 0: SAMPLE-AES encryption is not supported with this codec",
+		},
+		{
+			"invalid method",
+			MuxerVariantFMP4,
+			0,
+			testVideoTrack,
+			"invalid encryption method",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant: ca.v,
+				Tracks:  []*Track{ca.track},
+				Encryption: &MuxerEncryption{
+					Method: ca.method,
+				},
+			}
+			err := m.Start()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMuxerCloseBeforeData(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+
+	m.Close()
+
+	b, _, _ := doRequest(m, "index.m3u8")
+	require.Equal(t, []byte(nil), b)
+
+	b, _, _ = doRequest(m, "video1_stream.m3u8")
+	require.Equal(t, []byte(nil), b)
+
+	b, _, _ = doRequest(m, m.prefix+"_init.mp4")
+	require.Equal(t, []byte(nil), b)
+}
+
+func TestMuxerCloseWhileRequestStream(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		b, _, _ := doRequest(m, "video1_stream.m3u8")
+		require.Equal(t, []byte(nil), b)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	m.Close()
+	<-done
+}
+
+func TestMuxerMaxSegmentSize(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		SegmentMaxSize:     1,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteH264(
+		testVideoTrack,
+		testTime,
+		int64(2*time.Second)*int64(testVideoTrack.ClockRate)/int64(time.Second),
+		[][]byte{
+			testH264SPS,
+			{5}, // IDR
+		})
+	require.EqualError(t, err, "reached maximum segment size")
+}
+
+func TestMuxerDoubleRead(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
+		testH264SPS,
+		{5}, // IDR
+		{1},
+	})
+	require.NoError(t, err)
+
+	err = m.WriteH264(
+		testVideoTrack,
+		testTime,
+		int64(2*time.Second)*int64(testVideoTrack.ClockRate)/int64(time.Second),
+		[][]byte{
+			{5}, // IDR
+			{2},
+		})
+	require.NoError(t, err)
+
+	byts, _, err := doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	re := regexp.MustCompile(`^#EXTM3U\n` +
+		`#EXT-X-VERSION:3\n` +
+		`#EXT-X-ALLOW-CACHE:NO\n` +
+		`#EXT-X-TARGETDURATION:2\n` +
+		`#EXT-X-MEDIA-SEQUENCE:0\n` +
+		`#EXT-X-PROGRAM-DATE-TIME:(.*?)\n` +
+		`#EXTINF:2.00000,\n` +
+		`(.*?_seg0\.ts)\n$`)
+	require.Regexp(t, re, string(byts))
+	ma := re.FindStringSubmatch(string(byts))
+
+	byts1, _, err := doRequest(m, ma[2])
+	require.NoError(t, err)
+
+	byts2, _, err := doRequest(m, ma[2])
+	require.NoError(t, err)
+	require.Equal(t, byts1, byts2)
+}
+
+func TestMuxerSaveToDisk(t *testing.T) {
+	for _, ca := range []string{
+		"mpegts",
+		"mp4",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "gohlslib")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			var v MuxerVariant
+			if ca == "mpegts" {
+				v = MuxerVariantMPEGTS
+			} else {
+				v = MuxerVariantFMP4
+			}
+
+			m := &Muxer{
+				Variant:            v,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack},
+				Directory:          dir,
+			}
+
+			err = m.Start()
+			require.NoError(t, err)
+
+			err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
+				testH264SPS,
+				{5}, // IDR
+				{1},
+			})
+			require.NoError(t, err)
+
+			err = m.WriteH264(testVideoTrack, testTime, 2*90000, [][]byte{
+				{5}, // IDR
 				{2},
 			})
 			require.NoError(t, err)
@@ -2048,6 +3740,90 @@ func TestMuxerSaveToDisk(t *testing.T) {
 	}
 }
 
+type testStorageFile struct {
+	storage.File
+	factory *testStorageFactory
+	name    string
+}
+
+func (f *testStorageFile) Remove() {
+	f.factory.mutex.Lock()
+	f.factory.removed = append(f.factory.removed, f.name)
+	f.factory.mutex.Unlock()
+
+	f.File.Remove()
+}
+
+type testStorageFactory struct {
+	mutex   sync.Mutex
+	created []string
+	removed []string
+}
+
+func (f *testStorageFactory) NewFile(fileName string) (storage.File, error) {
+	f.mutex.Lock()
+	f.created = append(f.created, fileName)
+	f.mutex.Unlock()
+
+	inner, err := storage.NewFactoryRAM().NewFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testStorageFile{
+		File:    inner,
+		factory: f,
+		name:    fileName,
+	}, nil
+}
+
+func TestMuxerStorageFactory(t *testing.T) {
+	sf := &testStorageFactory{}
+
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		StorageFactory:     sf,
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+
+	for i := range 5 {
+		err = m.WriteH264(testVideoTrack, testTime, int64(i)*90000, [][]byte{
+			testH264SPS,
+			{5}, // IDR
+			{byte(i)},
+		})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	var pl playlist.Media
+	err = pl.Unmarshal(byts)
+	require.NoError(t, err)
+	require.Len(t, pl.Segments, 3)
+
+	for _, seg := range pl.Segments {
+		byts, _, err = doRequest(m, seg.URI)
+		require.NoError(t, err)
+		require.NotEmpty(t, byts)
+	}
+
+	sf.mutex.Lock()
+	require.Len(t, sf.created, 5)
+	require.Equal(t, sf.created[:1], sf.removed)
+	sf.mutex.Unlock()
+
+	m.Close()
+
+	require.ElementsMatch(t, sf.created, sf.removed)
+}
+
 func TestMuxerDynamicParams(t *testing.T) {
 	m := &Muxer{
 		Variant:            MuxerVariantFMP4,
@@ -2521,7 +4297,7 @@ func TestMuxerFMP4SequenceNumber(t *testing.T) {
 	re := regexp.MustCompile(`^#EXTM3U\n` +
 		`#EXT-X-VERSION:10\n` +
 		`#EXT-X-TARGETDURATION:4\n` +
-		`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=2\.50000,CAN-SKIP-UNTIL=24\.00000\n` +
+		`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=2\.50000,CAN-SKIP-UNTIL=24\.00000,CAN-SKIP-DATERANGES=YES\n` +
 		`#EXT-X-PART-INF:PART-TARGET=1.00000\n` +
 		`#EXT-X-MEDIA-SEQUENCE:1\n` +
 		`#EXT-X-MAP:URI=".*?_init.mp4"\n` +
@@ -2701,7 +4477,7 @@ func TestMuxerPreloadHint(t *testing.T) {
 	re := regexp.MustCompile(`^#EXTM3U\n` +
 		`#EXT-X-VERSION:10\n` +
 		`#EXT-X-TARGETDURATION:1\n` +
-		`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=2.50000,CAN-SKIP-UNTIL=6.00000\n` +
+		`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=2.50000,CAN-SKIP-UNTIL=6.00000,CAN-SKIP-DATERANGES=YES\n` +
 		`#EXT-X-PART-INF:PART-TARGET=1.00000\n` +
 		`#EXT-X-MEDIA-SEQUENCE:1\n` +
 		`#EXT-X-MAP:URI=".*?_init\.mp4"\n` +
@@ -2776,3 +4552,96 @@ func TestMuxerPreloadHint(t *testing.T) {
 		}},
 	}}, parts)
 }
+
+func TestMuxerS3(t *testing.T) {
+	var mutex sync.Mutex
+	objects := make(map[string][]byte)
+	deleted := 0
+
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		byts, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path] = byts
+
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			deleted++
+		}
+	}))
+	defer s.Close()
+
+	sf, err := storage.NewFactoryS3(storage.S3Config{
+		Endpoint: s.URL,
+		Bucket:   "mybucket",
+	})
+	require.NoError(t, err)
+
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		StorageFactory:     sf,
+	}
+
+	err = m.Start()
+	require.NoError(t, err)
+
+	for i := range 6 {
+		err = m.WriteH264(testVideoTrack, testTime, int64(i)*90000, [][]byte{
+			testH264SPS,
+			{5}, // IDR
+			{byte(i)},
+		})
+		require.NoError(t, err)
+	}
+
+	m.Close()
+
+	// the factory is closed by its owner.
+	// Pending requests are flushed and segments are not deleted, since
+	// removed segments are kept until the end of their retention.
+	sf.(storage.Closer).Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	require.Equal(t, 0, deleted)
+
+	byts, ok := objects["/mybucket/index.m3u8"]
+	require.True(t, ok)
+
+	var mpl playlist.Multivariant
+	err = mpl.Unmarshal(byts)
+	require.NoError(t, err)
+
+	byts, ok = objects["/mybucket/"+mpl.Variants[0].URI]
+	require.True(t, ok)
+
+	var pl playlist.Media
+	err = pl.Unmarshal(byts)
+	require.NoError(t, err)
+	require.Len(t, pl.Segments, 3)
+
+	_, ok = objects["/mybucket/"+pl.Map.URI]
+	require.True(t, ok)
+
+	for _, seg := range pl.Segments {
+		_, ok = objects["/mybucket/"+seg.URI]
+		require.True(t, ok)
+	}
+
+	// removed segments are still available, the in-progress segment is not uploaded.
+	_, ok = objects["/mybucket/"+strings.Replace(pl.Segments[0].URI, "seg2", "seg0", 1)]
+	require.True(t, ok)
+	_, ok = objects["/mybucket/"+strings.Replace(pl.Segments[0].URI, "seg2", "seg5", 1)]
+	require.False(t, ok)
+
+	require.Len(t, objects, 8)
+}
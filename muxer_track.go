@@ -5,6 +5,9 @@ import (
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	tscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 )
 
 type muxerTrack struct {
@@ -16,16 +19,32 @@ type muxerTrack struct {
 	firstRandomAccessReceived bool
 	h264DTSExtractor          *h264.DTSExtractor
 	h265DTSExtractor          *h265.DTSExtractor
-	mpegtsTrack               *mpegts.Track        // mpegts only
-	mpegtsAACPTS              int64                // mpegts only, in track clock-rate units
-	mpegtsAACPTSInitialized   bool                 // mpegts only
-	fmp4NextSample            *fmp4AugmentedSample // fmp4 only
-	fmp4Samples               []*fmp4.Sample       // fmp4 only
-	fmp4StartDTS              int64                // fmp4 only
+	mpegtsTrack               *mpegts.Track         // mpegts only
+	mpegtsAACPTS              int64                 // mpegts only, in track clock-rate units
+	mpegtsAACPTSInitialized   bool                  // mpegts only
+	fmp4NextSample            *fmp4AugmentedSample  // fmp4 only
+	fmp4Samples               []*fmp4.Sample        // fmp4 only
+	fmp4StartDTS              int64                 // fmp4 only
+	webVTTStream              *muxerStreamWebVTT    // webvtt only
+	captions                  *muxerCaptionInserter // h264 and h265 only
+	emsgID                    uint32                // id3 + fmp4 only
 }
 
 func (t *muxerTrack) initialize() {
+	if _, ok := t.Codec.(*codecs.WebVTT); ok {
+		return
+	}
+
 	if t.variant == MuxerVariantMPEGTS {
+		if _, ok := t.Codec.(*codecs.ID3); ok {
+			// timed ID3 is not supported by mpegts.Writer.
+			// Use a codec with the same PES format, whose PMT entry is replaced by mpegtsID3Writer.
+			t.mpegtsTrack = &mpegts.Track{
+				Codec: &tscodecs.DVBSubtitle{},
+			}
+			return
+		}
+
 		t.mpegtsTrack = &mpegts.Track{
 			Codec: toMPEGTS(t.Codec),
 		}
@@ -176,15 +176,44 @@ func Marshal(codec codecs.Codec) string {
 			return "avc1." + hex.EncodeToString(codec.SPS[1:4])
 		}
 
+	case *codecs.MPEG4Video:
+		// RFC6381, object type indication of MPEG-4 Visual
+		return "mp4v.20"
+
+	case *codecs.MPEG1Video:
+		// RFC6381, object type indication of MPEG-2 Video Main Profile,
+		// that is the one signaled in MPEG-TS streams.
+		return "mp4v.61"
+
 	case *codecs.Opus:
 		return "opus"
 
 	case *codecs.FLAC:
 		return "flac"
 
+	case *codecs.AC3:
+		return "ac-3"
+
+	case *codecs.EAC3:
+		return "ec-3"
+
 	case *codecs.MPEG4Audio:
 		// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
 		return "mp4a.40." + strconv.FormatInt(int64(codec.Config.Type), 10)
+
+	case *codecs.MPEG4AudioLATM:
+		// the audio object type is carried in-band
+		return "mp4a.40"
+
+	case *codecs.MPEG1Audio:
+		// RFC6381 and HLS Authoring Specification
+		switch codec.Layer {
+		case 2:
+			return "mp4a.40.33"
+
+		case 3:
+			return "mp4a.40.34"
+		}
 	}
 
 	return ""
@@ -66,6 +66,16 @@ func TestMarshal(t *testing.T) {
 			},
 			"avc1.42c028",
 		},
+		{
+			"mpeg-4 video",
+			&codecs.MPEG4Video{},
+			"mp4v.20",
+		},
+		{
+			"mpeg-1 video",
+			&codecs.MPEG1Video{},
+			"mp4v.61",
+		},
 		{
 			"opus",
 			&codecs.Opus{},
@@ -76,6 +86,26 @@ func TestMarshal(t *testing.T) {
 			&codecs.FLAC{},
 			"flac",
 		},
+		{
+			"mpeg-1 audio layer 2",
+			&codecs.MPEG1Audio{Layer: 2},
+			"mp4a.40.33",
+		},
+		{
+			"mpeg-1 audio layer 3",
+			&codecs.MPEG1Audio{Layer: 3},
+			"mp4a.40.34",
+		},
+		{
+			"ac-3",
+			&codecs.AC3{},
+			"ac-3",
+		},
+		{
+			"e-ac-3",
+			&codecs.EAC3{},
+			"ec-3",
+		},
 		{
 			"mpeg-4 audio",
 			&codecs.MPEG4Audio{
@@ -88,6 +118,11 @@ func TestMarshal(t *testing.T) {
 			},
 			"mp4a.40.2",
 		},
+		{
+			"mpeg-4 audio latm",
+			&codecs.MPEG4AudioLATM{},
+			"mp4a.40",
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			enc := codecparams.Marshal(ca.codec)
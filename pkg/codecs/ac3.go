@@ -0,0 +1,23 @@
+package codecs
+
+// AC3 is an AC-3 (Dolby Digital) codec.
+type AC3 struct {
+	SampleRate   int
+	ChannelCount int
+
+	// fMP4 only.
+	// When muxing, they are filled automatically from frames.
+	Bsid        uint8
+	Bsmod       uint8
+	Acmod       uint8
+	LfeOn       bool
+	BitRateCode uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*AC3) IsVideo() bool {
+	return false
+}
+
+func (*AC3) isCodec() {
+}
@@ -0,0 +1,25 @@
+package codecs
+
+// EAC3 is an E-AC-3 (Dolby Digital Plus) codec.
+type EAC3 struct {
+	SampleRate   int
+	ChannelCount int
+
+	// fMP4 only.
+	// When muxing, DataRate, Acmod and LfeOn are filled automatically from frames.
+	DataRate  uint16
+	Asvc      bool
+	Bsmod     uint8
+	Acmod     uint8
+	LfeOn     bool
+	NumDepSub uint8
+	ChanLoc   uint16
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*EAC3) IsVideo() bool {
+	return false
+}
+
+func (*EAC3) isCodec() {
+}
@@ -0,0 +1,13 @@
+package codecs
+
+// ID3 is a timed metadata codec, that carries ID3v2 tags.
+// Specification: Timed Metadata for HTTP Live Streaming
+type ID3 struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*ID3) IsVideo() bool {
+	return false
+}
+
+func (*ID3) isCodec() {
+}
@@ -0,0 +1,20 @@
+package codecs
+
+// MPEG1Audio is a MPEG-1 Audio codec (MP2, MP3).
+type MPEG1Audio struct {
+	SampleRate   int
+	ChannelCount int
+
+	// layer (2 for MP2, 3 for MP3).
+	// It is not stored by containers, therefore it is zero in tracks provided by Client.
+	// When zero, Muxer fills it with the layer of the first written frame.
+	Layer int
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG1Audio) IsVideo() bool {
+	return false
+}
+
+func (*MPEG1Audio) isCodec() {
+}
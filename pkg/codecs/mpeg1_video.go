@@ -0,0 +1,13 @@
+package codecs
+
+// MPEG1Video is a MPEG-1/2 Video codec.
+// It is supported by the MPEG-TS variant only.
+type MPEG1Video struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG1Video) IsVideo() bool {
+	return true
+}
+
+func (*MPEG1Video) isCodec() {
+}
@@ -0,0 +1,14 @@
+package codecs
+
+// MPEG4AudioLATM is a MPEG-4 Audio codec in LATM format.
+// Configuration is carried in-band, therefore it is not stored in the codec.
+// It is supported by the MPEG-TS variant only.
+type MPEG4AudioLATM struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG4AudioLATM) IsVideo() bool {
+	return false
+}
+
+func (*MPEG4AudioLATM) isCodec() {
+}
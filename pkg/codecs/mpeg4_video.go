@@ -0,0 +1,13 @@
+package codecs
+
+// MPEG4Video is a MPEG-4 Video codec (MPEG-4 Part 2).
+// It is supported by the MPEG-TS variant only.
+type MPEG4Video struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG4Video) IsVideo() bool {
+	return true
+}
+
+func (*MPEG4Video) isCodec() {
+}
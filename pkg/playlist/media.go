@@ -28,6 +28,9 @@ func parseTime(v string) (time.Time, error) {
 	return ret, nil
 }
 
+// MediaDefine is a EXT-X-DEFINE tag.
+type MediaDefine = MultivariantDefine
+
 // MediaStart is a EXT-X-START tag.
 type MediaStart = MultivariantStart
 
@@ -48,6 +51,9 @@ type Media struct {
 	// EXT-X-INDEPENDENT-SEGMENTS
 	IndependentSegments bool
 
+	// EXT-X-DEFINE
+	Defines []*MediaDefine
+
 	// EXT-X-ALLOWCACHE (removed since v7)
 	AllowCache *bool
 
@@ -78,12 +84,19 @@ type Media struct {
 	// EXT-X-SKIP
 	Skip *MediaSkip
 
+	// EXT-X-DATERANGE tags that precede the first segment.
+	// Tags that follow a segment are stored in the segment.
+	DateRanges []*MediaDateRange
+
 	// segments (at least one is required)
 	Segments []*MediaSegment
 
 	// EXT-X-PART
 	Parts []*MediaPart
 
+	// EXT-X-KEY that applies to Parts, when it differs from the one of the last segment
+	PartsKey *MediaKey
+
 	// EXT-X-PRELOAD-HINT
 	PreloadHint *MediaPreloadHint
 
@@ -98,6 +111,11 @@ func (m Media) isPlaylist() {}
 
 // Unmarshal decodes the playlist.
 func (m *Media) Unmarshal(buf []byte) error {
+	return m.UnmarshalWithContext(buf, UnmarshalContext{})
+}
+
+// UnmarshalWithContext decodes the playlist, resolving variables with the given context.
+func (m *Media) UnmarshalWithContext(buf []byte, uc UnmarshalContext) error {
 	s := string(buf)
 
 	s, err := primitives.SkipHeader(s)
@@ -109,6 +127,8 @@ func (m *Media) Unmarshal(buf []byte) error {
 
 	curSegment := &MediaSegment{}
 
+	vars := make(variables)
+
 	for {
 		var line string
 		line, s = primitives.ReadLine(s)
@@ -116,6 +136,14 @@ func (m *Media) Unmarshal(buf []byte) error {
 			break
 		}
 
+		// values of variables are not subject to substitution
+		if !strings.HasPrefix(line, "#EXT-X-DEFINE:") {
+			line, err = vars.substituteLine(line)
+			if err != nil {
+				return err
+			}
+		}
+
 		switch {
 		case strings.HasPrefix(line, "#EXT-X-VERSION:"):
 			line = line[len("#EXT-X-VERSION:"):]
@@ -134,6 +162,21 @@ func (m *Media) Unmarshal(buf []byte) error {
 		case strings.HasPrefix(line, "#EXT-X-INDEPENDENT-SEGMENTS"):
 			m.IndependentSegments = true
 
+		case strings.HasPrefix(line, "#EXT-X-DEFINE:"):
+			line = line[len("#EXT-X-DEFINE:"):]
+
+			d := &MediaDefine{}
+			err = d.unmarshal(line)
+			if err != nil {
+				return err
+			}
+
+			err = vars.define(d, uc, true)
+			if err != nil {
+				return err
+			}
+			m.Defines = append(m.Defines, d)
+
 		case strings.HasPrefix(line, "#EXT-X-ALLOW-CACHE:"):
 			line = line[len("#EXT-X-ALLOW-CACHE:"):]
 
@@ -240,6 +283,22 @@ func (m *Media) Unmarshal(buf []byte) error {
 				return err
 			}
 
+		case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+			line = line[len("#EXT-X-DATERANGE:"):]
+
+			dr := &MediaDateRange{}
+			err = dr.unmarshal(line)
+			if err != nil {
+				return err
+			}
+
+			if len(m.Segments) != 0 {
+				seg := m.Segments[len(m.Segments)-1]
+				seg.DateRanges = append(seg.DateRanges, dr)
+			} else {
+				m.DateRanges = append(m.DateRanges, dr)
+			}
+
 		case line == "#EXT-X-DISCONTINUITY":
 			curSegment.Discontinuity = true
 
@@ -350,6 +409,10 @@ func (m *Media) Unmarshal(buf []byte) error {
 
 	m.Parts = curSegment.Parts
 
+	if len(m.Parts) != 0 && len(m.Segments) != 0 && m.Segments[len(m.Segments)-1].Key != curKey {
+		m.PartsKey = curKey
+	}
+
 	if m.TargetDuration == 0 {
 		return fmt.Errorf("TARGETDURATION not set")
 	}
@@ -357,6 +420,62 @@ func (m *Media) Unmarshal(buf []byte) error {
 		return fmt.Errorf("no segments found")
 	}
 
+	// date ranges can't be placed on the timeline without EXT-X-PROGRAM-DATE-TIME,
+	// therefore they are discarded instead of rejecting the playlist.
+	if !m.hasDateTime() {
+		m.DateRanges = nil
+		for _, seg := range m.Segments {
+			seg.DateRanges = nil
+		}
+	}
+
+	return m.validateDateRanges()
+}
+
+// AllDateRanges returns all EXT-X-DATERANGE tags, in order of appearance.
+func (m Media) AllDateRanges() []*MediaDateRange {
+	ret := append([]*MediaDateRange(nil), m.DateRanges...)
+	for _, seg := range m.Segments {
+		ret = append(ret, seg.DateRanges...)
+	}
+	return ret
+}
+
+func (m Media) hasDateTime() bool {
+	for _, seg := range m.Segments {
+		if seg.DateTime != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Media) validateDateRanges() error {
+	dateRanges := m.AllDateRanges()
+
+	if len(dateRanges) == 0 {
+		return nil
+	}
+
+	// If a Playlist contains an EXT-X-DATERANGE tag, it MUST also contain
+	// at least one EXT-X-PROGRAM-DATE-TIME tag.
+	if !m.hasDateTime() {
+		return fmt.Errorf("EXT-X-DATERANGE requires EXT-X-PROGRAM-DATE-TIME")
+	}
+
+	// If a Playlist contains two EXT-X-DATERANGE tags with the same ID
+	// attribute value, then any AttributeName that appears in both tags
+	// MUST have the same AttributeValue.
+	byID := make(map[string][]*MediaDateRange)
+	for _, dr := range dateRanges {
+		for _, prev := range byID[dr.ID] {
+			if prev.conflicts(dr) {
+				return fmt.Errorf("EXT-X-DATERANGE tags with ID '%s' have different attributes", dr.ID)
+			}
+		}
+		byID[dr.ID] = append(byID[dr.ID], dr)
+	}
+
 	return nil
 }
 
@@ -370,6 +489,15 @@ func (m Media) Marshal() ([]byte, error) {
 		ret.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
+	for _, d := range m.Defines {
+		err := d.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		ret.WriteString(d.marshal())
+	}
+
 	if m.AllowCache != nil {
 		var v string
 		if *m.AllowCache {
@@ -412,6 +540,27 @@ func (m Media) Marshal() ([]byte, error) {
 		ret.WriteString(m.Skip.marshal())
 	}
 
+	err := m.validateDateRanges()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dr := range m.AllDateRanges() {
+		err = dr.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		err = dr.validateClientAttributeValues()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dr := range m.DateRanges {
+		ret.WriteString(dr.marshal())
+	}
+
 	var prevKey *MediaKey
 	for _, seg := range m.Segments {
 		if seg.Key != nil && (prevKey == nil || !seg.Key.Equal(prevKey)) {
@@ -420,6 +569,14 @@ func (m Media) Marshal() ([]byte, error) {
 		}
 
 		ret.WriteString(seg.marshal())
+
+		for _, dr := range seg.DateRanges {
+			ret.WriteString(dr.marshal())
+		}
+	}
+
+	if len(m.Parts) != 0 && m.PartsKey != nil && (prevKey == nil || !m.PartsKey.Equal(prevKey)) {
+		ret.WriteString(m.PartsKey.marshal())
 	}
 
 	for _, part := range m.Parts {
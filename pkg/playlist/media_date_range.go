@@ -0,0 +1,393 @@
+package playlist
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+func unmarshalHexadecimalSequence(v string) ([]byte, error) {
+	if !strings.HasPrefix(v, "0x") && !strings.HasPrefix(v, "0X") {
+		return nil, fmt.Errorf("invalid hexadecimal sequence: %v", v)
+	}
+
+	v = v[2:]
+	if (len(v) % 2) != 0 {
+		v = "0" + v
+	}
+
+	ret, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hexadecimal sequence: %v", v)
+	}
+
+	return ret, nil
+}
+
+func marshalHexadecimalSequence(v []byte) string {
+	return "0x" + strings.ToUpper(hex.EncodeToString(v))
+}
+
+// IsClientAttributeName checks whether a string is a valid name of a client attribute
+// of a EXT-X-DATERANGE tag.
+func IsClientAttributeName(v string) bool {
+	if !strings.HasPrefix(v, "X-") || len(v) == len("X-") {
+		return false
+	}
+
+	for _, c := range v {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isUnquotedAttributeValue checks whether a client attribute value
+// is a hexadecimal sequence or a decimal-floating-point number.
+func isUnquotedAttributeValue(v string) bool {
+	if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+		_, err := unmarshalHexadecimalSequence(v)
+		return err == nil
+	}
+
+	if v == "" || strings.ContainsAny(v, "eE+") {
+		return false
+	}
+
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+// MediaDateRangeClientAttribute is a client attribute of a EXT-X-DATERANGE tag.
+type MediaDateRangeClientAttribute struct {
+	// value
+	Value string
+
+	// whether the value is a quoted-string.
+	// Otherwise, it is a hexadecimal sequence or a decimal-floating-point number.
+	Quoted bool
+}
+
+func (a MediaDateRangeClientAttribute) validate() error {
+	if a.Quoted {
+		if strings.ContainsAny(a.Value, "\"\r\n") {
+			return fmt.Errorf("invalid quoted-string: %v", a.Value)
+		}
+	} else if !isUnquotedAttributeValue(a.Value) {
+		return fmt.Errorf("invalid client attribute value: %v", a.Value)
+	}
+
+	return nil
+}
+
+func (a MediaDateRangeClientAttribute) marshal() string {
+	if a.Quoted {
+		return "\"" + a.Value + "\""
+	}
+	return a.Value
+}
+
+// MediaDateRange is a EXT-X-DATERANGE tag.
+type MediaDateRange struct {
+	// ID
+	// required
+	ID string
+
+	// CLASS
+	Class string
+
+	// START-DATE
+	// required
+	StartDate time.Time
+
+	// END-DATE
+	EndDate *time.Time
+
+	// DURATION
+	Duration *time.Duration
+
+	// PLANNED-DURATION
+	PlannedDuration *time.Duration
+
+	// SCTE35-CMD
+	SCTE35Cmd []byte
+
+	// SCTE35-OUT
+	SCTE35Out []byte
+
+	// SCTE35-IN
+	SCTE35In []byte
+
+	// END-ON-NEXT
+	EndOnNext bool
+
+	// X-<client-attribute>
+	ClientAttributes map[string]MediaDateRangeClientAttribute
+}
+
+func (t *MediaDateRange) unmarshal(v string) error {
+	var attrs primitives.Attributes
+	quoted, err := attrs.UnmarshalWithQuoted(v)
+	if err != nil {
+		return err
+	}
+
+	startDateFound := false
+
+	for key, val := range attrs {
+		switch key {
+		case "ID":
+			t.ID = val
+
+		case "CLASS":
+			t.Class = val
+
+		case "START-DATE":
+			t.StartDate, err = parseTime(val)
+			if err != nil {
+				return err
+			}
+			startDateFound = true
+
+		case "END-DATE":
+			var tmp time.Time
+			tmp, err = parseTime(val)
+			if err != nil {
+				return err
+			}
+			t.EndDate = &tmp
+
+		case "DURATION", "PLANNED-DURATION":
+			var d primitives.Duration
+			err = d.Unmarshal(val)
+			if err != nil {
+				return err
+			}
+			tmp := time.Duration(d)
+
+			if key == "DURATION" {
+				t.Duration = &tmp
+			} else {
+				t.PlannedDuration = &tmp
+			}
+
+		case "SCTE35-CMD":
+			t.SCTE35Cmd, err = unmarshalHexadecimalSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "SCTE35-OUT":
+			t.SCTE35Out, err = unmarshalHexadecimalSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "SCTE35-IN":
+			t.SCTE35In, err = unmarshalHexadecimalSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "END-ON-NEXT":
+			if val != "YES" {
+				return fmt.Errorf("invalid END-ON-NEXT: %v", val)
+			}
+			t.EndOnNext = true
+
+		default:
+			if strings.HasPrefix(key, "X-") {
+				if !IsClientAttributeName(key) {
+					return fmt.Errorf("invalid client attribute name: %v", key)
+				}
+
+				if t.ClientAttributes == nil {
+					t.ClientAttributes = make(map[string]MediaDateRangeClientAttribute)
+				}
+
+				_, isQuoted := quoted[key]
+				t.ClientAttributes[key] = MediaDateRangeClientAttribute{
+					Value:  val,
+					Quoted: isQuoted,
+				}
+			}
+		}
+	}
+
+	if !startDateFound {
+		return fmt.Errorf("START-DATE is missing")
+	}
+
+	return t.validate()
+}
+
+func (t MediaDateRange) validate() error {
+	if t.ID == "" {
+		return fmt.Errorf("ID is missing")
+	}
+
+	if strings.ContainsAny(t.ID, "\"\r\n") {
+		return fmt.Errorf("invalid ID: %v", t.ID)
+	}
+
+	if strings.ContainsAny(t.Class, "\"\r\n") {
+		return fmt.Errorf("invalid CLASS: %v", t.Class)
+	}
+
+	if t.Duration != nil && *t.Duration < 0 {
+		return fmt.Errorf("DURATION is negative")
+	}
+
+	if t.PlannedDuration != nil && *t.PlannedDuration < 0 {
+		return fmt.Errorf("PLANNED-DURATION is negative")
+	}
+
+	if t.EndDate != nil {
+		// END-DATE MUST be equal to or later than the value of the START-DATE attribute.
+		if t.EndDate.Before(t.StartDate) {
+			return fmt.Errorf("END-DATE is before START-DATE")
+		}
+
+		// If both DURATION and END-DATE are present, END-DATE MUST be equal to
+		// START-DATE plus DURATION. A tolerance is needed since DURATION is rounded.
+		if t.Duration != nil {
+			diff := t.EndDate.Sub(t.StartDate.Add(*t.Duration))
+			if diff < -time.Millisecond || diff > time.Millisecond {
+				return fmt.Errorf("END-DATE is not equal to START-DATE plus DURATION")
+			}
+		}
+	}
+
+	if t.EndOnNext {
+		// An EXT-X-DATERANGE tag with an END-ON-NEXT=YES attribute MUST have a CLASS attribute.
+		if t.Class == "" {
+			return fmt.Errorf("END-ON-NEXT requires CLASS")
+		}
+
+		// An EXT-X-DATERANGE tag with an END-ON-NEXT=YES attribute
+		// MUST NOT contain DURATION or END-DATE attributes.
+		if t.Duration != nil || t.EndDate != nil {
+			return fmt.Errorf("END-ON-NEXT is incompatible with DURATION and END-DATE")
+		}
+	}
+
+	for key := range t.ClientAttributes {
+		if !IsClientAttributeName(key) {
+			return fmt.Errorf("invalid client attribute name: %v", key)
+		}
+	}
+
+	return nil
+}
+
+// validateClientAttributeValues checks client attribute values.
+// It is performed when marshaling only, in order to accept tags of non-compliant servers.
+func (t MediaDateRange) validateClientAttributeValues() error {
+	for _, val := range t.ClientAttributes {
+		err := val.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// conflicts checks whether an attribute that appears in both tags has a different value.
+func (t MediaDateRange) conflicts(other *MediaDateRange) bool {
+	if t.Class != "" && other.Class != "" && t.Class != other.Class {
+		return true
+	}
+
+	if !t.StartDate.Equal(other.StartDate) {
+		return true
+	}
+
+	if t.EndDate != nil && other.EndDate != nil && !t.EndDate.Equal(*other.EndDate) {
+		return true
+	}
+
+	if t.Duration != nil && other.Duration != nil && *t.Duration != *other.Duration {
+		return true
+	}
+
+	if t.PlannedDuration != nil && other.PlannedDuration != nil && *t.PlannedDuration != *other.PlannedDuration {
+		return true
+	}
+
+	if t.SCTE35Cmd != nil && other.SCTE35Cmd != nil && !bytes.Equal(t.SCTE35Cmd, other.SCTE35Cmd) {
+		return true
+	}
+
+	if t.SCTE35Out != nil && other.SCTE35Out != nil && !bytes.Equal(t.SCTE35Out, other.SCTE35Out) {
+		return true
+	}
+
+	if t.SCTE35In != nil && other.SCTE35In != nil && !bytes.Equal(t.SCTE35In, other.SCTE35In) {
+		return true
+	}
+
+	for key, val := range t.ClientAttributes {
+		if otherVal, ok := other.ClientAttributes[key]; ok && otherVal != val {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t MediaDateRange) marshal() string {
+	ret := "#EXT-X-DATERANGE:ID=\"" + t.ID + "\""
+
+	if t.Class != "" {
+		ret += ",CLASS=\"" + t.Class + "\""
+	}
+
+	ret += ",START-DATE=\"" + t.StartDate.Format(timeRFC3339Millis) + "\""
+
+	if t.EndDate != nil {
+		ret += ",END-DATE=\"" + t.EndDate.Format(timeRFC3339Millis) + "\""
+	}
+
+	if t.Duration != nil {
+		ret += ",DURATION=" + strconv.FormatFloat(t.Duration.Seconds(), 'f', 5, 64)
+	}
+
+	if t.PlannedDuration != nil {
+		ret += ",PLANNED-DURATION=" + strconv.FormatFloat(t.PlannedDuration.Seconds(), 'f', 5, 64)
+	}
+
+	// client attributes are sorted in order to produce a deterministic output
+	for _, key := range slices.Sorted(maps.Keys(t.ClientAttributes)) {
+		ret += "," + key + "=" + t.ClientAttributes[key].marshal()
+	}
+
+	if t.SCTE35Cmd != nil {
+		ret += ",SCTE35-CMD=" + marshalHexadecimalSequence(t.SCTE35Cmd)
+	}
+
+	if t.SCTE35Out != nil {
+		ret += ",SCTE35-OUT=" + marshalHexadecimalSequence(t.SCTE35Out)
+	}
+
+	if t.SCTE35In != nil {
+		ret += ",SCTE35-IN=" + marshalHexadecimalSequence(t.SCTE35In)
+	}
+
+	if t.EndOnNext {
+		ret += ",END-ON-NEXT=YES"
+	}
+
+	ret += "\n"
+
+	return ret
+}
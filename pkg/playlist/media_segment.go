@@ -41,6 +41,9 @@ type MediaSegment struct {
 
 	// EXT-X-PART
 	Parts []*MediaPart
+
+	// EXT-X-DATERANGE tags that follow the segment
+	DateRanges []*MediaDateRange
 }
 
 func (s MediaSegment) validate() error {
@@ -1,6 +1,7 @@
 package playlist
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -27,6 +28,11 @@ type MediaServerControl struct {
 	// Skip Boundary, a decimal-floating-point number of seconds.  The
 	// Skip Boundary MUST be at least six times the Target Duration.
 	CanSkipUntil *time.Duration
+
+	// CAN-SKIP-DATERANGES
+	// Indicates that the Server-side Playlist Delta Updates can also
+	// skip older EXT-X-DATERANGE tags. It requires CAN-SKIP-UNTIL.
+	CanSkipDateRanges bool
 }
 
 func (t *MediaServerControl) unmarshal(v string) error {
@@ -58,9 +64,16 @@ func (t *MediaServerControl) unmarshal(v string) error {
 			}
 			tmp := time.Duration(d)
 			t.CanSkipUntil = &tmp
+
+		case "CAN-SKIP-DATERANGES":
+			t.CanSkipDateRanges = (val == "YES")
 		}
 	}
 
+	if t.CanSkipDateRanges && t.CanSkipUntil == nil {
+		return fmt.Errorf("CAN-SKIP-DATERANGES requires CAN-SKIP-UNTIL")
+	}
+
 	return nil
 }
 
@@ -77,6 +90,10 @@ func (t MediaServerControl) marshal() string {
 
 	if t.CanSkipUntil != nil {
 		ret += ",CAN-SKIP-UNTIL=" + strconv.FormatFloat(t.CanSkipUntil.Seconds(), 'f', 5, 64)
+
+		if t.CanSkipDateRanges {
+			ret += ",CAN-SKIP-DATERANGES=YES"
+		}
 	}
 
 	ret += "\n"
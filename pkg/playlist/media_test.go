@@ -1,6 +1,7 @@
 package playlist_test
 
 import (
+	"net/url"
 	"testing"
 	"time"
 
@@ -162,6 +163,108 @@ var casesMedia = []struct {
 			},
 		},
 	},
+	{
+		"date ranges",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-TARGETDURATION:2\n" +
+			"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=12,CAN-SKIP-DATERANGES=YES\n" +
+			"#EXT-X-MEDIA-SEQUENCE:10\n" +
+			"#EXT-X-SKIP:SKIPPED-SEGMENTS=3,RECENTLY-REMOVED-DATERANGES=\"ad1\tad2\"\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-6FFFFFF0\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+			"PLANNED-DURATION=59.993,SCTE35-OUT=0xFC002F0000000000FF000014056FFFFFF000E011622DCAFF0000526362" +
+			"00000000000A0008029896F50000008700000000\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-03-05T11:14:58Z\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg1.mp4\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-6FFFFFF0\",START-DATE=\"2014-03-05T11:15:00Z\",DURATION=59.993,SCTE35-IN=0xfc002a\n" +
+			"#EXT-X-DATERANGE:ID=\"program\",CLASS=\"com.example.program\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+			"END-ON-NEXT=YES,X-COM-EXAMPLE-TITLE=\"News, at noon\",X-COM-EXAMPLE-ID=0xAB01,X-COM-EXAMPLE-RATING=4.5," +
+			"X-COM-EXAMPLE-CODE=\"0x1F\",X-COM-EXAMPLE-VERSION=\"4.5\"\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg2.mp4\n",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-TARGETDURATION:2\n" +
+			"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=12.00000,CAN-SKIP-DATERANGES=YES\n" +
+			"#EXT-X-MEDIA-SEQUENCE:10\n" +
+			"#EXT-X-SKIP:SKIPPED-SEGMENTS=3,RECENTLY-REMOVED-DATERANGES=\"ad1\tad2\"\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-6FFFFFF0\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+			"PLANNED-DURATION=59.99300,SCTE35-OUT=0xFC002F0000000000FF000014056FFFFFF000E011622DCAFF0000526362" +
+			"00000000000A0008029896F50000008700000000\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-03-05T11:14:58Z\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg1.mp4\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-6FFFFFF0\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+			"DURATION=59.99300,SCTE35-IN=0xFC002A\n" +
+			"#EXT-X-DATERANGE:ID=\"program\",CLASS=\"com.example.program\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+			"X-COM-EXAMPLE-CODE=\"0x1F\",X-COM-EXAMPLE-ID=0xAB01,X-COM-EXAMPLE-RATING=4.5," +
+			"X-COM-EXAMPLE-TITLE=\"News, at noon\",X-COM-EXAMPLE-VERSION=\"4.5\",END-ON-NEXT=YES\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg2.mp4\n",
+		playlist.Media{
+			Version:        9,
+			TargetDuration: 2,
+			ServerControl: &playlist.MediaServerControl{
+				CanBlockReload:    true,
+				CanSkipUntil:      ptrOf(12 * time.Second),
+				CanSkipDateRanges: true,
+			},
+			MediaSequence: 10,
+			Skip: &playlist.MediaSkip{
+				SkippedSegments:           3,
+				RecentlyRemovedDateRanges: []string{"ad1", "ad2"},
+			},
+			DateRanges: []*playlist.MediaDateRange{
+				{
+					ID:              "splice-6FFFFFF0",
+					StartDate:       time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC),
+					PlannedDuration: ptrOf(59993 * time.Millisecond),
+					SCTE35Out: []byte{
+						0xfc, 0x00, 0x2f, 0x00, 0x00, 0x00, 0x00, 0x00,
+						0xff, 0x00, 0x00, 0x14, 0x05, 0x6f, 0xff, 0xff,
+						0xf0, 0x00, 0xe0, 0x11, 0x62, 0x2d, 0xca, 0xff,
+						0x00, 0x00, 0x52, 0x63, 0x62, 0x00, 0x00, 0x00,
+						0x00, 0x00, 0x0a, 0x00, 0x08, 0x02, 0x98, 0x96,
+						0xf5, 0x00, 0x00, 0x00, 0x87, 0x00, 0x00, 0x00,
+						0x00,
+					},
+				},
+			},
+			Segments: []*playlist.MediaSegment{
+				{
+					DateTime: ptrOf(time.Date(2014, 3, 5, 11, 14, 58, 0, time.UTC)),
+					Duration: 2 * time.Second,
+					URI:      "seg1.mp4",
+					DateRanges: []*playlist.MediaDateRange{
+						{
+							ID:        "splice-6FFFFFF0",
+							StartDate: time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC),
+							Duration:  ptrOf(59993 * time.Millisecond),
+							SCTE35In:  []byte{0xfc, 0x00, 0x2a},
+						},
+						{
+							ID:        "program",
+							Class:     "com.example.program",
+							StartDate: time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC),
+							EndOnNext: true,
+							ClientAttributes: map[string]playlist.MediaDateRangeClientAttribute{
+								"X-COM-EXAMPLE-TITLE":   {Value: "News, at noon", Quoted: true},
+								"X-COM-EXAMPLE-ID":      {Value: "0xAB01"},
+								"X-COM-EXAMPLE-RATING":  {Value: "4.5"},
+								"X-COM-EXAMPLE-CODE":    {Value: "0x1F", Quoted: true},
+								"X-COM-EXAMPLE-VERSION": {Value: "4.5", Quoted: true},
+							},
+						},
+					},
+				},
+				{
+					Duration: 2 * time.Second,
+					URI:      "seg2.mp4",
+				},
+			},
+		},
+	},
 	{
 		"apple vod",
 		`#EXTM3U
@@ -390,6 +493,59 @@ segment1.ts
 			},
 		},
 	},
+	{
+		"key-parts",
+		`#EXTM3U
+#EXT-X-VERSION:10
+#EXT-X-TARGETDURATION:2
+#EXT-X-PART-INF:PART-TARGET=1.00000
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin"
+#EXTINF:2.00000,
+segment1.mp4
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key1.bin"
+#EXT-X-PART:DURATION=1.00000,URI="part1.mp4",INDEPENDENT=YES
+`,
+		`#EXTM3U
+#EXT-X-VERSION:10
+#EXT-X-TARGETDURATION:2
+#EXT-X-PART-INF:PART-TARGET=1.00000
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin"
+#EXTINF:2.00000,
+segment1.mp4
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key1.bin"
+#EXT-X-PART:DURATION=1.00000,URI="part1.mp4",INDEPENDENT=YES
+`,
+		playlist.Media{
+			Version:        10,
+			TargetDuration: 2,
+			PartInf: &playlist.MediaPartInf{
+				PartTarget: 1 * time.Second,
+			},
+			Segments: []*playlist.MediaSegment{
+				{
+					Duration: 2 * time.Second,
+					URI:      "segment1.mp4",
+					Key: &playlist.MediaKey{
+						Method: playlist.MediaKeyMethodSampleAES,
+						URI:    "key0.bin",
+					},
+				},
+			},
+			Parts: []*playlist.MediaPart{
+				{
+					Duration:    1 * time.Second,
+					Independent: true,
+					URI:         "part1.mp4",
+				},
+			},
+			PartsKey: &playlist.MediaKey{
+				Method: playlist.MediaKeyMethodSampleAES,
+				URI:    "key1.bin",
+			},
+		},
+	},
 	{
 		"missing extinf comma",
 		`#EXTM3U
@@ -421,6 +577,52 @@ segment1.ts
 			},
 		},
 	},
+	{
+		"define",
+		`#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:NAME="base",VALUE="https://cdn.example.com/live"
+#EXT-X-DEFINE:NAME="token-1",VALUE="abc"
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="{$base}/init.mp4?t={$token-1}"
+#EXTINF:4.00000,{$base}
+{$base}/segment1.mp4?t={$token-1}
+`,
+		`#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:NAME="base",VALUE="https://cdn.example.com/live"
+#EXT-X-DEFINE:NAME="token-1",VALUE="abc"
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="https://cdn.example.com/live/init.mp4?t=abc"
+#EXTINF:4.00000,{$base}
+https://cdn.example.com/live/segment1.mp4?t=abc
+`,
+		playlist.Media{
+			Version: 8,
+			Defines: []*playlist.MediaDefine{
+				{
+					Name:  "base",
+					Value: "https://cdn.example.com/live",
+				},
+				{
+					Name:  "token-1",
+					Value: "abc",
+				},
+			},
+			TargetDuration: 4,
+			Map: &playlist.MediaMap{
+				URI: "https://cdn.example.com/live/init.mp4?t=abc",
+			},
+			Segments: []*playlist.MediaSegment{
+				{
+					Duration: 4 * time.Second,
+					Title:    "{$base}",
+					URI:      "https://cdn.example.com/live/segment1.mp4?t=abc",
+				},
+			},
+		},
+	},
 }
 
 func TestMediaUnmarshal(t *testing.T) {
@@ -497,6 +699,354 @@ func TestMediaUnmarshalDateTime(t *testing.T) {
 	}
 }
 
+func TestMediaUnmarshalDateRangeErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name      string
+		dateRange string
+		err       string
+	}{
+		{
+			"missing id",
+			`START-DATE="2014-03-05T11:15:00Z"`,
+			"ID is missing",
+		},
+		{
+			"missing start date",
+			`ID="a"`,
+			"START-DATE is missing",
+		},
+		{
+			"end date before start date",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",END-DATE="2014-03-05T11:14:00Z"`,
+			"END-DATE is before START-DATE",
+		},
+		{
+			"end date and duration mismatch",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",END-DATE="2014-03-05T11:16:00Z",DURATION=30`,
+			"END-DATE is not equal to START-DATE plus DURATION",
+		},
+		{
+			"negative duration",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",DURATION=-1`,
+			"DURATION is negative",
+		},
+		{
+			"end on next without class",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",END-ON-NEXT=YES`,
+			"END-ON-NEXT requires CLASS",
+		},
+		{
+			"end on next with duration",
+			`ID="a",CLASS="b",START-DATE="2014-03-05T11:15:00Z",END-ON-NEXT=YES,DURATION=1`,
+			"END-ON-NEXT is incompatible with DURATION and END-DATE",
+		},
+		{
+			"invalid scte35",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",SCTE35-CMD=FC00`,
+			"invalid hexadecimal sequence: FC00",
+		},
+		{
+			"invalid client attribute",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",X-abc=1`,
+			"invalid client attribute name: X-abc",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var m playlist.Media
+			err := m.Unmarshal([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:9\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXT-X-DATERANGE:" + ca.dateRange + "\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2014-03-05T11:14:58Z\n" +
+				"#EXTINF:2.00000,\n" +
+				"seg1.mp4\n"))
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMediaUnmarshalDateRangeWithoutDateTime(t *testing.T) {
+	var m playlist.Media
+	err := m.Unmarshal([]byte("#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-DATERANGE:ID=\"a\",START-DATE=\"2014-03-05T11:15:00Z\"\n" +
+		"#EXTINF:2.00000,\n" +
+		"seg1.mp4\n" +
+		"#EXT-X-DATERANGE:ID=\"b\",START-DATE=\"2014-03-05T11:15:00Z\"\n" +
+		"#EXTINF:2.00000,\n" +
+		"seg2.mp4\n"))
+	require.NoError(t, err)
+	require.Empty(t, m.AllDateRanges())
+
+	m.DateRanges = []*playlist.MediaDateRange{{
+		ID:        "a",
+		StartDate: time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC),
+	}}
+
+	_, err = m.Marshal()
+	require.EqualError(t, err, "EXT-X-DATERANGE requires EXT-X-PROGRAM-DATE-TIME")
+}
+
+func TestMediaUnmarshalDateRangeSameID(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		second string
+		err    string
+	}{
+		{
+			"additional attributes",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",DURATION=1,X-B="c",SCTE35-IN=0xFC`,
+			"",
+		},
+		{
+			"different class",
+			`ID="a",CLASS="d",START-DATE="2014-03-05T11:15:00Z"`,
+			"EXT-X-DATERANGE tags with ID 'a' have different attributes",
+		},
+		{
+			"different planned duration",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",PLANNED-DURATION=2`,
+			"EXT-X-DATERANGE tags with ID 'a' have different attributes",
+		},
+		{
+			"different scte35",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",SCTE35-OUT=0xFC01`,
+			"EXT-X-DATERANGE tags with ID 'a' have different attributes",
+		},
+		{
+			"different client attribute",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",X-A=1`,
+			"EXT-X-DATERANGE tags with ID 'a' have different attributes",
+		},
+		{
+			"different client attribute type",
+			`ID="a",START-DATE="2014-03-05T11:15:00Z",X-A="2"`,
+			"EXT-X-DATERANGE tags with ID 'a' have different attributes",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var m playlist.Media
+			err := m.Unmarshal([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:9\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXT-X-DATERANGE:ID=\"a\",CLASS=\"b\",START-DATE=\"2014-03-05T11:15:00Z\"," +
+				"PLANNED-DURATION=1,SCTE35-OUT=0xFC00,X-A=2\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2014-03-05T11:14:58Z\n" +
+				"#EXTINF:2.00000,\n" +
+				"seg1.mp4\n" +
+				"#EXT-X-DATERANGE:" + ca.second + "\n" +
+				"#EXTINF:2.00000,\n" +
+				"seg2.mp4\n"))
+			if ca.err == "" {
+				require.NoError(t, err)
+				require.Len(t, m.AllDateRanges(), 2)
+			} else {
+				require.EqualError(t, err, ca.err)
+			}
+		})
+	}
+}
+
+func TestMediaMarshalDateRangeClientAttributeErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		attr playlist.MediaDateRangeClientAttribute
+		err  string
+	}{
+		{
+			"unquoted string",
+			playlist.MediaDateRangeClientAttribute{Value: "abc"},
+			"invalid client attribute value: abc",
+		},
+		{
+			"quote in quoted string",
+			playlist.MediaDateRangeClientAttribute{Value: "a\"b", Quoted: true},
+			"invalid quoted-string: a\"b",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := playlist.Media{
+				Version:        9,
+				TargetDuration: 2,
+				DateRanges: []*playlist.MediaDateRange{{
+					ID:               "a",
+					StartDate:        time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC),
+					ClientAttributes: map[string]playlist.MediaDateRangeClientAttribute{"X-A": ca.attr},
+				}},
+				Segments: []*playlist.MediaSegment{{
+					DateTime: ptrOf(time.Date(2014, 3, 5, 11, 14, 58, 0, time.UTC)),
+					Duration: 2 * time.Second,
+					URI:      "seg1.mp4",
+				}},
+			}
+
+			_, err := m.Marshal()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMediaMarshalDateRangeErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name      string
+		dateRange playlist.MediaDateRange
+		err       string
+	}{
+		{
+			"quote in id",
+			playlist.MediaDateRange{ID: "a\"b"},
+			"invalid ID: a\"b",
+		},
+		{
+			"quote in class",
+			playlist.MediaDateRange{ID: "a", Class: "b\"c"},
+			"invalid CLASS: b\"c",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			ca.dateRange.StartDate = time.Date(2014, 3, 5, 11, 15, 0, 0, time.UTC)
+
+			m := playlist.Media{
+				Version:        9,
+				TargetDuration: 2,
+				DateRanges:     []*playlist.MediaDateRange{&ca.dateRange},
+				Segments: []*playlist.MediaSegment{{
+					DateTime: ptrOf(time.Date(2014, 3, 5, 11, 14, 58, 0, time.UTC)),
+					Duration: 2 * time.Second,
+					URI:      "seg1.mp4",
+				}},
+			}
+
+			_, err := m.Marshal()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestMediaMarshalSkipWithoutRemovedDateRanges(t *testing.T) {
+	m := playlist.Media{
+		Version:        9,
+		TargetDuration: 2,
+		Skip: &playlist.MediaSkip{
+			SkippedSegments:           3,
+			RecentlyRemovedDateRanges: []string{},
+		},
+		Segments: []*playlist.MediaSegment{{
+			Duration: 2 * time.Second,
+			URI:      "seg1.mp4",
+		}},
+	}
+
+	byts, err := m.Marshal()
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "#EXT-X-SKIP:SKIPPED-SEGMENTS=3\n")
+}
+
+func TestMediaUnmarshalDefineWithContext(t *testing.T) {
+	u, err := url.Parse("http://localhost/stream.m3u8?token=a%2Fb&other=1")
+	require.NoError(t, err)
+
+	var m playlist.Media
+	err = m.UnmarshalWithContext([]byte("#EXTM3U\n"+
+		"#EXT-X-VERSION:8\n"+
+		"#EXT-X-DEFINE:IMPORT=\"host\"\n"+
+		"#EXT-X-DEFINE:QUERYPARAM=\"token\"\n"+
+		"#EXT-X-TARGETDURATION:2\n"+
+		"#EXTINF:2.00000,\n"+
+		"http://{$host}/seg1.mp4?token={$token}\n"),
+		playlist.UnmarshalContext{
+			URL:       u,
+			Variables: map[string]string{"host": "cdn1.example.com"},
+		})
+	require.NoError(t, err)
+	require.Equal(t, []*playlist.MediaDefine{
+		{
+			Import: "host",
+			Value:  "cdn1.example.com",
+		},
+		{
+			QueryParam: "token",
+			Value:      "a/b",
+		},
+	}, m.Defines)
+	require.Equal(t, "http://cdn1.example.com/seg1.mp4?token=a/b", m.Segments[0].URI)
+
+	byts, err := m.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:8\n"+
+		"#EXT-X-DEFINE:IMPORT=\"host\"\n"+
+		"#EXT-X-DEFINE:QUERYPARAM=\"token\"\n"+
+		"#EXT-X-TARGETDURATION:2\n"+
+		"#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXTINF:2.00000,\n"+
+		"http://cdn1.example.com/seg1.mp4?token=a/b\n", string(byts))
+}
+
+func TestMediaUnmarshalDefineErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		define string
+		uri    string
+		err    string
+	}{
+		{
+			"missing name",
+			`VALUE="a"`,
+			"seg1.mp4",
+			"exactly one between NAME, IMPORT and QUERYPARAM must be present",
+		},
+		{
+			"missing value",
+			`NAME="a"`,
+			"seg1.mp4",
+			"VALUE missing",
+		},
+		{
+			"invalid name",
+			`NAME="a.b",VALUE="c"`,
+			"seg1.mp4",
+			"invalid variable name: 'a.b'",
+		},
+		{
+			"duplicate name",
+			"NAME=\"a\",VALUE=\"b\"\n#EXT-X-DEFINE:NAME=\"a\",VALUE=\"c\"",
+			"seg1.mp4",
+			"variable 'a' is defined twice",
+		},
+		{
+			"missing import",
+			`IMPORT="a"`,
+			"seg1.mp4",
+			"variable 'a' is not defined by the multivariant playlist",
+		},
+		{
+			"missing query parameter",
+			`QUERYPARAM="a"`,
+			"seg1.mp4",
+			"query parameter 'a' not found",
+		},
+		{
+			"undefined reference",
+			`NAME="a",VALUE="b"`,
+			"{$c}/seg1.mp4",
+			"variable 'c' is not defined",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var m playlist.Media
+			err := m.Unmarshal([]byte("#EXTM3U\n" +
+				"#EXT-X-VERSION:8\n" +
+				"#EXT-X-DEFINE:" + ca.define + "\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXTINF:2.00000,\n" +
+				ca.uri + "\n"))
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
 func TestMediaMarshal(t *testing.T) {
 	for _, ca := range casesMedia {
 		t.Run(ca.name, func(t *testing.T) {
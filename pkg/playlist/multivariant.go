@@ -16,9 +16,21 @@ type Multivariant struct {
 	// EXT-X-INDEPENDENT-SEGMENTS
 	IndependentSegments bool
 
+	// EXT-X-DEFINE
+	Defines []*MultivariantDefine
+
 	// EXT-X-START
 	Start *MultivariantStart
 
+	// EXT-X-SESSION-DATA
+	SessionData []*MultivariantSessionData
+
+	// EXT-X-SESSION-KEY
+	SessionKeys []*MultivariantSessionKey
+
+	// EXT-X-CONTENT-STEERING
+	ContentSteering *MultivariantContentSteering
+
 	// EXT-X-STREAM-INF (at least one is required)
 	Variants []*MultivariantVariant
 
@@ -30,6 +42,11 @@ func (m Multivariant) isPlaylist() {}
 
 // Unmarshal decodes the playlist.
 func (m *Multivariant) Unmarshal(buf []byte) error {
+	return m.UnmarshalWithContext(buf, UnmarshalContext{})
+}
+
+// UnmarshalWithContext decodes the playlist, resolving variables with the given context.
+func (m *Multivariant) UnmarshalWithContext(buf []byte, uc UnmarshalContext) error {
 	s := string(buf)
 
 	s, err := primitives.SkipHeader(s)
@@ -37,6 +54,8 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 		return err
 	}
 
+	vars := make(variables)
+
 	for {
 		var line string
 		line, s = primitives.ReadLine(s)
@@ -44,6 +63,14 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 			break
 		}
 
+		// values of variables are not subject to substitution
+		if !strings.HasPrefix(line, "#EXT-X-DEFINE:") {
+			line, err = vars.substituteLine(line)
+			if err != nil {
+				return err
+			}
+		}
+
 		switch {
 		case strings.HasPrefix(line, "#EXT-X-VERSION:"):
 			line = line[len("#EXT-X-VERSION:"):]
@@ -62,6 +89,21 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 		case strings.HasPrefix(line, "#EXT-X-INDEPENDENT-SEGMENTS"):
 			m.IndependentSegments = true
 
+		case strings.HasPrefix(line, "#EXT-X-DEFINE:"):
+			line = line[len("#EXT-X-DEFINE:"):]
+
+			d := &MultivariantDefine{}
+			err = d.unmarshal(line)
+			if err != nil {
+				return err
+			}
+
+			err = vars.define(d, uc, false)
+			if err != nil {
+				return err
+			}
+			m.Defines = append(m.Defines, d)
+
 		case strings.HasPrefix(line, "#EXT-X-START:"):
 			line = line[len("#EXT-X-START:"):]
 
@@ -71,11 +113,50 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 				return err
 			}
 
+		case strings.HasPrefix(line, "#EXT-X-SESSION-DATA:"):
+			line = line[len("#EXT-X-SESSION-DATA:"):]
+
+			// invalid tags are skipped instead of rejecting the playlist,
+			// since session data is not required for playback.
+			var sd MultivariantSessionData
+			err = sd.unmarshal(line)
+			if err != nil || m.hasSessionData(sd.DataID, sd.Language) {
+				continue
+			}
+
+			m.SessionData = append(m.SessionData, &sd)
+
+		case strings.HasPrefix(line, "#EXT-X-SESSION-KEY:"):
+			line = line[len("#EXT-X-SESSION-KEY:"):]
+
+			var sk MultivariantSessionKey
+			err = sk.unmarshal(line)
+			if err != nil {
+				return fmt.Errorf("invalid session key: %w", err)
+			}
+
+			m.SessionKeys = append(m.SessionKeys, &sk)
+
+		case strings.HasPrefix(line, "#EXT-X-CONTENT-STEERING:"):
+			line = line[len("#EXT-X-CONTENT-STEERING:"):]
+
+			m.ContentSteering = &MultivariantContentSteering{}
+			err = m.ContentSteering.unmarshal(line)
+			if err != nil {
+				return fmt.Errorf("invalid content steering: %w", err)
+			}
+
 		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
 			line = line[len("#EXT-X-STREAM-INF:"):]
 
 			var line2 string
 			line2, s = primitives.ReadLine(s)
+
+			line2, err = vars.substituteLine(line2)
+			if err != nil {
+				return err
+			}
+
 			line += "\n" + line2
 
 			var v MultivariantVariant
@@ -106,6 +187,40 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 	return nil
 }
 
+func (m Multivariant) hasSessionData(dataID string, language string) bool {
+	for _, sd := range m.SessionData {
+		if sd.DataID == dataID && sd.Language == language {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Multivariant) validateSessionData() error {
+	// A Playlist MUST NOT contain more than one EXT-X-SESSION-DATA tag with the
+	// same DATA-ID attribute and the same LANGUAGE attribute.
+	for i, sd := range m.SessionData {
+		for _, other := range m.SessionData[:i] {
+			if other.DataID == sd.DataID && other.Language == sd.Language {
+				return fmt.Errorf("multiple EXT-X-SESSION-DATA tags with DATA-ID '%s' and LANGUAGE '%s'",
+					sd.DataID, sd.Language)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Variables returns values of variables defined through EXT-X-DEFINE tags.
+// They can be imported by media playlists.
+func (m Multivariant) Variables() map[string]string {
+	ret := make(map[string]string)
+	for _, d := range m.Defines {
+		ret[d.VariableName()] = d.Value
+	}
+	return ret
+}
+
 // Marshal encodes the playlist.
 func (m Multivariant) Marshal() ([]byte, error) {
 	var ret strings.Builder
@@ -116,10 +231,57 @@ func (m Multivariant) Marshal() ([]byte, error) {
 		ret.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
+	for _, d := range m.Defines {
+		err := d.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if d.Import != "" {
+			return nil, fmt.Errorf("IMPORT is not allowed in multivariant playlists")
+		}
+
+		ret.WriteString(d.marshal())
+	}
+
 	if m.Start != nil {
 		ret.WriteString(m.Start.marshal())
 	}
 
+	for _, sd := range m.SessionData {
+		err := sd.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := m.validateSessionData()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sd := range m.SessionData {
+		ret.WriteString(sd.marshal())
+	}
+
+	for _, sk := range m.SessionKeys {
+		err := sk.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		ret.WriteString(sk.marshal())
+	}
+
+	if m.ContentSteering != nil {
+		err := m.ContentSteering.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		ret.WriteString(m.ContentSteering.marshal())
+	}
+
 	if len(m.Renditions) != 0 {
 		ret.WriteString("\n")
 
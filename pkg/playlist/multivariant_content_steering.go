@@ -0,0 +1,78 @@
+package playlist
+
+import (
+	"fmt"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+// MultivariantContentSteering is a EXT-X-CONTENT-STEERING tag.
+type MultivariantContentSteering struct {
+	// SERVER-URI
+	// required
+	ServerURI string
+
+	// PATHWAY-ID
+	// pathway to use until the steering manifest is obtained.
+	PathwayID string
+}
+
+func (t *MultivariantContentSteering) unmarshal(v string) error {
+	var attrs primitives.Attributes
+	err := attrs.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range attrs {
+		switch key {
+		case "SERVER-URI":
+			t.ServerURI = val
+
+		case "PATHWAY-ID":
+			t.PathwayID = val
+		}
+	}
+
+	return t.validate()
+}
+
+func (t MultivariantContentSteering) validate() error {
+	if t.ServerURI == "" {
+		return fmt.Errorf("SERVER-URI missing")
+	}
+
+	if t.PathwayID != "" && !isPathwayID(t.PathwayID) {
+		return fmt.Errorf("invalid PATHWAY-ID: %s", t.PathwayID)
+	}
+
+	return nil
+}
+
+func (t MultivariantContentSteering) marshal() string {
+	ret := "#EXT-X-CONTENT-STEERING:SERVER-URI=\"" + t.ServerURI + "\""
+
+	if t.PathwayID != "" {
+		ret += ",PATHWAY-ID=\"" + t.PathwayID + "\""
+	}
+
+	ret += "\n"
+
+	return ret
+}
+
+// isPathwayID checks whether a string is a valid PATHWAY-ID,
+// that can contain only characters from the set [a-z], [A-Z], [0-9], '.', '-', and '_'.
+func isPathwayID(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, c := range v {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '.' && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package playlist
+
+import (
+	"fmt"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+// MultivariantDefine is a EXT-X-DEFINE tag.
+// Exactly one between Name, Import and QueryParam must be filled.
+type MultivariantDefine struct {
+	// NAME
+	Name string
+
+	// VALUE
+	// When the variable is imported or read from a query parameter,
+	// it is filled with the resolved value after unmarshaling.
+	Value string
+
+	// IMPORT
+	// Allowed in media playlists only.
+	Import string
+
+	// QUERYPARAM
+	QueryParam string
+}
+
+// VariableName returns the name of the defined variable.
+func (t MultivariantDefine) VariableName() string {
+	switch {
+	case t.Name != "":
+		return t.Name
+
+	case t.Import != "":
+		return t.Import
+
+	default:
+		return t.QueryParam
+	}
+}
+
+func (t *MultivariantDefine) unmarshal(v string) error {
+	var attrs primitives.Attributes
+	err := attrs.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	valueFound := false
+
+	for key, val := range attrs {
+		switch key {
+		case "NAME":
+			t.Name = val
+
+		case "VALUE":
+			t.Value = val
+			valueFound = true
+
+		case "IMPORT":
+			t.Import = val
+
+		case "QUERYPARAM":
+			t.QueryParam = val
+		}
+	}
+
+	err = t.validate()
+	if err != nil {
+		return err
+	}
+
+	if t.Name != "" && !valueFound {
+		return fmt.Errorf("VALUE missing")
+	}
+
+	return nil
+}
+
+func (t MultivariantDefine) validate() error {
+	n := 0
+	for _, v := range []string{t.Name, t.Import, t.QueryParam} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one between NAME, IMPORT and QUERYPARAM must be present")
+	}
+
+	if !isVariableName(t.VariableName()) {
+		return fmt.Errorf("invalid variable name: '%s'", t.VariableName())
+	}
+
+	return nil
+}
+
+func (t MultivariantDefine) marshal() string {
+	switch {
+	case t.Name != "":
+		return "#EXT-X-DEFINE:NAME=\"" + t.Name + "\",VALUE=\"" + t.Value + "\"\n"
+
+	case t.Import != "":
+		return "#EXT-X-DEFINE:IMPORT=\"" + t.Import + "\"\n"
+
+	default:
+		return "#EXT-X-DEFINE:QUERYPARAM=\"" + t.QueryParam + "\"\n"
+	}
+}
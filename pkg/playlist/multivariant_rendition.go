@@ -54,6 +54,9 @@ type MultivariantRendition struct {
 	// INSTREAM-ID
 	// for CLOSED-CAPTIONS only
 	InStreamID *string
+
+	// STABLE-RENDITION-ID
+	StableRenditionID string
 }
 
 func (t *MultivariantRendition) unmarshal(v string) error {
@@ -101,6 +104,9 @@ func (t *MultivariantRendition) unmarshal(v string) error {
 
 		case "INSTREAM-ID":
 			t.InStreamID = ptrOf(val)
+
+		case "STABLE-RENDITION-ID":
+			t.StableRenditionID = val
 		}
 	}
 
@@ -184,6 +190,10 @@ func (t MultivariantRendition) marshal() string {
 		ret += ",INSTREAM-ID=\"" + *t.InStreamID + "\""
 	}
 
+	if t.StableRenditionID != "" {
+		ret += ",STABLE-RENDITION-ID=\"" + t.StableRenditionID + "\""
+	}
+
 	ret += "\n"
 
 	return ret
@@ -0,0 +1,114 @@
+package playlist
+
+import (
+	"fmt"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+// MultivariantSessionDataFormat is a EXT-X-SESSION-DATA format.
+type MultivariantSessionDataFormat string
+
+// standard formats.
+const (
+	MultivariantSessionDataFormatJSON MultivariantSessionDataFormat = "JSON"
+	MultivariantSessionDataFormatRaw  MultivariantSessionDataFormat = "RAW"
+)
+
+// MultivariantSessionData is a EXT-X-SESSION-DATA tag.
+type MultivariantSessionData struct {
+	// DATA-ID
+	// required
+	DataID string
+
+	// VALUE
+	// required unless URI is present
+	Value *string
+
+	// URI
+	// required unless VALUE is present
+	URI *string
+
+	// FORMAT
+	// for URI only
+	Format MultivariantSessionDataFormat
+
+	// LANGUAGE
+	Language string
+}
+
+func (t *MultivariantSessionData) unmarshal(v string) error {
+	var attrs primitives.Attributes
+	err := attrs.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range attrs {
+		switch key {
+		case "DATA-ID":
+			t.DataID = val
+
+		case "VALUE":
+			t.Value = &val
+
+		case "URI":
+			t.URI = &val
+
+		case "FORMAT":
+			t.Format = MultivariantSessionDataFormat(val)
+
+		case "LANGUAGE":
+			t.Language = val
+		}
+	}
+
+	return t.validate()
+}
+
+func (t MultivariantSessionData) validate() error {
+	if t.DataID == "" {
+		return fmt.Errorf("DATA-ID missing")
+	}
+
+	if (t.Value != nil) == (t.URI != nil) {
+		return fmt.Errorf("exactly one between VALUE and URI must be present")
+	}
+
+	if t.Format != "" {
+		if t.URI == nil {
+			return fmt.Errorf("FORMAT requires URI")
+		}
+
+		if t.Format != MultivariantSessionDataFormatJSON &&
+			t.Format != MultivariantSessionDataFormatRaw {
+			return fmt.Errorf("invalid format: %s", t.Format)
+		}
+	}
+
+	return nil
+}
+
+func (t MultivariantSessionData) marshal() string {
+	ret := "#EXT-X-SESSION-DATA:DATA-ID=\"" + t.DataID + "\""
+
+	if t.Value != nil {
+		ret += ",VALUE=\"" + *t.Value + "\""
+	}
+
+	if t.URI != nil {
+		ret += ",URI=\"" + *t.URI + "\""
+	}
+
+	if t.Format != "" {
+		ret += ",FORMAT=" + string(t.Format)
+	}
+
+	if t.Language != "" {
+		ret += ",LANGUAGE=\"" + t.Language + "\""
+	}
+
+	ret += "\n"
+
+	return ret
+}
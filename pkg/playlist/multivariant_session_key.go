@@ -0,0 +1,41 @@
+package playlist
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultivariantSessionKey is a EXT-X-SESSION-KEY tag.
+// It allows to preload keys of media playlists.
+type MultivariantSessionKey MediaKey
+
+func (t *MultivariantSessionKey) unmarshal(v string) error {
+	err := (*MediaKey)(t).unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	return t.validate()
+}
+
+func (t MultivariantSessionKey) validate() error {
+	switch t.Method {
+	case MediaKeyMethodAES128, MediaKeyMethodSampleAES:
+		if t.URI == "" {
+			return fmt.Errorf("URI is required for method %s", t.Method)
+		}
+
+	case MediaKeyMethodNone:
+		// The value of the METHOD attribute MUST NOT be NONE.
+		return fmt.Errorf("method %s is not allowed", t.Method)
+
+	default:
+		return fmt.Errorf("METHOD missing")
+	}
+
+	return nil
+}
+
+func (t MultivariantSessionKey) marshal() string {
+	return "#EXT-X-SESSION-KEY:" + strings.TrimPrefix(MediaKey(t).marshal(), "#EXT-X-KEY:")
+}
@@ -2,6 +2,7 @@
 package playlist_test
 
 import (
+	"net/url"
 	"testing"
 	"time"
 
@@ -850,6 +851,131 @@ QualityLevels(5977913)/Manifest(video,format=m3u8-aapl)
 			},
 		},
 	},
+	{
+		"session data and keys",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:7\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Example title\",LANGUAGE=\"en\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Titolo di esempio\",LANGUAGE=\"it\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.lyrics\",URI=\"lyrics.json\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.raw\",URI=\"raw.bin\",FORMAT=RAW\n" +
+			"#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI=\"skd://key0\",KEYFORMAT=\"com.apple.streamingkeydelivery\",KEYFORMATVERSIONS=\"1\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\"\n" +
+			"stream1.m3u8\n",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:7\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Example title\",LANGUAGE=\"en\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Titolo di esempio\",LANGUAGE=\"it\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.lyrics\",URI=\"lyrics.json\"\n" +
+			"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.raw\",URI=\"raw.bin\",FORMAT=RAW\n" +
+			"#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI=\"skd://key0\",KEYFORMAT=\"com.apple.streamingkeydelivery\",KEYFORMATVERSIONS=\"1\"\n" +
+			"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\"\n" +
+			"stream1.m3u8\n",
+		playlist.Multivariant{
+			Version: 7,
+			SessionData: []*playlist.MultivariantSessionData{
+				{
+					DataID:   "com.example.title",
+					Value:    ptrOf("Example title"),
+					Language: "en",
+				},
+				{
+					DataID:   "com.example.title",
+					Value:    ptrOf("Titolo di esempio"),
+					Language: "it",
+				},
+				{
+					DataID: "com.example.lyrics",
+					URI:    ptrOf("lyrics.json"),
+				},
+				{
+					DataID: "com.example.raw",
+					URI:    ptrOf("raw.bin"),
+					Format: playlist.MultivariantSessionDataFormatRaw,
+				},
+			},
+			SessionKeys: []*playlist.MultivariantSessionKey{
+				{
+					Method:            playlist.MediaKeyMethodSampleAES,
+					URI:               "skd://key0",
+					KeyFormat:         "com.apple.streamingkeydelivery",
+					KeyFormatVersions: "1",
+				},
+			},
+			Variants: []*playlist.MultivariantVariant{
+				{
+					Bandwidth: 155000,
+					Codecs:    []string{"avc1.42c028"},
+					URI:       "stream1.m3u8",
+				},
+			},
+		},
+	},
+	{
+		"content steering",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-CONTENT-STEERING:SERVER-URI=\"/steering?video=abc\",PATHWAY-ID=\"CDN-A\"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud-a\",NAME=\"english\",URI=\"https://cdn-a.example.com/audio.m3u8\",STABLE-RENDITION-ID=\"en\"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud-b\",NAME=\"english\",URI=\"https://cdn-b.example.com/audio.m3u8\",STABLE-RENDITION-ID=\"en\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028,mp4a.40.2\",AUDIO=\"aud-a\",STABLE-VARIANT-ID=\"hd\",PATHWAY-ID=\"CDN-A\"\n" +
+			"https://cdn-a.example.com/stream.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028,mp4a.40.2\",AUDIO=\"aud-b\",STABLE-VARIANT-ID=\"hd\",PATHWAY-ID=\"CDN-B\"\n" +
+			"https://cdn-b.example.com/stream.m3u8\n",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-CONTENT-STEERING:SERVER-URI=\"/steering?video=abc\",PATHWAY-ID=\"CDN-A\"\n" +
+			"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud-a\",NAME=\"english\",URI=\"https://cdn-a.example.com/audio.m3u8\",STABLE-RENDITION-ID=\"en\"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud-b\",NAME=\"english\",URI=\"https://cdn-b.example.com/audio.m3u8\",STABLE-RENDITION-ID=\"en\"\n" +
+			"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028,mp4a.40.2\",AUDIO=\"aud-a\",STABLE-VARIANT-ID=\"hd\",PATHWAY-ID=\"CDN-A\"\n" +
+			"https://cdn-a.example.com/stream.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028,mp4a.40.2\",AUDIO=\"aud-b\",STABLE-VARIANT-ID=\"hd\",PATHWAY-ID=\"CDN-B\"\n" +
+			"https://cdn-b.example.com/stream.m3u8\n",
+		playlist.Multivariant{
+			Version: 9,
+			ContentSteering: &playlist.MultivariantContentSteering{
+				ServerURI: "/steering?video=abc",
+				PathwayID: "CDN-A",
+			},
+			Variants: []*playlist.MultivariantVariant{
+				{
+					Bandwidth:       155000,
+					Codecs:          []string{"avc1.42c028", "mp4a.40.2"},
+					URI:             "https://cdn-a.example.com/stream.m3u8",
+					Audio:           "aud-a",
+					StableVariantID: "hd",
+					PathwayID:       "CDN-A",
+				},
+				{
+					Bandwidth:       155000,
+					Codecs:          []string{"avc1.42c028", "mp4a.40.2"},
+					URI:             "https://cdn-b.example.com/stream.m3u8",
+					Audio:           "aud-b",
+					StableVariantID: "hd",
+					PathwayID:       "CDN-B",
+				},
+			},
+			Renditions: []*playlist.MultivariantRendition{
+				{
+					Type:              playlist.MultivariantRenditionTypeAudio,
+					GroupID:           "aud-a",
+					Name:              "english",
+					URI:               ptrOf("https://cdn-a.example.com/audio.m3u8"),
+					StableRenditionID: "en",
+				},
+				{
+					Type:              playlist.MultivariantRenditionTypeAudio,
+					GroupID:           "aud-b",
+					Name:              "english",
+					URI:               ptrOf("https://cdn-b.example.com/audio.m3u8"),
+					StableRenditionID: "en",
+				},
+			},
+		},
+	},
 }
 
 func TestMultivariantUnmarshal(t *testing.T) {
@@ -901,6 +1027,117 @@ gear4/prog_index.m3u8
 	require.Equal(t, dec, m)
 }
 
+func TestMultivariantUnmarshalDefine(t *testing.T) {
+	u, err := url.Parse("http://localhost/index.m3u8?token=abc")
+	require.NoError(t, err)
+
+	var m playlist.Multivariant
+	err = m.UnmarshalWithContext([]byte("#EXTM3U\n"+
+		"#EXT-X-VERSION:8\n"+
+		"#EXT-X-DEFINE:NAME=\"host\",VALUE=\"cdn1.example.com\"\n"+
+		"#EXT-X-DEFINE:QUERYPARAM=\"token\"\n"+
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud1\",NAME=\"english\",URI=\"http://{$host}/audio.m3u8?token={$token}\"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028,mp4a.40.2\",AUDIO=\"aud1\"\n"+
+		"http://{$host}/stream1.m3u8?token={$token}\n"),
+		playlist.UnmarshalContext{URL: u})
+	require.NoError(t, err)
+	require.Equal(t, "http://cdn1.example.com/audio.m3u8?token=abc", *m.Renditions[0].URI)
+	require.Equal(t, "http://cdn1.example.com/stream1.m3u8?token=abc", m.Variants[0].URI)
+	require.Equal(t, map[string]string{
+		"host":  "cdn1.example.com",
+		"token": "abc",
+	}, m.Variables())
+
+	err = m.Unmarshal([]byte("#EXTM3U\n" +
+		"#EXT-X-VERSION:8\n" +
+		"#EXT-X-DEFINE:IMPORT=\"host\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=155000\n" +
+		"stream1.m3u8\n"))
+	require.EqualError(t, err, "IMPORT is not allowed in multivariant playlists")
+}
+
+func TestMultivariantUnmarshalSessionKeyErrors(t *testing.T) {
+	var m playlist.Multivariant
+	err := m.Unmarshal([]byte("#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-SESSION-KEY:METHOD=NONE\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=155000\n" +
+		"stream1.m3u8\n"))
+	require.EqualError(t, err, "invalid session key: method NONE is not allowed")
+}
+
+func TestMultivariantUnmarshalInvalidSessionData(t *testing.T) {
+	var m playlist.Multivariant
+	err := m.Unmarshal([]byte("#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-SESSION-DATA:VALUE=\"a\"\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"b\",URI=\"c.json\"\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"b\",FORMAT=JSON\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"b\"\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"c\"\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"d\",LANGUAGE=\"en\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=155000\n" +
+		"stream1.m3u8\n"))
+	require.NoError(t, err)
+	require.Equal(t, []*playlist.MultivariantSessionData{
+		{
+			DataID: "a",
+			Value:  ptrOf("b"),
+		},
+		{
+			DataID:   "a",
+			Value:    ptrOf("d"),
+			Language: "en",
+		},
+	}, m.SessionData)
+}
+
+func TestMultivariantMarshalSessionDataErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		data []*playlist.MultivariantSessionData
+		err  string
+	}{
+		{
+			"missing data id",
+			[]*playlist.MultivariantSessionData{{Value: ptrOf("a")}},
+			"DATA-ID missing",
+		},
+		{
+			"value and uri",
+			[]*playlist.MultivariantSessionData{{DataID: "a", Value: ptrOf("b"), URI: ptrOf("c.json")}},
+			"exactly one between VALUE and URI must be present",
+		},
+		{
+			"format without uri",
+			[]*playlist.MultivariantSessionData{{
+				DataID: "a",
+				Value:  ptrOf("b"),
+				Format: playlist.MultivariantSessionDataFormatJSON,
+			}},
+			"FORMAT requires URI",
+		},
+		{
+			"duplicate",
+			[]*playlist.MultivariantSessionData{{DataID: "a", Value: ptrOf("b")}, {DataID: "a", Value: ptrOf("c")}},
+			"multiple EXT-X-SESSION-DATA tags with DATA-ID 'a' and LANGUAGE ''",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			m := playlist.Multivariant{
+				Version:     7,
+				SessionData: ca.data,
+				Variants: []*playlist.MultivariantVariant{{
+					Bandwidth: 155000,
+					URI:       "stream1.m3u8",
+				}},
+			}
+			_, err := m.Marshal()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
 func TestMultivariantMarshal(t *testing.T) {
 	for _, ca := range casesMultivariant {
 		t.Run(ca.name, func(t *testing.T) {
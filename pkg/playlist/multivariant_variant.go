@@ -42,6 +42,13 @@ type MultivariantVariant struct {
 
 	// CLOSED-CAPTIONS
 	ClosedCaptions string
+
+	// STABLE-VARIANT-ID
+	StableVariantID string
+
+	// PATHWAY-ID
+	// It defaults to ".".
+	PathwayID string
 }
 
 func (v *MultivariantVariant) unmarshal(va string) error {
@@ -96,6 +103,15 @@ func (v *MultivariantVariant) unmarshal(va string) error {
 
 		case "CLOSED-CAPTIONS":
 			v.ClosedCaptions = val
+
+		case "STABLE-VARIANT-ID":
+			v.StableVariantID = val
+
+		case "PATHWAY-ID":
+			if !isPathwayID(val) {
+				return fmt.Errorf("invalid PATHWAY-ID: %s", val)
+			}
+			v.PathwayID = val
 		}
 	}
 
@@ -140,6 +156,14 @@ func (v MultivariantVariant) marshal() string {
 		ret += ",CLOSED-CAPTIONS=\"" + v.ClosedCaptions + "\""
 	}
 
+	if v.StableVariantID != "" {
+		ret += ",STABLE-VARIANT-ID=\"" + v.StableVariantID + "\""
+	}
+
+	if v.PathwayID != "" {
+		ret += ",PATHWAY-ID=\"" + v.PathwayID + "\""
+	}
+
 	ret += "\n" + v.URI + "\n"
 
 	return ret
@@ -4,6 +4,7 @@ package playlist
 import (
 	"bufio"
 	"bytes"
+	"net/url"
 	"strings"
 )
 
@@ -11,9 +12,22 @@ const (
 	maxSupportedVersion = 10
 )
 
+// UnmarshalContext contains data that is used to resolve EXT-X-DEFINE tags
+// that read variables from outside the playlist.
+type UnmarshalContext struct {
+	// URL of the playlist.
+	// It is used to resolve QUERYPARAM attributes.
+	URL *url.URL
+
+	// variables defined by the multivariant playlist.
+	// They are used to resolve IMPORT attributes of media playlists.
+	Variables map[string]string
+}
+
 // Playlist is either Media or Multivariant.
 type Playlist interface {
 	Unmarshal([]byte) error
+	UnmarshalWithContext([]byte, UnmarshalContext) error
 	Marshal() ([]byte, error)
 
 	isPlaylist()
@@ -40,12 +54,17 @@ func findType(buf []byte) (Playlist, error) {
 
 // Unmarshal decodes a playlist.
 func Unmarshal(byts []byte) (Playlist, error) {
+	return UnmarshalWithContext(byts, UnmarshalContext{})
+}
+
+// UnmarshalWithContext decodes a playlist, resolving variables with the given context.
+func UnmarshalWithContext(byts []byte, uc UnmarshalContext) (Playlist, error) {
 	pl, err := findType(byts)
 	if err != nil {
 		return nil, err
 	}
 
-	err = pl.Unmarshal(byts)
+	err = pl.UnmarshalWithContext(byts, uc)
 	if err != nil {
 		return nil, err
 	}
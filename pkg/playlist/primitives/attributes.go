@@ -10,6 +10,21 @@ type Attributes map[string]string
 
 // Unmarshal decodes attributes.
 func (a *Attributes) Unmarshal(v string) error {
+	return a.unmarshal(v, nil)
+}
+
+// UnmarshalWithQuoted decodes attributes and returns the names of the ones
+// whose value is a quoted-string.
+func (a *Attributes) UnmarshalWithQuoted(v string) (map[string]struct{}, error) {
+	quoted := make(map[string]struct{})
+	err := a.unmarshal(v, quoted)
+	if err != nil {
+		return nil, err
+	}
+	return quoted, nil
+}
+
+func (a *Attributes) unmarshal(v string, quoted map[string]struct{}) error {
 	*a = make(Attributes)
 
 	for len(v) != 0 {
@@ -33,6 +48,10 @@ func (a *Attributes) Unmarshal(v string) error {
 			val, v = v[:i], v[i+1:]
 			(*a)[key] = val
 
+			if quoted != nil {
+				quoted[key] = struct{}{}
+			}
+
 			if len(v) != 0 {
 				if v[0] != ',' {
 					return fmt.Errorf("delimiter not found")
@@ -44,9 +63,11 @@ func (a *Attributes) Unmarshal(v string) error {
 			if i >= 0 {
 				val, v = v[:i], v[i+1:]
 				(*a)[key] = val
+				delete(quoted, key)
 			} else {
 				val = v
 				(*a)[key] = val
+				delete(quoted, key)
 				break
 			}
 		}
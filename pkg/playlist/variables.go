@@ -0,0 +1,144 @@
+package playlist
+
+import (
+	"fmt"
+	"strings"
+)
+
+func isVariableName(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, c := range v {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// variables are variables defined through EXT-X-DEFINE tags.
+type variables map[string]string
+
+// define adds the variable defined by a EXT-X-DEFINE tag,
+// resolving its value when it is imported or read from a query parameter.
+func (v variables) define(d *MultivariantDefine, uc UnmarshalContext, isMedia bool) error {
+	name := d.VariableName()
+
+	if _, ok := v[name]; ok {
+		return fmt.Errorf("variable '%s' is defined twice", name)
+	}
+
+	switch {
+	case d.Import != "":
+		if !isMedia {
+			return fmt.Errorf("IMPORT is not allowed in multivariant playlists")
+		}
+
+		val, ok := uc.Variables[name]
+		if !ok {
+			return fmt.Errorf("variable '%s' is not defined by the multivariant playlist", name)
+		}
+		d.Value = val
+
+	case d.QueryParam != "":
+		if uc.URL == nil {
+			return fmt.Errorf("query parameter '%s' not found", name)
+		}
+
+		q := uc.URL.Query()
+		if !q.Has(name) {
+			return fmt.Errorf("query parameter '%s' not found", name)
+		}
+		d.Value = q.Get(name)
+	}
+
+	v[name] = d.Value
+	return nil
+}
+
+// substitute replaces variable references ({$name}) in a string.
+// Values are not scanned again for references.
+func (v variables) substitute(s string) (string, error) {
+	if !strings.Contains(s, "{$") {
+		return s, nil
+	}
+
+	var ret strings.Builder
+
+	for {
+		i := strings.Index(s, "{$")
+		if i < 0 {
+			break
+		}
+
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			break
+		}
+
+		name := s[i+2 : i+2+j]
+
+		// not a variable reference
+		if !isVariableName(name) {
+			ret.WriteString(s[:i+2])
+			s = s[i+2:]
+			continue
+		}
+
+		val, ok := v[name]
+		if !ok {
+			return "", fmt.Errorf("variable '%s' is not defined", name)
+		}
+
+		ret.WriteString(s[:i])
+		ret.WriteString(val)
+		s = s[i+3+j:]
+	}
+
+	ret.WriteString(s)
+
+	return ret.String(), nil
+}
+
+// substituteLine replaces variable references in a playlist line.
+// References are replaced in URI lines and in quoted-string attribute values.
+func (v variables) substituteLine(line string) (string, error) {
+	if !strings.Contains(line, "{$") {
+		return line, nil
+	}
+
+	if line[0] != '#' {
+		return v.substitute(line)
+	}
+
+	var ret strings.Builder
+
+	for {
+		i := strings.IndexByte(line, '"')
+		if i < 0 {
+			break
+		}
+
+		j := strings.IndexByte(line[i+1:], '"')
+		if j < 0 {
+			break
+		}
+
+		val, err := v.substitute(line[i+1 : i+1+j])
+		if err != nil {
+			return "", err
+		}
+
+		ret.WriteString(line[:i+1])
+		ret.WriteString(val)
+		ret.WriteByte('"')
+		line = line[i+2+j:]
+	}
+
+	ret.WriteString(line)
+
+	return ret.String(), nil
+}
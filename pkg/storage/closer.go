@@ -0,0 +1,9 @@
+package storage
+
+// Closer can be implemented by a Factory that performs operations in background.
+// The factory is not closed by the Muxer, since it can be shared between multiple muxers;
+// it must be closed by its owner once all muxers that use it are closed.
+type Closer interface {
+	// Close waits for pending operations to complete.
+	Close()
+}
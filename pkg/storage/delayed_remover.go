@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"time"
+)
+
+// DelayedRemover can be implemented by a File that is copied to a remote location,
+// in order to keep the remote copy available to clients that still hold
+// a playlist that refers to it.
+type DelayedRemover interface {
+	// RemoveAfter removes the file, and removes the remote copy once the given duration
+	// has passed and a playlist has been published after the call.
+	RemoveAfter(d time.Duration)
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	s3DefaultRegion     = "us-east-1"
+	s3DefaultMaxRetries = 3
+	s3DefaultRetryPause = 1 * time.Second
+	s3DefaultTimeout    = 10 * time.Second
+)
+
+func s3ContentType(fileName string) string {
+	switch path.Ext(fileName) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+
+	case ".ts":
+		return "video/MP2T"
+
+	case ".mp4":
+		return "video/mp4"
+
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// S3Config contains settings of a S3-backed factory.
+type S3Config struct {
+	// Endpoint of the S3-compatible service, in the format "https://host:port".
+	// Required.
+	Endpoint string
+	// Region.
+	// It defaults to "us-east-1".
+	Region string
+	// Bucket.
+	// Required.
+	Bucket string
+	// Prefix of object keys.
+	Prefix string
+	// Credentials.
+	// When empty, requests are not signed.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Value of the Cache-Control header of segments and init files.
+	CacheControl string
+	// Value of the Cache-Control header of playlists.
+	PlaylistCacheControl string
+	// HTTP client.
+	// It defaults to a client with a timeout of 10 seconds.
+	HTTPClient *http.Client
+	// Maximum number of retries of a failed request.
+	// It defaults to 3.
+	MaxRetries int
+	// Pause between retries.
+	// It defaults to 1 second.
+	RetryPause time.Duration
+	// Called when a request fails after all retries.
+	// It defaults to a function that prints the error.
+	OnError func(err error)
+}
+
+type s3Request struct {
+	method   string
+	fileName string
+	byts     []byte
+}
+
+type s3DelayedDelete struct {
+	fileName  string
+	notBefore time.Time
+}
+
+type factoryS3 struct {
+	conf     S3Config
+	endpoint *url.URL
+
+	mutex   sync.Mutex
+	queue   []*s3Request
+	delayed []*s3DelayedDelete
+	running bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewFactoryS3 allocates a factory that keeps files in RAM and uploads them to a S3-compatible service.
+// Files are uploaded once finalized and deleted once removed. Published files are uploaded too.
+// Requests are performed in background, in the same order in which they are generated,
+// therefore playlists are always uploaded after the segments they refer to.
+// Files removed through Remove() are kept on the remote service, while files removed
+// through RemoveAfter() are deleted when a playlist is published after the given duration.
+// The factory implements Closer: once closed, pending requests are flushed
+// and delayed deletions are discarded, so that published playlists stay valid.
+func NewFactoryS3(conf S3Config) (Factory, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported endpoint: %v", conf.Endpoint)
+	}
+
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("bucket is empty")
+	}
+
+	if conf.Region == "" {
+		conf.Region = s3DefaultRegion
+	}
+	if conf.HTTPClient == nil {
+		conf.HTTPClient = &http.Client{Timeout: s3DefaultTimeout}
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = s3DefaultMaxRetries
+	}
+	if conf.RetryPause == 0 {
+		conf.RetryPause = s3DefaultRetryPause
+	}
+	if conf.OnError == nil {
+		conf.OnError = func(err error) {
+			log.Printf("%v", err)
+		}
+	}
+
+	return &factoryS3{
+		conf:     conf,
+		endpoint: endpoint,
+	}, nil
+}
+
+// NewFile implements Factory.
+func (s *factoryS3) NewFile(fileName string) (File, error) {
+	return &fileS3{
+		File:     newFileRAM(),
+		factory:  s,
+		fileName: fileName,
+	}, nil
+}
+
+// Publish implements Publisher.
+func (s *factoryS3) Publish(fileName string, byts []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.enqueueUnlocked(&s3Request{
+		method:   http.MethodPut,
+		fileName: fileName,
+		byts:     byts,
+	}) {
+		return fmt.Errorf("factory is closed")
+	}
+
+	// deletions are performed after the upload of a playlist that does not contain the files anymore.
+	if path.Ext(fileName) == ".m3u8" {
+		s.releaseDeletes(time.Now())
+	}
+
+	return nil
+}
+
+// Close implements Closer.
+func (s *factoryS3) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.delayed = nil
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *factoryS3) delayDelete(fileName string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.delayed = append(s.delayed, &s3DelayedDelete{
+		fileName:  fileName,
+		notBefore: time.Now().Add(d),
+	})
+}
+
+func (s *factoryS3) releaseDeletes(now time.Time) {
+	n := 0
+	for _, d := range s.delayed {
+		if now.Before(d.notBefore) {
+			s.delayed[n] = d
+			n++
+			continue
+		}
+
+		s.enqueueUnlocked(&s3Request{
+			method:   http.MethodDelete,
+			fileName: d.fileName,
+		})
+	}
+
+	clear(s.delayed[n:])
+	s.delayed = s.delayed[:n]
+}
+
+func (s *factoryS3) enqueue(req *s3Request) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.enqueueUnlocked(req)
+}
+
+func (s *factoryS3) enqueueUnlocked(req *s3Request) bool {
+	if s.closed {
+		return false
+	}
+
+	s.queue = append(s.queue, req)
+
+	if !s.running {
+		s.running = true
+		s.wg.Add(1)
+		go s.run()
+	}
+
+	return true
+}
+
+func (s *factoryS3) run() {
+	defer s.wg.Done()
+
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.mutex.Unlock()
+			return
+		}
+		req := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		s.process(req)
+	}
+}
+
+func (s *factoryS3) process(req *s3Request) {
+	for i := 0; ; i++ {
+		err := s.do(req)
+		if err == nil {
+			return
+		}
+
+		if i >= s.conf.MaxRetries {
+			s.conf.OnError(fmt.Errorf("%s %s failed: %w", req.method, req.fileName, err))
+			return
+		}
+
+		time.Sleep(s.conf.RetryPause)
+	}
+}
+
+func (s *factoryS3) objectURL(fileName string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.conf.Bucket + "/" + s.conf.Prefix + fileName
+	u.RawPath = ""
+	return &u
+}
+
+func (s *factoryS3) do(r *s3Request) error {
+	req, err := http.NewRequest(r.method, s.objectURL(r.fileName).String(), bytes.NewReader(r.byts))
+	if err != nil {
+		return err
+	}
+
+	if r.method == http.MethodPut {
+		req.Header.Set("Content-Type", s3ContentType(r.fileName))
+
+		var cacheControl string
+		if path.Ext(r.fileName) == ".m3u8" {
+			cacheControl = s.conf.PlaylistCacheControl
+		} else {
+			cacheControl = s.conf.CacheControl
+		}
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+	}
+
+	if s.conf.AccessKeyID != "" {
+		s3Sign(req, s3Hash(r.byts), s.conf.Region, s.conf.AccessKeyID, s.conf.SecretAccessKey, time.Now())
+	}
+
+	res, err := s.conf.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
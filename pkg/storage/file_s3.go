@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+type fileS3 struct {
+	File
+	factory  *factoryS3
+	fileName string
+	uploaded bool
+	removed  bool
+}
+
+// Finalize implements File.
+func (s *fileS3) Finalize() {
+	s.File.Finalize()
+
+	// files removed before being finalized are not referenced by playlists
+	if s.removed {
+		return
+	}
+
+	r, err := s.File.Reader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	byts, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	s.uploaded = s.factory.enqueue(&s3Request{
+		method:   http.MethodPut,
+		fileName: s.fileName,
+		byts:     byts,
+	})
+}
+
+// Remove implements File.
+// Only the local copy is removed, the remote file is kept.
+// Files that have not been finalized yet are not uploaded anymore.
+func (s *fileS3) Remove() {
+	s.File.Remove()
+	s.removed = true
+}
+
+// RemoveAfter implements DelayedRemover.
+func (s *fileS3) RemoveAfter(d time.Duration) {
+	s.File.Remove()
+	s.removed = true
+
+	if s.uploaded {
+		s.factory.delayDelete(s.fileName, d)
+	}
+}
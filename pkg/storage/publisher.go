@@ -0,0 +1,9 @@
+package storage
+
+// Publisher can be implemented by a Factory in order to receive files that are
+// not allocated through NewFile, like playlists and init files, and to publish them
+// together with segments.
+type Publisher interface {
+	// Publish publishes a file, replacing any previous version.
+	Publish(fileName string, byts []byte) error
+}
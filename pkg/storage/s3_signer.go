@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requests to S3 are authenticated with the AWS Signature Version 4.
+
+const (
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3DateFormat       = "20060102T150405Z"
+)
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3Hash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// s3URIEncode encodes a string as described in the specification,
+// leaving in clear only unreserved characters and, optionally, slashes.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := range len(s) {
+		c := s[i]
+
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+
+	return b.String()
+}
+
+// s3Sign signs a request, by filling the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers.
+// The host and all headers of the request are signed.
+func s3Sign(
+	req *http.Request,
+	payloadHash string,
+	region string,
+	accessKeyID string,
+	secretAccessKey string,
+	t time.Time,
+) {
+	amzDate := t.UTC().Format(s3DateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.Host,
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+
+	canonicalQuery := make([]string, 0, len(queryKeys))
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := req.Method + "\n" +
+		s3URIEncode(path, false) + "\n" +
+		strings.Join(canonicalQuery, "&") + "\n" +
+		canonicalHeaders.String() + "\n" +
+		signedHeaders + "\n" +
+		payloadHash
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	stringToSign := s3SigningAlgorithm + "\n" +
+		amzDate + "\n" +
+		scope + "\n" +
+		s3Hash([]byte(canonicalRequest))
+
+	key := s3HMAC([]byte("AWS4"+secretAccessKey), date)
+	key = s3HMAC(key, region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", s3SigningAlgorithm+
+		" Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+}
@@ -0,0 +1,110 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+)
+
+// SCTE-35 splice_info_section() carried by MPEG-TS streams, as described in ANSI/SCTE 35.
+
+const (
+	mpegtsStreamTypeSCTE35 = 0x86
+
+	scte35TableID             = 0xfc
+	scte35CommandSpliceInsert = 0x05
+	scte35CommandTimeSignal   = 0x06
+)
+
+// mpegtsSCTE35Sections extracts SCTE-35 sections, CRC included, from MPEG-TS data.
+// PIDs that carry SCTE-35 are found by reading PATs and PMTs.
+// Sections that span multiple packets are skipped.
+func mpegtsSCTE35Sections(payload []byte) [][]byte {
+	scte35PIDs := make(map[uint16]struct{})
+
+	// invalid tables are reported by the demuxer
+	mpegtsForEachPMTStream(payload, func(es []byte) { //nolint:errcheck
+		if es[0] == mpegtsStreamTypeSCTE35 {
+			scte35PIDs[mpegtsPMTStreamPID(es)] = struct{}{}
+		}
+	})
+
+	if len(scte35PIDs) == 0 {
+		return nil
+	}
+
+	var ret [][]byte
+
+	for i := 0; (len(payload) - i) >= mpegtsPacketSize; i += mpegtsPacketSize {
+		pkt := payload[i : i+mpegtsPacketSize]
+		if pkt[0] != 0x47 {
+			return ret
+		}
+
+		if _, ok := scte35PIDs[binary.BigEndian.Uint16(pkt[1:])&0x1fff]; !ok {
+			continue
+		}
+
+		start, end, err := mpegtsSection(pkt)
+		if err != nil || start == 0 {
+			continue
+		}
+
+		if pkt[start] == scte35TableID {
+			ret = append(ret, append([]byte(nil), pkt[start:end+4]...))
+		}
+	}
+
+	return ret
+}
+
+// scte35SpliceTime returns the PTS at which the command of a splice_info_section() is executed.
+// It returns false when the command is executed immediately, or when it is not a
+// splice_insert() or time_signal() command.
+func scte35SpliceTime(section []byte) (int64, bool) {
+	if len(section) < 14 || section[0] != scte35TableID {
+		return 0, false
+	}
+
+	// encrypted_packet
+	if (section[4] & 0x80) != 0 {
+		return 0, false
+	}
+
+	ptsAdjustment := int64(section[4]&0x01)<<32 | int64(binary.BigEndian.Uint32(section[5:]))
+	commandLength := int(section[11]&0x0f)<<8 | int(section[12])
+	commandType := section[13]
+
+	cmd := section[14:]
+	if commandLength <= len(cmd) {
+		cmd = cmd[:commandLength]
+	}
+
+	switch commandType {
+	case scte35CommandSpliceInsert:
+		// splice_event_cancel_indicator
+		if len(cmd) < 6 || (cmd[4]&0x80) != 0 {
+			return 0, false
+		}
+
+		programSpliceFlag := (cmd[5] & 0x40) != 0
+		spliceImmediateFlag := (cmd[5] & 0x10) != 0
+		if !programSpliceFlag || spliceImmediateFlag {
+			return 0, false
+		}
+
+		cmd = cmd[6:]
+
+	case scte35CommandTimeSignal:
+
+	default:
+		return 0, false
+	}
+
+	// splice_time(), time_specified_flag
+	if len(cmd) < 5 || (cmd[0]&0x80) == 0 {
+		return 0, false
+	}
+
+	ptsTime := int64(cmd[0]&0x01)<<32 | int64(binary.BigEndian.Uint32(cmd[1:]))
+
+	return (ptsTime + ptsAdjustment) & 0x1ffffffff, true
+}
@@ -13,14 +13,19 @@ type Track struct {
 	ClockRate int
 
 	// Name
-	// For audio renditions only.
+	// For audio and subtitle renditions only.
 	Name string
 
 	// Language
-	// For audio renditions only.
+	// For audio and subtitle renditions only.
 	Language string
 
 	// whether this is the default track.
-	// For audio renditions only.
+	// For audio and subtitle renditions only.
 	IsDefault bool
+
+	// whether subtitles must be displayed even when the user
+	// did not select them.
+	// For subtitle renditions only.
+	IsForced bool
 }
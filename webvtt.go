@@ -0,0 +1,190 @@
+package gohlslib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webVTTCue is a WebVTT cue.
+// Timestamps are relative to the LOCAL value of X-TIMESTAMP-MAP.
+type webVTTCue struct {
+	start time.Duration
+	end   time.Duration
+	text  string
+}
+
+func webVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
+
+// webVTTCueText normalizes the text of a cue,
+// removing empty lines, that would terminate the cue.
+func webVTTCueText(text string) (string, error) {
+	if strings.Contains(text, "-->") {
+		return "", fmt.Errorf("cue text cannot contain '-->'")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", fmt.Errorf("cue text is empty")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func parseWebVTTTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp: '%s'", s)
+	}
+
+	secParts := strings.Split(parts[len(parts)-1], ".")
+	if len(secParts) != 2 || len(secParts[1]) != 3 {
+		return 0, fmt.Errorf("invalid timestamp: '%s'", s)
+	}
+
+	values := make([]string, 0, 4)
+	values = append(values, parts[:len(parts)-1]...)
+	values = append(values, secParts...)
+
+	units := []time.Duration{time.Hour, time.Minute, time.Second, time.Millisecond}[4-len(values):]
+
+	var ret time.Duration
+
+	for i, v := range values {
+		tmp, err := strconv.ParseUint(v, 10, 31)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: '%s'", s)
+		}
+		ret += time.Duration(tmp) * units[i]
+	}
+
+	return ret, nil
+}
+
+// webVTTTimestampMap is the content of X-TIMESTAMP-MAP.
+type webVTTTimestampMap struct {
+	mpegts int64
+	local  time.Duration
+}
+
+func (m *webVTTTimestampMap) unmarshal(v string) error {
+	for _, kv := range strings.Split(v, ",") {
+		key, val, ok := strings.Cut(kv, ":")
+		if !ok {
+			return fmt.Errorf("invalid X-TIMESTAMP-MAP: '%s'", v)
+		}
+
+		switch key {
+		case "MPEGTS":
+			tmp, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid X-TIMESTAMP-MAP: '%s'", v)
+			}
+			m.mpegts = tmp
+
+		case "LOCAL":
+			tmp, err := parseWebVTTTimestamp(val)
+			if err != nil {
+				return err
+			}
+			m.local = tmp
+		}
+	}
+
+	return nil
+}
+
+// unmarshalWebVTT decodes a WebVTT segment.
+// When X-TIMESTAMP-MAP is missing, local times are assumed to be MPEG-TS times.
+func unmarshalWebVTT(byts []byte) (*webVTTTimestampMap, []*webVTTCue, error) {
+	content := strings.TrimPrefix(string(byts), "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	blocks := strings.Split(content, "\n\n")
+
+	header := strings.Split(blocks[0], "\n")
+	if header[0] != "WEBVTT" && !strings.HasPrefix(header[0], "WEBVTT ") &&
+		!strings.HasPrefix(header[0], "WEBVTT\t") {
+		return nil, nil, fmt.Errorf("invalid WebVTT header")
+	}
+
+	tm := &webVTTTimestampMap{}
+
+	for _, line := range header[1:] {
+		if v, ok := strings.CutPrefix(line, "X-TIMESTAMP-MAP="); ok {
+			err := tm.unmarshal(v)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var cues []*webVTTCue
+
+	for _, block := range blocks[1:] {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+
+		// skip identifier
+		if !strings.Contains(lines[0], "-->") {
+			lines = lines[1:]
+		}
+
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			// NOTE, STYLE and REGION blocks
+			continue
+		}
+
+		start, rest, _ := strings.Cut(lines[0], "-->")
+		end := strings.Fields(rest)
+		if len(end) == 0 {
+			return nil, nil, fmt.Errorf("invalid cue timings: '%s'", lines[0])
+		}
+
+		cue := &webVTTCue{
+			text: strings.Join(lines[1:], "\n"),
+		}
+
+		var err error
+		cue.start, err = parseWebVTTTimestamp(strings.TrimSpace(start))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cue.end, err = parseWebVTTTimestamp(end[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cues = append(cues, cue)
+	}
+
+	return tm, cues, nil
+}
+
+// marshalWebVTT generates a WebVTT segment.
+// mpegtsOffset is the MPEG-TS timestamp, in 90khz units, that corresponds to the local time zero.
+func marshalWebVTT(mpegtsOffset int64, cues []*webVTTCue) []byte {
+	var b strings.Builder
+
+	b.WriteString("WEBVTT\n" +
+		"X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(mpegtsOffset, 10) + ",LOCAL:00:00:00.000\n")
+
+	for _, cue := range cues {
+		b.WriteString("\n" + webVTTTimestamp(cue.start) + " --> " + webVTTTimestamp(cue.end) + "\n" +
+			cue.text + "\n")
+	}
+
+	return []byte(b.String())
+}